@@ -1,57 +1,182 @@
 package api
 
 import (
+	"api-proxy/internal/api/middleware"
+	"api-proxy/internal/api/problem"
 	"api-proxy/internal/model"
+	"api-proxy/internal/oidc"
+	"api-proxy/internal/refresh"
+	"api-proxy/internal/revocation"
+	"api-proxy/internal/scope"
+	"api-proxy/internal/signing"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"errors"
 	"log/slog"
+	"maps"
+	"mime"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// tokenExchangeGrantType is the RFC 8693 grant, kinde_token is kept as an alias for the callers already using it
+const tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// maxTokenLifetime is how long the longest lived token the handler issues stays valid, revoking every token of an org
+// or service account has to last this long
+const maxTokenLifetime = time.Hour
+
+// Error codes of the token, revocation and introspection endpoints (RFC 6749 section 5.2, RFC 7009 section 2.2.1)
+const (
+	oauthInvalidRequest       = "invalid_request"
+	oauthInvalidClient        = "invalid_client"
+	oauthInvalidGrant         = "invalid_grant"
+	oauthUnauthorizedClient   = "unauthorized_client"
+	oauthUnsupportedGrantType = "unsupported_grant_type"
+	oauthInvalidScope         = "invalid_scope"
+	oauthUnsupportedTokenType = "unsupported_token_type"
+)
+
 type AuthServiceAccountDataStorer interface {
+	FindActiveByFilter(filter *model.ServiceAccountFilter) ([]*model.ServiceAccount, error)
+	FindByID(id int) (*model.ServiceAccount, error)
 	FindByClientID(clientID string) (*model.ServiceAccount, error)
 }
 
+type AuthOrgDataStorer interface {
+	FindByID(id int) (*model.Org, error)
+	FindActiveByName(name string) (*model.Org, error)
+}
+
+// ExternalTokenVerifier verifies tokens issued by the configured third-party identity providers
+type ExternalTokenVerifier interface {
+	Verify(ctx context.Context, token string) (*oidc.Subject, error)
+}
+
 type AuthInternalUserDataStorer interface {
+	FindByID(id int) (*model.InternalUser, error)
 	FindByEmail(email string) (*model.InternalUser, error)
 }
 
+// RefreshTokens issues rotating refresh tokens and redeems them, revoking a token's family when it is used twice
+type RefreshTokens interface {
+	Issue(token *model.RefreshToken) (string, error)
+	Find(raw string) (*model.RefreshToken, error)
+	Redeem(ctx context.Context, token *model.RefreshToken) error
+	RevokeFamily(ctx context.Context, familyID string) error
+}
+
 type AuthTokenRequest struct {
 	GrantType    string `json:"grant_type"`
 	KindeToken   string `json:"token"`
+	SubjectToken string `json:"subject_token"`
 	ClientID     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
+	Scope        string `json:"scope"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ClientTokenRequest is how a client revokes (RFC 7009) or introspects (RFC 7662) a token
+type ClientTokenRequest struct {
+	Token         string `json:"token"`
+	TokenTypeHint string `json:"token_type_hint"`
+	ClientID      string `json:"client_id"`
+	ClientSecret  string `json:"client_secret"`
 }
 
+// TokenIntrospection describes a token as RFC 7662 does, only active is set for tokens that are not active
+type TokenIntrospection struct {
+	Active      bool   `json:"active"`
+	Scope       string `json:"scope,omitempty"`
+	TokenType   string `json:"token_type,omitempty"`
+	Subject     *int   `json:"sub,omitempty"`
+	SubjectType string `json:"sub_type,omitempty"`
+	OrgID       int    `json:"org_id,omitempty"`
+	Issuer      string `json:"iss,omitempty"`
+	JTI         string `json:"jti,omitempty"`
+	IssuedAt    int64  `json:"iat,omitempty"`
+	ExpiresAt   int64  `json:"exp,omitempty"`
+}
+
+// InternalAuthTokenRequest logs a user in with their email and password, or with grant_type refresh_token swaps a
+// refresh token for a new access token
 type InternalAuthTokenRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	GrantType    string `json:"grant_type"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// OAuthError is the error body OAuth clients expect from the token, revocation and introspection endpoints
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
 }
 
 type AccessToken struct {
-	AccessToken string `json:"access_token"`
-	ExpiresIn   int    `json:"expires_in"`
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+
+	// jti and expiresAt tie the refresh token issued alongside to this token, so both are revoked together
+	jti       string
+	expiresAt time.Time
+}
+
+// TokenKeys hands out the signing key new tokens are signed with, ok is false while no key is active, and the keys
+// the tokens a client revokes or introspects were signed with
+type TokenKeys interface {
+	middleware.VerificationKeys
+	Current() (*signing.Key, bool)
+}
+
+// AuthTokenRevoker revokes the tokens clients hand back and tells which tokens are revoked
+type AuthTokenRevoker interface {
+	TokenRevoker
+	middleware.RevocationList
 }
 
 type AuthHandler struct {
 	jwtSigningSecret        string
 	adminJwtSigningSecret   string
+	issuer                  string
+	keys                    TokenKeys
+	verifier                ExternalTokenVerifier
+	revoker                 AuthTokenRevoker
+	refreshTokens           RefreshTokens
 	serviceAccountDataStore AuthServiceAccountDataStorer
+	orgDataStore            AuthOrgDataStorer
 	internalUserDataStore   AuthInternalUserDataStorer
 }
 
 func NewAuthHandler(
 	jwtSigningSecret string,
 	adminJwtSigningSecret string,
+	issuer string,
+	keys TokenKeys,
+	verifier ExternalTokenVerifier,
+	revoker AuthTokenRevoker,
+	refreshTokens RefreshTokens,
 	authServiceAccountDataStore AuthServiceAccountDataStorer,
+	authOrgDataStore AuthOrgDataStorer,
 	authInternalUserDataStore AuthInternalUserDataStorer,
 ) *AuthHandler {
 	return &AuthHandler{
 		jwtSigningSecret:        jwtSigningSecret,
 		adminJwtSigningSecret:   adminJwtSigningSecret,
+		issuer:                  issuer,
+		keys:                    keys,
+		verifier:                verifier,
+		revoker:                 revoker,
+		refreshTokens:           refreshTokens,
 		serviceAccountDataStore: authServiceAccountDataStore,
+		orgDataStore:            authOrgDataStore,
 		internalUserDataStore:   authInternalUserDataStore,
 	}
 }
@@ -61,11 +186,16 @@ func (ah *AuthHandler) handleInternalOAuth(w http.ResponseWriter, r *http.Reques
 
 	if err != nil {
 		slog.Error("error decoding request", "error", err)
-		http.Error(w, "unable to read json request body", http.StatusBadRequest)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "unable to read json request body")
 		return
 	}
 
-	ah.handleInternalCredentials(w, authRequest)
+	if authRequest.GrantType == "refresh_token" {
+		ah.handleInternalRefreshToken(w, r, authRequest)
+		return
+	}
+
+	ah.handleInternalCredentials(w, r, authRequest)
 }
 
 func (ah *AuthHandler) handleOAuth(w http.ResponseWriter, r *http.Request) {
@@ -73,85 +203,559 @@ func (ah *AuthHandler) handleOAuth(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		slog.Error("error decoding request", "error", err)
-		http.Error(w, "unable to read json request body", http.StatusBadRequest)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "unable to read json request body")
 		return
 	}
 
 	switch authRequest.GrantType {
 	case "client_credentials":
-		ah.handleClientCredentials(w, authRequest)
-	case "kinde_token":
-		ah.handleKindeToken(w, r, authRequest)
+		ah.handleClientCredentials(w, r, authRequest)
+	case tokenExchangeGrantType, "kinde_token":
+		ah.handleTokenExchange(w, r, authRequest)
+	case "refresh_token":
+		ah.handleRefreshToken(w, r, authRequest)
 	default:
 		slog.Error("unknown grant type", "grant_type", authRequest.GrantType)
-		http.Error(w, "invalid grant type", http.StatusBadRequest)
+		writeOAuthError(w, http.StatusBadRequest, oauthUnsupportedGrantType, "")
 	}
 }
 
-func (ah *AuthHandler) handleInternalCredentials(w http.ResponseWriter, authRequest *InternalAuthTokenRequest) {
+func (ah *AuthHandler) handleInternalCredentials(w http.ResponseWriter, r *http.Request, authRequest *InternalAuthTokenRequest) {
 	user, err := ah.findInternalUser(authRequest.Email, authRequest.Password)
 
 	if err != nil {
 		slog.Error("error finding internal user", "error", err)
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
 	if user == nil {
 		slog.Error("internal user not found", "email", authRequest.Email)
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "")
 		return
 	}
 
 	accessToken, err := ah.issueTokenForUser(user)
 
+	if err == nil {
+		err = ah.addRefreshToken(accessToken, &model.RefreshToken{InternalUserID: &user.ID})
+	}
+
 	if err != nil {
 		slog.Error("error issuing token for user", "email", authRequest.Email, "error", err)
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
 	writeJSON(w, accessToken, http.StatusOK)
 }
 
-func (ah *AuthHandler) handleClientCredentials(w http.ResponseWriter, authRequest *AuthTokenRequest) {
+func (ah *AuthHandler) handleClientCredentials(w http.ResponseWriter, r *http.Request, authRequest *AuthTokenRequest) {
 	account, err := ah.findServiceAccount(authRequest.ClientID, authRequest.ClientSecret)
 
 	if err != nil {
 		slog.Error("error finding service account", "error", err)
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
 	if account == nil {
 		slog.Error("service account not found", "client_id", authRequest.ClientID)
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		writeOAuthError(w, http.StatusUnauthorized, oauthInvalidClient, "")
+		return
+	}
+
+	requested, ok := requestedScopes(authRequest)
+
+	if !ok {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidScope, "")
 		return
 	}
 
-	accessToken, err := ah.issueTokenForServiceAccount(account)
+	granted := scope.Cap(requested, account.Scopes)
+	accessToken, err := ah.issueTokenForServiceAccount(account, granted)
+
+	if err == nil {
+		err = ah.addRefreshToken(accessToken, &model.RefreshToken{ServiceAccountID: &account.ID, Scopes: granted})
+	}
 
 	if err != nil {
 		slog.Error("error issuing token for service account", "client_id", authRequest.ClientID, "error", err)
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
 	writeJSON(w, accessToken, http.StatusOK)
 }
 
-func (ah *AuthHandler) handleKindeToken(w http.ResponseWriter, r *http.Request, authRequest *AuthTokenRequest) {
-	http.Error(w, "kinde token not supported", http.StatusNotImplemented)
+// handleTokenExchange trades a token from one of the configured identity providers for one of ours. The provider's
+// service account claim picks the service account the token is issued for, which has to belong to the org named by
+// the org claim when the provider maps both. Without a service account claim the token is issued for the service
+// account the provider's users of the org act as.
+func (ah *AuthHandler) handleTokenExchange(w http.ResponseWriter, r *http.Request, authRequest *AuthTokenRequest) {
+	externalToken := cmp.Or(authRequest.SubjectToken, authRequest.KindeToken)
+
+	if externalToken == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "subject_token is required")
+		return
+	}
+
+	requested, ok := requestedScopes(authRequest)
+
+	if !ok {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidScope, "")
+		return
+	}
+
+	subject, err := ah.verifier.Verify(r.Context(), externalToken)
+
+	if err != nil {
+		slog.Info("rejected external token", "error", err)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "")
+		return
+	}
+
+	account, err := ah.findExternalSubject(subject)
+
+	if err != nil {
+		slog.Error("error mapping external subject", "provider", subject.Provider, "subject", subject.Subject, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	if account == nil {
+		slog.Info("external subject maps to no service account", "provider", subject.Provider, "subject", subject.Subject)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "")
+		return
+	}
+
+	var accessToken *AccessToken
+
+	if subject.OrgUser {
+		accessToken, err = ah.issueTokenForExternalUser(account, subject, scope.Cap(requested, account.Scopes))
+	} else {
+		accessToken, err = ah.issueTokenForServiceAccount(account, scope.Cap(requested, account.Scopes))
+	}
+
+	if err != nil {
+		slog.Error("error issuing token for external subject", "provider", subject.Provider, "subject", subject.Subject, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	writeJSON(w, accessToken, http.StatusOK)
+}
+
+// handleRefreshToken swaps a service account's refresh token for a new access token and the next refresh token of
+// its family. A narrower scope can be requested, never one the refresh token was not issued with.
+func (ah *AuthHandler) handleRefreshToken(w http.ResponseWriter, r *http.Request, authRequest *AuthTokenRequest) {
+	requested, ok := requestedScopes(authRequest)
+
+	if !ok {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidScope, "")
+		return
+	}
+
+	token, ok := ah.findRefreshToken(w, r, authRequest.RefreshToken, func(token *model.RefreshToken) bool {
+		return token.ServiceAccountID != nil
+	})
+
+	if !ok {
+		return
+	}
+
+	if len(scope.Missing(token.Scopes, requested)) > 0 {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidScope, "")
+		return
+	}
+
+	account, err := ah.serviceAccountDataStore.FindByID(*token.ServiceAccountID)
+
+	if err != nil {
+		slog.Error("error finding service account", "id", *token.ServiceAccountID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	active := false
+
+	if account != nil && (authRequest.ClientID == "" || authRequest.ClientID == account.ClientID) {
+		if active, err = ah.active(account); err != nil {
+			slog.Error("error finding org of service account", "id", account.ID, "error", err)
+			problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+			return
+		}
+	}
+
+	if !active {
+		slog.Info("refresh token of an unknown, inactive or other client", "service_account_id", *token.ServiceAccountID)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "")
+		return
+	}
+
+	if !ah.redeemRefreshToken(w, r, token) {
+		return
+	}
+
+	granted := token.Scopes
+
+	if len(requested) > 0 {
+		granted = requested
+	}
+
+	if len(granted) > 0 {
+		granted = scope.Cap(granted, account.Scopes)
+	}
+
+	accessToken, err := ah.issueTokenForServiceAccount(account, granted)
+
+	if err == nil {
+		err = ah.addRefreshToken(accessToken, &model.RefreshToken{FamilyID: token.FamilyID, ServiceAccountID: &account.ID, Scopes: token.Scopes})
+	}
+
+	if err != nil {
+		slog.Error("error issuing token for service account", "client_id", account.ClientID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	writeJSON(w, accessToken, http.StatusOK)
+}
+
+// handleInternalRefreshToken swaps an internal user's refresh token for a new access token and the next refresh token
+// of its family
+func (ah *AuthHandler) handleInternalRefreshToken(w http.ResponseWriter, r *http.Request, authRequest *InternalAuthTokenRequest) {
+	token, ok := ah.findRefreshToken(w, r, authRequest.RefreshToken, func(token *model.RefreshToken) bool {
+		return token.InternalUserID != nil
+	})
+
+	if !ok {
+		return
+	}
+
+	user, err := ah.internalUserDataStore.FindByID(*token.InternalUserID)
+
+	if err != nil {
+		slog.Error("error finding internal user", "id", *token.InternalUserID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	if user == nil || user.InactivatedAt != nil {
+		slog.Info("refresh token of an unknown or inactive internal user", "internal_user_id", *token.InternalUserID)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "")
+		return
+	}
+
+	if !ah.redeemRefreshToken(w, r, token) {
+		return
+	}
+
+	accessToken, err := ah.issueTokenForUser(user)
+
+	if err == nil {
+		err = ah.addRefreshToken(accessToken, &model.RefreshToken{FamilyID: token.FamilyID, InternalUserID: &user.ID})
+	}
+
+	if err != nil {
+		slog.Error("error issuing token for user", "email", user.Email, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	writeJSON(w, accessToken, http.StatusOK)
+}
+
+// findRefreshToken looks up the presented refresh token, which has to be one issuedFor accepts. The request has been
+// answered when ok is false.
+func (ah *AuthHandler) findRefreshToken(w http.ResponseWriter, r *http.Request, raw string, issuedFor func(token *model.RefreshToken) bool) (*model.RefreshToken, bool) {
+	if raw == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "refresh_token is required")
+		return nil, false
+	}
+
+	token, err := ah.refreshTokens.Find(raw)
+
+	if err != nil {
+		slog.Error("error finding refresh token", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return nil, false
+	}
+
+	if token == nil || !issuedFor(token) {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "")
+		return nil, false
+	}
+
+	return token, true
+}
+
+// redeemRefreshToken uses up the refresh token, the request has been answered when it returns false
+func (ah *AuthHandler) redeemRefreshToken(w http.ResponseWriter, r *http.Request, token *model.RefreshToken) bool {
+	err := ah.refreshTokens.Redeem(r.Context(), token)
+
+	if errors.Is(err, refresh.ErrInvalidGrant) || errors.Is(err, refresh.ErrReused) {
+		slog.Info("rejected refresh token", "family_id", token.FamilyID, "error", err)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, "")
+		return false
+	}
+
+	if err != nil {
+		slog.Error("error redeeming refresh token", "family_id", token.FamilyID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return false
+	}
+
+	return true
+}
+
+// addRefreshToken issues the refresh token handed out with accessToken, tied to it so reusing the refresh token
+// revokes the access token too
+func (ah *AuthHandler) addRefreshToken(accessToken *AccessToken, token *model.RefreshToken) error {
+	token.AccessTokenJTI = &accessToken.jti
+	token.AccessTokenExpiresAt = &accessToken.expiresAt
+
+	raw, err := ah.refreshTokens.Issue(token)
+
+	if err != nil {
+		return err
+	}
+
+	accessToken.RefreshToken = raw
+	return nil
+}
+
+// handleRevoke revokes an access or refresh token the calling client was issued (RFC 7009), revoking a refresh token
+// revokes its whole family. Tokens that are invalid, expired or already revoked need no revoking and are answered like
+// revoked ones.
+func (ah *AuthHandler) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	tokenRequest, err := decodeClientTokenRequest(r)
+
+	if err != nil {
+		slog.Error("error decoding request", "error", err)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "unable to read request body")
+		return
+	}
+
+	account, ok := ah.authenticateClient(w, r, tokenRequest)
+
+	if !ok {
+		return
+	}
+
+	claims, ok := ah.verifyToken(r.Context(), tokenRequest.Token)
+
+	if !ok {
+		ah.revokeRefreshToken(w, r, account, tokenRequest)
+		return
+	}
+
+	if subject, _ := claims["sub"].(float64); int(subject) != account.ID {
+		slog.Info("client tried revoking a token issued to someone else", "client_id", tokenRequest.ClientID)
+		writeOAuthError(w, http.StatusBadRequest, oauthUnauthorizedClient, "")
+		return
+	}
+
+	token := revocation.TokenFromClaims(claims)
+
+	if token.ID == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthUnsupportedTokenType, "")
+		return
+	}
+
+	expiresAt, err := claims.GetExpirationTime()
+
+	if err != nil || expiresAt == nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthUnsupportedTokenType, "")
+		return
+	}
+
+	err = ah.revoker.Revoke(r.Context(), &model.TokenRevocation{JTI: &token.ID, RevokedAt: time.Now(), ExpiresAt: expiresAt.Time})
+
+	if err != nil {
+		slog.Error("error revoking token", "client_id", tokenRequest.ClientID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeRefreshToken revokes the family of a refresh token the calling client was issued
+func (ah *AuthHandler) revokeRefreshToken(w http.ResponseWriter, r *http.Request, account *model.ServiceAccount, tokenRequest *ClientTokenRequest) {
+	token, err := ah.refreshTokens.Find(tokenRequest.Token)
+
+	if err != nil {
+		slog.Error("error finding refresh token", "client_id", tokenRequest.ClientID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	if token == nil || token.RevokedAt != nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if token.ServiceAccountID == nil || *token.ServiceAccountID != account.ID {
+		slog.Info("client tried revoking a refresh token issued to someone else", "client_id", tokenRequest.ClientID)
+		writeOAuthError(w, http.StatusBadRequest, oauthUnauthorizedClient, "")
+		return
+	}
+
+	if err := ah.refreshTokens.RevokeFamily(r.Context(), token.FamilyID); err != nil {
+		slog.Error("error revoking refresh token family", "client_id", tokenRequest.ClientID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleIntrospect describes a token to a client of the same org (RFC 7662), tokens of other orgs are reported as
+// not active like invalid ones so clients learn nothing about them
+func (ah *AuthHandler) handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	tokenRequest, err := decodeClientTokenRequest(r)
+
+	if err != nil {
+		slog.Error("error decoding request", "error", err)
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "unable to read request body")
+		return
+	}
+
+	account, ok := ah.authenticateClient(w, r, tokenRequest)
+
+	if !ok {
+		return
+	}
+
+	claims, ok := ah.verifyToken(r.Context(), tokenRequest.Token)
+
+	if orgID, _ := claims["org_id"].(float64); !ok || int(orgID) != account.OrgID {
+		writeJSON(w, &TokenIntrospection{Active: false}, http.StatusOK)
+		return
+	}
+
+	subject, _ := claims["sub"].(float64)
+	subjectType, _ := claims["sub_type"].(string)
+	granted, _ := claims[scope.Claim].(string)
+	token := revocation.TokenFromClaims(claims)
+	introspection := &TokenIntrospection{
+		Active:      true,
+		Scope:       granted,
+		TokenType:   "Bearer",
+		Subject:     new(int(subject)),
+		SubjectType: subjectType,
+		OrgID:       token.OrgID,
+		JTI:         token.ID,
+	}
+
+	if issuer, err := claims.GetIssuer(); err == nil {
+		introspection.Issuer = issuer
+	}
+
+	if !token.IssuedAt.IsZero() {
+		introspection.IssuedAt = token.IssuedAt.Unix()
+	}
+
+	if expiresAt, err := claims.GetExpirationTime(); err == nil && expiresAt != nil {
+		introspection.ExpiresAt = expiresAt.Unix()
+	}
+
+	writeJSON(w, introspection, http.StatusOK)
+}
+
+// decodeClientTokenRequest reads a revocation or introspection request. RFC 7009 and RFC 7662 send them form encoded
+// with the client credentials in the form or in a basic Authorization header, a json body is accepted as well.
+func decodeClientTokenRequest(r *http.Request) (*ClientTokenRequest, error) {
+	tokenRequest := &ClientTokenRequest{}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType != "application/x-www-form-urlencoded" {
+		decoded, err := decodeJSON[ClientTokenRequest](r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tokenRequest = decoded
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+
+		tokenRequest.Token = r.PostForm.Get("token")
+		tokenRequest.TokenTypeHint = r.PostForm.Get("token_type_hint")
+		tokenRequest.ClientID = r.PostForm.Get("client_id")
+		tokenRequest.ClientSecret = r.PostForm.Get("client_secret")
+	}
+
+	// Basic credentials are form encoded before being base64 encoded (RFC 6749 section 2.3.1)
+	if clientID, clientSecret, ok := r.BasicAuth(); ok && tokenRequest.ClientID == "" {
+		tokenRequest.ClientID, _ = url.QueryUnescape(clientID)
+		tokenRequest.ClientSecret, _ = url.QueryUnescape(clientSecret)
+	}
+
+	return tokenRequest, nil
+}
+
+// authenticateClient finds the active service account a revocation or introspection request authenticates as, the
+// request has been answered when ok is false
+func (ah *AuthHandler) authenticateClient(w http.ResponseWriter, r *http.Request, tokenRequest *ClientTokenRequest) (*model.ServiceAccount, bool) {
+	account, err := ah.findServiceAccount(tokenRequest.ClientID, tokenRequest.ClientSecret)
+
+	if err != nil {
+		slog.Error("error finding service account", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return nil, false
+	}
+
+	if account == nil {
+		slog.Error("service account not found", "client_id", tokenRequest.ClientID)
+		writeOAuthError(w, http.StatusUnauthorized, oauthInvalidClient, "")
+		return nil, false
+	}
+
+	return account, true
+}
+
+// verifyToken returns the claims of an external token this proxy issued, ok is false when it is not one or when it
+// was revoked
+func (ah *AuthHandler) verifyToken(ctx context.Context, token string) (jwt.MapClaims, bool) {
+	claims, err := middleware.VerifyJWT(token, ah.jwtSigningSecret, ah.keys)
+
+	if err != nil || claims["type"] != "external" {
+		return nil, false
+	}
+
+	if ah.revoker.Revoked(ctx, revocation.TokenFromClaims(claims)) {
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// issueTokenForExternalUser mints a token for a user an identity provider vouches for on behalf of an org. It is
+// issued as the service account the provider's users of that org act as, the provider's subject is kept in ext_sub.
+func (ah *AuthHandler) issueTokenForExternalUser(serviceAccount *model.ServiceAccount, subject *oidc.Subject, granted []string) (*AccessToken, error) {
+	return ah.issueExternalToken(serviceAccount, granted, jwt.MapClaims{
+		"sub_type": "external-user",
+		"ext_sub":  subject.Subject,
+		"idp":      subject.Provider,
+	})
 }
 
 func (ah *AuthHandler) issueTokenForUser(user *model.InternalUser) (*AccessToken, error) {
 	var expiresIn = 3600
+	jti := rand.Text()
+	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
 
 	claims := jwt.MapClaims{
 		"sub":  user.ID,
 		"type": "internal",
 		"iss":  "api-proxy",
-		"exp":  time.Now().Add(time.Duration(expiresIn) * time.Second).Unix(),
+		"jti":  jti,
+		"iat":  time.Now().Unix(),
+		"exp":  expiresAt.Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -165,24 +769,39 @@ func (ah *AuthHandler) issueTokenForUser(user *model.InternalUser) (*AccessToken
 	return &AccessToken{
 		AccessToken: signed,
 		ExpiresIn:   expiresIn,
+		jti:         jti,
+		expiresAt:   expiresAt,
 	}, nil
 }
 
-func (ah *AuthHandler) issueTokenForServiceAccount(serviceAccount *model.ServiceAccount) (*AccessToken, error) {
+// issueTokenForServiceAccount mints a token carrying the granted scopes, already capped at what the account is allowed
+func (ah *AuthHandler) issueTokenForServiceAccount(serviceAccount *model.ServiceAccount, granted []string) (*AccessToken, error) {
+	return ah.issueExternalToken(serviceAccount, granted, jwt.MapClaims{"sub_type": "service-account"})
+}
+
+// issueExternalToken mints a token for the service account with the granted scopes and the subject claims
+func (ah *AuthHandler) issueExternalToken(serviceAccount *model.ServiceAccount, granted []string, subjectClaims jwt.MapClaims) (*AccessToken, error) {
 	var expiresIn = 3600
+	jti := rand.Text()
+	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
 
 	claims := jwt.MapClaims{
-		"sub":      serviceAccount.ID,
-		"org_id":   serviceAccount.OrgID,
-		"type":     "external",
-		"sub_type": "service-account",
-		"iss":      "api-proxy",
-		"exp":      time.Now().Add(time.Duration(expiresIn) * time.Second).Unix(),
+		"sub":    serviceAccount.ID,
+		"org_id": serviceAccount.OrgID,
+		"type":   "external",
+		"iss":    ah.issuer,
+		"jti":    jti,
+		"iat":    time.Now().Unix(),
+		"exp":    expiresAt.Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	maps.Copy(claims, subjectClaims)
+
+	if len(granted) > 0 {
+		claims[scope.Claim] = scope.Join(granted)
+	}
 
-	signed, err := token.SignedString([]byte(ah.jwtSigningSecret))
+	signed, err := ah.sign(claims)
 
 	if err != nil {
 		return nil, err
@@ -191,9 +810,21 @@ func (ah *AuthHandler) issueTokenForServiceAccount(serviceAccount *model.Service
 	return &AccessToken{
 		AccessToken: signed,
 		ExpiresIn:   expiresIn,
+		Scope:       scope.Join(granted),
+		jti:         jti,
+		expiresAt:   expiresAt,
 	}, nil
 }
 
+// sign signs external tokens with the current signing key, or with the shared secret while there is none
+func (ah *AuthHandler) sign(claims jwt.MapClaims) (string, error) {
+	if key, ok := ah.keys.Current(); ok {
+		return key.Sign(claims)
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(ah.jwtSigningSecret))
+}
+
 func (ah *AuthHandler) findInternalUser(email, password string) (*model.InternalUser, error) {
 	user, err := ah.internalUserDataStore.FindByEmail(email)
 
@@ -229,13 +860,80 @@ func (ah *AuthHandler) findServiceAccount(clientId, clientSecret string) (*model
 		return nil, nil
 	}
 
+	active, err := ah.active(account)
+
+	if err != nil || !active {
+		return nil, err
+	}
+
 	return account, nil
 }
 
+// active reports whether neither the service account nor its org were inactivated, their tokens were revoked when
+// they were and they must not get new ones
+func (ah *AuthHandler) active(account *model.ServiceAccount) (bool, error) {
+	if account.InactivatedAt != nil {
+		return false, nil
+	}
+
+	org, err := ah.orgDataStore.FindByID(account.OrgID)
+
+	if err != nil {
+		return false, err
+	}
+
+	return org != nil && org.InactivatedAt == nil, nil
+}
+
+// findExternalSubject resolves the service account a verified external token stands for, the provider already
+// checked it may vouch for it. It is nil when the claims name nothing we know or disagree with each other.
+func (ah *AuthHandler) findExternalSubject(subject *oidc.Subject) (*model.ServiceAccount, error) {
+	if subject.ServiceAccount == "" {
+		return nil, nil
+	}
+
+	accounts, err := ah.serviceAccountDataStore.FindActiveByFilter(&model.ServiceAccountFilter{Identifier: subject.ServiceAccount})
+
+	if err != nil || len(accounts) == 0 {
+		return nil, err
+	}
+
+	if subject.Org != "" {
+		org, err := ah.orgDataStore.FindActiveByName(subject.Org)
+
+		if err != nil || org == nil || org.ID != accounts[0].OrgID {
+			return nil, err
+		}
+	}
+
+	active, err := ah.active(accounts[0])
+
+	if err != nil || !active {
+		return nil, err
+	}
+
+	return accounts[0], nil
+}
+
 func matches(savedSecret, requestSecret string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(savedSecret), []byte(requestSecret)) == nil
 }
 
-func validateKindeToken() (bool, error) {
-	return false, nil
+// requestedScopes parses the scope parameter, ok is false when it holds something that cannot be a scope
+func requestedScopes(authRequest *AuthTokenRequest) ([]string, bool) {
+	requested := scope.Parse(authRequest.Scope)
+
+	for _, s := range requested {
+		if !scope.Valid(s) {
+			return nil, false
+		}
+	}
+
+	return requested, true
+}
+
+// writeOAuthError answers with the RFC 6749 error body instead of a problem, OAuth clients look for the error code
+func writeOAuthError(w http.ResponseWriter, statusCode int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, &OAuthError{Error: code, ErrorDescription: description}, statusCode)
 }
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"api-proxy/internal/api/problem"
 	"api-proxy/internal/model"
 	"log/slog"
 	"net/http"
@@ -44,7 +45,7 @@ func (iuh *InternalUserHandler) handleGetInternalUsers(w http.ResponseWriter, r
 
 	if err != nil {
 		slog.Error("error finding active internal users", "error", err)
-		http.Error(w, "unexpected error.", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
 		return
 	}
 
@@ -60,7 +61,7 @@ func (iuh *InternalUserHandler) handleGetInternalUser(w http.ResponseWriter, r *
 
 	if strconvErr != nil {
 		slog.Error("error converting id to int while GETing internal user", "id", chi.URLParam(r, "id"), "error", strconvErr)
-		http.Error(w, "invalid id in the uri", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
 		return
 	}
 
@@ -68,13 +69,13 @@ func (iuh *InternalUserHandler) handleGetInternalUser(w http.ResponseWriter, r *
 
 	if err != nil {
 		slog.Error("error finding internal user", "id", uriId, "error", err)
-		http.Error(w, "unexpected error.", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
 		return
 	}
 
 	if user == nil {
 		slog.Error("internal user not found with id", "id", uriId)
-		http.Error(w, "user not found", http.StatusNotFound)
+		problem.Write(w, r, http.StatusNotFound, "user not found")
 		return
 	}
 
@@ -88,7 +89,7 @@ func (iuh *InternalUserHandler) handleCreateInternalUser(w http.ResponseWriter,
 
 	if err != nil {
 		slog.Error("error decoding json while creating user", "error", err)
-		http.Error(w, "unable to read json request body", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
 		return
 	}
 
@@ -96,7 +97,7 @@ func (iuh *InternalUserHandler) handleCreateInternalUser(w http.ResponseWriter,
 
 	if err != nil {
 		slog.Error("error hashing password for internal user", "error", err)
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
@@ -106,7 +107,7 @@ func (iuh *InternalUserHandler) handleCreateInternalUser(w http.ResponseWriter,
 
 	if err != nil {
 		slog.Error("error inserting internal user", "error", err)
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
@@ -120,7 +121,7 @@ func (iuh *InternalUserHandler) handleUpdateInternalUser(w http.ResponseWriter,
 
 	if strconvErr != nil {
 		slog.Error("error converting id to int while PUTing internal user", "id", chi.URLParam(r, "id"), "error", strconvErr)
-		http.Error(w, "invalid id in the uri", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
 		return
 	}
 
@@ -128,13 +129,13 @@ func (iuh *InternalUserHandler) handleUpdateInternalUser(w http.ResponseWriter,
 
 	if err != nil {
 		slog.Error("error decoding json while updating user", "error", err)
-		http.Error(w, "unable to read json request body", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
 		return
 	}
 
 	if user.ID != uriId {
 		slog.Error("internal user not found for update with id", "id", uriId, "user", user)
-		http.Error(w, "id in uri must match request body id", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "id in uri must match request body id")
 		return
 	}
 
@@ -142,7 +143,7 @@ func (iuh *InternalUserHandler) handleUpdateInternalUser(w http.ResponseWriter,
 
 	if err != nil {
 		slog.Error("error updating internal user", "error", err)
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
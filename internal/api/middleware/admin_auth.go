@@ -2,6 +2,6 @@ package middleware
 
 import "net/http"
 
-func AdminAuth(jwtSigningSecret string) func(http.Handler) http.Handler {
-	return handleAuth(jwtSigningSecret, "internal")
+func AdminAuth(jwtSigningSecret string, revocations RevocationList) func(http.Handler) http.Handler {
+	return handleAuth(jwtSigningSecret, nil, revocations, "internal")
 }
@@ -1,7 +1,11 @@
 package middleware
 
 import (
+	"api-proxy/internal/api/problem"
+	"api-proxy/internal/identity"
+	"api-proxy/internal/revocation"
 	"context"
+	"crypto"
 	"errors"
 	"net/http"
 	"strings"
@@ -16,27 +20,42 @@ const (
 	claimsKey contextKey = "jwt_claims"
 )
 
-func handleAuth(jwtSigningSecret, desiredTokenType string) func(http.Handler) http.Handler {
+// VerificationKeys finds the public key an asymmetrically signed token names in its kid header
+type VerificationKeys interface {
+	VerificationKey(kid string) (jwt.SigningMethod, crypto.PublicKey, bool)
+}
+
+// RevocationList tells whether a token was revoked before it expired
+type RevocationList interface {
+	Revoked(ctx context.Context, token revocation.Token) bool
+}
+
+func handleAuth(jwtSigningSecret string, keys VerificationKeys, revocations RevocationList, desiredTokenType string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			token, err := extractBearerToken(r)
 
 			if err != nil {
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				unauthorized(w, r)
 				return
 			}
 
-			claims, err := verifyJWT(token, jwtSigningSecret)
+			claims, err := VerifyJWT(token, jwtSigningSecret, keys)
 
 			if err != nil {
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				unauthorized(w, r)
 				return
 			}
 
 			tokenType, ok := claims["type"]
 
 			if !ok || tokenType != desiredTokenType {
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				unauthorized(w, r)
+				return
+			}
+
+			if revocations.Revoked(r.Context(), revocation.TokenFromClaims(claims)) {
+				unauthorized(w, r)
 				return
 			}
 
@@ -48,8 +67,36 @@ func handleAuth(jwtSigningSecret, desiredTokenType string) func(http.Handler) ht
 	}
 }
 
-func Claims(r *http.Request) *jwt.MapClaims {
-	return r.Context().Value(claimsKey).(*jwt.MapClaims)
+// unauthorized rejects the request with a Bearer challenge, the reason the token was rejected is never disclosed
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api-proxy"`)
+	problem.Write(w, r, http.StatusUnauthorized, "a valid bearer token is required")
+}
+
+func Claims(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(claimsKey).(jwt.MapClaims)
+	return claims
+}
+
+// Identity returns the org and service account from a verified external token, ok is false if the request
+// was not authenticated with one
+func Identity(r *http.Request) (*identity.Identity, bool) {
+	claims := Claims(r)
+
+	orgID, orgOk := claims["org_id"].(float64)
+	subject, subOk := claims["sub"].(float64)
+
+	if !orgOk || !subOk {
+		return nil, false
+	}
+
+	subjectType, _ := claims["sub_type"].(string)
+
+	return &identity.Identity{
+		OrgID:            int(orgID),
+		ServiceAccountID: int(subject),
+		SubjectType:      subjectType,
+	}, true
 }
 
 func extractBearerToken(r *http.Request) (string, error) {
@@ -66,13 +113,30 @@ func extractBearerToken(r *http.Request) (string, error) {
 	return strings.TrimPrefix(authHeader, bearer), nil
 }
 
-func verifyJWT(token, jwtSigningSecret string) (jwt.MapClaims, error) {
+// VerifyJWT accepts tokens signed with the shared secret, or with one of keys when it names it in its kid header and
+// was signed with that key's algorithm
+func VerifyJWT(token, jwtSigningSecret string, keys VerificationKeys) (jwt.MapClaims, error) {
 	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			if jwtSigningSecret == "" {
+				return nil, errors.New("unexpected signing method")
+			}
+
+			return []byte(jwtSigningSecret), nil
+		}
+
+		if keys == nil {
 			return nil, errors.New("unexpected signing method")
 		}
 
-		return []byte(jwtSigningSecret), nil
+		kid, _ := token.Header["kid"].(string)
+		method, key, ok := keys.VerificationKey(kid)
+
+		if !ok || method.Alg() != token.Method.Alg() {
+			return nil, errors.New("unknown signing key")
+		}
+
+		return key, nil
 	})
 
 	if err != nil {
@@ -2,6 +2,8 @@ package middleware
 
 import "net/http"
 
-func ExternalAuth(jwtSigningSecret string) func(http.Handler) http.Handler {
-	return handleAuth(jwtSigningSecret, "external")
+// ExternalAuth accepts tokens signed with the shared secret or with one of the published signing keys, unless they
+// were revoked
+func ExternalAuth(jwtSigningSecret string, keys VerificationKeys, revocations RevocationList) func(http.Handler) http.Handler {
+	return handleAuth(jwtSigningSecret, keys, revocations, "external")
 }
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"api-proxy/internal/api/problem"
 	"api-proxy/internal/model"
 	"context"
 	"log/slog"
@@ -20,7 +21,7 @@ func RateLimit(rateLimiter RateLimiter) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.Context().Value(claimsKey) == nil {
 				slog.Error("made it to rate limiter with missing auth/claims")
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				unauthorized(w, r)
 				return
 			}
 
@@ -33,7 +34,7 @@ func RateLimit(rateLimiter RateLimiter) func(http.Handler) http.Handler {
 
 			if !ok {
 				slog.Error("unexpected type from \"claims\" in the context", "claims", r.Context().Value(claimsKey))
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				problem.Write(w, r, http.StatusInternalServerError, "")
 				return
 			}
 
@@ -42,7 +43,7 @@ func RateLimit(rateLimiter RateLimiter) func(http.Handler) http.Handler {
 
 			if !ok {
 				slog.Error("org_id missing from claims or invalid", "org_id", claims["org_id"])
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				unauthorized(w, r)
 				return
 			}
 
@@ -51,7 +52,7 @@ func RateLimit(rateLimiter RateLimiter) func(http.Handler) http.Handler {
 
 			if !ok {
 				slog.Error("sub missing from claims or invalid", "sub", claims["sub"])
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				unauthorized(w, r)
 				return
 			}
 
@@ -59,7 +60,7 @@ func RateLimit(rateLimiter RateLimiter) func(http.Handler) http.Handler {
 
 			if !ok {
 				slog.Error("type missing from claims or invalid", "type", claims["type"])
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				unauthorized(w, r)
 				return
 			}
 
@@ -70,14 +71,14 @@ func RateLimit(rateLimiter RateLimiter) func(http.Handler) http.Handler {
 				return
 			}
 
-			if tokenType != "external" || tokenSubType != "service-account" {
+			if tokenType != "external" || (tokenSubType != "service-account" && tokenSubType != "external-user") {
 				next.ServeHTTP(w, r)
 				return
 			}
 
 			if !rateLimiter.AllowRequest(orgID, serviceAccountID) {
 				slog.Info("rate limiting request", "org_id", orgID, "service_account_id", serviceAccountID)
-				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				problem.Write(w, r, http.StatusTooManyRequests, "rate limit exceeded")
 				return
 			}
 
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"api-proxy/internal/api/problem"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const maxRequestIDLength = 128
+
+// RequestID assigns every request an id, reusing a well-formed X-Request-ID from the caller. The id is echoed
+// back on the response, forwarded to the backend and included in problem responses.
+func RequestID() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := r.Header.Get(problem.RequestIDHeader)
+
+			if !validRequestID(requestID) {
+				requestID = newRequestID()
+			}
+
+			r.Header.Set(problem.RequestIDHeader, requestID)
+			w.Header().Set(problem.RequestIDHeader, requestID)
+
+			next.ServeHTTP(w, r.WithContext(problem.WithRequestID(r.Context(), requestID)))
+		})
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range requestID {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
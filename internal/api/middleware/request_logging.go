@@ -1,36 +1,89 @@
 package middleware
 
 import (
-	"api-proxy/internal/model"
+	"api-proxy/internal/api/problem"
+	"api-proxy/internal/grpcstatus"
+	"api-proxy/internal/logger"
+	"bufio"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
 type RequestLogger interface {
-	Log(route *model.Route, method, url string, statusCode int, latency time.Duration)
+	Log(entry logger.RequestLog)
 }
 
+// responseRecorder captures the status code and counts the bytes moved in each direction, including those copied
+// over a hijacked connection after a protocol upgrade
 type responseRecorder struct {
 	http.ResponseWriter
 	statusCode int
+	bytesIn    atomic.Int64
+	bytesOut   atomic.Int64
 }
 
 func (rr *responseRecorder) WriteHeader(code int) {
-	rr.statusCode = code
+	if code >= http.StatusOK {
+		rr.statusCode = code
+	}
+
 	rr.ResponseWriter.WriteHeader(code)
 }
 
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	n, err := rr.ResponseWriter.Write(p)
+	rr.bytesOut.Add(int64(n))
+
+	return n, err
+}
+
+// Hijack hands over the client connection for an upgraded protocol, the status is recorded as 101 since the
+// handshake response is written straight to the connection
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rr.ResponseWriter).Hijack()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rr.statusCode = http.StatusSwitchingProtocols
+	counted := &countingConn{Conn: conn, recorder: rr}
+
+	return counted, bufio.NewReadWriter(brw.Reader, bufio.NewWriter(counted)), nil
+}
+
+// Unwrap exposes the underlying writer so http.ResponseController can reach Flush and Hijack when streaming
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
 func LogRequest(requestLogger RequestLogger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			rr := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
 			r = NewRouteHolder(r)
 
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &countingBody{ReadCloser: r.Body, recorder: rr}
+			}
+
 			start := time.Now()
 			next.ServeHTTP(rr, r)
 			end := time.Now()
 
+			// gRPC calls answer 200 and report how they ended in grpc-status, the log records its HTTP equivalent
+			statusCode := rr.statusCode
+			var grpcStatus *int
+
+			if code, ok := grpcstatus.FromHeader(rr.Header()); ok && grpcstatus.IsGRPC(r) && statusCode == http.StatusOK {
+				grpcStatus = &code
+				statusCode = grpcstatus.HTTPStatus(code)
+			}
+
 			slog.Info(
 				"Request completed.",
 				"method",
@@ -38,14 +91,59 @@ func LogRequest(requestLogger RequestLogger) func(http.Handler) http.Handler {
 				"path",
 				r.URL.Path,
 				"status",
-				rr.statusCode,
+				statusCode,
 				"response_time",
 				end.Sub(start).Milliseconds(),
+				"bytes_in",
+				rr.bytesIn.Load(),
+				"bytes_out",
+				rr.bytesOut.Load(),
+				"request_id",
+				problem.RequestID(r.Context()),
 			)
 
-			route := MatchedRoute(r)
-
-			requestLogger.Log(route, r.Method, r.URL.Path, rr.statusCode, end.Sub(start))
+			requestLogger.Log(logger.RequestLog{
+				Route:      MatchedRoute(r),
+				Method:     r.Method,
+				URL:        r.URL.Path,
+				StatusCode: statusCode,
+				Latency:    end.Sub(start),
+				BytesIn:    rr.bytesIn.Load(),
+				BytesOut:   rr.bytesOut.Load(),
+				GRPCStatus: grpcStatus,
+				Variant:    Variant(r),
+			})
 		})
 	}
 }
+
+type countingBody struct {
+	io.ReadCloser
+	recorder *responseRecorder
+}
+
+func (cb *countingBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	cb.recorder.bytesIn.Add(int64(n))
+
+	return n, err
+}
+
+type countingConn struct {
+	net.Conn
+	recorder *responseRecorder
+}
+
+func (cc *countingConn) Read(p []byte) (int, error) {
+	n, err := cc.Conn.Read(p)
+	cc.recorder.bytesIn.Add(int64(n))
+
+	return n, err
+}
+
+func (cc *countingConn) Write(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	cc.recorder.bytesOut.Add(int64(n))
+
+	return n, err
+}
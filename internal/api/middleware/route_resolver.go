@@ -1,53 +1,67 @@
 package middleware
 
 import (
+	"api-proxy/internal/api/problem"
 	"api-proxy/internal/model"
 	"context"
-	"errors"
 	"log/slog"
 	"net/http"
-	"strings"
 )
 
-type RouteStorer interface {
-	FindActiveByFilter(filter *model.RouteFilter) ([]*model.Route, error)
+// RouteMatcher resolves a request to the most specific route and its captured path parameters
+type RouteMatcher interface {
+	Match(r *http.Request) (*model.Route, map[string]string, bool)
 }
 
-const matchedRouteKey contextKey = "matched_route"
+// GrantChecker reports whether a caller holds a grant on a route
+type GrantChecker interface {
+	Allowed(route *model.Route, orgID, serviceAccountID int) bool
+}
 
-var ErrRouteNotFound = errors.New("route not found")
+const matchedRouteKey contextKey = "matched_route"
 
 type routeHolder struct {
-	route *model.Route
+	route   *model.Route
+	params  map[string]string
+	variant string
 }
 
 func NewRouteHolder(r *http.Request) *http.Request {
 	return r.WithContext(context.WithValue(r.Context(), matchedRouteKey, &routeHolder{}))
 }
 
-func ResolveRoute(routeStore RouteStorer) func(http.Handler) http.Handler {
+// ResolveRoute matches the request to a route and refuses callers without a grant on it. With requireGrants off, while
+// grants are rolled out, only routes with RequireGrant set are checked and any authenticated caller reaches the others.
+func ResolveRoute(routeMatcher RouteMatcher, grants GrantChecker, requireGrants bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			routes, err := routeStore.FindActiveByFilter(nil)
+			route, params, ok := routeMatcher.Match(r)
 
-			if err != nil {
-				slog.Error("failed to find routes from storer", "err", err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			if !ok {
+				slog.Warn("route not found", "method", r.Method, "host", r.Host, "path", r.URL.Path)
+				problem.Write(w, r, http.StatusNotFound, "no route matches the request")
 				return
 			}
 
-			route, err := findRoute(routes, r)
+			if h, ok := r.Context().Value(matchedRouteKey).(*routeHolder); ok && h != nil {
+				h.route = route
+				h.params = params
+			}
+
+			if requireGrants || route.RequireGrant {
+				caller, ok := Identity(r)
 
-			if err != nil {
-				if errors.Is(err, ErrRouteNotFound) {
-					slog.Warn("route not found", "route", route)
+				if !ok {
+					slog.Info("route requires a grant but the caller is not a service account", "route_id", route.ID)
+					problem.Write(w, r, http.StatusForbidden, "the caller is not granted access to this route")
+					return
 				}
-				http.Error(w, err.Error(), http.StatusNotFound)
-				return
-			}
 
-			if h, ok := r.Context().Value(matchedRouteKey).(*routeHolder); ok && h != nil {
-				h.route = route
+				if !grants.Allowed(route, caller.OrgID, caller.ServiceAccountID) {
+					slog.Info("caller has no grant for route", "route_id", route.ID, "org_id", caller.OrgID, "service_account_id", caller.ServiceAccountID)
+					problem.Write(w, r, http.StatusForbidden, "the caller is not granted access to this route")
+					return
+				}
 			}
 
 			next.ServeHTTP(w, r)
@@ -65,48 +79,36 @@ func MatchedRoute(r *http.Request) *model.Route {
 	return h.route
 }
 
-func findRoute(routes []*model.Route, r *http.Request) (*model.Route, error) {
-	requestSegments := splitUri(r.URL.Path)
-
-	for _, route := range routes {
-		if route.Method != r.Method {
-			continue
-		}
-
-		routeSegments := splitUri(route.Pattern)
-
-		if ok := compareSegments(requestSegments, routeSegments); !ok {
-			continue
-		}
+// PathParams returns the values captured by the matched route's {param} segments
+func PathParams(r *http.Request) map[string]string {
+	h, ok := r.Context().Value(matchedRouteKey).(*routeHolder)
 
-		return route, nil
+	if !ok || h == nil {
+		return nil
 	}
 
-	return nil, ErrRouteNotFound
+	return h.params
 }
 
-func splitUri(uri string) []string {
-	return strings.Split(uri, "/")
+// PathParam returns a single captured path parameter, or an empty string if the route did not capture it
+func PathParam(r *http.Request, name string) string {
+	return PathParams(r)[name]
 }
 
-func compareSegments(request, possibleRoute []string) bool {
-	if len(request) != len(possibleRoute) {
-		return false
+// SetVariant records which of the matched route's variants serves the request so it ends up in the request log
+func SetVariant(r *http.Request, name string) {
+	if h, ok := r.Context().Value(matchedRouteKey).(*routeHolder); ok && h != nil {
+		h.variant = name
 	}
-
-	for i := 0; i < len(request); i++ {
-		if !verifySegment(request[i], possibleRoute[i]) {
-			return false
-		}
-	}
-
-	return true
 }
 
-func verifySegment(requestSegment, possibleRouteSegment string) bool {
-	if strings.HasPrefix(possibleRouteSegment, "{") && strings.HasSuffix(possibleRouteSegment, "}") {
-		return true
+// Variant returns the name of the route variant that served the request, empty for routes without variants
+func Variant(r *http.Request) string {
+	h, ok := r.Context().Value(matchedRouteKey).(*routeHolder)
+
+	if !ok || h == nil {
+		return ""
 	}
 
-	return requestSegment == possibleRouteSegment
+	return h.variant
 }
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"api-proxy/internal/cache"
+	"api-proxy/internal/model"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fixedMatcher matches every request to the same route
+type fixedMatcher struct {
+	route *model.Route
+}
+
+func (fm fixedMatcher) Match(_ *http.Request) (*model.Route, map[string]string, bool) {
+	return fm.route, nil, true
+}
+
+func TestResolveRoute_Grants(t *testing.T) {
+	grants := cache.NewGrantCache()
+	grants.Set([]*model.RouteGrant{
+		{OrgID: 7, RouteID: new(1)},
+		{OrgID: 8, ServiceAccountID: new(20), RouteID: new(1)},
+	})
+
+	caller := func(orgID, serviceAccountID int) jwt.MapClaims {
+		return jwt.MapClaims{"type": "external", "org_id": float64(orgID), "sub": float64(serviceAccountID)}
+	}
+
+	scenarios := []struct {
+		name           string
+		route          *model.Route
+		requireGrants  bool
+		claims         jwt.MapClaims
+		expectedStatus int
+	}{
+		{name: "route without require_grant lets any caller through while grants are rolled out", route: &model.Route{ID: 2}, claims: caller(9, 30), expectedStatus: http.StatusOK},
+		{name: "route without require_grant refuses callers without a grant by default", route: &model.Route{ID: 2}, requireGrants: true, claims: caller(9, 30), expectedStatus: http.StatusForbidden},
+		{name: "route without require_grant lets granted callers through by default", route: &model.Route{ID: 1}, requireGrants: true, claims: caller(7, 10), expectedStatus: http.StatusOK},
+		{name: "org grant", route: &model.Route{ID: 1, RequireGrant: true}, claims: caller(7, 10), expectedStatus: http.StatusOK},
+		{name: "service account grant", route: &model.Route{ID: 1, RequireGrant: true}, claims: caller(8, 20), expectedStatus: http.StatusOK},
+		{name: "service account grant held by another account of the org", route: &model.Route{ID: 1, RequireGrant: true}, claims: caller(8, 21), expectedStatus: http.StatusForbidden},
+		{name: "org without a grant", route: &model.Route{ID: 1, RequireGrant: true}, claims: caller(9, 30), expectedStatus: http.StatusForbidden},
+		{name: "caller that is not a service account", route: &model.Route{ID: 1, RequireGrant: true}, expectedStatus: http.StatusForbidden},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			handler := ResolveRoute(fixedMatcher{route: scenario.route}, grants, scenario.requireGrants)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/countries", nil)
+
+			if scenario.claims != nil {
+				r = r.WithContext(context.WithValue(r.Context(), claimsKey, scenario.claims))
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, NewRouteHolder(r))
+
+			if w.Code != scenario.expectedStatus {
+				t.Fatalf("expected status %d, got %d", scenario.expectedStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"api-proxy/internal/api/problem"
+	"api-proxy/internal/scope"
+	"log/slog"
+	"net/http"
+)
+
+// RequireScopes refuses requests whose token lacks any of the scopes the matched route requires. It has to run after
+// the route was resolved.
+func RequireScopes() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := MatchedRoute(r)
+
+			if route == nil || len(route.RequiredScopes) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			missing := scope.Missing(Scopes(r), route.RequiredScopes)
+
+			if len(missing) > 0 {
+				slog.Info("token lacks scopes required by route", "route_id", route.ID, "missing", missing)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api-proxy", error="`+scope.ErrorInsufficientScope+`", scope="`+scope.Join(route.RequiredScopes)+`"`)
+				problem.WriteProblem(w, r, &problem.Problem{
+					Type:   problem.TypeInsufficientScope,
+					Title:  scope.ErrorInsufficientScope,
+					Status: http.StatusForbidden,
+					Detail: "the token is missing scopes this route requires",
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Scopes returns the scopes granted to the request's verified token
+func Scopes(r *http.Request) []string {
+	granted, _ := Claims(r)[scope.Claim].(string)
+	return scope.Parse(granted)
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"api-proxy/internal/api/problem"
 	"api-proxy/internal/model"
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -17,10 +19,11 @@ type OrgDataStorer interface {
 
 type OrgHandler struct {
 	dataStore OrgDataStorer
+	revoker   TokenRevoker
 }
 
-func NewOrgHandler(orgDataStore OrgDataStorer) *OrgHandler {
-	return &OrgHandler{dataStore: orgDataStore}
+func NewOrgHandler(orgDataStore OrgDataStorer, revoker TokenRevoker) *OrgHandler {
+	return &OrgHandler{dataStore: orgDataStore, revoker: revoker}
 }
 
 func (oh *OrgHandler) Router() http.Handler {
@@ -38,7 +41,8 @@ func (oh *OrgHandler) handleGetOrgs(w http.ResponseWriter, r *http.Request) {
 	active, err := oh.dataStore.FindActive()
 
 	if err != nil {
-		http.Error(w, "unexpected error.", http.StatusInternalServerError)
+		slog.Error("error finding active orgs", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
 		return
 	}
 
@@ -49,19 +53,20 @@ func (oh *OrgHandler) handleGetOrg(w http.ResponseWriter, r *http.Request) {
 	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
 
 	if strconvErr != nil {
-		http.Error(w, "invalid id in the uri", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
 		return
 	}
 
 	org, err := oh.dataStore.FindByID(uriId)
 
 	if err != nil {
-		http.Error(w, "unexpected error.", http.StatusInternalServerError)
+		slog.Error("error finding org", "id", uriId, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
 		return
 	}
 
 	if org == nil {
-		http.Error(w, "org not found", http.StatusNotFound)
+		problem.Write(w, r, http.StatusNotFound, "org not found")
 		return
 	}
 
@@ -72,14 +77,15 @@ func (oh *OrgHandler) handleCreateOrg(w http.ResponseWriter, r *http.Request) {
 	org, err := decodeJSON[model.Org](r)
 
 	if err != nil {
-		http.Error(w, "unable to read json request body", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
 		return
 	}
 
 	created, err := oh.dataStore.Insert(org)
 
 	if err != nil {
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		slog.Error("error inserting org", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
@@ -90,28 +96,37 @@ func (oh *OrgHandler) handleUpdateOrg(w http.ResponseWriter, r *http.Request) {
 	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
 
 	if strconvErr != nil {
-		http.Error(w, "invalid id in the uri", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
 		return
 	}
 
 	org, err := decodeJSON[model.Org](r)
 
 	if err != nil {
-		http.Error(w, "unable to read json request body", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
 		return
 	}
 
 	if org.ID != uriId {
-		http.Error(w, "id in uri must match request body id", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "id in uri must match request body id")
 		return
 	}
 
 	updated, err := oh.dataStore.Update(org)
 
 	if err != nil {
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		slog.Error("error updating org", "id", org.ID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
+	if updated.InactivatedAt != nil {
+		if err := revokeSubject(r.Context(), oh.revoker, &updated.ID, nil); err != nil {
+			slog.Error("error revoking tokens of inactivated org", "id", updated.ID, "error", err)
+			problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+			return
+		}
+	}
+
 	writeJSON(w, updated, http.StatusOK)
 }
@@ -0,0 +1,91 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+type contextKey string
+
+const (
+	ContentType     = "application/problem+json"
+	RequestIDHeader = "X-Request-ID"
+
+	requestIDKey contextKey = "request_id"
+)
+
+// Problem types used for responses the proxy generates itself, anything else is about:blank
+const (
+	TypeDefault             = "about:blank"
+	TypeUpstreamTimeout     = "urn:api-proxy:problem:upstream-timeout"
+	TypeUpstreamUnavailable = "urn:api-proxy:problem:upstream-unavailable"
+	TypeUpstreamDNS         = "urn:api-proxy:problem:upstream-dns"
+	TypeUpstreamTLS         = "urn:api-proxy:problem:upstream-tls"
+	TypeUpstreamCancelled   = "urn:api-proxy:problem:upstream-cancelled"
+	TypeBadGateway          = "urn:api-proxy:problem:bad-gateway"
+	TypeInsufficientScope   = "urn:api-proxy:problem:insufficient-scope"
+)
+
+// Problem is an RFC 7807 problem details body, extended with the id of the request that produced it
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// New builds a problem of the default type, titled with the status text
+func New(status int, detail string) *Problem {
+	return &Problem{
+		Type:   TypeDefault,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Write sends an about:blank problem with the given status and a caller-safe detail message
+func Write(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	WriteProblem(w, r, New(status, detail))
+}
+
+// WriteProblem fills in the instance and request id from the request (r may be nil) and writes the problem
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if r != nil {
+		p.Instance = r.URL.Path
+		p.RequestID = RequestID(r.Context())
+	}
+
+	if p.RequestID == "" {
+		p.RequestID = w.Header().Get(RequestIDHeader)
+	}
+
+	b, err := json.Marshal(p)
+
+	if err != nil {
+		slog.Error("error marshalling problem details", "error", err)
+		w.WriteHeader(p.Status)
+		return
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write(b)
+}
+
+// WithRequestID stores the request id on the context so problems and logs can reference it
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestID returns the id assigned to the request, or an empty string if none was assigned
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
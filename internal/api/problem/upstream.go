@@ -0,0 +1,71 @@
+package problem
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// FromUpstreamError classifies an error returned while calling a backend. The returned problem only carries
+// a generic description, the error itself is meant for the logs.
+func FromUpstreamError(err error) *Problem {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return upstreamProblem(http.StatusBadGateway, TypeUpstreamCancelled, "the upstream request was cancelled")
+	case isTimeout(err):
+		return upstreamProblem(http.StatusGatewayTimeout, TypeUpstreamTimeout, "the upstream service did not respond in time")
+	case isDNSError(err):
+		return upstreamProblem(http.StatusBadGateway, TypeUpstreamDNS, "the upstream service could not be resolved")
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return upstreamProblem(http.StatusServiceUnavailable, TypeUpstreamUnavailable, "the upstream service refused the connection")
+	case isTLSError(err):
+		return upstreamProblem(http.StatusBadGateway, TypeUpstreamTLS, "a secure connection to the upstream service could not be established")
+	default:
+		return upstreamProblem(http.StatusBadGateway, TypeBadGateway, "the upstream service could not be reached")
+	}
+}
+
+func upstreamProblem(status int, problemType, detail string) *Problem {
+	return &Problem{
+		Type:   problemType,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func isDNSError(err error) bool {
+	var dnsErr *net.DNSError
+
+	return errors.As(err, &dnsErr)
+}
+
+func isTLSError(err error) bool {
+	var recordHeaderErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verificationErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateInvalidErr x509.CertificateInvalidError
+
+	return errors.As(err, &recordHeaderErr) ||
+		errors.As(err, &alertErr) ||
+		errors.As(err, &verificationErr) ||
+		errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &certificateInvalidErr)
+}
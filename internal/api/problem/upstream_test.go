@@ -0,0 +1,81 @@
+package problem
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestFromUpstreamError(t *testing.T) {
+	scenarios := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedType   string
+	}{
+		{
+			name:           "cancelled",
+			err:            &url.Error{Op: "Get", URL: "http://users-svc", Err: context.Canceled},
+			expectedStatus: http.StatusBadGateway,
+			expectedType:   TypeUpstreamCancelled,
+		},
+		{
+			name:           "deadline exceeded",
+			err:            fmt.Errorf("proxying: %w", context.DeadlineExceeded),
+			expectedStatus: http.StatusGatewayTimeout,
+			expectedType:   TypeUpstreamTimeout,
+		},
+		{
+			name:           "dial timeout",
+			err:            &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded},
+			expectedStatus: http.StatusGatewayTimeout,
+			expectedType:   TypeUpstreamTimeout,
+		},
+		{
+			name:           "dns",
+			err:            &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "users-svc", IsNotFound: true}},
+			expectedStatus: http.StatusBadGateway,
+			expectedType:   TypeUpstreamDNS,
+		},
+		{
+			name:           "connection refused",
+			err:            &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedType:   TypeUpstreamUnavailable,
+		},
+		{
+			name:           "tls unknown authority",
+			err:            &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}},
+			expectedStatus: http.StatusBadGateway,
+			expectedType:   TypeUpstreamTLS,
+		},
+		{
+			name:           "other",
+			err:            errors.New("unexpected EOF"),
+			expectedStatus: http.StatusBadGateway,
+			expectedType:   TypeBadGateway,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			actual := FromUpstreamError(scenario.err)
+
+			if scenario.expectedStatus != actual.Status {
+				t.Fatalf("expected status %d, got %d", scenario.expectedStatus, actual.Status)
+			}
+
+			if scenario.expectedType != actual.Type {
+				t.Fatalf("expected type %s, got %s", scenario.expectedType, actual.Type)
+			}
+		})
+	}
+}
@@ -2,67 +2,415 @@ package api
 
 import (
 	"api-proxy/internal/api/middleware"
-	"io"
+	"api-proxy/internal/api/problem"
+	"api-proxy/internal/breaker"
+	"api-proxy/internal/coalesce"
+	"api-proxy/internal/forwarded"
+	"api-proxy/internal/httpcache"
+	"api-proxy/internal/identity"
+	"api-proxy/internal/mirror"
+	"api-proxy/internal/model"
+	"api-proxy/internal/retry"
+	"api-proxy/internal/upstream"
+	"api-proxy/internal/variant"
+	"api-proxy/internal/websocket"
+	"context"
+	"errors"
 	"log/slog"
+	"math"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"time"
 )
 
+type proxyContextKey string
+
+const (
+	targetURLKey     proxyContextKey = "target_url"
+	poolSelectionKey proxyContextKey = "pool_selection"
+	breakerCallKey   proxyContextKey = "breaker_call"
+	cacheKeyKey      proxyContextKey = "cache_key"
+	shadowKey        proxyContextKey = "shadow"
+)
+
+// breakerCall reports the outcome of a call the route's circuit breaker let through
+type breakerCall struct {
+	done  func(outcome breaker.Outcome, elapsed time.Duration)
+	start time.Time
+}
+
+// poolSelection remembers which pool target a request was sent to so its outcome can be reported back. A retry moves
+// it onto another target, tried lists every target the request went to and release ends the current one's in-flight
+// count.
+type poolSelection struct {
+	pool       *upstream.Pool
+	key        string
+	target     *upstream.PoolTarget
+	tried      []*upstream.PoolTarget
+	release    func()
+	requestURL *url.URL
+}
+
 type ProxyHandler struct {
+	reverseProxy *httputil.ReverseProxy
+	transports   *upstream.Transports
+	forwarder    *forwarded.Forwarder
+	identities   *identity.Injector
+	credentials  *upstream.Credentials
+	pools        *upstream.Pools
+	breakers     *breaker.Breakers
+	retrier      *retry.Retrier
+	websockets   *websocket.Connections
+	responses    *httpcache.Cache
+	coalescer    *coalesce.Group
+	mirrors      *mirror.Mirror
 }
 
-func NewProxyHandler() *ProxyHandler {
-	return &ProxyHandler{}
+func NewProxyHandler(
+	transports *upstream.Transports,
+	forwarder *forwarded.Forwarder,
+	identities *identity.Injector,
+	credentials *upstream.Credentials,
+	pools *upstream.Pools,
+	breakers *breaker.Breakers,
+	retrier *retry.Retrier,
+	websockets *websocket.Connections,
+	responses *httpcache.Cache,
+	coalescer *coalesce.Group,
+	mirrors *mirror.Mirror,
+) *ProxyHandler {
+	ph := &ProxyHandler{
+		transports:  transports,
+		forwarder:   forwarder,
+		identities:  identities,
+		credentials: credentials,
+		pools:       pools,
+		breakers:    breakers,
+		retrier:     retrier,
+		websockets:  websockets,
+		responses:   responses,
+		coalescer:   coalescer,
+		mirrors:     mirrors,
+	}
+
+	// FlushInterval is left at zero: the reverse proxy already flushes text/event-stream and
+	// chunked (unknown length) responses after every write, everything else is copied in chunks.
+	// Upgrade requests keep their Upgrade and Connection headers, a 101 from the backend makes the
+	// reverse proxy hijack the client connection and copy bytes both ways until either side closes.
+	ph.reverseProxy = &httputil.ReverseProxy{
+		Rewrite:      ph.rewrite,
+		Transport:    roundTripperFunc(ph.roundTrip),
+		ErrorHandler: ph.handleError,
+	}
+
+	return ph
 }
 
+// ServeHTTP streams the request to the matched route's backend and the response back to the caller.
+// The outbound request is bound to the inbound request's context so a client disconnect cancels it.
+// Upgraded connections count against the caller's service account limit and are closed when idle
+// instead of at the route's timeout.
 func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	matchedRoute := middleware.MatchedRoute(r)
-	url := matchedRoute.BackendURL + r.URL.RequestURI()
-	body := r.Body
-	defer body.Close()
+	caller, _ := middleware.Identity(r)
+	backendURL, upstreamID := matchedRoute.BackendURL, matchedRoute.UpstreamID
+	ctx := r.Context()
+	upgrade := websocket.IsUpgrade(r)
 
-	request, err := http.NewRequest(r.Method, url, body)
+	if upgrade && caller != nil {
+		release, ok := ph.websockets.Acquire(caller.ServiceAccountID)
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		if !ok {
+			slog.Info("limiting upgraded connections", "org_id", caller.OrgID, "service_account_id", caller.ServiceAccountID)
+			problem.Write(w, r, http.StatusTooManyRequests, "too many open websocket connections for this service account")
+			return
+		}
+
+		defer release()
+	}
+
+	if upgrade {
+		w = websocket.WithIdleTimeout(w, ph.websockets.IdleTimeoutFor(matchedRoute))
+	}
+
+	// A route with variants sends the request to the backend of the variant picked for the caller
+	var variantName string
+
+	if v := variant.Pick(matchedRoute, r, caller); v != nil {
+		backendURL, upstreamID, variantName = v.BackendURL, v.UpstreamID, v.Name
+		middleware.SetVariant(r, v.Name)
+	}
+
+	// The cache key is built from the inbound request, before identity and credentials rewrite its headers
+	if policy := httpcache.PolicyFor(matchedRoute); policy.Enabled && r.Method == http.MethodGet && !upgrade {
+		ctx = context.WithValue(ctx, cacheKeyKey, policy.KeyFor(r, caller, variantName))
+	}
+
+	if b := ph.breakers.Get(matchedRoute.ID, breaker.SettingsFor(matchedRoute)); b != nil {
+		done, retryAfter, ok := b.Allow()
+
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			problem.Write(w, r, http.StatusServiceUnavailable, "the circuit breaker for this route is open")
+			return
+		}
+
+		// Anything that stops the request before it reaches the backend leaves the breaker's counts alone
+		defer done(breaker.Ignored, 0)
+
+		ctx = context.WithValue(ctx, breakerCallKey, &breakerCall{done: done, start: time.Now()})
+	}
+
+	if upstreamID != nil {
+		selection, err := ph.pickTarget(*upstreamID, caller)
+
+		if err != nil {
+			slog.Error("unable to pick an upstream target for route", "route_id", matchedRoute.ID, "upstream_id", *upstreamID, "error", err)
+			problem.Write(w, r, http.StatusServiceUnavailable, "no upstream targets are available")
+			return
+		}
+
+		selection.release = selection.target.Acquire()
+		selection.requestURL = r.URL
+		defer func() { selection.release() }()
+
+		backendURL = selection.target.URL
+		ctx = context.WithValue(ctx, poolSelectionKey, selection)
 	}
 
-	request.Header = r.Header
+	target, err := upstream.Target(backendURL, matchedRoute, r.URL, middleware.PathParams(r))
 
-	response, err := http.DefaultClient.Do(request)
+	if errors.Is(err, upstream.ErrDotSegment) {
+		problem.Write(w, r, http.StatusBadRequest, "path parameters must not be . or .. segments")
+		return
+	}
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Error("invalid backend url for route", "route_id", matchedRoute.ID, "backend_url", backendURL, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "")
 		return
 	}
-	defer response.Body.Close()
 
-	err = writeResponse(w, response)
+	if err := ph.identities.Apply(r.Header, matchedRoute.IdentityMode, caller, target.Host); err != nil {
+		slog.Error("unable to apply identity for route", "route_id", matchedRoute.ID, "identity_mode", matchedRoute.IdentityMode, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "")
+		return
+	}
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := ph.credentials.Apply(r.Context(), r.Header, matchedRoute.UpstreamAuth); err != nil {
+		slog.Error("unable to obtain upstream credentials for route", "route_id", matchedRoute.ID, "error", err)
+		problem.Write(w, r, http.StatusBadGateway, "upstream credentials could not be obtained")
 		return
 	}
+
+	ctx = context.WithValue(ctx, targetURLKey, target)
+
+	if !upgrade && mirror.Sampled(matchedRoute) {
+		if shadow := ph.shadow(r, matchedRoute, caller); shadow != nil {
+			ctx = context.WithValue(ctx, shadowKey, shadow)
+			defer shadow.Fire()
+		}
+	}
+
+	if timeout := ph.transports.SettingsFor(matchedRoute).Timeout; timeout > 0 && !upgrade {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ph.reverseProxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// pickTarget chooses an available target from the upstream pool, consistent hashing keeps an org on the same target
+func (ph *ProxyHandler) pickTarget(upstreamID int, caller *identity.Identity) (*poolSelection, error) {
+	pool := ph.pools.Get(upstreamID)
+
+	if pool == nil {
+		return nil, upstream.ErrNoTargets
+	}
+
+	key := ""
+
+	if caller != nil {
+		key = strconv.Itoa(caller.OrgID)
+	}
+
+	target, err := pool.Pick(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &poolSelection{pool: pool, key: key, target: target, tried: []*upstream.PoolTarget{target}}, nil
 }
 
-func writeResponse(w http.ResponseWriter, response *http.Response) error {
-	responseBody, err := io.ReadAll(response.Body)
+// retarget moves a retry onto a target of the pool the request has not been sent to yet, releasing the one the
+// previous attempt went to. The retry stays on its target when the pool has no other one available.
+func (ph *ProxyHandler) retarget(attempt *http.Request, selection *poolSelection) *http.Request {
+	next, err := selection.pool.Pick(selection.key, selection.tried...)
 
 	if err != nil {
-		return err
+		return attempt
 	}
 
-	for key, values := range response.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
+	matchedRoute := middleware.MatchedRoute(attempt)
+	target, err := upstream.Target(next.URL, matchedRoute, selection.requestURL, middleware.PathParams(attempt))
+
+	if err != nil {
+		slog.Error("invalid backend url for route", "route_id", matchedRoute.ID, "backend_url", next.URL, "error", err)
+		return attempt
+	}
+
+	retried := attempt.Clone(attempt.Context())
+	retried.URL = target
+
+	// Identity tokens are minted for the backend host, the new target gets its own
+	if matchedRoute.IdentityMode == identity.ModeToken && target.Host != attempt.URL.Host {
+		caller, _ := middleware.Identity(attempt)
+
+		if err := ph.identities.Apply(retried.Header, matchedRoute.IdentityMode, caller, target.Host); err != nil {
+			return attempt
+		}
+
+		if err := ph.credentials.Apply(retried.Context(), retried.Header, matchedRoute.UpstreamAuth); err != nil {
+			return attempt
 		}
+	}
+
+	selection.release()
+	selection.target, selection.release = next, next.Acquire()
+	selection.tried = append(selection.tried, next)
+
+	return retried
+}
+
+// shadow prepares the copy of a sampled request for a target of the route's mirror upstream, it returns nil when
+// no target is available
+func (ph *ProxyHandler) shadow(r *http.Request, matchedRoute *model.Route, caller *identity.Identity) *mirror.Shadow {
+	selection, err := ph.pickTarget(*matchedRoute.MirrorUpstreamID, caller)
+
+	if err != nil {
+		slog.Debug("no mirror target available for route", "route_id", matchedRoute.ID, "upstream_id", *matchedRoute.MirrorUpstreamID, "error", err)
+		return nil
+	}
+
+	target, err := upstream.Target(selection.target.URL, matchedRoute, r.URL, middleware.PathParams(r))
+
+	if err != nil {
+		slog.Error("invalid mirror target for route", "route_id", matchedRoute.ID, "target", selection.target.URL, "error", err)
+		return nil
+	}
+
+	transport := ph.transports.Get(matchedRoute)
+
+	return ph.mirrors.Shadow(matchedRoute, r.URL.Path, target, transport)
+}
+
+// rewrite points the outbound request at the backend and sets the forwarding headers. Hop-by-hop headers,
+// including any listed in Connection, and inbound forwarding headers have already been removed by the reverse proxy.
+func (ph *ProxyHandler) rewrite(pr *httputil.ProxyRequest) {
+	pr.Out.URL = pr.In.Context().Value(targetURLKey).(*url.URL)
+	pr.Out.Host = ""
+
+	ph.forwarder.Apply(pr)
+}
+
+// roundTrip answers GET requests on routes with caching enabled from the response cache when it can, everything
+// else is sent to the backend. A sampled request on a mirrored route is copied on its way out.
+func (ph *ProxyHandler) roundTrip(r *http.Request) (*http.Response, error) {
+	if shadow, ok := r.Context().Value(shadowKey).(*mirror.Shadow); ok {
+		shadow.Capture(r)
+	}
+
+	if key, ok := r.Context().Value(cacheKeyKey).(string); ok {
+		return ph.responses.Do(r, key, httpcache.PolicyFor(middleware.MatchedRoute(r)), ph.coalesce)
+	}
+
+	return ph.coalesce(r)
+}
+
+// coalesce collapses concurrent identical safe requests on routes with coalescing enabled onto one backend call.
+// Every caller still goes through its own request log entry, only the backend call is shared.
+func (ph *ProxyHandler) coalesce(r *http.Request) (*http.Response, error) {
+	policy := coalesce.PolicyFor(middleware.MatchedRoute(r))
 
+	if !policy.Enabled || !coalesce.Safe(r.Method) || websocket.IsUpgrade(r) {
+		return ph.send(r)
 	}
-	w.WriteHeader(response.StatusCode)
 
-	if _, err := w.Write(responseBody); err != nil {
-		slog.Error("error writing response body for proxied route", "error", err)
+	caller, _ := middleware.Identity(r)
+
+	return ph.coalescer.Do(r, policy.KeyFor(r, caller), policy, ph.send)
+}
+
+// send sends the outbound request through the transport dedicated to the matched route's backend, retrying
+// under the route's retry policy. Every attempt's 5xx response or transport error counts towards pool target
+// ejection, only the final result counts towards the route's circuit breaker. A caller hanging up counts for neither.
+// Retries go to another target of the pool when it has one. A 401 drops the route's cached OAuth2 token.
+func (ph *ProxyHandler) send(r *http.Request) (*http.Response, error) {
+	matchedRoute := middleware.MatchedRoute(r)
+	transport := ph.transports.Get(matchedRoute)
+	selection, _ := r.Context().Value(poolSelectionKey).(*poolSelection)
+	attempts := 0
+
+	response, err := ph.retrier.Do(r, retry.PolicyFor(matchedRoute), func(attempt *http.Request) (*http.Response, error) {
+		if attempts++; attempts > 1 && selection != nil {
+			attempt = ph.retarget(attempt, selection)
+		}
+
+		response, err := transport.RoundTrip(attempt)
+
+		if err == nil && response.StatusCode == http.StatusUnauthorized {
+			ph.credentials.Invalidate(attempt.Header, matchedRoute.UpstreamAuth)
+		}
+
+		if selection != nil && !errors.Is(attempt.Context().Err(), context.Canceled) {
+			selection.pool.Report(selection.target, failed(response, err))
+		}
+
+		return response, err
+	})
+
+	if call, ok := r.Context().Value(breakerCallKey).(*breakerCall); ok {
+		outcome := breaker.Success
+
+		switch {
+		case errors.Is(r.Context().Err(), context.Canceled):
+			outcome = breaker.Ignored
+		case failed(response, err):
+			outcome = breaker.Failure
+		}
+
+		call.done(outcome, time.Since(call.start))
 	}
 
-	return nil
+	return response, err
+}
+
+func failed(response *http.Response, err error) bool {
+	return err != nil || response.StatusCode >= http.StatusInternalServerError
+}
+
+// handleError maps transport failures onto problem responses, the underlying error is only logged
+func (ph *ProxyHandler) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	p := problem.FromUpstreamError(err)
+
+	slog.Error(
+		"error proxying request",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"request_id", problem.RequestID(r.Context()),
+		"status", p.Status,
+		"error", err,
+	)
+
+	problem.WriteProblem(w, r, p)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
 }
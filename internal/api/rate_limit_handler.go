@@ -2,6 +2,7 @@ package api
 
 import (
 	"api-proxy/internal/api/middleware"
+	"api-proxy/internal/api/problem"
 	"api-proxy/internal/model"
 	"log/slog"
 	"net/http"
@@ -46,7 +47,7 @@ func (rlh *RateLimitHandler) handleGetRateLimits(w http.ResponseWriter, r *http.
 
 	if orgIdParamErr != nil || saIDParamErr != nil {
 		slog.Error("either orgId or serviceAccountId was invalid", "org_id", orgIdParamErr, "service_account_id", saIDParamErr)
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "")
 		return
 	}
 
@@ -58,7 +59,8 @@ func (rlh *RateLimitHandler) handleGetRateLimits(w http.ResponseWriter, r *http.
 	active, err := rlh.dataStore.FindActiveByFilter(filter)
 
 	if err != nil {
-		http.Error(w, "unexpected error.", http.StatusInternalServerError)
+		slog.Error("error finding active rate limits", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
 		return
 	}
 
@@ -69,19 +71,20 @@ func (rlh *RateLimitHandler) handleGetRateLimit(w http.ResponseWriter, r *http.R
 	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
 
 	if strconvErr != nil {
-		http.Error(w, "invalid id in the uri", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
 		return
 	}
 
 	rateLimit, err := rlh.dataStore.FindByID(uriId)
 
 	if err != nil {
-		http.Error(w, "unexpected error.", http.StatusInternalServerError)
+		slog.Error("error finding rate limit", "id", uriId, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
 		return
 	}
 
 	if rateLimit == nil {
-		http.Error(w, "rate limit not found", http.StatusNotFound)
+		problem.Write(w, r, http.StatusNotFound, "rate limit not found")
 		return
 	}
 
@@ -92,14 +95,15 @@ func (rlh *RateLimitHandler) handleCreateRateLimit(w http.ResponseWriter, r *htt
 	rateLimit, err := decodeJSON[model.RateLimit](r)
 
 	if err != nil {
-		http.Error(w, "unable to read json request body", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
 		return
 	}
 
 	created, err := rlh.dataStore.Insert(rateLimit)
 
 	if err != nil {
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		slog.Error("error inserting rate limit", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
@@ -110,26 +114,27 @@ func (rlh *RateLimitHandler) handleUpdateRateLimit(w http.ResponseWriter, r *htt
 	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
 
 	if strconvErr != nil {
-		http.Error(w, "invalid id in the uri", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
 		return
 	}
 
 	rateLimit, err := decodeJSON[model.RateLimit](r)
 
 	if err != nil {
-		http.Error(w, "unable to read json request body", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
 		return
 	}
 
 	if rateLimit.ID != uriId {
-		http.Error(w, "id in uri must match request body id", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "id in uri must match request body id")
 		return
 	}
 
 	updated, err := rlh.dataStore.Update(rateLimit)
 
 	if err != nil {
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		slog.Error("error updating rate limit", "id", rateLimit.ID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"api-proxy/internal/api/problem"
 	"api-proxy/internal/model"
 	"log/slog"
 	"net/http"
@@ -36,22 +37,22 @@ func (rh *RequestHandler) HandleGetRequests(w http.ResponseWriter, r *http.Reque
 	to, toErr := time.Parse(time.RFC3339, r.URL.Query().Get("to"))
 
 	if fromErr != nil || toErr != nil {
-		slog.Error("unable to parse url param(s)", "from", from, "to", to)
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		slog.Error("unable to parse url param(s)", "from", fromErr, "to", toErr)
+		problem.Write(w, r, http.StatusBadRequest, "from and to must be RFC 3339 timestamps")
 		return
 	}
 
 	if to.Before(from) {
 		slog.Error("invalid ordering of params", "from", from, "to", to)
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "to must not be before from")
 		return
 	}
 
 	requests, err := rh.datastore.FindBetween(from, to)
 
 	if err != nil {
-		slog.Error("unable to find requests", "from", from, "to", to)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		slog.Error("unable to find requests", "from", from, "to", to, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "")
 		return
 	}
 
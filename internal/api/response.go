@@ -1,7 +1,9 @@
 package api
 
 import (
+	"api-proxy/internal/api/problem"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 )
 
@@ -9,7 +11,8 @@ func writeJSON(w http.ResponseWriter, data any, statusCode int) {
 	b, err := json.Marshal(data)
 
 	if err != nil {
-		http.Error(w, "error while writing json response body", http.StatusInternalServerError)
+		slog.Error("error marshalling json response body", "error", err)
+		problem.Write(w, nil, http.StatusInternalServerError, "")
 		return
 	}
 
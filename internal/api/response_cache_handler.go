@@ -0,0 +1,77 @@
+package api
+
+import (
+	"api-proxy/internal/api/middleware"
+	"api-proxy/internal/api/problem"
+	"api-proxy/internal/httpcache"
+	"api-proxy/internal/model"
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ResponsePurger removes cached responses by key prefix
+type ResponsePurger interface {
+	Purge(ctx context.Context, prefix string) (int, error)
+}
+
+type ResponseCacheHandler struct {
+	auditLogger middleware.AuditLogger
+	purger      ResponsePurger
+}
+
+func NewResponseCacheHandler(auditLogger middleware.AuditLogger, purger ResponsePurger) *ResponseCacheHandler {
+	return &ResponseCacheHandler{
+		auditLogger: auditLogger,
+		purger:      purger,
+	}
+}
+
+func (rch *ResponseCacheHandler) Router() http.Handler {
+	r := chi.NewRouter()
+
+	r.With(middleware.LogAuditable(rch.auditLogger, model.RESPONSE_CACHE, model.PURGE)).Delete("/", rch.handlePurge)
+
+	return r
+}
+
+type purgeResponse struct {
+	Prefix string `json:"prefix"`
+	Purged int    `json:"purged"`
+}
+
+// handlePurge removes every cached response of a route, or every one whose key starts with a prefix such as
+// route_3:/api/v1/countries. Cache keys start with route_<id>: followed by the request path.
+func (rch *ResponseCacheHandler) handlePurge(w http.ResponseWriter, r *http.Request) {
+	routeID := r.URL.Query().Get("route_id")
+	prefix := r.URL.Query().Get("prefix")
+
+	if (routeID == "") == (prefix == "") {
+		problem.Write(w, r, http.StatusBadRequest, "exactly one of route_id or prefix is required")
+		return
+	}
+
+	if routeID != "" {
+		id, err := strconv.Atoi(routeID)
+
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "invalid route_id")
+			return
+		}
+
+		prefix = httpcache.RoutePrefix(id)
+	}
+
+	purged, err := rch.purger.Purge(r.Context(), prefix)
+
+	if err != nil {
+		slog.Error("error purging the response cache", "prefix", prefix, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	writeJSON(w, purgeResponse{Prefix: prefix, Purged: purged}, http.StatusOK)
+}
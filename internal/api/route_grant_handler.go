@@ -0,0 +1,194 @@
+package api
+
+import (
+	"api-proxy/internal/api/middleware"
+	"api-proxy/internal/api/problem"
+	"api-proxy/internal/model"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var ErrInvalidRouteGrant = errors.New("a route grant needs an org_id and exactly one of route_id or route_group")
+
+type RouteGrantDataStorer interface {
+	FindActiveByFilter(filter *model.RouteGrantFilter) ([]*model.RouteGrant, error)
+	FindByID(id int) (*model.RouteGrant, error)
+	Insert(grant *model.RouteGrant) (*model.RouteGrant, error)
+	Update(grant *model.RouteGrant) (*model.RouteGrant, error)
+}
+
+// GrantSetter replaces the grants the proxy enforces
+type GrantSetter interface {
+	Set(grants []*model.RouteGrant)
+}
+
+type RouteGrantHandler struct {
+	auditLogger middleware.AuditLogger
+	dataStore   RouteGrantDataStorer
+	grants      GrantSetter
+}
+
+func NewRouteGrantHandler(auditLogger middleware.AuditLogger, routeGrantDataStore RouteGrantDataStorer, grants GrantSetter) *RouteGrantHandler {
+	return &RouteGrantHandler{
+		auditLogger: auditLogger,
+		dataStore:   routeGrantDataStore,
+		grants:      grants,
+	}
+}
+
+func (rgh *RouteGrantHandler) Router() http.Handler {
+	r := chi.NewRouter()
+
+	r.Get("/", rgh.handleGetRouteGrants)
+	r.Get("/{id}", rgh.handleGetRouteGrant)
+	r.With(middleware.LogAuditable(rgh.auditLogger, model.ROUTE_GRANT, model.CREATE)).Post("/", rgh.handleCreateRouteGrant)
+	r.With(middleware.LogAuditable(rgh.auditLogger, model.ROUTE_GRANT, model.UPDATE)).Put("/{id}", rgh.handleUpdateRouteGrant)
+
+	return r
+}
+
+func (rgh *RouteGrantHandler) handleGetRouteGrants(w http.ResponseWriter, r *http.Request) {
+	orgID, orgIdParamErr := queryParam("orgId", r, toIntParam)
+	serviceAccountID, saIDParamErr := queryParam("serviceAccountId", r, toIntParam)
+	routeID, routeIDParamErr := queryParam("routeId", r, toIntParam)
+
+	if orgIdParamErr != nil || saIDParamErr != nil || routeIDParamErr != nil {
+		slog.Error("either orgId, serviceAccountId or routeId was invalid", "org_id", orgIdParamErr, "service_account_id", saIDParamErr, "route_id", routeIDParamErr)
+		problem.Write(w, r, http.StatusBadRequest, "")
+		return
+	}
+
+	filter := &model.RouteGrantFilter{
+		OrgID:            orgID,
+		ServiceAccountID: serviceAccountID,
+		RouteID:          routeID,
+		RouteGroup:       r.URL.Query().Get("routeGroup"),
+	}
+
+	active, err := rgh.dataStore.FindActiveByFilter(filter)
+
+	if err != nil {
+		slog.Error("error finding active route grants", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
+		return
+	}
+
+	writeJSON(w, active, http.StatusOK)
+}
+
+func (rgh *RouteGrantHandler) handleGetRouteGrant(w http.ResponseWriter, r *http.Request) {
+	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if strconvErr != nil {
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
+		return
+	}
+
+	grant, err := rgh.dataStore.FindByID(uriId)
+
+	if err != nil {
+		slog.Error("error finding route grant", "id", uriId, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
+		return
+	}
+
+	if grant == nil {
+		problem.Write(w, r, http.StatusNotFound, "route grant not found")
+		return
+	}
+
+	writeJSON(w, grant, http.StatusOK)
+}
+
+func (rgh *RouteGrantHandler) handleCreateRouteGrant(w http.ResponseWriter, r *http.Request) {
+	grant, err := decodeJSON[model.RouteGrant](r)
+
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
+		return
+	}
+
+	if err := validateRouteGrant(grant); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := rgh.dataStore.Insert(grant)
+
+	if err != nil {
+		slog.Error("error inserting route grant", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	rgh.refreshGrants()
+
+	writeJSON(w, created, http.StatusCreated)
+}
+
+func (rgh *RouteGrantHandler) handleUpdateRouteGrant(w http.ResponseWriter, r *http.Request) {
+	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if strconvErr != nil {
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
+		return
+	}
+
+	grant, err := decodeJSON[model.RouteGrant](r)
+
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
+		return
+	}
+
+	if grant.ID != uriId {
+		problem.Write(w, r, http.StatusBadRequest, "id in uri must match request body id")
+		return
+	}
+
+	if err := validateRouteGrant(grant); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updated, err := rgh.dataStore.Update(grant)
+
+	if err != nil {
+		slog.Error("error updating route grant", "id", grant.ID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	rgh.refreshGrants()
+
+	writeJSON(w, updated, http.StatusOK)
+}
+
+// refreshGrants reloads the active grants into the proxy's cache so a change is enforced right away instead of at the
+// next sync. A failed reload is logged, the next sync picks the change up.
+func (rgh *RouteGrantHandler) refreshGrants() {
+	grants, err := rgh.dataStore.FindActiveByFilter(nil)
+
+	if err != nil {
+		slog.Error("error refreshing the route grant cache", "error", err)
+		return
+	}
+
+	rgh.grants.Set(grants)
+}
+
+// validateRouteGrant checks that the grant names an org and targets either a single route or a route group
+func validateRouteGrant(grant *model.RouteGrant) error {
+	hasRoute := grant.RouteID != nil
+	hasGroup := grant.RouteGroup != nil && *grant.RouteGroup != ""
+
+	if grant.OrgID <= 0 || hasRoute == hasGroup {
+		return ErrInvalidRouteGrant
+	}
+
+	return nil
+}
@@ -2,9 +2,22 @@ package api
 
 import (
 	"api-proxy/internal/api/middleware"
+	"api-proxy/internal/api/problem"
+	"api-proxy/internal/coalesce"
+	"api-proxy/internal/httpcache"
+	"api-proxy/internal/identity"
 	"api-proxy/internal/model"
+	"api-proxy/internal/retry"
+	"api-proxy/internal/routing"
+	"api-proxy/internal/scope"
+	"api-proxy/internal/upstream"
+	"api-proxy/internal/variant"
+	"errors"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -16,15 +29,39 @@ type RouteDataStorer interface {
 	Update(route *model.Route) (*model.Route, error)
 }
 
+var ErrNegativeRouteSetting = errors.New("timeouts, connection limits, circuit breaker and retry settings must not be negative")
+var ErrInvalidBreakerRate = errors.New("circuit breaker rates are percentages up to 100 and a slow call rate needs breaker_slow_call_ms")
+var ErrInvalidRetryPolicy = errors.New("retry_status_codes must be 5xx or 429 status codes, retry_on must only contain connect, reset or timeout, and retry_max_backoff_ms must not be below retry_backoff_ms")
+var ErrInvalidUpstreamProtocol = errors.New("upstream_protocol must be one of http1, h2 or h2c, h2 needs an https backend_url and h2c an http one")
+var ErrInvalidCacheKey = errors.New("cache_key parts must be query, org_id, service_account_id or header:<name>, and cache_key and cache_ttl_ms need cache_enabled on a GET route")
+var ErrInvalidCoalesceKey = errors.New("coalesce_key parts must be org_id or header:<name>, and need coalesce_enabled")
+var ErrInvalidMirror = errors.New("mirror_percent must be between 1 and 100 when mirror_upstream_id is set and 0 otherwise")
+var ErrInvalidIdentityMode = errors.New("identity_mode must be one of passthrough, headers or token")
+var ErrInvalidUpstreamAuth = errors.New("upstream_auth must be an api_key with api_key, basic with username and password, or oauth2 with token_url, client_id and client_secret")
+var ErrUnknownTemplateParam = errors.New("backend_url references a path parameter the pattern does not capture")
+var ErrInvalidStripPrefix = errors.New("strip_prefix must start with / and cannot be combined with a templated backend_url")
+var ErrBackendRequired = errors.New("a route, or each of its variants, must set exactly one of backend_url or upstream_id")
+var ErrInvalidVariants = errors.New("variants need at least two entries with unique lowercase names and non-negative weights adding up to more than 0, and leave the route's own backend_url and upstream_id unset")
+var ErrInvalidVariantStickyBy = errors.New("variant_sticky_by must be org_id or service_account_id")
+var ErrInvalidScopes = errors.New("scopes must be printable ascii without spaces, quotes or backslashes")
+var ErrUpstreamAuthConflictsWithIdentity = errors.New("upstream_auth cannot set Authorization on a route using the token identity mode")
+
+// UpstreamFinder looks up the upstream a route points at
+type UpstreamFinder interface {
+	FindByID(id int) (*model.Upstream, error)
+}
+
 type RouteHandler struct {
-	auditLogger middleware.AuditLogger
-	dataStore   RouteDataStorer
+	auditLogger    middleware.AuditLogger
+	dataStore      RouteDataStorer
+	upstreamFinder UpstreamFinder
 }
 
-func NewRouteHandler(auditLogger middleware.AuditLogger, routeDataStore RouteDataStorer) *RouteHandler {
+func NewRouteHandler(auditLogger middleware.AuditLogger, routeDataStore RouteDataStorer, upstreamFinder UpstreamFinder) *RouteHandler {
 	return &RouteHandler{
-		auditLogger: auditLogger,
-		dataStore:   routeDataStore,
+		auditLogger:    auditLogger,
+		dataStore:      routeDataStore,
+		upstreamFinder: upstreamFinder,
 	}
 }
 
@@ -35,6 +72,7 @@ func (rh *RouteHandler) Router() http.Handler {
 	r.Get("/{id}", rh.handleGetRoute)
 	r.With(middleware.LogAuditable(rh.auditLogger, model.ROUTE, model.CREATE)).Post("/", rh.handleCreateRoute)
 	r.With(middleware.LogAuditable(rh.auditLogger, model.ROUTE, model.UPDATE)).Put("/{id}", rh.handleUpdateRoute)
+	r.With(middleware.LogAuditable(rh.auditLogger, model.ROUTE, model.UPDATE)).Put("/{id}/weights", rh.handleUpdateWeights)
 
 	return r
 }
@@ -48,10 +86,15 @@ func (rh *RouteHandler) handleGetRoutes(w http.ResponseWriter, r *http.Request)
 	active, err := rh.dataStore.FindActiveByFilter(filter)
 
 	if err != nil {
-		http.Error(w, "unexpected error.", http.StatusInternalServerError)
+		slog.Error("error finding active routes", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
 		return
 	}
 
+	for _, route := range active {
+		redactUpstreamAuth(route)
+	}
+
 	writeJSON(w, active, http.StatusOK)
 }
 
@@ -59,22 +102,25 @@ func (rh *RouteHandler) handleGetRoute(w http.ResponseWriter, r *http.Request) {
 	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
 
 	if strconvErr != nil {
-		http.Error(w, "invalid id in the uri", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
 		return
 	}
 
 	route, err := rh.dataStore.FindByID(uriId)
 
 	if err != nil {
-		http.Error(w, "unexpected error.", http.StatusInternalServerError)
+		slog.Error("error finding route", "id", uriId, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
 		return
 	}
 
 	if route == nil {
-		http.Error(w, "route not found", http.StatusNotFound)
+		problem.Write(w, r, http.StatusNotFound, "route not found")
 		return
 	}
 
+	redactUpstreamAuth(route)
+
 	writeJSON(w, route, http.StatusOK)
 }
 
@@ -82,17 +128,29 @@ func (rh *RouteHandler) handleCreateRoute(w http.ResponseWriter, r *http.Request
 	route, err := decodeJSON[model.Route](r)
 
 	if err != nil {
-		http.Error(w, "unable to read json request body", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
+		return
+	}
+
+	if err := validateRoute(route); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !rh.checkUpstream(w, r, route) || !rh.checkDuplicate(w, r, route) {
 		return
 	}
 
 	created, err := rh.dataStore.Insert(route)
 
 	if err != nil {
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		slog.Error("error inserting route", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
+	redactUpstreamAuth(created)
+
 	writeJSON(w, created, http.StatusCreated)
 }
 
@@ -100,28 +158,452 @@ func (rh *RouteHandler) handleUpdateRoute(w http.ResponseWriter, r *http.Request
 	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
 
 	if strconvErr != nil {
-		http.Error(w, "invalid id in the uri", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
 		return
 	}
 
 	route, err := decodeJSON[model.Route](r)
 
 	if err != nil {
-		http.Error(w, "unable to read json request body", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
 		return
 	}
 
 	if route.ID != uriId {
-		http.Error(w, "id in uri must match request body id", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "id in uri must match request body id")
+		return
+	}
+
+	existing, err := rh.dataStore.FindByID(uriId)
+
+	if err != nil {
+		slog.Error("error finding route", "id", uriId, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	if existing == nil {
+		problem.Write(w, r, http.StatusNotFound, "route not found")
+		return
+	}
+
+	// The pattern is not updatable, check and echo the stored one rather than whatever was sent
+	route.Pattern = existing.Pattern
+	keepUpstreamSecrets(route.UpstreamAuth, existing.UpstreamAuth)
+
+	if err := validateRoute(route); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !rh.checkUpstream(w, r, route) || !rh.checkDuplicate(w, r, route) {
 		return
 	}
 
 	updated, err := rh.dataStore.Update(route)
 
 	if err != nil {
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		slog.Error("error updating route", "id", route.ID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
+	redactUpstreamAuth(updated)
+
 	writeJSON(w, updated, http.StatusOK)
 }
+
+// handleUpdateWeights changes the weights of some or all of a route's variants, the body maps variant names to their
+// new weight. It lets traffic move between variants step by step without resending the whole route.
+func (rh *RouteHandler) handleUpdateWeights(w http.ResponseWriter, r *http.Request) {
+	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if strconvErr != nil {
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
+		return
+	}
+
+	weights, err := decodeJSON[map[string]int](r)
+
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
+		return
+	}
+
+	route, err := rh.dataStore.FindByID(uriId)
+
+	if err != nil {
+		slog.Error("error finding route", "id", uriId, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	if route == nil {
+		problem.Write(w, r, http.StatusNotFound, "route not found")
+		return
+	}
+
+	for name, weight := range *weights {
+		i := slices.IndexFunc(route.Variants, func(v model.RouteVariant) bool { return v.Name == name })
+
+		if i < 0 {
+			problem.Write(w, r, http.StatusBadRequest, "the route has no variant named "+name)
+			return
+		}
+
+		route.Variants[i].Weight = weight
+	}
+
+	if !validVariants(route) {
+		problem.Write(w, r, http.StatusBadRequest, ErrInvalidVariants.Error())
+		return
+	}
+
+	updated, err := rh.dataStore.Update(route)
+
+	if err != nil {
+		slog.Error("error updating route weights", "id", route.ID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	redactUpstreamAuth(updated)
+
+	writeJSON(w, updated, http.StatusOK)
+}
+
+// upstreamReference is a route field pointing at an upstream
+type upstreamReference struct {
+	field string
+	id    *int
+}
+
+// checkUpstream writes a bad request when the route points at an upstream, or mirrors to one, that does not exist
+// or is inactive and reports whether the caller should carry on
+func (rh *RouteHandler) checkUpstream(w http.ResponseWriter, r *http.Request, route *model.Route) bool {
+	references := []upstreamReference{
+		{field: "upstream_id", id: route.UpstreamID},
+		{field: "mirror_upstream_id", id: route.MirrorUpstreamID},
+	}
+
+	for _, v := range route.Variants {
+		references = append(references, upstreamReference{field: "the upstream_id of variant " + v.Name, id: v.UpstreamID})
+	}
+
+	for _, reference := range references {
+		if reference.id == nil {
+			continue
+		}
+
+		found, err := rh.upstreamFinder.FindByID(*reference.id)
+
+		if err != nil {
+			slog.Error("error finding upstream", "id", *reference.id, "error", err)
+			problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+			return false
+		}
+
+		if found == nil || found.InactivatedAt != nil {
+			problem.Write(w, r, http.StatusBadRequest, reference.field+" does not reference an active upstream")
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkDuplicate writes a conflict when another active route matches exactly the same requests as route and
+// reports whether the caller should carry on
+func (rh *RouteHandler) checkDuplicate(w http.ResponseWriter, r *http.Request, route *model.Route) bool {
+	if route.InactivatedAt != nil {
+		return true
+	}
+
+	active, err := rh.dataStore.FindActiveByFilter(&model.RouteFilter{Method: route.Method})
+
+	if err != nil {
+		slog.Error("error finding active routes", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return false
+	}
+
+	others := slices.DeleteFunc(active, func(other *model.Route) bool { return other.ID == route.ID })
+	tree, _ := routing.Build(others)
+
+	if err := tree.Insert(route); errors.Is(err, routing.ErrDuplicateRoute) {
+		problem.Write(w, r, http.StatusConflict, "an active route with the same pattern, method, host and match conditions already exists")
+		return false
+	}
+
+	return true
+}
+
+func validateRoute(route *model.Route) error {
+	settings := []int{
+		route.ConnectTimeoutMs,
+		route.ResponseHeaderTimeoutMs,
+		route.IdleConnTimeoutMs,
+		route.TimeoutMs,
+		route.MaxIdleConns,
+		route.MaxConns,
+		route.BreakerErrorRatePercent,
+		route.BreakerSlowCallMs,
+		route.BreakerSlowCallRatePercent,
+		route.BreakerMinRequests,
+		route.BreakerWindowMs,
+		route.BreakerOpenMs,
+		route.BreakerHalfOpenRequests,
+		route.RetryMaxAttempts,
+		route.RetryBackoffMs,
+		route.RetryMaxBackoffMs,
+		route.WebSocketIdleTimeoutMs,
+		route.CacheTTLMs,
+	}
+
+	for _, setting := range settings {
+		if setting < 0 {
+			return ErrNegativeRouteSetting
+		}
+	}
+
+	if route.BreakerErrorRatePercent > 100 || route.BreakerSlowCallRatePercent > 100 {
+		return ErrInvalidBreakerRate
+	}
+
+	if route.BreakerSlowCallRatePercent > 0 && route.BreakerSlowCallMs == 0 {
+		return ErrInvalidBreakerRate
+	}
+
+	if !validRetryPolicy(route) {
+		return ErrInvalidRetryPolicy
+	}
+
+	if !validCachePolicy(route) {
+		return ErrInvalidCacheKey
+	}
+
+	if !validCoalescePolicy(route) {
+		return ErrInvalidCoalesceKey
+	}
+
+	if (route.MirrorUpstreamID == nil && route.MirrorPercent != 0) || (route.MirrorUpstreamID != nil && (route.MirrorPercent < 1 || route.MirrorPercent > 100)) {
+		return ErrInvalidMirror
+	}
+
+	if !upstream.ValidProtocol(route.UpstreamProtocol) {
+		return ErrInvalidUpstreamProtocol
+	}
+
+	if !identity.ValidMode(route.IdentityMode) {
+		return ErrInvalidIdentityMode
+	}
+
+	if len(route.Variants) > 0 && !validVariants(route) {
+		return ErrInvalidVariants
+	}
+
+	if !variant.ValidStickyBy(route.VariantStickyBy) {
+		return ErrInvalidVariantStickyBy
+	}
+
+	if !validScopes(route.RequiredScopes) {
+		return ErrInvalidScopes
+	}
+
+	for _, backend := range backends(route) {
+		if (backend.BackendURL == "") == (backend.UpstreamID == nil) {
+			return ErrBackendRequired
+		}
+
+		if !validUpstreamProtocol(route.UpstreamProtocol, backend.BackendURL) {
+			return ErrInvalidUpstreamProtocol
+		}
+	}
+
+	captured, err := routing.ParamNames(route.Pattern)
+
+	if err != nil {
+		return err
+	}
+
+	if err := routing.ValidateHost(route.Host); err != nil {
+		return err
+	}
+
+	for _, backend := range backends(route) {
+		for _, name := range upstream.TemplateParams(backend.BackendURL) {
+			if !slices.Contains(captured, name) {
+				return ErrUnknownTemplateParam
+			}
+		}
+
+		if route.StripPrefix != "" && (!strings.HasPrefix(route.StripPrefix, "/") || upstream.IsTemplate(backend.BackendURL)) {
+			return ErrInvalidStripPrefix
+		}
+	}
+
+	if route.IdentityMode == "" {
+		route.IdentityMode = identity.ModePassthrough
+	}
+
+	if route.UpstreamAuth == nil {
+		return nil
+	}
+
+	if !validUpstreamAuth(route.UpstreamAuth) {
+		return ErrInvalidUpstreamAuth
+	}
+
+	if route.IdentityMode == identity.ModeToken && setsAuthorization(route.UpstreamAuth) {
+		return ErrUpstreamAuthConflictsWithIdentity
+	}
+
+	return nil
+}
+
+func validCachePolicy(route *model.Route) bool {
+	if !route.CacheEnabled {
+		return len(route.CacheKey) == 0 && route.CacheTTLMs == 0
+	}
+
+	if route.Method != http.MethodGet {
+		return false
+	}
+
+	for _, part := range route.CacheKey {
+		if !httpcache.ValidKeyPart(part) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validCoalescePolicy(route *model.Route) bool {
+	if !route.CoalesceEnabled {
+		return len(route.CoalesceKey) == 0
+	}
+
+	for _, part := range route.CoalesceKey {
+		if !coalesce.ValidKeyPart(part) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// backends lists where the route sends its traffic, its variants or else the route's own backend
+func backends(route *model.Route) []model.RouteVariant {
+	if len(route.Variants) > 0 {
+		return route.Variants
+	}
+
+	return []model.RouteVariant{{BackendURL: route.BackendURL, UpstreamID: route.UpstreamID}}
+}
+
+func validVariants(route *model.Route) bool {
+	if len(route.Variants) < 2 || route.BackendURL != "" || route.UpstreamID != nil {
+		return false
+	}
+
+	names := make(map[string]bool, len(route.Variants))
+	total := 0
+
+	for _, v := range route.Variants {
+		if !variant.ValidName(v.Name) || names[v.Name] || v.Weight < 0 {
+			return false
+		}
+
+		names[v.Name] = true
+		total += v.Weight
+	}
+
+	return total > 0
+}
+
+func validUpstreamProtocol(protocol, backendURL string) bool {
+	switch {
+	case backendURL == "":
+		return true
+	case protocol == upstream.ProtocolH2:
+		return strings.HasPrefix(backendURL, "https://")
+	case protocol == upstream.ProtocolH2C:
+		return strings.HasPrefix(backendURL, "http://")
+	default:
+		return true
+	}
+}
+
+func validScopes(scopes []string) bool {
+	for _, s := range scopes {
+		if !scope.Valid(s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validRetryPolicy(route *model.Route) bool {
+	for _, code := range route.RetryStatusCodes {
+		if code != http.StatusTooManyRequests && (code < http.StatusInternalServerError || code > 599) {
+			return false
+		}
+	}
+
+	for _, class := range route.RetryOn {
+		if !retry.ValidClass(class) {
+			return false
+		}
+	}
+
+	return route.RetryMaxBackoffMs == 0 || route.RetryMaxBackoffMs >= route.RetryBackoffMs
+}
+
+func validUpstreamAuth(auth *model.UpstreamAuth) bool {
+	switch auth.Type {
+	case model.UpstreamAuthAPIKey:
+		return auth.APIKey != ""
+	case model.UpstreamAuthBasic:
+		return auth.Username != "" && auth.Password != ""
+	case model.UpstreamAuthOAuth2:
+		return auth.TokenURL != "" && auth.ClientID != "" && auth.ClientSecret != ""
+	default:
+		return false
+	}
+}
+
+func setsAuthorization(auth *model.UpstreamAuth) bool {
+	return auth.Type != model.UpstreamAuthAPIKey || http.CanonicalHeaderKey(auth.HeaderName) == "Authorization"
+}
+
+// keepUpstreamSecrets carries the stored secrets over when an update leaves them blank, since they are never
+// returned by the API a client cannot send them back
+func keepUpstreamSecrets(incoming, existing *model.UpstreamAuth) {
+	if incoming == nil || existing == nil || incoming.Type != existing.Type {
+		return
+	}
+
+	if incoming.APIKey == "" {
+		incoming.APIKey = existing.APIKey
+	}
+
+	if incoming.Password == "" {
+		incoming.Password = existing.Password
+	}
+
+	if incoming.ClientSecret == "" {
+		incoming.ClientSecret = existing.ClientSecret
+	}
+}
+
+func redactUpstreamAuth(route *model.Route) {
+	if route.UpstreamAuth == nil {
+		return
+	}
+
+	route.UpstreamAuth.APIKey = ""
+	route.UpstreamAuth.Password = ""
+	route.UpstreamAuth.ClientSecret = ""
+}
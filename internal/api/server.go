@@ -2,12 +2,28 @@ package api
 
 import (
 	"api-proxy/internal/api/middleware"
+	"api-proxy/internal/api/problem"
+	"api-proxy/internal/breaker"
 	"api-proxy/internal/cache"
+	"api-proxy/internal/coalesce"
 	"api-proxy/internal/config"
+	"api-proxy/internal/forwarded"
+	"api-proxy/internal/httpcache"
+	"api-proxy/internal/identity"
 	"api-proxy/internal/logger"
+	"api-proxy/internal/metrics"
+	"api-proxy/internal/mirror"
 	"api-proxy/internal/model"
+	"api-proxy/internal/oidc"
 	"api-proxy/internal/ratelimit"
+	"api-proxy/internal/refresh"
 	"api-proxy/internal/repository"
+	"api-proxy/internal/retry"
+	"api-proxy/internal/revocation"
+	"api-proxy/internal/secret"
+	"api-proxy/internal/signing"
+	"api-proxy/internal/upstream"
+	"api-proxy/internal/websocket"
 	"context"
 	"database/sql"
 	"errors"
@@ -25,12 +41,32 @@ import (
 type Server struct {
 	jwtSigningSecret      string
 	adminJwtSigningSecret string
+	jwtIssuer             string
+	oidcProviders         []oidc.Provider
+	revocation            config.RevocationConfig
+	refreshTokenTTL       time.Duration
 	port                  string
 	db                    *sql.DB
 	requestLogQueueSize   int
 	auditLogQueueSize     int
 	rateLimiter           string
 	redisUrl              string
+	upstreamDefaults      upstream.Settings
+	retryBudgetPercent    int
+	retryBudgetMinPerSec  int
+	retryMaxBodyBytes     int64
+	websocketIdleTimeout  time.Duration
+	websocketMaxConns     int
+	coalesceMaxBodyBytes  int64
+	mirrorMaxInFlight     int
+	mirrorMaxBodyBytes    int64
+	mirrorTimeout         time.Duration
+	requireGrants         bool
+	responseCache         config.ResponseCacheConfig
+	trustedProxies        []string
+	identitySigningSecret string
+	identityTokenTTL      time.Duration
+	encryptionKey         string
 }
 
 // NewServer creates a server listening on the specified port
@@ -42,62 +78,188 @@ func NewServer(
 		port:                  c.Server.Port,
 		jwtSigningSecret:      c.JWTConfig.SigningSecret,
 		adminJwtSigningSecret: c.JWTConfig.Admin.SigningSecret,
+		jwtIssuer:             c.JWTConfig.Issuer,
+		oidcProviders:         oidcProviders(c.OIDC),
+		revocation:            *c.JWTConfig.Revocation,
+		refreshTokenTTL:       c.JWTConfig.RefreshToken.TTL,
 		db:                    db,
 		requestLogQueueSize:   *c.LoggingConfig.LoggingRequestConfig.QueueSize,
 		auditLogQueueSize:     *c.LoggingConfig.LoggingAuditConfig.QueueSize,
 		rateLimiter:           c.RateLimitingConfig.Backend,
 		redisUrl:              c.RateLimitingConfig.Redis.URL,
+		trustedProxies:        c.Server.TrustedProxies,
+		identitySigningSecret: c.JWTConfig.Identity.SigningSecret,
+		identityTokenTTL:      c.JWTConfig.Identity.TTL,
+		encryptionKey:         c.EncryptionConfig.Key,
+		upstreamDefaults: upstream.Settings{
+			ConnectTimeout:        c.ProxyConfig.ConnectTimeout,
+			ResponseHeaderTimeout: c.ProxyConfig.ResponseHeaderTimeout,
+			IdleConnTimeout:       c.ProxyConfig.IdleConnTimeout,
+			Timeout:               c.ProxyConfig.Timeout,
+			MaxIdleConns:          c.ProxyConfig.MaxIdleConns,
+			MaxConns:              c.ProxyConfig.MaxConns,
+		},
+		retryBudgetPercent:   c.ProxyConfig.RetryBudgetPercent,
+		retryBudgetMinPerSec: c.ProxyConfig.RetryBudgetMinPerSecond,
+		retryMaxBodyBytes:    c.ProxyConfig.RetryMaxBodyBytes,
+		websocketIdleTimeout: c.ProxyConfig.WebSocketIdleTimeout,
+		websocketMaxConns:    c.ProxyConfig.WebSocketMaxConnsPerServiceAccount,
+		coalesceMaxBodyBytes: c.ProxyConfig.CoalesceMaxBodyBytes,
+		mirrorMaxInFlight:    c.ProxyConfig.MirrorMaxInFlight,
+		mirrorMaxBodyBytes:   c.ProxyConfig.MirrorMaxBodyBytes,
+		mirrorTimeout:        c.ProxyConfig.MirrorTimeout,
+		requireGrants:        *c.ProxyConfig.RequireGrants,
+		responseCache:        *c.ResponseCache,
 	}
 }
 
+func oidcProviders(c *config.OIDCConfig) []oidc.Provider {
+	providers := make([]oidc.Provider, 0, len(c.Providers))
+
+	for _, p := range c.Providers {
+		providers = append(providers, oidc.Provider{
+			Name:                p.Name,
+			Issuer:              p.Issuer,
+			Audience:            p.Audience,
+			JWKSURL:             p.JWKSURL,
+			SubjectClaim:        p.Claims.Subject,
+			ServiceAccountClaim: p.Claims.ServiceAccount,
+			OrgClaim:            p.Claims.Org,
+			ServiceAccounts:     p.ServiceAccounts,
+			Orgs:                p.Orgs,
+		})
+	}
+
+	return providers
+}
+
 // Start spins up the server so and registers any handlers as well as provides a graceful shutdown
 func (server *Server) Start() error {
 	var rateLimiter middleware.RateLimiter
 	router := chi.NewRouter()
 
 	routeCache := cache.NewRouteCache()
+	grantCache := cache.NewGrantCache()
+	signingKeys := signing.NewKeySet()
+	pools := upstream.NewPools()
+	transports := upstream.NewTransports(server.upstreamDefaults)
+	forwarder, err := forwarded.NewForwarder(server.trustedProxies)
+
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	var cipher *secret.Cipher
+
+	if server.encryptionKey != "" {
+		if cipher, err = secret.NewCipher(server.encryptionKey); err != nil {
+			return err
+		}
+	} else {
+		slog.Warn("no encryption key configured, routes cannot use upstream credentials")
+	}
+
+	var responseStore httpcache.Store = httpcache.NewMemoryStore(server.responseCache.MaxEntries, server.responseCache.MaxBytes)
+	var revocations revocation.List = revocation.NewMemoryList()
 
 	if server.rateLimiter == "memory" || server.redisUrl == "" {
 		slog.Info("using in-memory rate limiter")
 		rateLimiter = ratelimit.NewMemoryRateLimiter()
 	} else {
 		slog.Info("using redis rate limiter")
-		rateLimiter = ratelimit.NewRedisRateLimiter(server.redisUrl)
+		redisRateLimiter := ratelimit.NewRedisRateLimiter(server.redisUrl)
+		rateLimiter = redisRateLimiter
+
+		if server.responseCache.Backend == "redis" {
+			responseStore = httpcache.NewRedisStore(redisRateLimiter.Client())
+		}
+
+		if server.revocation.Backend == "redis" {
+			revocations = revocation.NewRedisList(redisRateLimiter.Client())
+		}
+	}
+
+	if _, ok := responseStore.(*httpcache.MemoryStore); ok && server.responseCache.Backend == "redis" {
+		slog.Warn("the redis response cache needs the redis rate limiter, using the in-memory response cache")
+	}
+
+	if _, ok := revocations.(*revocation.MemoryList); ok && server.revocation.Backend == "redis" {
+		slog.Warn("the redis token revocation list needs the redis rate limiter, using the in-memory revocation list")
 	}
 
+	responseCache := httpcache.New(responseStore, server.responseCache.MaxEntryBytes)
+
 	internalUserRepo := repository.NewInternalUserRepository(server.db)
 	orgRepo := repository.NewOrgRepository(server.db)
 	rateLimitRepo := repository.NewRateLimitRepository(server.db)
-	routeRepo := repository.NewRouteRepository(server.db)
+	routeRepo := repository.NewRouteRepository(server.db, cipher)
+	upstreamRepo := repository.NewUpstreamRepository(server.db)
 	serviceAccountRepo := repository.NewServiceAccountRepository(server.db)
 	requestRepo := repository.NewRequestRepository(server.db)
 	auditLogRepo := repository.NewAuditLogRepository(server.db)
+	routeGrantRepo := repository.NewRouteGrantRepository(server.db)
+	signingKeyRepo := repository.NewSigningKeyRepository(server.db, cipher)
+	tokenRevocationRepo := repository.NewTokenRevocationRepository(server.db)
+	refreshTokenRepo := repository.NewRefreshTokenRepository(server.db)
 
 	requestLogger := logger.NewRequestLogger(requestRepo, server.requestLogQueueSize)
 	auditLogger := logger.NewAuditLogger(auditLogRepo, server.auditLogQueueSize)
+	revoker := revocation.NewRevoker(tokenRevocationRepo, revocations)
+	refreshTokens := refresh.NewTokens(refreshTokenRepo, revoker, server.refreshTokenTTL)
 
-	authHandler := NewAuthHandler(server.jwtSigningSecret, server.adminJwtSigningSecret, serviceAccountRepo, internalUserRepo)
+	router.Use(middleware.RequestID())
+	router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, http.StatusNotFound, "")
+	})
+	router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, http.StatusMethodNotAllowed, "")
+	})
+
+	externalTokens := oidc.NewVerifier(&http.Client{Timeout: 10 * time.Second}, server.oidcProviders)
+	authHandler := NewAuthHandler(server.jwtSigningSecret, server.adminJwtSigningSecret, server.jwtIssuer, signingKeys, externalTokens, revoker, refreshTokens, serviceAccountRepo, orgRepo, internalUserRepo)
 
 	router.Post("/api/v1/oauth/token", authHandler.handleOAuth)
+	router.Post("/api/v1/oauth/revoke", authHandler.handleRevoke)
+	router.Post("/api/v1/oauth/introspect", authHandler.handleIntrospect)
 	router.Post("/api/v1/admin/oauth/token", authHandler.handleInternalOAuth)
+	router.Mount("/.well-known", NewWellKnownHandler(server.jwtIssuer, signingKeys).Router())
 
 	router.Route("/api/v1/admin", func(r chi.Router) {
-		r.Use(middleware.AdminAuth(server.adminJwtSigningSecret))
+		r.Use(middleware.AdminAuth(server.adminJwtSigningSecret, revoker))
 
 		r.Mount("/users", NewInternalUserHandler(internalUserRepo).Router())
-		r.Mount("/orgs", NewOrgHandler(orgRepo).Router())
+		r.Mount("/orgs", NewOrgHandler(orgRepo, revoker).Router())
 		r.Mount("/rate-limits", NewRateLimitHandler(auditLogger, rateLimitRepo).Router())
-		r.Mount("/routes", NewRouteHandler(auditLogger, routeRepo).Router())
-		r.Mount("/service-accounts", NewServiceAccountHandler(serviceAccountRepo).Router())
+		r.Mount("/route-grants", NewRouteGrantHandler(auditLogger, routeGrantRepo, grantCache).Router())
+		r.Mount("/routes", NewRouteHandler(auditLogger, routeRepo, upstreamRepo).Router())
+		r.Mount("/upstreams", NewUpstreamHandler(auditLogger, upstreamRepo, pools).Router())
+		r.Mount("/signing-keys", NewSigningKeyHandler(auditLogger, signingKeyRepo).Router())
+		r.Mount("/token-revocations", NewTokenRevocationHandler(auditLogger, tokenRevocationRepo, revoker).Router())
+		r.Mount("/service-accounts", NewServiceAccountHandler(serviceAccountRepo, revoker).Router())
 		r.Mount("/requests", NewRequestHandler(requestRepo).Router())
+		r.Mount("/response-cache", NewResponseCacheHandler(auditLogger, responseCache).Router())
+		r.Handle("/metrics", metrics.Handler())
 	})
 
 	router.With(
 		middleware.LogRequest(requestLogger),
-		middleware.ExternalAuth(server.jwtSigningSecret),
-		middleware.ResolveRoute(routeCache),
+		middleware.ExternalAuth(server.jwtSigningSecret, signingKeys, revoker),
+		middleware.ResolveRoute(routeCache, grantCache, server.requireGrants),
+		middleware.RequireScopes(),
 		middleware.RateLimit(rateLimiter),
-	).Handle("/*", NewProxyHandler())
+	).Handle("/*", NewProxyHandler(
+		transports,
+		forwarder,
+		identity.NewInjector(server.identitySigningSecret, server.identityTokenTTL, signingKeys),
+		upstream.NewCredentials(&http.Client{Timeout: 10 * time.Second}),
+		pools,
+		breaker.NewBreakers(),
+		retry.NewRetrier(retry.NewBudget(server.retryBudgetPercent, server.retryBudgetMinPerSec), server.retryMaxBodyBytes),
+		websocket.NewConnections(server.websocketIdleTimeout, server.websocketMaxConns),
+		responseCache,
+		coalesce.NewGroup(server.coalesceMaxBodyBytes),
+		mirror.New(requestLogger, server.mirrorMaxInFlight, server.mirrorMaxBodyBytes, server.mirrorTimeout),
+	))
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -105,8 +267,24 @@ func (server *Server) Start() error {
 	auditLogger.Start(ctx)
 	requestLogger.Start(ctx)
 	routeCache.StartSync(ctx, 1*time.Minute, func() ([]*model.Route, error) { // TODO: Do some benchmarking on routeRepo.FindActiveByFilter and/orgRepo the syncCache() method and adjust the interval accordingly
-		return routeRepo.FindActiveByFilter(nil)
+		routes, err := routeRepo.FindActiveByFilter(nil)
+
+		if err == nil {
+			transports.Retain(routes)
+			warnUngatedRoutes(routes, server.requireGrants)
+		}
+
+		return routes, err
+	})
+	signingKeys.StartSync(ctx, 1*time.Minute, signingKeyRepo.FindActive)
+	revocations.StartSync(ctx, server.revocation.SyncInterval, tokenRevocationRepo.FindActive)
+	grantCache.StartSync(ctx, 1*time.Minute, func() ([]*model.RouteGrant, error) {
+		return routeGrantRepo.FindActiveByFilter(nil)
 	})
+	pools.StartSync(ctx, 1*time.Minute, func() ([]*model.Upstream, error) {
+		return upstreamRepo.FindActiveByFilter(nil)
+	})
+	upstream.NewHealthChecker(pools, transports).Start(ctx, 1*time.Second)
 	rateLimiter.StartSync(ctx, 1*time.Minute, func() ([]*model.RateLimit, error) {
 		return rateLimitRepo.FindActiveByFilter(nil)
 	})
@@ -116,11 +294,18 @@ func (server *Server) Start() error {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	defer transports.CloseIdleConnections()
+
 	return httpServer.Shutdown(shutdownCtx)
 }
 
 func (server *Server) listenAndServe(r *chi.Mux) *http.Server {
-	httpServer := &http.Server{Addr: fmt.Sprintf(":%v", server.port), Handler: r}
+	// h2c lets gRPC clients and other HTTP/2 clients connect without TLS, HTTP/1.1 stays available for everyone else
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	httpServer := &http.Server{Addr: fmt.Sprintf(":%v", server.port), Handler: r, Protocols: protocols}
 
 	go func() {
 		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -130,3 +315,22 @@ func (server *Server) listenAndServe(r *chi.Mux) *http.Server {
 
 	return httpServer
 }
+
+// warnUngatedRoutes lists the routes any authenticated caller reaches while grants are not required on every route
+func warnUngatedRoutes(routes []*model.Route, requireGrants bool) {
+	if requireGrants {
+		return
+	}
+
+	var ungated []int
+
+	for _, route := range routes {
+		if !route.RequireGrant {
+			ungated = append(ungated, route.ID)
+		}
+	}
+
+	if len(ungated) > 0 {
+		slog.Warn("grants are not required on every route, these routes are open to any authenticated caller", "route_ids", ungated)
+	}
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"api-proxy/internal/api/problem"
 	"api-proxy/internal/model"
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -17,10 +19,11 @@ type ServiceAccountDataStorer interface {
 
 type ServiceAccountHandler struct {
 	dataStore ServiceAccountDataStorer
+	revoker   TokenRevoker
 }
 
-func NewServiceAccountHandler(serviceAccountDataStore ServiceAccountDataStorer) *ServiceAccountHandler {
-	return &ServiceAccountHandler{dataStore: serviceAccountDataStore}
+func NewServiceAccountHandler(serviceAccountDataStore ServiceAccountDataStorer, revoker TokenRevoker) *ServiceAccountHandler {
+	return &ServiceAccountHandler{dataStore: serviceAccountDataStore, revoker: revoker}
 }
 
 func (sah *ServiceAccountHandler) Router() http.Handler {
@@ -43,7 +46,8 @@ func (sah *ServiceAccountHandler) handleGetServiceAccounts(w http.ResponseWriter
 	active, err := sah.dataStore.FindActiveByFilter(filter)
 
 	if err != nil {
-		http.Error(w, "unexpected error.", http.StatusInternalServerError)
+		slog.Error("error finding active service accounts", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
 		return
 	}
 
@@ -58,19 +62,20 @@ func (sah *ServiceAccountHandler) handleGetServiceAccount(w http.ResponseWriter,
 	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
 
 	if strconvErr != nil {
-		http.Error(w, "invalid id in the uri", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
 		return
 	}
 
 	sa, err := sah.dataStore.FindByID(uriId)
 
 	if err != nil {
-		http.Error(w, "unexpected error.", http.StatusInternalServerError)
+		slog.Error("error finding service account", "id", uriId, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
 		return
 	}
 
 	if sa == nil {
-		http.Error(w, "sa not found", http.StatusNotFound)
+		problem.Write(w, r, http.StatusNotFound, "sa not found")
 		return
 	}
 
@@ -83,14 +88,20 @@ func (sah *ServiceAccountHandler) handleCreateServiceAccount(w http.ResponseWrit
 	sa, err := decodeJSON[model.ServiceAccount](r)
 
 	if err != nil {
-		http.Error(w, "unable to read json request body", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
+		return
+	}
+
+	if !validScopes(sa.Scopes) {
+		problem.Write(w, r, http.StatusBadRequest, ErrInvalidScopes.Error())
 		return
 	}
 
 	hashedSecret, err := hashSecret(sa.ClientSecret)
 
 	if err != nil {
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		slog.Error("error hashing client secret for service account", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
@@ -99,7 +110,8 @@ func (sah *ServiceAccountHandler) handleCreateServiceAccount(w http.ResponseWrit
 	created, err := sah.dataStore.Insert(sa)
 
 	if err != nil {
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		slog.Error("error inserting service account", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
@@ -112,29 +124,43 @@ func (sah *ServiceAccountHandler) handleUpdateServiceAccount(w http.ResponseWrit
 	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
 
 	if strconvErr != nil {
-		http.Error(w, "invalid id in the uri", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
 		return
 	}
 
 	sa, err := decodeJSON[model.ServiceAccount](r)
 
 	if err != nil {
-		http.Error(w, "unable to read json request body", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
 		return
 	}
 
 	if sa.ID != uriId {
-		http.Error(w, "id in uri must match request body id", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "id in uri must match request body id")
+		return
+	}
+
+	if !validScopes(sa.Scopes) {
+		problem.Write(w, r, http.StatusBadRequest, ErrInvalidScopes.Error())
 		return
 	}
 
 	updated, err := sah.dataStore.Update(sa)
 
 	if err != nil {
-		http.Error(w, "unexpected error", http.StatusInternalServerError)
+		slog.Error("error updating service account", "id", sa.ID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
 		return
 	}
 
+	if updated.InactivatedAt != nil {
+		if err := revokeSubject(r.Context(), sah.revoker, nil, &updated.ID); err != nil {
+			slog.Error("error revoking tokens of inactivated service account", "id", updated.ID, "error", err)
+			problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+			return
+		}
+	}
+
 	updated.ClientSecret = ""
 
 	writeJSON(w, updated, http.StatusOK)
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"api-proxy/internal/api/middleware"
+	"api-proxy/internal/api/problem"
+	"api-proxy/internal/model"
+	"api-proxy/internal/repository"
+	"api-proxy/internal/signing"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var ErrInvalidSigningAlgorithm = errors.New("algorithm must be one of RS256, ES256 or EdDSA")
+var ErrInvalidSigningKeySchedule = errors.New("retires_at must be after activates_at, and expires_at needs retires_at and must not be before it")
+
+type SigningKeyDataStorer interface {
+	FindActive() ([]*model.SigningKey, error)
+	FindByID(id int) (*model.SigningKey, error)
+	Insert(key *model.SigningKey) (*model.SigningKey, error)
+	Update(key *model.SigningKey) (*model.SigningKey, error)
+}
+
+// SigningKeyHandler manages the keys tokens are signed with. A rotation is scheduled by creating the next key with
+// a future activates_at, so it is published before it signs anything, and setting retires_at and expires_at on the
+// key it replaces. Keys are generated here, their private halves never leave the proxy.
+type SigningKeyHandler struct {
+	auditLogger middleware.AuditLogger
+	dataStore   SigningKeyDataStorer
+}
+
+func NewSigningKeyHandler(auditLogger middleware.AuditLogger, signingKeyDataStore SigningKeyDataStorer) *SigningKeyHandler {
+	return &SigningKeyHandler{
+		auditLogger: auditLogger,
+		dataStore:   signingKeyDataStore,
+	}
+}
+
+func (skh *SigningKeyHandler) Router() http.Handler {
+	r := chi.NewRouter()
+
+	r.Get("/", skh.handleGetSigningKeys)
+	r.Get("/{id}", skh.handleGetSigningKey)
+	r.With(middleware.LogAuditable(skh.auditLogger, model.SIGNING_KEY, model.CREATE)).Post("/", skh.handleCreateSigningKey)
+	r.With(middleware.LogAuditable(skh.auditLogger, model.SIGNING_KEY, model.UPDATE)).Put("/{id}", skh.handleUpdateSigningKey)
+
+	return r
+}
+
+func (skh *SigningKeyHandler) handleGetSigningKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := skh.dataStore.FindActive()
+
+	if err != nil {
+		slog.Error("error finding active signing keys", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
+		return
+	}
+
+	writeJSON(w, keys, http.StatusOK)
+}
+
+func (skh *SigningKeyHandler) handleGetSigningKey(w http.ResponseWriter, r *http.Request) {
+	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if strconvErr != nil {
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
+		return
+	}
+
+	key, err := skh.dataStore.FindByID(uriId)
+
+	if err != nil {
+		slog.Error("error finding signing key", "id", uriId, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
+		return
+	}
+
+	if key == nil {
+		problem.Write(w, r, http.StatusNotFound, "signing key not found")
+		return
+	}
+
+	writeJSON(w, key, http.StatusOK)
+}
+
+func (skh *SigningKeyHandler) handleCreateSigningKey(w http.ResponseWriter, r *http.Request) {
+	key, err := decodeJSON[model.SigningKey](r)
+
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
+		return
+	}
+
+	if !signing.ValidAlgorithm(key.Algorithm) {
+		problem.Write(w, r, http.StatusBadRequest, ErrInvalidSigningAlgorithm.Error())
+		return
+	}
+
+	if key.ActivatesAt.IsZero() {
+		key.ActivatesAt = time.Now()
+	}
+
+	if !validSigningKeySchedule(key) {
+		problem.Write(w, r, http.StatusBadRequest, ErrInvalidSigningKeySchedule.Error())
+		return
+	}
+
+	if key.KID, err = signing.NewKID(); err == nil {
+		key.PrivateKey, err = signing.Generate(key.Algorithm)
+	}
+
+	if err != nil {
+		slog.Error("error generating signing key", "algorithm", key.Algorithm, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	created, err := skh.dataStore.Insert(key)
+
+	if errors.Is(err, repository.ErrSigningKeyEncryptionMissing) {
+		problem.Write(w, r, http.StatusConflict, err.Error())
+		return
+	}
+
+	if err != nil {
+		slog.Error("error inserting signing key", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	writeJSON(w, created, http.StatusCreated)
+}
+
+// handleUpdateSigningKey changes when a key activates, retires and expires, or inactivates it outright
+func (skh *SigningKeyHandler) handleUpdateSigningKey(w http.ResponseWriter, r *http.Request) {
+	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if strconvErr != nil {
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
+		return
+	}
+
+	key, err := decodeJSON[model.SigningKey](r)
+
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
+		return
+	}
+
+	if key.ID != uriId {
+		problem.Write(w, r, http.StatusBadRequest, "id in uri must match request body id")
+		return
+	}
+
+	if key.ActivatesAt.IsZero() || !validSigningKeySchedule(key) {
+		problem.Write(w, r, http.StatusBadRequest, ErrInvalidSigningKeySchedule.Error())
+		return
+	}
+
+	updated, err := skh.dataStore.Update(key)
+
+	if err != nil {
+		slog.Error("error updating signing key", "id", key.ID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	writeJSON(w, updated, http.StatusOK)
+}
+
+func validSigningKeySchedule(key *model.SigningKey) bool {
+	if key.RetiresAt != nil && !key.RetiresAt.After(key.ActivatesAt) {
+		return false
+	}
+
+	if key.ExpiresAt != nil && (key.RetiresAt == nil || key.ExpiresAt.Before(*key.RetiresAt)) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"api-proxy/internal/api/middleware"
+	"api-proxy/internal/api/problem"
+	"api-proxy/internal/model"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var ErrInvalidTokenRevocation = errors.New("a token revocation needs exactly one of jti, org_id or service_account_id")
+
+type TokenRevocationDataStorer interface {
+	FindActive() ([]*model.TokenRevocation, error)
+}
+
+// TokenRevoker records a revocation and makes the proxy reject the tokens it covers
+type TokenRevoker interface {
+	Revoke(ctx context.Context, revocation *model.TokenRevocation) error
+}
+
+type TokenRevocationHandler struct {
+	auditLogger middleware.AuditLogger
+	dataStore   TokenRevocationDataStorer
+	revoker     TokenRevoker
+}
+
+func NewTokenRevocationHandler(auditLogger middleware.AuditLogger, tokenRevocationDataStore TokenRevocationDataStorer, revoker TokenRevoker) *TokenRevocationHandler {
+	return &TokenRevocationHandler{
+		auditLogger: auditLogger,
+		dataStore:   tokenRevocationDataStore,
+		revoker:     revoker,
+	}
+}
+
+func (trh *TokenRevocationHandler) Router() http.Handler {
+	r := chi.NewRouter()
+
+	r.Get("/", trh.handleGetTokenRevocations)
+	r.With(middleware.LogAuditable(trh.auditLogger, model.TOKEN_REVOCATION, model.CREATE)).Post("/", trh.handleCreateTokenRevocation)
+
+	return r
+}
+
+func (trh *TokenRevocationHandler) handleGetTokenRevocations(w http.ResponseWriter, r *http.Request) {
+	active, err := trh.dataStore.FindActive()
+
+	if err != nil {
+		slog.Error("error finding active token revocations", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
+		return
+	}
+
+	writeJSON(w, active, http.StatusOK)
+}
+
+// handleCreateTokenRevocation revokes a single token, or every token issued so far to an org or service account.
+// Revocations take effect now and are kept until the tokens they cover have expired, unless expires_at says otherwise.
+func (trh *TokenRevocationHandler) handleCreateTokenRevocation(w http.ResponseWriter, r *http.Request) {
+	revocation, err := decodeJSON[model.TokenRevocation](r)
+
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
+		return
+	}
+
+	if err := validateTokenRevocation(revocation); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	revocation.RevokedAt = time.Now()
+
+	if revocation.ExpiresAt.IsZero() {
+		revocation.ExpiresAt = revocation.RevokedAt.Add(maxTokenLifetime)
+	}
+
+	if err := trh.revoker.Revoke(r.Context(), revocation); err != nil {
+		slog.Error("error revoking tokens", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	writeJSON(w, revocation, http.StatusCreated)
+}
+
+func validateTokenRevocation(revocation *model.TokenRevocation) error {
+	targets := 0
+
+	if revocation.JTI != nil && *revocation.JTI != "" {
+		targets++
+	}
+
+	if revocation.OrgID != nil && *revocation.OrgID > 0 {
+		targets++
+	}
+
+	if revocation.ServiceAccountID != nil && *revocation.ServiceAccountID > 0 {
+		targets++
+	}
+
+	if targets != 1 {
+		return ErrInvalidTokenRevocation
+	}
+
+	return nil
+}
+
+// revokeSubject revokes every outstanding token of an org or service account, called when one is inactivated
+func revokeSubject(ctx context.Context, revoker TokenRevoker, orgID, serviceAccountID *int) error {
+	now := time.Now()
+
+	return revoker.Revoke(ctx, &model.TokenRevocation{
+		OrgID:            orgID,
+		ServiceAccountID: serviceAccountID,
+		RevokedAt:        now,
+		ExpiresAt:        now.Add(maxTokenLifetime),
+	})
+}
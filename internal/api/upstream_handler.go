@@ -0,0 +1,237 @@
+package api
+
+import (
+	"api-proxy/internal/api/middleware"
+	"api-proxy/internal/api/problem"
+	"api-proxy/internal/model"
+	"api-proxy/internal/upstream"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type UpstreamDataStorer interface {
+	FindActiveByFilter(filter *model.UpstreamFilter) ([]*model.Upstream, error)
+	FindByID(id int) (*model.Upstream, error)
+	Insert(upstream *model.Upstream) (*model.Upstream, error)
+	Update(upstream *model.Upstream) (*model.Upstream, error)
+}
+
+var ErrUpstreamNameRequired = errors.New("name is required")
+var ErrInvalidStrategy = errors.New("strategy must be one of round_robin, weighted_random, least_outstanding or consistent_hash")
+var ErrTargetsRequired = errors.New("an upstream needs at least one target")
+var ErrInvalidHealthCheck = errors.New("health_check_path must start with / and health check settings must not be negative")
+var ErrInvalidTarget = errors.New("every target needs an absolute http or https url without placeholders and a weight of at least 0")
+
+// PoolFinder looks up the runtime pool of an active upstream
+type PoolFinder interface {
+	Get(id int) *upstream.Pool
+}
+
+type UpstreamHandler struct {
+	auditLogger middleware.AuditLogger
+	dataStore   UpstreamDataStorer
+	pools       PoolFinder
+}
+
+func NewUpstreamHandler(auditLogger middleware.AuditLogger, upstreamDataStore UpstreamDataStorer, pools PoolFinder) *UpstreamHandler {
+	return &UpstreamHandler{
+		auditLogger: auditLogger,
+		dataStore:   upstreamDataStore,
+		pools:       pools,
+	}
+}
+
+func (uh *UpstreamHandler) Router() http.Handler {
+	r := chi.NewRouter()
+
+	r.Get("/", uh.handleGetUpstreams)
+	r.Get("/{id}", uh.handleGetUpstream)
+	r.Get("/{id}/health", uh.handleGetUpstreamHealth)
+	r.With(middleware.LogAuditable(uh.auditLogger, model.UPSTREAM, model.CREATE)).Post("/", uh.handleCreateUpstream)
+	r.With(middleware.LogAuditable(uh.auditLogger, model.UPSTREAM, model.UPDATE)).Put("/{id}", uh.handleUpdateUpstream)
+
+	return r
+}
+
+func (uh *UpstreamHandler) handleGetUpstreams(w http.ResponseWriter, r *http.Request) {
+	filter := &model.UpstreamFilter{
+		Name: r.URL.Query().Get("name"),
+	}
+
+	active, err := uh.dataStore.FindActiveByFilter(filter)
+
+	if err != nil {
+		slog.Error("error finding active upstreams", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
+		return
+	}
+
+	writeJSON(w, active, http.StatusOK)
+}
+
+func (uh *UpstreamHandler) handleGetUpstream(w http.ResponseWriter, r *http.Request) {
+	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if strconvErr != nil {
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
+		return
+	}
+
+	found, err := uh.dataStore.FindByID(uriId)
+
+	if err != nil {
+		slog.Error("error finding upstream", "id", uriId, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error.")
+		return
+	}
+
+	if found == nil {
+		problem.Write(w, r, http.StatusNotFound, "upstream not found")
+		return
+	}
+
+	writeJSON(w, found, http.StatusOK)
+}
+
+// handleGetUpstreamHealth reports the live health of the upstream's targets as seen by this proxy instance
+func (uh *UpstreamHandler) handleGetUpstreamHealth(w http.ResponseWriter, r *http.Request) {
+	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if strconvErr != nil {
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
+		return
+	}
+
+	pool := uh.pools.Get(uriId)
+
+	if pool == nil {
+		problem.Write(w, r, http.StatusNotFound, "upstream is not active or has not been synced yet")
+		return
+	}
+
+	writeJSON(w, pool.Health(), http.StatusOK)
+}
+
+func (uh *UpstreamHandler) handleCreateUpstream(w http.ResponseWriter, r *http.Request) {
+	body, err := decodeJSON[model.Upstream](r)
+
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
+		return
+	}
+
+	if err := validateUpstream(body); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := uh.dataStore.Insert(body)
+
+	if err != nil {
+		slog.Error("error inserting upstream", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	writeJSON(w, created, http.StatusCreated)
+}
+
+func (uh *UpstreamHandler) handleUpdateUpstream(w http.ResponseWriter, r *http.Request) {
+	uriId, strconvErr := strconv.Atoi(chi.URLParam(r, "id"))
+
+	if strconvErr != nil {
+		problem.Write(w, r, http.StatusBadRequest, "invalid id in the uri")
+		return
+	}
+
+	body, err := decodeJSON[model.Upstream](r)
+
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "unable to read json request body")
+		return
+	}
+
+	if body.ID != uriId {
+		problem.Write(w, r, http.StatusBadRequest, "id in uri must match request body id")
+		return
+	}
+
+	if err := validateUpstream(body); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updated, err := uh.dataStore.Update(body)
+
+	if err != nil {
+		slog.Error("error updating upstream", "id", body.ID, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "unexpected error")
+		return
+	}
+
+	writeJSON(w, updated, http.StatusOK)
+}
+
+func validateUpstream(body *model.Upstream) error {
+	if body.Name == "" {
+		return ErrUpstreamNameRequired
+	}
+
+	if !upstream.ValidStrategy(body.Strategy) {
+		return ErrInvalidStrategy
+	}
+
+	if body.Strategy == "" {
+		body.Strategy = model.StrategyRoundRobin
+	}
+
+	healthSettings := []int{
+		body.HealthCheckIntervalMs,
+		body.HealthyThreshold,
+		body.UnhealthyThreshold,
+		body.MaxConsecutiveFailures,
+		body.EjectionDurationMs,
+	}
+
+	for _, setting := range healthSettings {
+		if setting < 0 {
+			return ErrInvalidHealthCheck
+		}
+	}
+
+	if body.HealthCheckPath != "" && !strings.HasPrefix(body.HealthCheckPath, "/") {
+		return ErrInvalidHealthCheck
+	}
+
+	if len(body.Targets) == 0 {
+		return ErrTargetsRequired
+	}
+
+	for _, target := range body.Targets {
+		if target == nil || !validTargetURL(target.URL) || (target.Weight != nil && *target.Weight < 0) {
+			return ErrInvalidTarget
+		}
+
+		if target.Weight == nil {
+			target.Weight = new(1)
+		}
+	}
+
+	return nil
+}
+
+func validTargetURL(raw string) bool {
+	if upstream.IsTemplate(raw) {
+		return false
+	}
+
+	parsed, err := url.Parse(raw)
+
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"api-proxy/internal/signing"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// KeyPublisher lists the public keys tokens can currently be verified with
+type KeyPublisher interface {
+	JWKS() signing.JWKS
+}
+
+// WellKnownHandler publishes what backends need to verify the proxy's tokens on their own
+type WellKnownHandler struct {
+	issuer string
+	keys   KeyPublisher
+}
+
+func NewWellKnownHandler(issuer string, keys KeyPublisher) *WellKnownHandler {
+	return &WellKnownHandler{
+		issuer: issuer,
+		keys:   keys,
+	}
+}
+
+func (wkh *WellKnownHandler) Router() http.Handler {
+	r := chi.NewRouter()
+
+	r.Get("/jwks.json", wkh.handleJWKS)
+	r.Get("/openid-configuration", wkh.handleDiscovery)
+
+	return r
+}
+
+type discoveryDocument struct {
+	Issuer                                    string   `json:"issuer"`
+	JWKSURI                                   string   `json:"jwks_uri"`
+	TokenEndpoint                             string   `json:"token_endpoint"`
+	RevocationEndpoint                        string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint"`
+	GrantTypesSupported                       []string `json:"grant_types_supported"`
+	ResponseTypesSupported                    []string `json:"response_types_supported"`
+	SubjectTypesSupported                     []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported          []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported         []string `json:"token_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported    []string `json:"revocation_endpoint_auth_methods_supported"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported"`
+}
+
+// handleJWKS lists the public keys, including keys scheduled to activate so verifiers know them before they sign
+func (wkh *WellKnownHandler) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, wkh.keys.JWKS(), http.StatusOK)
+}
+
+func (wkh *WellKnownHandler) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	base := wkh.baseURL(r)
+
+	writeJSON(w, discoveryDocument{
+		Issuer:                                    wkh.issuer,
+		JWKSURI:                                   base + "/.well-known/jwks.json",
+		TokenEndpoint:                             base + "/api/v1/oauth/token",
+		RevocationEndpoint:                        base + "/api/v1/oauth/revoke",
+		IntrospectionEndpoint:                     base + "/api/v1/oauth/introspect",
+		GrantTypesSupported:                       []string{"client_credentials", "refresh_token", tokenExchangeGrantType},
+		ResponseTypesSupported:                    []string{"token"},
+		SubjectTypesSupported:                     []string{"public"},
+		IDTokenSigningAlgValuesSupported:          []string{signing.AlgorithmRS256, signing.AlgorithmES256, signing.AlgorithmEdDSA},
+		TokenEndpointAuthMethodsSupported:         []string{"client_secret_post"},
+		RevocationEndpointAuthMethodsSupported:    []string{"client_secret_post", "client_secret_basic"},
+		IntrospectionEndpointAuthMethodsSupported: []string{"client_secret_post", "client_secret_basic"},
+	}, http.StatusOK)
+}
+
+// baseURL is the issuer when it is a url, as OIDC expects, otherwise the address the request was made to
+func (wkh *WellKnownHandler) baseURL(r *http.Request) string {
+	if strings.HasPrefix(wkh.issuer, "https://") || strings.HasPrefix(wkh.issuer, "http://") {
+		return strings.TrimSuffix(wkh.issuer, "/")
+	}
+
+	scheme := "http"
+
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,269 @@
+package breaker
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	windowBuckets           = 10
+	defaultMinRequests      = 20
+	defaultWindow           = 10 * time.Second
+	defaultOpenDuration     = 30 * time.Second
+	defaultHalfOpenRequests = 1
+)
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// Outcome is how a call that was let through ended
+type Outcome int
+
+const (
+	// Ignored calls are not counted, for instance when the caller hung up before the backend answered
+	Ignored Outcome = iota
+	Success
+	Failure
+)
+
+// Settings are the thresholds of a single breaker. Rates are percentages of the calls in the window, a rate of zero
+// disables that check and a breaker with neither check is disabled.
+type Settings struct {
+	ErrorRatePercent    int
+	SlowCallRatePercent int
+	SlowCall            time.Duration
+	MinRequests         int
+	Window              time.Duration
+	OpenDuration        time.Duration
+	HalfOpenRequests    int
+}
+
+// Enabled reports whether either threshold is set
+func (s Settings) Enabled() bool {
+	return s.ErrorRatePercent > 0 || (s.SlowCallRatePercent > 0 && s.SlowCall > 0)
+}
+
+func (s Settings) withDefaults() Settings {
+	if s.MinRequests <= 0 {
+		s.MinRequests = defaultMinRequests
+	}
+
+	if s.Window <= 0 {
+		s.Window = defaultWindow
+	}
+
+	if s.OpenDuration <= 0 {
+		s.OpenDuration = defaultOpenDuration
+	}
+
+	if s.HalfOpenRequests <= 0 {
+		s.HalfOpenRequests = defaultHalfOpenRequests
+	}
+
+	return s
+}
+
+type bucket struct {
+	start    time.Time
+	total    int
+	failures int
+	slow     int
+}
+
+// Breaker stops calls to a backend once too many of the recent ones failed or were slow. It opens when either rate
+// crosses its threshold over the window, fails fast while open, then lets a few trial calls through half-open and
+// closes again only if all of them succeed.
+type Breaker struct {
+	mu               sync.Mutex
+	name             string
+	settings         Settings
+	state            State
+	buckets          [windowBuckets]bucket
+	openedAt         time.Time
+	halfOpenInFlight int
+	halfOpenPassed   int
+	generation       int
+	onStateChange    func(name string, from, to State)
+	now              func() time.Time
+}
+
+func New(name string, settings Settings, onStateChange func(name string, from, to State)) *Breaker {
+	return &Breaker{
+		name:          name,
+		settings:      settings.withDefaults(),
+		onStateChange: onStateChange,
+		now:           time.Now,
+	}
+}
+
+// State returns the breaker's current state, an open breaker whose open duration has passed reports half-open
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(b.now())
+
+	return b.state
+}
+
+// Allow asks to make a call. When the call is allowed the returned func must be called exactly once with how it
+// ended, along with how long it took. When it is not, the returned duration is how long until a trial call may be made.
+func (b *Breaker) Allow() (func(outcome Outcome, elapsed time.Duration), time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.advance(now)
+
+	switch b.state {
+	case Open:
+		return nil, b.openedAt.Add(b.settings.OpenDuration).Sub(now), false
+	case HalfOpen:
+		if b.halfOpenInFlight+b.halfOpenPassed >= b.settings.HalfOpenRequests {
+			return nil, time.Second, false
+		}
+
+		b.halfOpenInFlight++
+	}
+
+	var once sync.Once
+	generation := b.generation
+
+	return func(outcome Outcome, elapsed time.Duration) {
+		once.Do(func() { b.record(generation, outcome, elapsed) })
+	}, 0, true
+}
+
+// record counts a finished call, calls that were let through before the last state change no longer count
+func (b *Breaker) record(generation int, outcome Outcome, elapsed time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	now := b.now()
+	failed := outcome == Failure || (outcome == Success && b.isSlow(elapsed))
+
+	if b.state == HalfOpen {
+		b.halfOpenInFlight--
+
+		switch {
+		case outcome == Ignored:
+		case failed:
+			b.transition(Open, now)
+		default:
+			b.halfOpenPassed++
+
+			if b.halfOpenPassed >= b.settings.HalfOpenRequests {
+				b.transition(Closed, now)
+			}
+		}
+
+		return
+	}
+
+	if outcome == Ignored {
+		return
+	}
+
+	current := b.bucketAt(now)
+	current.total++
+
+	if outcome == Failure {
+		current.failures++
+	}
+
+	if b.isSlow(elapsed) {
+		current.slow++
+	}
+
+	if b.tripped(now) {
+		b.transition(Open, now)
+	}
+}
+
+func (b *Breaker) isSlow(elapsed time.Duration) bool {
+	return b.settings.SlowCallRatePercent > 0 && b.settings.SlowCall > 0 && elapsed >= b.settings.SlowCall
+}
+
+// tripped checks the rates over the buckets still inside the window
+func (b *Breaker) tripped(now time.Time) bool {
+	total, failures, slow := 0, 0, 0
+
+	for _, bk := range b.buckets {
+		if now.Sub(bk.start) < b.settings.Window {
+			total += bk.total
+			failures += bk.failures
+			slow += bk.slow
+		}
+	}
+
+	if total < b.settings.MinRequests {
+		return false
+	}
+
+	if b.settings.ErrorRatePercent > 0 && failures*100 >= b.settings.ErrorRatePercent*total {
+		return true
+	}
+
+	return b.settings.SlowCallRatePercent > 0 && slow*100 >= b.settings.SlowCallRatePercent*total
+}
+
+func (b *Breaker) bucketAt(now time.Time) *bucket {
+	width := b.settings.Window / windowBuckets
+	start := now.Truncate(width)
+	current := &b.buckets[(start.UnixNano()/int64(width))%windowBuckets]
+
+	if !current.start.Equal(start) {
+		*current = bucket{start: start}
+	}
+
+	return current
+}
+
+// advance moves an open breaker to half-open once its open duration has passed
+func (b *Breaker) advance(now time.Time) {
+	if b.state == Open && !now.Before(b.openedAt.Add(b.settings.OpenDuration)) {
+		b.transition(HalfOpen, now)
+	}
+}
+
+func (b *Breaker) transition(to State, now time.Time) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.halfOpenInFlight = 0
+	b.halfOpenPassed = 0
+
+	switch to {
+	case Open:
+		b.openedAt = now
+	case Closed:
+		b.buckets = [windowBuckets]bucket{}
+	}
+
+	slog.Warn("circuit breaker state changed", "breaker", b.name, "from", from.String(), "to", to.String())
+
+	if b.onStateChange != nil {
+		b.onStateChange(b.name, from, to)
+	}
+}
@@ -0,0 +1,172 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestBreaker(settings Settings) (*Breaker, *clock, *[]State) {
+	c := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var transitions []State
+
+	b := New("test", settings, func(_ string, _, to State) {
+		transitions = append(transitions, to)
+	})
+	b.now = func() time.Time { return c.now }
+
+	return b, c, &transitions
+}
+
+func call(t *testing.T, b *Breaker, outcome Outcome, elapsed time.Duration) {
+	t.Helper()
+
+	done, _, ok := b.Allow()
+
+	if !ok {
+		t.Fatalf("expected the call to be allowed in state %s", b.State())
+	}
+
+	done(outcome, elapsed)
+}
+
+func TestBreaker_ErrorRate(t *testing.T) {
+	b, c, transitions := newTestBreaker(Settings{ErrorRatePercent: 50, MinRequests: 4, OpenDuration: 5 * time.Second})
+
+	call(t, b, Failure, 0)
+	call(t, b, Failure, 0)
+	call(t, b, Failure, 0)
+
+	if b.State() != Closed {
+		t.Fatalf("expected the breaker to stay closed below the minimum number of requests")
+	}
+
+	call(t, b, Success, 0)
+
+	if b.State() != Open {
+		t.Fatalf("expected the breaker to open at a 75%% error rate, got %s", b.State())
+	}
+
+	c.advance(2 * time.Second)
+
+	if _, retryAfter, ok := b.Allow(); ok || retryAfter != 3*time.Second {
+		t.Fatalf("expected an open breaker to fail fast with 3s left, got %v, %v", ok, retryAfter)
+	}
+
+	c.advance(3 * time.Second)
+
+	done, _, ok := b.Allow()
+
+	if !ok || b.State() != HalfOpen {
+		t.Fatalf("expected a trial call once the open duration passed, got %v in %s", ok, b.State())
+	}
+
+	if _, _, ok := b.Allow(); ok {
+		t.Fatalf("expected only one trial call at a time")
+	}
+
+	done(Success, 0)
+
+	if b.State() != Closed {
+		t.Fatalf("expected a successful trial call to close the breaker, got %s", b.State())
+	}
+
+	expected := []State{Open, HalfOpen, Closed}
+
+	if len(*transitions) != len(expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, *transitions)
+	}
+
+	for i, state := range expected {
+		if (*transitions)[i] != state {
+			t.Fatalf("expected transitions %v, got %v", expected, *transitions)
+		}
+	}
+}
+
+func TestBreaker_FailedTrialReopens(t *testing.T) {
+	b, c, _ := newTestBreaker(Settings{ErrorRatePercent: 50, MinRequests: 1, OpenDuration: time.Second})
+
+	call(t, b, Failure, 0)
+	c.advance(time.Second)
+	call(t, b, Failure, 0)
+
+	if b.State() != Open {
+		t.Fatalf("expected a failed trial call to reopen the breaker, got %s", b.State())
+	}
+}
+
+func TestBreaker_SlowCalls(t *testing.T) {
+	b, _, _ := newTestBreaker(Settings{SlowCallRatePercent: 50, SlowCall: time.Second, MinRequests: 2})
+
+	call(t, b, Success, 10*time.Millisecond)
+	call(t, b, Success, 2*time.Second)
+
+	if b.State() != Open {
+		t.Fatalf("expected half the calls being slow to open the breaker, got %s", b.State())
+	}
+}
+
+func TestBreaker_Window(t *testing.T) {
+	b, c, _ := newTestBreaker(Settings{ErrorRatePercent: 50, MinRequests: 2, Window: 10 * time.Second})
+
+	call(t, b, Failure, 0)
+	c.advance(11 * time.Second)
+	call(t, b, Failure, 0)
+
+	if b.State() != Closed {
+		t.Fatalf("expected failures outside the window to be forgotten, got %s", b.State())
+	}
+}
+
+func TestBreaker_IgnoredAndLateCalls(t *testing.T) {
+	b, c, _ := newTestBreaker(Settings{ErrorRatePercent: 50, MinRequests: 1, OpenDuration: time.Second})
+
+	call(t, b, Ignored, 0)
+
+	if b.State() != Closed {
+		t.Fatalf("expected ignored calls not to count, got %s", b.State())
+	}
+
+	late, _, _ := b.Allow()
+	call(t, b, Failure, 0)
+	c.advance(time.Second)
+
+	trial, _, _ := b.Allow()
+	late(Success, 0)
+
+	if b.State() != HalfOpen {
+		t.Fatalf("expected a call let through before the breaker opened not to count as a trial, got %s", b.State())
+	}
+
+	trial(Ignored, 0)
+
+	if _, _, ok := b.Allow(); !ok {
+		t.Fatalf("expected an ignored trial call to free its slot")
+	}
+}
+
+func TestBreakers_Get(t *testing.T) {
+	breakers := NewBreakers()
+
+	if breakers.Get(1, Settings{}) != nil {
+		t.Fatalf("expected no breaker without thresholds")
+	}
+
+	first := breakers.Get(1, Settings{ErrorRatePercent: 50})
+
+	if breakers.Get(1, Settings{ErrorRatePercent: 50}) != first {
+		t.Fatalf("expected the same breaker while settings are unchanged")
+	}
+
+	if breakers.Get(1, Settings{ErrorRatePercent: 60}) == first {
+		t.Fatalf("expected a new breaker once settings change")
+	}
+}
@@ -0,0 +1,66 @@
+package breaker
+
+import (
+	"api-proxy/internal/metrics"
+	"api-proxy/internal/model"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	settings Settings
+	breaker  *Breaker
+}
+
+// Breakers hands out one breaker per route. A route's breaker keeps its state until the route's settings change.
+type Breakers struct {
+	mu       sync.Mutex
+	breakers map[int]*entry
+}
+
+func NewBreakers() *Breakers {
+	return &Breakers{
+		mu:       sync.Mutex{},
+		breakers: make(map[int]*entry),
+	}
+}
+
+// Get returns the breaker for the route, or nil when the route has no breaker configured
+func (bs *Breakers) Get(routeID int, settings Settings) *Breaker {
+	if !settings.Enabled() {
+		return nil
+	}
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if e, ok := bs.breakers[routeID]; ok && e.settings == settings {
+		return e.breaker
+	}
+
+	name := "route_" + strconv.Itoa(routeID)
+	b := New(name, settings, recordTransition)
+	bs.breakers[routeID] = &entry{settings: settings, breaker: b}
+	metrics.SetString(metrics.CircuitBreakerState, name, Closed.String())
+
+	return b
+}
+
+func recordTransition(name string, _, to State) {
+	metrics.SetString(metrics.CircuitBreakerState, name, to.String())
+	metrics.CircuitBreakerTransitions.Add(name+":"+to.String(), 1)
+}
+
+// SettingsFor reads the route's breaker thresholds, unset values fall back to the breaker defaults
+func SettingsFor(route *model.Route) Settings {
+	return Settings{
+		ErrorRatePercent:    route.BreakerErrorRatePercent,
+		SlowCallRatePercent: route.BreakerSlowCallRatePercent,
+		SlowCall:            time.Duration(route.BreakerSlowCallMs) * time.Millisecond,
+		MinRequests:         route.BreakerMinRequests,
+		Window:              time.Duration(route.BreakerWindowMs) * time.Millisecond,
+		OpenDuration:        time.Duration(route.BreakerOpenMs) * time.Millisecond,
+		HalfOpenRequests:    route.BreakerHalfOpenRequests,
+	}
+}
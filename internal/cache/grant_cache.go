@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// grantKey identifies what a grant entitles, serviceAccountID is zero for grants covering a whole org and routeID is
+// zero for grants on a route group
+type grantKey struct {
+	orgID            int
+	serviceAccountID int
+	routeID          int
+	group            string
+}
+
+// GrantCache holds the active route grants in memory so the proxy can check them without querying the database
+type GrantCache struct {
+	rw     sync.RWMutex
+	grants map[grantKey]struct{}
+}
+
+func NewGrantCache() *GrantCache {
+	return &GrantCache{
+		rw:     sync.RWMutex{},
+		grants: make(map[grantKey]struct{}),
+	}
+}
+
+// Allowed reports whether the service account, directly or through its org, holds a grant on the route or its group
+func (g *GrantCache) Allowed(route *model.Route, orgID, serviceAccountID int) bool {
+	keys := []grantKey{
+		{orgID: orgID, routeID: route.ID},
+		{orgID: orgID, serviceAccountID: serviceAccountID, routeID: route.ID},
+	}
+
+	if route.Group != "" {
+		keys = append(keys,
+			grantKey{orgID: orgID, group: route.Group},
+			grantKey{orgID: orgID, serviceAccountID: serviceAccountID, group: route.Group},
+		)
+	}
+
+	g.rw.RLock()
+	defer g.rw.RUnlock()
+
+	for _, key := range keys {
+		if _, ok := g.grants[key]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Set replaces the cached grants
+func (g *GrantCache) Set(grants []*model.RouteGrant) {
+	index := make(map[grantKey]struct{}, len(grants))
+
+	for _, grant := range grants {
+		key := grantKey{orgID: grant.OrgID}
+
+		if grant.ServiceAccountID != nil {
+			key.serviceAccountID = *grant.ServiceAccountID
+		}
+
+		switch {
+		case grant.RouteID != nil:
+			key.routeID = *grant.RouteID
+		case grant.RouteGroup != nil:
+			key.group = *grant.RouteGroup
+		default:
+			continue
+		}
+
+		index[key] = struct{}{}
+	}
+
+	g.rw.Lock()
+	defer g.rw.Unlock()
+	g.grants = index
+}
+
+func (g *GrantCache) StartSync(ctx context.Context, interval time.Duration, findGrants func() ([]*model.RouteGrant, error)) {
+	g.syncCache(findGrants)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				g.syncCache(findGrants)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (g *GrantCache) syncCache(findGrants func() ([]*model.RouteGrant, error)) {
+	slog.Info("started route grant cache sync...")
+
+	grants, err := findGrants()
+
+	if err != nil {
+		slog.Error("error syncing route grants from db to cache", "err", err)
+		return
+	}
+
+	g.Set(grants)
+
+	slog.Info("finished route grant cache sync...")
+}
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"api-proxy/internal/model"
+	"testing"
+)
+
+func TestGrantCache_Allowed(t *testing.T) {
+	cache := NewGrantCache()
+	cache.Set([]*model.RouteGrant{
+		{ID: 1, OrgID: 1, RouteID: new(10)},
+		{ID: 2, OrgID: 2, ServiceAccountID: new(20), RouteID: new(10)},
+		{ID: 3, OrgID: 3, RouteGroup: new("billing")},
+		{ID: 4, OrgID: 4, ServiceAccountID: new(40), RouteGroup: new("billing")},
+		{ID: 5, OrgID: 5},
+	})
+
+	billing := &model.Route{ID: 11, Group: "billing"}
+	ungrouped := &model.Route{ID: 10}
+
+	scenarios := []struct {
+		name             string
+		route            *model.Route
+		orgID            int
+		serviceAccountID int
+		expected         bool
+	}{
+		{name: "org grant on route", route: ungrouped, orgID: 1, serviceAccountID: 100, expected: true},
+		{name: "org grant on another route", route: billing, orgID: 1, serviceAccountID: 100, expected: false},
+		{name: "service account grant on route", route: ungrouped, orgID: 2, serviceAccountID: 20, expected: true},
+		{name: "other service account of the org", route: ungrouped, orgID: 2, serviceAccountID: 21, expected: false},
+		{name: "org grant on group", route: billing, orgID: 3, serviceAccountID: 30, expected: true},
+		{name: "org grant on group does not cover ungrouped route", route: ungrouped, orgID: 3, serviceAccountID: 30, expected: false},
+		{name: "service account grant on group", route: billing, orgID: 4, serviceAccountID: 40, expected: true},
+		{name: "grant without route or group is ignored", route: ungrouped, orgID: 5, serviceAccountID: 50, expected: false},
+		{name: "org without grants", route: billing, orgID: 6, serviceAccountID: 60, expected: false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if got := cache.Allowed(s.route, s.orgID, s.serviceAccountID); got != s.expected {
+				t.Errorf("expected %v, got %v", s.expected, got)
+			}
+		})
+	}
+}
@@ -2,8 +2,12 @@ package cache
 
 import (
 	"api-proxy/internal/model"
+	"api-proxy/internal/routing"
 	"context"
 	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -11,15 +15,26 @@ import (
 type RouteCache struct {
 	rw    sync.RWMutex
 	cache map[string]*model.Route
+	tree  *routing.Tree
 }
 
 func NewRouteCache() *RouteCache {
 	return &RouteCache{
 		rw:    sync.RWMutex{},
 		cache: newCache(0),
+		tree:  routing.NewTree(),
 	}
 }
 
+// Match resolves a request against the compiled route tree
+func (r *RouteCache) Match(request *http.Request) (*model.Route, map[string]string, bool) {
+	r.rw.RLock()
+	tree := r.tree
+	r.rw.RUnlock()
+
+	return tree.Match(request)
+}
+
 func (r *RouteCache) FindActiveByFilter(filter *model.RouteFilter) ([]*model.Route, error) {
 	r.rw.RLock()
 	defer r.rw.RUnlock()
@@ -67,6 +82,7 @@ func (r *RouteCache) Set(key string, route *model.Route) {
 	defer r.rw.Unlock()
 
 	r.cache[key] = route
+	r.tree = buildTree(r.cache)
 }
 
 func (r *RouteCache) Delete(key string) {
@@ -74,6 +90,7 @@ func (r *RouteCache) Delete(key string) {
 	defer r.rw.Unlock()
 
 	delete(r.cache, key)
+	r.tree = buildTree(r.cache)
 }
 
 func (r *RouteCache) Clear() {
@@ -81,6 +98,7 @@ func (r *RouteCache) Clear() {
 	defer r.rw.Unlock()
 
 	r.cache = newCache(len(r.cache))
+	r.tree = routing.NewTree()
 }
 
 func newCache(size int) map[string]*model.Route {
@@ -118,12 +136,35 @@ func (r *RouteCache) syncCache(findRoutes func() ([]*model.Route, error)) {
 	nc := newCache(len(routes))
 
 	for _, route := range routes {
-		nc[route.Pattern+":"+route.Method] = route
+		nc[strconv.Itoa(route.ID)] = route
 	}
 
+	tree := buildTree(nc)
+
 	r.rw.Lock()
 	defer r.rw.Unlock()
 	r.cache = nc
+	r.tree = tree
 
 	slog.Info("finished route cache sync...")
 }
+
+// buildTree compiles the cached routes into a matcher, a route that cannot be compiled is logged and left unreachable
+func buildTree(routes map[string]*model.Route) *routing.Tree {
+	list := make([]*model.Route, 0, len(routes))
+
+	for _, route := range routes {
+		list = append(list, route)
+	}
+
+	// Patterns that only differ by parameter names collide in the tree, sorting keeps the oldest route in place
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	tree, failed := routing.Build(list)
+
+	for route, err := range failed {
+		slog.Error("unable to compile route", "route_id", route.ID, "pattern", route.Pattern, "method", route.Method, "err", err)
+	}
+
+	return tree
+}
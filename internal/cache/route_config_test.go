@@ -6,6 +6,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"sort"
 	"sync"
@@ -394,3 +395,40 @@ func TestRouteCache_StartSync(t *testing.T) {
 		})
 	}
 }
+
+func TestRouteCache_Match(t *testing.T) {
+	cache := NewRouteCache()
+
+	cache.syncCache(func() ([]*model.Route, error) {
+		return []*model.Route{
+			{ID: 1, Pattern: "/api/v1/users/{id}", Method: http.MethodGet},
+			{ID: 2, Pattern: "/api/v1/users/me", Method: http.MethodGet},
+			{ID: 3, Pattern: "/api/v1/users/{userId}", Method: http.MethodGet},
+		}, nil
+	})
+
+	scenarios := []struct {
+		name       string
+		path       string
+		expectedID int
+	}{
+		{name: "static", path: "/api/v1/users/me", expectedID: 2},
+		{name: "param keeps oldest of colliding routes", path: "/api/v1/users/42", expectedID: 1},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			route, _, ok := cache.Match(httptest.NewRequest(http.MethodGet, scenario.path, nil))
+
+			if !ok || route.ID != scenario.expectedID {
+				t.Fatalf("expected route %d, got %v", scenario.expectedID, route)
+			}
+		})
+	}
+
+	cache.Delete("2")
+
+	if route, _, ok := cache.Match(httptest.NewRequest(http.MethodGet, "/api/v1/users/me", nil)); !ok || route.ID != 1 {
+		t.Fatalf("expected route 1 after delete, got %v", route)
+	}
+}
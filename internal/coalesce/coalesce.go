@@ -0,0 +1,249 @@
+package coalesce
+
+import (
+	"api-proxy/internal/identity"
+	"api-proxy/internal/metrics"
+	"api-proxy/internal/model"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Parts a route's coalescing key can add on top of the method and the resolved URL
+const (
+	KeyOrgID        = "org_id"
+	KeyHeaderPrefix = "header:"
+)
+
+// keyCredentials adds a digest of the outbound Authorization header to the key of routes forwarding the caller's own
+// credentials, the backend authorizes each of them on its own
+const keyCredentials = "credentials"
+
+// conditionalHeaders are always part of the key, a caller asking for part of a response or only for changes must not
+// be handed somebody else's answer
+var conditionalHeaders = []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "Range", "If-Range"}
+
+// errNotShared tells waiters the leader's response could not be shared and they have to call the backend themselves
+var errNotShared = errors.New("response not shared")
+
+// ValidKeyPart reports whether part can be used in a route's coalescing key
+func ValidKeyPart(part string) bool {
+	if part == KeyOrgID {
+		return true
+	}
+
+	return strings.HasPrefix(part, KeyHeaderPrefix) && len(part) > len(KeyHeaderPrefix)
+}
+
+// Safe reports whether requests with the method can be collapsed onto one upstream call
+func Safe(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// Policy is a route's coalescing setup, Key lists the parts added to the method and resolved URL
+type Policy struct {
+	Name    string
+	Enabled bool
+	Key     []string
+}
+
+// PolicyFor builds the route's coalescing setup. Every caller is authenticated and the backend answers for the caller's
+// org, so the org is always part of the key. Routes passing the caller's credentials through also key on them.
+func PolicyFor(route *model.Route) Policy {
+	policy := Policy{
+		Name:    "route_" + strconv.Itoa(route.ID),
+		Enabled: route.CoalesceEnabled,
+		Key:     slices.Clip(route.CoalesceKey),
+	}
+
+	if !slices.Contains(policy.Key, KeyOrgID) {
+		policy.Key = append(policy.Key, KeyOrgID)
+	}
+
+	if route.IdentityMode == "" || route.IdentityMode == identity.ModePassthrough {
+		policy.Key = append(policy.Key, keyCredentials)
+	}
+
+	return policy
+}
+
+// KeyFor builds the key of an outbound request, requests with the same key are answered by the same upstream call
+func (p Policy) KeyFor(r *http.Request, caller *identity.Identity) string {
+	var b strings.Builder
+
+	b.WriteString(r.Method + " " + r.URL.String())
+
+	for _, name := range conditionalHeaders {
+		if values := r.Header.Values(name); len(values) > 0 {
+			b.WriteString("|" + strings.ToLower(name) + "=" + strings.Join(values, ","))
+		}
+	}
+
+	for _, part := range p.Key {
+		b.WriteString("|")
+
+		switch {
+		case part == KeyOrgID && caller != nil:
+			b.WriteString("org_id=" + strconv.Itoa(caller.OrgID))
+		case part == keyCredentials:
+			sum := sha256.Sum256([]byte(r.Header.Get("Authorization")))
+			b.WriteString("credentials=" + hex.EncodeToString(sum[:]))
+		case strings.HasPrefix(part, KeyHeaderPrefix):
+			name := strings.TrimPrefix(part, KeyHeaderPrefix)
+			b.WriteString(strings.ToLower(name) + "=" + strings.Join(r.Header.Values(name), ","))
+		default:
+			b.WriteString(part + "=")
+		}
+	}
+
+	return b.String()
+}
+
+// call is an upstream call in flight, its result is set before done is closed
+type call struct {
+	done     chan struct{}
+	waiters  int
+	header   http.Header
+	trailer  http.Header
+	body     []byte
+	length   int64
+	err      error
+	template *http.Response
+}
+
+// Group collapses concurrent requests with the same key onto the first one's upstream call. Responses with bodies
+// larger than maxBodyBytes are not shared, the waiters then make their own calls.
+type Group struct {
+	mu           sync.Mutex
+	calls        map[string]*call
+	maxBodyBytes int64
+}
+
+func NewGroup(maxBodyBytes int64) *Group {
+	return &Group{
+		mu:           sync.Mutex{},
+		calls:        make(map[string]*call),
+		maxBodyBytes: maxBodyBytes,
+	}
+}
+
+// Do sends r unless a request with the same key is already in flight, in which case it waits for that request's
+// response and returns a copy of it. A waiter whose leader failed because its own caller hung up, or whose response
+// was too large to share, sends r itself.
+func (g *Group) Do(r *http.Request, key string, policy Policy, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	g.mu.Lock()
+
+	if c, ok := g.calls[key]; ok {
+		c.waiters++
+		g.mu.Unlock()
+
+		return g.wait(r, c, policy, send)
+	}
+
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	metrics.Coalescing.Add(policy.Name+":leader", 1)
+
+	response, err := g.lead(r, c, send)
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(c.done)
+
+	return response, err
+}
+
+// lead makes the upstream call and reads its body so it can be handed to the waiters
+func (g *Group) lead(r *http.Request, c *call, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	response, err := send(r)
+
+	if err != nil {
+		c.err = err
+
+		if r.Context().Err() != nil {
+			c.err = errNotShared
+		}
+
+		return nil, err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, g.maxBodyBytes+1))
+
+	if err != nil {
+		response.Body.Close()
+		c.err = errNotShared
+
+		return nil, err
+	}
+
+	if int64(len(body)) > g.maxBodyBytes {
+		c.err = errNotShared
+		response.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), response.Body), response.Body}
+
+		return response, nil
+	}
+
+	response.Body.Close()
+
+	c.header = response.Header.Clone()
+	c.trailer = response.Trailer.Clone()
+	c.body = body
+	c.length = int64(len(body))
+	c.template = response
+
+	// A HEAD response has no body but announces the length of the GET one
+	if r.Method == http.MethodHead {
+		c.length = response.ContentLength
+	}
+
+	return c.response(r), nil
+}
+
+func (g *Group) wait(r *http.Request, c *call, policy Policy, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	select {
+	case <-c.done:
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+
+	if errors.Is(c.err, errNotShared) {
+		return send(r)
+	}
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	metrics.Coalescing.Add(policy.Name+":shared", 1)
+
+	return c.response(r), nil
+}
+
+// response builds a copy of the shared response for the request
+func (c *call) response(r *http.Request) *http.Response {
+	return &http.Response{
+		Status:        c.template.Status,
+		StatusCode:    c.template.StatusCode,
+		Proto:         c.template.Proto,
+		ProtoMajor:    c.template.ProtoMajor,
+		ProtoMinor:    c.template.ProtoMinor,
+		Header:        c.header.Clone(),
+		Trailer:       c.trailer.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(c.body)),
+		ContentLength: c.length,
+		Request:       r,
+	}
+}
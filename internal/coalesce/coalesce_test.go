@@ -0,0 +1,281 @@
+package coalesce
+
+import (
+	"api-proxy/internal/identity"
+	"api-proxy/internal/model"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// gatedBackend holds every call until release is closed so concurrent requests pile up behind the first one
+type gatedBackend struct {
+	calls   atomic.Int32
+	release chan struct{}
+	body    string
+	err     error
+}
+
+func (b *gatedBackend) send(r *http.Request) (*http.Response, error) {
+	b.calls.Add(1)
+
+	select {
+	case <-b.release:
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": {"text/plain"}},
+		Body:          io.NopCloser(strings.NewReader(b.body)),
+		ContentLength: int64(len(b.body)),
+		Request:       r,
+	}, nil
+}
+
+type result struct {
+	body string
+	err  error
+}
+
+func do(g *Group, policy Policy, b *gatedBackend, r *http.Request) <-chan result {
+	results := make(chan result, 1)
+
+	go func() {
+		response, err := g.Do(r, policy.KeyFor(r, nil), policy, b.send)
+
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+
+		body, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		results <- result{body: string(body), err: err}
+	}()
+
+	return results
+}
+
+// waitFor blocks until the call in flight for the key has the number of waiters
+func waitFor(g *Group, key string, waiters int) {
+	for {
+		g.mu.Lock()
+		c, ok := g.calls[key]
+		joined := ok && c.waiters == waiters
+		g.mu.Unlock()
+
+		if joined {
+			return
+		}
+	}
+}
+
+func TestGroup_Do(t *testing.T) {
+	errBackend := errors.New("connection refused")
+
+	scenarios := []struct {
+		name          string
+		body          string
+		err           error
+		cancelLeader  bool
+		expectedCalls int32
+		expectedErr   error
+	}{
+		{name: "waiters share the response", body: "payload", expectedCalls: 1},
+		{name: "waiters share the error", err: errBackend, expectedCalls: 1, expectedErr: errBackend},
+		{name: "waiters call the backend when the leader's caller hangs up", body: "payload", cancelLeader: true, expectedCalls: 4},
+		{name: "waiters call the backend when the response is too large", body: strings.Repeat("a", 64), expectedCalls: 4},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			g := NewGroup(32)
+			b := &gatedBackend{release: make(chan struct{}), body: scenario.body, err: scenario.err}
+			policy := PolicyFor(&model.Route{ID: 1, CoalesceEnabled: true})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			leaderRequest := httptest.NewRequest(http.MethodGet, "http://backend/countries", nil).WithContext(ctx)
+			key := policy.KeyFor(leaderRequest, nil)
+			leader := do(g, policy, b, leaderRequest)
+
+			waitFor(g, key, 0)
+
+			var waiters []<-chan result
+
+			for range 3 {
+				waiters = append(waiters, do(g, policy, b, httptest.NewRequest(http.MethodGet, "http://backend/countries", nil)))
+			}
+
+			waitFor(g, key, 3)
+
+			if scenario.cancelLeader {
+				cancel()
+
+				if leaderResult := <-leader; !errors.Is(leaderResult.err, context.Canceled) {
+					t.Fatalf("expected the leader to be cancelled, got %v", leaderResult.err)
+				}
+			}
+
+			close(b.release)
+
+			if !scenario.cancelLeader {
+				if leaderResult := <-leader; leaderResult.body != scenario.body || !errors.Is(leaderResult.err, scenario.expectedErr) {
+					t.Fatalf("expected the leader to get %q and %v, got %q and %v", scenario.body, scenario.expectedErr, leaderResult.body, leaderResult.err)
+				}
+			}
+
+			for _, waiter := range waiters {
+				if waiterResult := <-waiter; waiterResult.body != scenario.body || !errors.Is(waiterResult.err, scenario.expectedErr) {
+					t.Fatalf("expected every waiter to get %q and %v, got %q and %v", scenario.body, scenario.expectedErr, waiterResult.body, waiterResult.err)
+				}
+			}
+
+			if calls := b.calls.Load(); calls != scenario.expectedCalls {
+				t.Fatalf("expected %d backend calls, got %d", scenario.expectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestGroup_Do_WaiterHangsUp(t *testing.T) {
+	g := NewGroup(32)
+	b := &gatedBackend{release: make(chan struct{}), body: "payload"}
+	policy := PolicyFor(&model.Route{ID: 1, CoalesceEnabled: true})
+
+	leaderRequest := httptest.NewRequest(http.MethodGet, "http://backend/countries", nil)
+	key := policy.KeyFor(leaderRequest, nil)
+	leader := do(g, policy, b, leaderRequest)
+
+	waitFor(g, key, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waiter := do(g, policy, b, httptest.NewRequest(http.MethodGet, "http://backend/countries", nil).WithContext(ctx))
+
+	waitFor(g, key, 1)
+	cancel()
+
+	if waiterResult := <-waiter; !errors.Is(waiterResult.err, context.Canceled) {
+		t.Fatalf("expected the waiter to stop waiting, got %v", waiterResult.err)
+	}
+
+	close(b.release)
+
+	if leaderResult := <-leader; leaderResult.body != "payload" {
+		t.Fatalf("expected the leader not to be affected, got %q and %v", leaderResult.body, leaderResult.err)
+	}
+}
+
+func digest(value string) string {
+	sum := sha256.Sum256([]byte(value))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPolicy_KeyFor(t *testing.T) {
+	caller := &identity.Identity{OrgID: 7, ServiceAccountID: 9}
+
+	scenarios := []struct {
+		name     string
+		method   string
+		key      []string
+		mode     string
+		header   http.Header
+		caller   *identity.Identity
+		expected string
+	}{
+		{name: "method and url", method: http.MethodGet, mode: identity.ModeHeaders, expected: "GET http://backend/countries?a=1|org_id="},
+		{name: "head", method: http.MethodHead, mode: identity.ModeHeaders, expected: "HEAD http://backend/countries?a=1|org_id="},
+		{name: "org", method: http.MethodGet, key: []string{KeyOrgID}, mode: identity.ModeHeaders, caller: caller, expected: "GET http://backend/countries?a=1|org_id=7"},
+		{name: "identity headers add the org", method: http.MethodGet, mode: identity.ModeHeaders, caller: caller, expected: "GET http://backend/countries?a=1|org_id=7"},
+		{name: "identity token adds the org once", method: http.MethodGet, key: []string{KeyOrgID}, mode: identity.ModeToken, caller: caller, expected: "GET http://backend/countries?a=1|org_id=7"},
+		{name: "passthrough adds the org and the credentials", method: http.MethodGet, mode: identity.ModePassthrough, header: http.Header{"Authorization": {"Bearer abc"}}, caller: caller, expected: "GET http://backend/countries?a=1|org_id=7|credentials=" + digest("Bearer abc")},
+		{name: "default mode adds the org and the credentials", method: http.MethodGet, header: http.Header{"Authorization": {"Bearer abc"}}, caller: caller, expected: "GET http://backend/countries?a=1|org_id=7|credentials=" + digest("Bearer abc")},
+		{name: "header", method: http.MethodGet, key: []string{"header:Accept"}, mode: identity.ModeHeaders, header: http.Header{"Accept": {"application/json"}}, expected: "GET http://backend/countries?a=1|accept=application/json|org_id="},
+		{name: "conditional headers", method: http.MethodGet, mode: identity.ModeHeaders, header: http.Header{"If-None-Match": {`"v1"`}, "Range": {"bytes=0-9"}}, expected: `GET http://backend/countries?a=1|if-none-match="v1"|range=bytes=0-9|org_id=`},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			r := httptest.NewRequest(scenario.method, "http://backend/countries?a=1", nil)
+
+			for name, values := range scenario.header {
+				r.Header[name] = values
+			}
+
+			policy := PolicyFor(&model.Route{ID: 1, CoalesceEnabled: true, CoalesceKey: scenario.key, IdentityMode: scenario.mode})
+
+			if key := policy.KeyFor(r, scenario.caller); key != scenario.expected {
+				t.Fatalf("expected key %q, got %q", scenario.expected, key)
+			}
+		})
+	}
+}
+
+// TestGroup_Do_Passthrough checks callers of different orgs on a passthrough route never share a response, the backend
+// authorized it for one of them only
+func TestGroup_Do_Passthrough(t *testing.T) {
+	g := NewGroup(32)
+	b := &gatedBackend{release: make(chan struct{}), body: "payload"}
+	policy := PolicyFor(&model.Route{ID: 1, CoalesceEnabled: true})
+
+	request := func(orgID int, token string) (*http.Request, string) {
+		r := httptest.NewRequest(http.MethodGet, "http://backend/countries", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		return r, policy.KeyFor(r, &identity.Identity{OrgID: orgID})
+	}
+
+	first, firstKey := request(7, "token-7")
+	second, secondKey := request(8, "token-8")
+
+	if firstKey == secondKey {
+		t.Fatalf("expected callers of different orgs to get different keys, both got %q", firstKey)
+	}
+
+	results := make(chan error, 2)
+
+	for _, call := range []struct {
+		r   *http.Request
+		key string
+	}{{first, firstKey}, {second, secondKey}} {
+		go func() {
+			response, err := g.Do(call.r, call.key, policy, b.send)
+
+			if err == nil {
+				response.Body.Close()
+			}
+
+			results <- err
+		}()
+	}
+
+	waitFor(g, firstKey, 0)
+	waitFor(g, secondKey, 0)
+	close(b.release)
+
+	for range 2 {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if b.calls.Load() != 2 {
+		t.Fatalf("expected each org to call the backend, got %d calls", b.calls.Load())
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,17 +15,108 @@ const (
 	defaultLogLevel             = "INFO"
 	defaultRequestLogQueueSize  = 500
 	defaultRequestRetentionDays = 7
+
+	defaultProxyConnectTimeout        = 5 * time.Second
+	defaultProxyResponseHeaderTimeout = 30 * time.Second
+	defaultProxyIdleConnTimeout       = 90 * time.Second
+	defaultProxyMaxIdleConns          = 100
+	defaultRetryMaxBodyBytes          = 64 << 10
+	defaultRetryBudgetPercent         = 20
+	defaultRetryBudgetMinPerSecond    = 10
+	defaultWebSocketIdleTimeout       = 5 * time.Minute
+	defaultWebSocketMaxConns          = 100
+	defaultCoalesceMaxBodyBytes       = 1 << 20
+	defaultMirrorMaxInFlight          = 100
+	defaultMirrorMaxBodyBytes         = 1 << 20
+	defaultMirrorTimeout              = 30 * time.Second
+
+	defaultIdentityTokenTTL = time.Minute
+	defaultRefreshTokenTTL  = 30 * 24 * time.Hour
+	defaultJWTIssuer        = "api-proxy"
+	defaultOIDCSubjectClaim = "sub"
+
+	defaultRevocationBackend      = "memory"
+	defaultRevocationSyncInterval = 10 * time.Second
+
+	defaultResponseCacheBackend       = "memory"
+	defaultResponseCacheMaxEntries    = 10000
+	defaultResponseCacheMaxBytes      = 64 << 20
+	defaultResponseCacheMaxEntryBytes = 1 << 20
 )
 
 var ErrInvalidLoggingRequestQueueSize = errors.New("invalid logging request queue size")
 var ErrInvalidLoggingRequestRetention = errors.New("invalid logging request retention")
+var ErrInvalidOIDCProvider = errors.New("oidc providers need a unique name, an issuer, an audience and a service_account or org claim with the service accounts or orgs it may map to")
 
 type Config struct {
-	Server             *ServerConfig       `yaml:"server"`
-	DB                 *DBConfig           `yaml:"db"`
-	JWTConfig          *JWTConfig          `yaml:"jwt"`
-	LoggingConfig      *LoggingConfig      `yaml:"logging"`
-	RateLimitingConfig *RateLimitingConfig `yaml:"rate_limiting"`
+	Server             *ServerConfig        `yaml:"server"`
+	DB                 *DBConfig            `yaml:"db"`
+	JWTConfig          *JWTConfig           `yaml:"jwt"`
+	LoggingConfig      *LoggingConfig       `yaml:"logging"`
+	RateLimitingConfig *RateLimitingConfig  `yaml:"rate_limiting"`
+	ProxyConfig        *ProxyConfig         `yaml:"proxy"`
+	EncryptionConfig   *EncryptionConfig    `yaml:"encryption"`
+	ResponseCache      *ResponseCacheConfig `yaml:"response_cache"`
+	OIDC               *OIDCConfig          `yaml:"oidc"`
+}
+
+// OIDCConfig lists the identity providers whose tokens can be exchanged for the proxy's own
+type OIDCConfig struct {
+	Providers []OIDCProviderConfig `yaml:"providers"`
+}
+
+// OIDCProviderConfig describes how to verify a provider's tokens and who they stand for. JWKSURL is discovered from
+// the issuer when empty. A token maps to the service account named by its ServiceAccount claim, or to the org named
+// by its Org claim when it carries no service account. The provider may only map to the service accounts listed in
+// ServiceAccounts and the orgs listed in Orgs, keyed by org name with the service account the provider's users of
+// that org act as.
+type OIDCProviderConfig struct {
+	Name            string            `yaml:"name"`
+	Issuer          string            `yaml:"issuer"`
+	Audience        string            `yaml:"audience"`
+	JWKSURL         string            `yaml:"jwks_url"`
+	Claims          OIDCClaimsConfig  `yaml:"claims"`
+	ServiceAccounts []string          `yaml:"service_accounts"`
+	Orgs            map[string]string `yaml:"orgs"`
+}
+
+// mapsSubjects reports whether the provider has a claim to map its tokens by and lists who each claim may map to
+func (p *OIDCProviderConfig) mapsSubjects() bool {
+	if p.Claims.ServiceAccount == "" && p.Claims.Org == "" {
+		return false
+	}
+
+	if (p.Claims.ServiceAccount != "" && len(p.ServiceAccounts) == 0) || (p.Claims.Org != "" && len(p.Orgs) == 0) {
+		return false
+	}
+
+	for _, serviceAccount := range p.Orgs {
+		if serviceAccount == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+type OIDCClaimsConfig struct {
+	Subject        string `yaml:"subject"`
+	ServiceAccount string `yaml:"service_account"`
+	Org            string `yaml:"org"`
+}
+
+// ResponseCacheConfig selects where responses of routes with caching enabled are stored. The redis backend shares
+// the rate limiter's redis connection, the size limits only apply to the memory backend except MaxEntryBytes.
+type ResponseCacheConfig struct {
+	Backend       string `yaml:"backend"`
+	MaxEntries    int    `yaml:"max_entries"`
+	MaxBytes      int64  `yaml:"max_bytes"`
+	MaxEntryBytes int64  `yaml:"max_entry_bytes"`
+}
+
+// EncryptionConfig holds the base64 encoded 32 byte key used to encrypt secrets stored in the database
+type EncryptionConfig struct {
+	Key string `yaml:"key"`
 }
 
 type LoggingConfig struct {
@@ -43,7 +136,36 @@ type LoggingAuditConfig struct {
 }
 
 type ServerConfig struct {
-	Port string `yaml:"port"`
+	Port           string   `yaml:"port"`
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// ProxyConfig holds the defaults used for upstream connections when a route does not override them.
+// A zero Timeout means proxied calls are only bounded by the connect and response header timeouts.
+// Retries are capped across all routes to the budget percent of recent requests, plus a small per second floor,
+// and a request body larger than RetryMaxBodyBytes is never retried. WebSocket and other upgraded connections are
+// not bound by Timeout, they are closed after WebSocketIdleTimeout without traffic. Coalesced requests only share
+// responses up to CoalesceMaxBodyBytes, callers waiting on a larger one make their own call. Mirrored copies are
+// dropped beyond MirrorMaxInFlight outstanding ones and never sent for bodies over MirrorMaxBodyBytes.
+// RequireGrants, on unless set to false, refuses callers without a grant on every route. Turning it off while grants
+// are rolled out leaves only the routes with require_grant set checked.
+type ProxyConfig struct {
+	ConnectTimeout                     time.Duration `yaml:"connect_timeout"`
+	ResponseHeaderTimeout              time.Duration `yaml:"response_header_timeout"`
+	IdleConnTimeout                    time.Duration `yaml:"idle_conn_timeout"`
+	Timeout                            time.Duration `yaml:"timeout"`
+	MaxIdleConns                       int           `yaml:"max_idle_conns"`
+	MaxConns                           int           `yaml:"max_conns"`
+	RetryMaxBodyBytes                  int64         `yaml:"retry_max_body_bytes"`
+	RetryBudgetPercent                 int           `yaml:"retry_budget_percent"`
+	RetryBudgetMinPerSecond            int           `yaml:"retry_budget_min_per_second"`
+	WebSocketIdleTimeout               time.Duration `yaml:"websocket_idle_timeout"`
+	WebSocketMaxConnsPerServiceAccount int           `yaml:"websocket_max_conns_per_service_account"`
+	CoalesceMaxBodyBytes               int64         `yaml:"coalesce_max_body_bytes"`
+	MirrorMaxInFlight                  int           `yaml:"mirror_max_in_flight"`
+	MirrorMaxBodyBytes                 int64         `yaml:"mirror_max_body_bytes"`
+	MirrorTimeout                      time.Duration `yaml:"mirror_timeout"`
+	RequireGrants                      *bool         `yaml:"require_grants"`
 }
 
 type RateLimitingConfig struct {
@@ -64,14 +186,36 @@ type DBConfig struct {
 }
 
 type JWTConfig struct {
-	SigningSecret string          `yaml:"signing_secret"`
-	Admin         *AdminJWTConfig `yaml:"admin"`
+	SigningSecret string              `yaml:"signing_secret"`
+	Issuer        string              `yaml:"issuer"`
+	Admin         *AdminJWTConfig     `yaml:"admin"`
+	Identity      *IdentityJWTConfig  `yaml:"identity"`
+	Revocation    *RevocationConfig   `yaml:"revocation"`
+	RefreshToken  *RefreshTokenConfig `yaml:"refresh_token"`
 }
 
 type AdminJWTConfig struct {
 	SigningSecret string `yaml:"signing_secret"`
 }
 
+// IdentityJWTConfig configures the short-lived tokens the proxy hands to backends of routes using the token identity mode
+type IdentityJWTConfig struct {
+	SigningSecret string        `yaml:"signing_secret"`
+	TTL           time.Duration `yaml:"ttl"`
+}
+
+// RevocationConfig selects where revoked tokens are looked up. The memory backend reloads the revocations from the
+// database every SyncInterval, the redis backend shares the rate limiter's redis connection.
+type RevocationConfig struct {
+	Backend      string        `yaml:"backend"`
+	SyncInterval time.Duration `yaml:"sync_interval"`
+}
+
+// RefreshTokenConfig sets how long a refresh token can be used, every refresh issues a new one with a fresh TTL
+type RefreshTokenConfig struct {
+	TTL time.Duration `yaml:"ttl"`
+}
+
 func LoadConfig(path string) (*Config, error) {
 	file, err := os.ReadFile(path)
 
@@ -114,18 +258,50 @@ func applyEnvOverrides(config *Config) (*Config, error) {
 		config.JWTConfig.Admin = &AdminJWTConfig{}
 	}
 
+	if config.JWTConfig.Identity == nil {
+		config.JWTConfig.Identity = &IdentityJWTConfig{}
+	}
+
+	if config.JWTConfig.Revocation == nil {
+		config.JWTConfig.Revocation = &RevocationConfig{}
+	}
+
+	if config.JWTConfig.RefreshToken == nil {
+		config.JWTConfig.RefreshToken = &RefreshTokenConfig{}
+	}
+
 	if config.LoggingConfig == nil {
 		config.LoggingConfig = &LoggingConfig{}
 	}
 
+	if config.OIDC == nil {
+		config.OIDC = &OIDCConfig{}
+	}
+
 	if config.LoggingConfig.LoggingRequestConfig == nil {
 		config.LoggingConfig.LoggingRequestConfig = &LoggingRequestConfig{}
 	}
 
+	if config.ProxyConfig == nil {
+		config.ProxyConfig = &ProxyConfig{}
+	}
+
+	if config.EncryptionConfig == nil {
+		config.EncryptionConfig = &EncryptionConfig{}
+	}
+
+	if config.ResponseCache == nil {
+		config.ResponseCache = &ResponseCacheConfig{}
+	}
+
 	if val := os.Getenv("SERVER_PORT"); val != "" {
 		config.Server.Port = val
 	}
 
+	if val := os.Getenv("TRUSTED_PROXIES"); val != "" {
+		config.Server.TrustedProxies = strings.Split(val, ",")
+	}
+
 	if val := os.Getenv("DB_URL"); val != "" {
 		config.DB.URL = val
 	}
@@ -150,10 +326,26 @@ func applyEnvOverrides(config *Config) (*Config, error) {
 		config.JWTConfig.SigningSecret = val
 	}
 
+	if val := os.Getenv("JWT_ISSUER"); val != "" {
+		config.JWTConfig.Issuer = val
+	}
+
 	if val := os.Getenv("ADMIN_JWT_SIGNING_SECRET"); val != "" {
 		config.JWTConfig.Admin.SigningSecret = val
 	}
 
+	if val := os.Getenv("JWT_IDENTITY_SIGNING_SECRET"); val != "" {
+		config.JWTConfig.Identity.SigningSecret = val
+	}
+
+	if val := os.Getenv("TOKEN_REVOCATION_BACKEND"); val != "" {
+		config.JWTConfig.Revocation.Backend = val
+	}
+
+	if val := os.Getenv("ENCRYPTION_KEY"); val != "" {
+		config.EncryptionConfig.Key = val
+	}
+
 	if val := os.Getenv("LOG_LEVEL"); val != "" {
 		config.LoggingConfig.Level = val
 	}
@@ -186,6 +378,10 @@ func applyEnvOverrides(config *Config) (*Config, error) {
 		config.RateLimitingConfig.Redis.URL = val
 	}
 
+	if val := os.Getenv("RESPONSE_CACHE_BACKEND"); val != "" {
+		config.ResponseCache.Backend = val
+	}
+
 	if config.Server.Port == "" {
 		config.Server.Port = DefaultServerPort
 	}
@@ -202,5 +398,113 @@ func applyEnvOverrides(config *Config) (*Config, error) {
 		config.LoggingConfig.LoggingRequestConfig.RetentionDays = new(defaultRequestRetentionDays)
 	}
 
+	if config.JWTConfig.Issuer == "" {
+		config.JWTConfig.Issuer = defaultJWTIssuer
+	}
+
+	if config.JWTConfig.Identity.TTL == 0 {
+		config.JWTConfig.Identity.TTL = defaultIdentityTokenTTL
+	}
+
+	if config.JWTConfig.RefreshToken.TTL == 0 {
+		config.JWTConfig.RefreshToken.TTL = defaultRefreshTokenTTL
+	}
+
+	if config.JWTConfig.Revocation.Backend == "" {
+		config.JWTConfig.Revocation.Backend = defaultRevocationBackend
+	}
+
+	if config.JWTConfig.Revocation.SyncInterval == 0 {
+		config.JWTConfig.Revocation.SyncInterval = defaultRevocationSyncInterval
+	}
+
+	if config.ProxyConfig.ConnectTimeout == 0 {
+		config.ProxyConfig.ConnectTimeout = defaultProxyConnectTimeout
+	}
+
+	if config.ProxyConfig.ResponseHeaderTimeout == 0 {
+		config.ProxyConfig.ResponseHeaderTimeout = defaultProxyResponseHeaderTimeout
+	}
+
+	if config.ProxyConfig.IdleConnTimeout == 0 {
+		config.ProxyConfig.IdleConnTimeout = defaultProxyIdleConnTimeout
+	}
+
+	if config.ProxyConfig.MaxIdleConns == 0 {
+		config.ProxyConfig.MaxIdleConns = defaultProxyMaxIdleConns
+	}
+
+	if config.ProxyConfig.RetryMaxBodyBytes == 0 {
+		config.ProxyConfig.RetryMaxBodyBytes = defaultRetryMaxBodyBytes
+	}
+
+	if config.ProxyConfig.RetryBudgetPercent == 0 {
+		config.ProxyConfig.RetryBudgetPercent = defaultRetryBudgetPercent
+	}
+
+	if config.ProxyConfig.RetryBudgetMinPerSecond == 0 {
+		config.ProxyConfig.RetryBudgetMinPerSecond = defaultRetryBudgetMinPerSecond
+	}
+
+	if config.ProxyConfig.WebSocketIdleTimeout == 0 {
+		config.ProxyConfig.WebSocketIdleTimeout = defaultWebSocketIdleTimeout
+	}
+
+	if config.ProxyConfig.WebSocketMaxConnsPerServiceAccount == 0 {
+		config.ProxyConfig.WebSocketMaxConnsPerServiceAccount = defaultWebSocketMaxConns
+	}
+
+	if config.ProxyConfig.CoalesceMaxBodyBytes == 0 {
+		config.ProxyConfig.CoalesceMaxBodyBytes = defaultCoalesceMaxBodyBytes
+	}
+
+	if config.ProxyConfig.MirrorMaxInFlight == 0 {
+		config.ProxyConfig.MirrorMaxInFlight = defaultMirrorMaxInFlight
+	}
+
+	if config.ProxyConfig.MirrorMaxBodyBytes == 0 {
+		config.ProxyConfig.MirrorMaxBodyBytes = defaultMirrorMaxBodyBytes
+	}
+
+	if config.ProxyConfig.MirrorTimeout == 0 {
+		config.ProxyConfig.MirrorTimeout = defaultMirrorTimeout
+	}
+
+	if config.ProxyConfig.RequireGrants == nil {
+		config.ProxyConfig.RequireGrants = new(true)
+	}
+
+	if config.ResponseCache.Backend == "" {
+		config.ResponseCache.Backend = defaultResponseCacheBackend
+	}
+
+	if config.ResponseCache.MaxEntries == 0 {
+		config.ResponseCache.MaxEntries = defaultResponseCacheMaxEntries
+	}
+
+	if config.ResponseCache.MaxBytes == 0 {
+		config.ResponseCache.MaxBytes = defaultResponseCacheMaxBytes
+	}
+
+	if config.ResponseCache.MaxEntryBytes == 0 {
+		config.ResponseCache.MaxEntryBytes = defaultResponseCacheMaxEntryBytes
+	}
+
+	names := make(map[string]bool, len(config.OIDC.Providers))
+
+	for i := range config.OIDC.Providers {
+		provider := &config.OIDC.Providers[i]
+
+		if provider.Claims.Subject == "" {
+			provider.Claims.Subject = defaultOIDCSubjectClaim
+		}
+
+		if provider.Name == "" || names[provider.Name] || provider.Issuer == "" || provider.Audience == "" || !provider.mapsSubjects() {
+			return nil, ErrInvalidOIDCProvider
+		}
+
+		names[provider.Name] = true
+	}
+
 	return config, nil
 }
@@ -1,17 +1,21 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var testConfig = `jwt:
   signing_secret: fjd3252jrkal;f234fk
   admin:
     signing_secret: 32fj32l3;f032f09f32
+  identity:
+    signing_secret: 9fj2k3l4;a0s9d8f7
 
 logging:
   level: INFO
@@ -35,7 +39,15 @@ db:
   port: "3306"
   username: root
   password: secret
-  db_name: api_proxy`
+  db_name: api_proxy
+
+encryption:
+  key: BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwc=
+
+proxy:
+  connect_timeout: 2s
+  timeout: 1m
+  max_conns: 50`
 
 func TestLoadConfig(t *testing.T) {
 	scenarios := []struct {
@@ -51,9 +63,21 @@ func TestLoadConfig(t *testing.T) {
 			expectedConfig: Config{
 				JWTConfig: &JWTConfig{
 					SigningSecret: "fjd3252jrkal;f234fk",
+					Issuer:        "api-proxy",
 					Admin: &AdminJWTConfig{
 						SigningSecret: "32fj32l3;f032f09f32",
 					},
+					Identity: &IdentityJWTConfig{
+						SigningSecret: "9fj2k3l4;a0s9d8f7",
+						TTL:           time.Minute,
+					},
+					Revocation: &RevocationConfig{
+						Backend:      "memory",
+						SyncInterval: 10 * time.Second,
+					},
+					RefreshToken: &RefreshTokenConfig{
+						TTL: 30 * 24 * time.Hour,
+					},
 				},
 				LoggingConfig: &LoggingConfig{
 					Level: "INFO",
@@ -82,6 +106,34 @@ func TestLoadConfig(t *testing.T) {
 					Password: "secret",
 					DBName:   "api_proxy",
 				},
+				ProxyConfig: &ProxyConfig{
+					ConnectTimeout:                     2 * time.Second,
+					ResponseHeaderTimeout:              30 * time.Second,
+					IdleConnTimeout:                    90 * time.Second,
+					Timeout:                            time.Minute,
+					MaxIdleConns:                       100,
+					MaxConns:                           50,
+					RetryMaxBodyBytes:                  64 << 10,
+					RetryBudgetPercent:                 20,
+					RetryBudgetMinPerSecond:            10,
+					WebSocketIdleTimeout:               5 * time.Minute,
+					WebSocketMaxConnsPerServiceAccount: 100,
+					CoalesceMaxBodyBytes:               1 << 20,
+					MirrorMaxInFlight:                  100,
+					MirrorMaxBodyBytes:                 1 << 20,
+					MirrorTimeout:                      30 * time.Second,
+					RequireGrants:                      new(true),
+				},
+				EncryptionConfig: &EncryptionConfig{
+					Key: "BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwc=",
+				},
+				ResponseCache: &ResponseCacheConfig{
+					Backend:       "memory",
+					MaxEntries:    10000,
+					MaxBytes:      64 << 20,
+					MaxEntryBytes: 1 << 20,
+				},
+				OIDC: &OIDCConfig{},
 			},
 		},
 		{
@@ -94,9 +146,21 @@ func TestLoadConfig(t *testing.T) {
 			expectedConfig: Config{
 				JWTConfig: &JWTConfig{
 					SigningSecret: "fjd3252jrkal;f234fk",
+					Issuer:        "api-proxy",
 					Admin: &AdminJWTConfig{
 						SigningSecret: "32fj32l3;f032f09f32",
 					},
+					Identity: &IdentityJWTConfig{
+						SigningSecret: "9fj2k3l4;a0s9d8f7",
+						TTL:           time.Minute,
+					},
+					Revocation: &RevocationConfig{
+						Backend:      "memory",
+						SyncInterval: 10 * time.Second,
+					},
+					RefreshToken: &RefreshTokenConfig{
+						TTL: 30 * 24 * time.Hour,
+					},
 				},
 				LoggingConfig: &LoggingConfig{
 					Level: "INFO",
@@ -125,6 +189,34 @@ func TestLoadConfig(t *testing.T) {
 					Password: "secret",
 					DBName:   "api_proxy",
 				},
+				ProxyConfig: &ProxyConfig{
+					ConnectTimeout:                     2 * time.Second,
+					ResponseHeaderTimeout:              30 * time.Second,
+					IdleConnTimeout:                    90 * time.Second,
+					Timeout:                            time.Minute,
+					MaxIdleConns:                       100,
+					MaxConns:                           50,
+					RetryMaxBodyBytes:                  64 << 10,
+					RetryBudgetPercent:                 20,
+					RetryBudgetMinPerSecond:            10,
+					WebSocketIdleTimeout:               5 * time.Minute,
+					WebSocketMaxConnsPerServiceAccount: 100,
+					CoalesceMaxBodyBytes:               1 << 20,
+					MirrorMaxInFlight:                  100,
+					MirrorMaxBodyBytes:                 1 << 20,
+					MirrorTimeout:                      30 * time.Second,
+					RequireGrants:                      new(true),
+				},
+				EncryptionConfig: &EncryptionConfig{
+					Key: "BwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwcHBwc=",
+				},
+				ResponseCache: &ResponseCacheConfig{
+					Backend:       "memory",
+					MaxEntries:    10000,
+					MaxBytes:      64 << 20,
+					MaxEntryBytes: 1 << 20,
+				},
+				OIDC: &OIDCConfig{},
 			},
 		},
 	}
@@ -161,6 +253,108 @@ func TestLoadConfig(t *testing.T) {
 	}
 }
 
+func TestLoadConfig_OIDCProviders(t *testing.T) {
+	scenarios := []struct {
+		name          string
+		providers     string
+		expectedErr   error
+		expectedClaim string
+	}{
+		{
+			name: "subject claim defaults to sub",
+			providers: `
+    - name: kinde
+      issuer: https://acme.kinde.com
+      audience: api-proxy
+      claims:
+        org: org_code
+      orgs:
+        acme: acme-users`,
+			expectedClaim: "sub",
+		},
+		{
+			name: "missing audience",
+			providers: `
+    - name: kinde
+      issuer: https://acme.kinde.com
+      claims:
+        org: org_code
+      orgs:
+        acme: acme-users`,
+			expectedErr: ErrInvalidOIDCProvider,
+		},
+		{
+			name: "org claim without orgs",
+			providers: `
+    - name: kinde
+      issuer: https://acme.kinde.com
+      audience: api-proxy
+      claims:
+        org: org_code`,
+			expectedErr: ErrInvalidOIDCProvider,
+		},
+		{
+			name: "service account claim without service accounts",
+			providers: `
+    - name: kinde
+      issuer: https://acme.kinde.com
+      audience: api-proxy
+      claims:
+        service_account: client_name
+      orgs:
+        acme: acme-users`,
+			expectedErr: ErrInvalidOIDCProvider,
+		},
+		{
+			name: "no mapping claim",
+			providers: `
+    - name: kinde
+      issuer: https://acme.kinde.com
+      audience: api-proxy`,
+			expectedErr: ErrInvalidOIDCProvider,
+		},
+		{
+			name: "duplicate name",
+			providers: `
+    - name: kinde
+      issuer: https://acme.kinde.com
+      audience: api-proxy
+      claims:
+        org: org_code
+    - name: kinde
+      issuer: https://other.kinde.com
+      audience: api-proxy
+      claims:
+        org: org_code
+      orgs:
+        acme: acme-users`,
+			expectedErr: ErrInvalidOIDCProvider,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			testFile := filepath.Join(t.TempDir(), "test_config_oidc.yml")
+
+			writeErr := os.WriteFile(testFile, []byte(testConfig+"\n\noidc:\n  providers:"+scenario.providers), 0644)
+
+			if writeErr != nil {
+				t.Fatal(writeErr)
+			}
+
+			actual, err := LoadConfig(testFile)
+
+			if !errors.Is(err, scenario.expectedErr) {
+				t.Fatalf("expected error %v, got %v", scenario.expectedErr, err)
+			}
+
+			if err == nil && actual.OIDC.Providers[0].Claims.Subject != scenario.expectedClaim {
+				t.Errorf("expected subject claim %s, got %s", scenario.expectedClaim, actual.OIDC.Providers[0].Claims.Subject)
+			}
+		})
+	}
+}
+
 func BenchmarkLoadConfig(b *testing.B) {
 	testFile := filepath.Join(b.TempDir(), "test_config_benchmark.yml")
 	writeErr := os.WriteFile(testFile, []byte(testConfig), 0644)
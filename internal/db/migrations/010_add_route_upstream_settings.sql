@@ -0,0 +1,6 @@
+ALTER TABLE route ADD COLUMN connect_timeout_ms INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN response_header_timeout_ms INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN idle_conn_timeout_ms INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN timeout_ms INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN max_idle_conns INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN max_conns INT NOT NULL DEFAULT 0;
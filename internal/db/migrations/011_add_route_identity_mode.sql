@@ -0,0 +1 @@
+ALTER TABLE route ADD COLUMN identity_mode VARCHAR(31) NOT NULL DEFAULT 'passthrough';
@@ -0,0 +1 @@
+ALTER TABLE route ADD COLUMN upstream_auth BLOB NULL;
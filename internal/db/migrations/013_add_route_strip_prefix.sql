@@ -0,0 +1 @@
+ALTER TABLE route ADD COLUMN strip_prefix VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE route ADD COLUMN host VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE route ADD COLUMN match_headers TEXT NULL;
+ALTER TABLE route ADD COLUMN match_query TEXT NULL;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS upstream (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    strategy VARCHAR(31) NOT NULL DEFAULT 'round_robin',
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at TIMESTAMP(6),
+    inactivated_at TIMESTAMP(6),
+
+    PRIMARY KEY (id),
+    CONSTRAINT uq_upstream_name UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS upstream_target (
+    id INT NOT NULL AUTO_INCREMENT,
+    upstream_id INT NOT NULL,
+    url VARCHAR(255) NOT NULL,
+    weight INT NOT NULL DEFAULT 1,
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+    PRIMARY KEY (id),
+    CONSTRAINT fk_upstream_target_upstream FOREIGN KEY (upstream_id) REFERENCES upstream(id) ON DELETE CASCADE
+);
+
+ALTER TABLE route ADD COLUMN upstream_id INT NULL;
+ALTER TABLE route ADD CONSTRAINT fk_route_upstream FOREIGN KEY (upstream_id) REFERENCES upstream(id);
@@ -0,0 +1,6 @@
+ALTER TABLE upstream ADD COLUMN health_check_path VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE upstream ADD COLUMN health_check_interval_ms INT NOT NULL DEFAULT 0;
+ALTER TABLE upstream ADD COLUMN healthy_threshold INT NOT NULL DEFAULT 0;
+ALTER TABLE upstream ADD COLUMN unhealthy_threshold INT NOT NULL DEFAULT 0;
+ALTER TABLE upstream ADD COLUMN max_consecutive_failures INT NOT NULL DEFAULT 0;
+ALTER TABLE upstream ADD COLUMN ejection_duration_ms INT NOT NULL DEFAULT 0;
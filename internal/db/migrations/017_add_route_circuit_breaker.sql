@@ -0,0 +1,7 @@
+ALTER TABLE route ADD COLUMN breaker_error_rate_percent INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN breaker_slow_call_ms INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN breaker_slow_call_rate_percent INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN breaker_min_requests INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN breaker_window_ms INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN breaker_open_ms INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN breaker_half_open_requests INT NOT NULL DEFAULT 0;
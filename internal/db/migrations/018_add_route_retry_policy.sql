@@ -0,0 +1,6 @@
+ALTER TABLE route ADD COLUMN retry_max_attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN retry_status_codes TEXT NULL;
+ALTER TABLE route ADD COLUMN retry_on TEXT NULL;
+ALTER TABLE route ADD COLUMN retry_backoff_ms INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN retry_max_backoff_ms INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN retry_non_idempotent BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1 @@
+ALTER TABLE route ADD COLUMN websocket_idle_timeout_ms INT NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE request ADD COLUMN bytes_in BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE request ADD COLUMN bytes_out BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE route ADD COLUMN upstream_protocol VARCHAR(10) NOT NULL DEFAULT '';
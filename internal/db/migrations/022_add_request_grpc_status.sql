@@ -0,0 +1 @@
+ALTER TABLE request ADD COLUMN grpc_status INT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE route ADD COLUMN cache_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE route ADD COLUMN cache_ttl_ms INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD COLUMN cache_key TEXT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE route ADD COLUMN coalesce_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE route ADD COLUMN coalesce_key TEXT NULL;
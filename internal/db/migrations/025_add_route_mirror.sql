@@ -0,0 +1,3 @@
+ALTER TABLE route ADD COLUMN mirror_upstream_id INT NULL;
+ALTER TABLE route ADD COLUMN mirror_percent INT NOT NULL DEFAULT 0;
+ALTER TABLE route ADD CONSTRAINT fk_route_mirror_upstream FOREIGN KEY (mirror_upstream_id) REFERENCES upstream(id);
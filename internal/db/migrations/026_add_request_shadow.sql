@@ -0,0 +1 @@
+ALTER TABLE request ADD COLUMN shadow BOOLEAN NOT NULL DEFAULT FALSE;
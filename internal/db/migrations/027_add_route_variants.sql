@@ -0,0 +1,2 @@
+ALTER TABLE route ADD COLUMN variants TEXT NULL;
+ALTER TABLE route ADD COLUMN variant_sticky_by VARCHAR(31) NOT NULL DEFAULT '';
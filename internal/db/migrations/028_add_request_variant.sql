@@ -0,0 +1 @@
+ALTER TABLE request ADD COLUMN variant VARCHAR(63) NOT NULL DEFAULT '';
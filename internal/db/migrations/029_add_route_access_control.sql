@@ -0,0 +1,2 @@
+ALTER TABLE route ADD COLUMN route_group VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE route ADD COLUMN require_grant BOOLEAN NOT NULL DEFAULT FALSE;
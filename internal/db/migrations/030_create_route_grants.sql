@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS route_grant (
+    id INT NOT NULL AUTO_INCREMENT,
+    org_id INT NOT NULL,
+    service_account_id INT NULL,
+    route_id INT NULL,
+    route_group VARCHAR(255) NULL,
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at TIMESTAMP(6),
+    inactivated_at TIMESTAMP(6),
+
+    PRIMARY KEY (id),
+    CONSTRAINT fk_route_grant_org FOREIGN KEY (org_id) REFERENCES org(id),
+    CONSTRAINT fk_route_grant_service_account FOREIGN KEY (service_account_id) REFERENCES service_account(id),
+    CONSTRAINT fk_route_grant_route FOREIGN KEY (route_id) REFERENCES route(id)
+);
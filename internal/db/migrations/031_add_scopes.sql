@@ -0,0 +1,2 @@
+ALTER TABLE service_account ADD COLUMN scopes TEXT NULL;
+ALTER TABLE route ADD COLUMN required_scopes TEXT NULL;
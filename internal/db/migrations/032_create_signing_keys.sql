@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS signing_key (
+    id INT NOT NULL AUTO_INCREMENT,
+    kid VARCHAR(64) NOT NULL,
+    algorithm VARCHAR(16) NOT NULL,
+    private_key BLOB NOT NULL,
+    activates_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    retires_at TIMESTAMP(6) NULL,
+    expires_at TIMESTAMP(6) NULL,
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at TIMESTAMP(6),
+    inactivated_at TIMESTAMP(6),
+
+    PRIMARY KEY (id),
+    CONSTRAINT uq_signing_key_kid UNIQUE (kid)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS token_revocation (
+    id INT NOT NULL AUTO_INCREMENT,
+    jti VARCHAR(64) NULL,
+    org_id INT NULL,
+    service_account_id INT NULL,
+    revoked_at TIMESTAMP(6) NOT NULL,
+    expires_at TIMESTAMP(6) NOT NULL,
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+    PRIMARY KEY (id),
+    INDEX idx_token_revocation_expires_at (expires_at),
+    CONSTRAINT fk_token_revocation_org FOREIGN KEY (org_id) REFERENCES org(id),
+    CONSTRAINT fk_token_revocation_service_account FOREIGN KEY (service_account_id) REFERENCES service_account(id)
+);
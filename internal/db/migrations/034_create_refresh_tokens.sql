@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS refresh_token (
+    id INT NOT NULL AUTO_INCREMENT,
+    family_id VARCHAR(64) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    service_account_id INT NULL,
+    internal_user_id INT NULL,
+    scopes TEXT NULL,
+    access_token_jti VARCHAR(64) NULL,
+    access_token_expires_at TIMESTAMP(6) NULL,
+    expires_at TIMESTAMP(6) NOT NULL,
+    used_at TIMESTAMP(6) NULL,
+    revoked_at TIMESTAMP(6) NULL,
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+    PRIMARY KEY (id),
+    CONSTRAINT uq_refresh_token_token_hash UNIQUE (token_hash),
+    INDEX idx_refresh_token_family_id (family_id),
+    CONSTRAINT fk_refresh_token_service_account FOREIGN KEY (service_account_id) REFERENCES service_account(id),
+    CONSTRAINT fk_refresh_token_internal_user FOREIGN KEY (internal_user_id) REFERENCES internal_user(id)
+);
@@ -0,0 +1,162 @@
+package forwarded
+
+import (
+	"net"
+	"net/http/httputil"
+	"net/netip"
+	"strings"
+)
+
+const (
+	headerForwarded      = "Forwarded"
+	headerForwardedFor   = "X-Forwarded-For"
+	headerForwardedHost  = "X-Forwarded-Host"
+	headerForwardedProto = "X-Forwarded-Proto"
+)
+
+// Forwarder writes X-Forwarded-For/Host/Proto and the RFC 7239 Forwarded header onto outbound requests.
+// Forwarded headers sent by a trusted proxy are extended, headers from anyone else are replaced so a caller
+// cannot spoof its address.
+type Forwarder struct {
+	trustedProxies []netip.Prefix
+}
+
+// NewForwarder parses the trusted proxy list, entries may be CIDRs or single addresses
+func NewForwarder(trustedProxies []string) (*Forwarder, error) {
+	prefixes := make([]netip.Prefix, 0, len(trustedProxies))
+
+	for _, trustedProxy := range trustedProxies {
+		trustedProxy = strings.TrimSpace(trustedProxy)
+
+		if !strings.Contains(trustedProxy, "/") {
+			addr, err := netip.ParseAddr(trustedProxy)
+
+			if err != nil {
+				return nil, err
+			}
+
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(trustedProxy)
+
+		if err != nil {
+			return nil, err
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return &Forwarder{trustedProxies: prefixes}, nil
+}
+
+// Apply sets the forwarding headers on pr.Out based on pr.In. The reverse proxy strips any inbound
+// forwarding headers from pr.Out before rewriting, so they are read from pr.In.
+func (f *Forwarder) Apply(pr *httputil.ProxyRequest) {
+	in := pr.In
+	out := pr.Out
+
+	clientIP := remoteIP(in.RemoteAddr)
+	trusted := f.isTrusted(clientIP)
+
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+
+	host := in.Host
+
+	forwardedFor := clientIP.String()
+	if !clientIP.IsValid() {
+		forwardedFor = "unknown"
+	}
+
+	forwarded := forwardedElement(clientIP, host, proto)
+
+	if trusted {
+		if prior := in.Header.Values(headerForwardedFor); len(prior) > 0 {
+			forwardedFor = strings.Join(prior, ", ") + ", " + forwardedFor
+		}
+
+		if prior := in.Header.Values(headerForwarded); len(prior) > 0 {
+			forwarded = strings.Join(prior, ", ") + ", " + forwarded
+		}
+
+		if prior := in.Header.Get(headerForwardedProto); prior != "" {
+			proto = prior
+		}
+
+		if prior := in.Header.Get(headerForwardedHost); prior != "" {
+			host = prior
+		}
+	}
+
+	out.Header.Set(headerForwardedFor, forwardedFor)
+	out.Header.Set(headerForwardedHost, host)
+	out.Header.Set(headerForwardedProto, proto)
+	out.Header.Set(headerForwarded, forwarded)
+}
+
+func (f *Forwarder) isTrusted(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+
+	for _, prefix := range f.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func remoteIP(remoteAddr string) netip.Addr {
+	host, _, err := net.SplitHostPort(remoteAddr)
+
+	if err != nil {
+		host = remoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
+
+// forwardedElement builds a single RFC 7239 forwarded-element, quoting values that are not tokens
+func forwardedElement(clientIP netip.Addr, host, proto string) string {
+	node := "unknown"
+
+	if clientIP.IsValid() {
+		node = clientIP.String()
+
+		if clientIP.Is6() {
+			node = "[" + node + "]"
+		}
+	}
+
+	return "for=" + quoteIfNeeded(node) + ";host=" + quoteIfNeeded(host) + ";proto=" + proto
+}
+
+func quoteIfNeeded(value string) string {
+	for _, c := range value {
+		if !isTokenChar(c) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+		}
+	}
+
+	return value
+}
+
+func isTokenChar(c rune) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
@@ -0,0 +1,124 @@
+package forwarded
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"testing"
+)
+
+func TestForwarder_Apply(t *testing.T) {
+	scenarios := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		tls            bool
+		inbound        map[string]string
+		expected       map[string]string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "203.0.113.7:51234",
+			expected: map[string]string{
+				"X-Forwarded-For":   "203.0.113.7",
+				"X-Forwarded-Host":  "api.example.com",
+				"X-Forwarded-Proto": "http",
+				"Forwarded":         "for=203.0.113.7;host=api.example.com;proto=http",
+			},
+		},
+		{
+			name:       "untrusted client headers are replaced",
+			remoteAddr: "203.0.113.7:51234",
+			tls:        true,
+			inbound: map[string]string{
+				"X-Forwarded-For":   "10.9.9.9",
+				"X-Forwarded-Host":  "evil.example.com",
+				"X-Forwarded-Proto": "http",
+				"Forwarded":         "for=10.9.9.9",
+			},
+			expected: map[string]string{
+				"X-Forwarded-For":   "203.0.113.7",
+				"X-Forwarded-Host":  "api.example.com",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         "for=203.0.113.7;host=api.example.com;proto=https",
+			},
+		},
+		{
+			name:           "trusted proxy headers are appended",
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.1.2.3:443",
+			inbound: map[string]string{
+				"X-Forwarded-For":   "198.51.100.4",
+				"X-Forwarded-Host":  "public.example.com",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         "for=198.51.100.4;proto=https",
+			},
+			expected: map[string]string{
+				"X-Forwarded-For":   "198.51.100.4, 10.1.2.3",
+				"X-Forwarded-Host":  "public.example.com",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         "for=198.51.100.4;proto=https, for=10.1.2.3;host=api.example.com;proto=http",
+			},
+		},
+		{
+			name:           "single trusted address",
+			trustedProxies: []string{"10.1.2.3"},
+			remoteAddr:     "10.1.2.4:443",
+			inbound: map[string]string{
+				"X-Forwarded-For": "198.51.100.4",
+			},
+			expected: map[string]string{
+				"X-Forwarded-For": "10.1.2.4",
+			},
+		},
+		{
+			name:       "ipv6 client is quoted",
+			remoteAddr: "[2001:db8::1]:51234",
+			expected: map[string]string{
+				"X-Forwarded-For": "2001:db8::1",
+				"Forwarded":       `for="[2001:db8::1]";host=api.example.com;proto=http`,
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			forwarder, err := NewForwarder(scenario.trustedProxies)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			in := httptest.NewRequest(http.MethodGet, "http://api.example.com/v1/users", nil)
+			in.RemoteAddr = scenario.remoteAddr
+
+			if scenario.tls {
+				in.TLS = &tls.ConnectionState{}
+			}
+
+			for key, value := range scenario.inbound {
+				in.Header.Set(key, value)
+			}
+
+			pr := &httputil.ProxyRequest{In: in, Out: in.Clone(in.Context())}
+			forwarder.Apply(pr)
+
+			for key, value := range scenario.expected {
+				if actual := pr.Out.Header.Get(key); actual != value {
+					t.Fatalf("expected %s to be %q, got %q", key, value, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestNewForwarder(t *testing.T) {
+	if _, err := NewForwarder([]string{"10.0.0.0/33"}); err == nil {
+		t.Fatal("expected an error for an invalid cidr")
+	}
+
+	if _, err := NewForwarder([]string{"not-an-ip"}); err == nil {
+		t.Fatal("expected an error for an invalid address")
+	}
+}
@@ -0,0 +1,84 @@
+package grpcstatus
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	OK                 = 0
+	Canceled           = 1
+	Unknown            = 2
+	InvalidArgument    = 3
+	DeadlineExceeded   = 4
+	NotFound           = 5
+	AlreadyExists      = 6
+	PermissionDenied   = 7
+	ResourceExhausted  = 8
+	FailedPrecondition = 9
+	Aborted            = 10
+	OutOfRange         = 11
+	Unimplemented      = 12
+	Internal           = 13
+	Unavailable        = 14
+	DataLoss           = 15
+	Unauthenticated    = 16
+)
+
+// statusClientClosedRequest is the non standard status used for calls the client canceled
+const statusClientClosedRequest = 499
+
+var httpStatuses = map[int]int{
+	OK:                 http.StatusOK,
+	Canceled:           statusClientClosedRequest,
+	Unknown:            http.StatusInternalServerError,
+	InvalidArgument:    http.StatusBadRequest,
+	DeadlineExceeded:   http.StatusGatewayTimeout,
+	NotFound:           http.StatusNotFound,
+	AlreadyExists:      http.StatusConflict,
+	PermissionDenied:   http.StatusForbidden,
+	ResourceExhausted:  http.StatusTooManyRequests,
+	FailedPrecondition: http.StatusBadRequest,
+	Aborted:            http.StatusConflict,
+	OutOfRange:         http.StatusBadRequest,
+	Unimplemented:      http.StatusNotImplemented,
+	Internal:           http.StatusInternalServerError,
+	Unavailable:        http.StatusServiceUnavailable,
+	DataLoss:           http.StatusInternalServerError,
+	Unauthenticated:    http.StatusUnauthorized,
+}
+
+// IsGRPC reports whether the request is a gRPC call
+func IsGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// FromHeader reads the grpc-status of a finished call from a response writer's header map. It is found among the
+// trailers, which the reverse proxy copies into the header map either under their own name or with
+// http.TrailerPrefix, or in the headers of a trailers-only response.
+func FromHeader(header http.Header) (int, bool) {
+	for _, key := range []string{"Grpc-Status", http.TrailerPrefix + "Grpc-Status"} {
+		values := header[key]
+
+		if len(values) == 0 {
+			continue
+		}
+
+		if code, err := strconv.Atoi(values[0]); err == nil {
+			return code, true
+		}
+	}
+
+	return 0, false
+}
+
+// HTTPStatus maps a gRPC status code onto the closest HTTP status, unknown codes map to 500
+func HTTPStatus(code int) int {
+	if status, ok := httpStatuses[code]; ok {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
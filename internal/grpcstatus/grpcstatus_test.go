@@ -0,0 +1,50 @@
+package grpcstatus
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFromHeader(t *testing.T) {
+	scenarios := []struct {
+		name         string
+		header       http.Header
+		expectedCode int
+		expectedOk   bool
+	}{
+		{name: "announced trailer", header: http.Header{"Grpc-Status": {"14"}}, expectedCode: Unavailable, expectedOk: true},
+		{name: "unannounced trailer", header: http.Header{http.TrailerPrefix + "Grpc-Status": {"5"}}, expectedCode: NotFound, expectedOk: true},
+		{name: "missing", header: http.Header{"Content-Type": {"application/grpc"}}, expectedOk: false},
+		{name: "malformed", header: http.Header{"Grpc-Status": {"unavailable"}}, expectedOk: false},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			code, ok := FromHeader(scenario.header)
+
+			if ok != scenario.expectedOk || code != scenario.expectedCode {
+				t.Fatalf("expected %d, %v, got %d, %v", scenario.expectedCode, scenario.expectedOk, code, ok)
+			}
+		})
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	scenarios := []struct {
+		code     int
+		expected int
+	}{
+		{code: OK, expected: http.StatusOK},
+		{code: InvalidArgument, expected: http.StatusBadRequest},
+		{code: Unauthenticated, expected: http.StatusUnauthorized},
+		{code: ResourceExhausted, expected: http.StatusTooManyRequests},
+		{code: Unavailable, expected: http.StatusServiceUnavailable},
+		{code: 42, expected: http.StatusInternalServerError},
+	}
+
+	for _, scenario := range scenarios {
+		if status := HTTPStatus(scenario.code); status != scenario.expected {
+			t.Fatalf("code %d: expected %d, got %d", scenario.code, scenario.expected, status)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package httpcache
+
+import (
+	"api-proxy/internal/identity"
+	"api-proxy/internal/metrics"
+	"api-proxy/internal/model"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderCacheStatus tells the caller how a response on a cached route was served
+const HeaderCacheStatus = "X-Cache"
+
+const (
+	StatusHit         = "HIT"
+	StatusMiss        = "MISS"
+	StatusRevalidated = "REVALIDATED"
+	StatusBypass      = "BYPASS"
+)
+
+// Parts a route's cache key can be made of, on top of the route and request path
+const (
+	KeyQuery            = "query"
+	KeyOrgID            = "org_id"
+	KeyServiceAccountID = "service_account_id"
+	KeyHeaderPrefix     = "header:"
+)
+
+// defaultKey keeps the callers of different orgs apart, routes that serve the same response to every org can opt
+// out with a key of their own
+var defaultKey = []string{KeyQuery, KeyOrgID}
+
+// hopByHopHeaders are not stored with an entry, they describe the connection the response arrived on
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	HeaderCacheStatus,
+}
+
+// ValidKeyPart reports whether part can be used in a route's cache key
+func ValidKeyPart(part string) bool {
+	switch {
+	case part == KeyQuery, part == KeyOrgID, part == KeyServiceAccountID:
+		return true
+	case strings.HasPrefix(part, KeyHeaderPrefix):
+		return len(part) > len(KeyHeaderPrefix)
+	default:
+		return false
+	}
+}
+
+// RoutePrefix is the prefix shared by every cache key of the route
+func RoutePrefix(routeID int) string {
+	return "route_" + strconv.Itoa(routeID) + ":"
+}
+
+// Policy is a route's response caching setup. TTL is how long responses without explicit freshness stay fresh,
+// zero means such responses are only stored when they can be revalidated.
+type Policy struct {
+	Name    string
+	Enabled bool
+	RouteID int
+	TTL     time.Duration
+	Key     []string
+}
+
+func PolicyFor(route *model.Route) Policy {
+	policy := Policy{
+		Name:    "route_" + strconv.Itoa(route.ID),
+		Enabled: route.CacheEnabled,
+		RouteID: route.ID,
+		TTL:     time.Duration(route.CacheTTLMs) * time.Millisecond,
+		Key:     route.CacheKey,
+	}
+
+	if len(policy.Key) == 0 {
+		policy.Key = defaultKey
+	}
+
+	return policy
+}
+
+// KeyFor builds the cache key of an inbound request. It always starts with the route prefix and the path so entries
+// can be purged by route or by path. variant is the name of the route variant picked for the request, the variants'
+// backends answer differently so each gets its own entries. It is empty on routes without variants.
+func (p Policy) KeyFor(r *http.Request, caller *identity.Identity, variant string) string {
+	var b strings.Builder
+
+	b.WriteString(RoutePrefix(p.RouteID))
+	b.WriteString(r.URL.Path)
+
+	if variant != "" {
+		b.WriteString("|variant=" + variant)
+	}
+
+	for _, part := range p.Key {
+		b.WriteString("|")
+
+		switch {
+		case part == KeyQuery:
+			b.WriteString("query=" + r.URL.Query().Encode())
+		case part == KeyOrgID && caller != nil:
+			b.WriteString("org_id=" + strconv.Itoa(caller.OrgID))
+		case part == KeyServiceAccountID && caller != nil:
+			b.WriteString("service_account_id=" + strconv.Itoa(caller.ServiceAccountID))
+		case strings.HasPrefix(part, KeyHeaderPrefix):
+			name := strings.TrimPrefix(part, KeyHeaderPrefix)
+			b.WriteString(strings.ToLower(name) + "=" + strings.Join(r.Header.Values(name), ","))
+		default:
+			b.WriteString(part + "=")
+		}
+	}
+
+	return b.String()
+}
+
+// Cache serves GET responses of routes with caching enabled from a store, honoring Cache-Control, ETag and Vary as
+// a shared cache would. Stale entries with validators are revalidated with a conditional request.
+type Cache struct {
+	store         Store
+	maxEntryBytes int64
+	now           func() time.Time
+}
+
+func New(store Store, maxEntryBytes int64) *Cache {
+	return &Cache{store: store, maxEntryBytes: maxEntryBytes, now: time.Now}
+}
+
+// Purge removes every entry whose key starts with prefix
+func (c *Cache) Purge(ctx context.Context, prefix string) (int, error) {
+	return c.store.Purge(ctx, prefix)
+}
+
+// Do answers r from the cache when it holds a fresh enough entry under key, otherwise it calls send and stores the
+// response while it is streamed back. Store errors are logged and the request goes to the backend as if the cache
+// was empty.
+func (c *Cache) Do(r *http.Request, key string, policy Policy, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	ctx := r.Context()
+	now := c.now()
+	requestCC := parseCacheControl(r.Header)
+
+	if requestCC.has("no-store") {
+		response, err := send(r)
+
+		if err == nil {
+			response.Header.Set(HeaderCacheStatus, StatusBypass)
+		}
+
+		return response, err
+	}
+
+	entryKey, entry := c.lookup(ctx, key, r)
+
+	if entry != nil && entry.fresh(now) && acceptable(requestCC, entry, now) {
+		metrics.ResponseCache.Add(policy.Name+":"+strings.ToLower(StatusHit), 1)
+		return entry.response(r, now, StatusHit), nil
+	}
+
+	outbound := r
+
+	if entry != nil && entry.hasValidators() {
+		outbound = r.Clone(ctx)
+		outbound.Header.Del("If-None-Match")
+		outbound.Header.Del("If-Modified-Since")
+
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			outbound.Header.Set("If-None-Match", etag)
+		}
+
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			outbound.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	response, err := send(outbound)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if outbound != r && response.StatusCode == http.StatusNotModified {
+		_, _ = io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+
+		updated := entry.revalidated(response.Header, now, policy.TTL)
+		c.set(ctx, entryKey, updated, now)
+		metrics.ResponseCache.Add(policy.Name+":"+strings.ToLower(StatusRevalidated), 1)
+
+		return updated.response(r, now, StatusRevalidated), nil
+	}
+
+	metrics.ResponseCache.Add(policy.Name+":"+strings.ToLower(StatusMiss), 1)
+	response.Header.Set(HeaderCacheStatus, StatusMiss)
+
+	if !storable(outbound, response) || response.ContentLength > c.maxEntryBytes {
+		return response, nil
+	}
+
+	fresh := lifetime(response.Header, now, policy.TTL)
+	stored := &Entry{Status: response.StatusCode, Header: storedHeader(response.Header), StoredAt: now, Expires: now.Add(fresh)}
+
+	if fresh <= 0 && !stored.hasValidators() {
+		return response, nil
+	}
+
+	vary := varyHeaders(response.Header)
+	storeCtx := context.WithoutCancel(ctx)
+
+	response.Body = &capturingBody{
+		ReadCloser: response.Body,
+		limit:      c.maxEntryBytes,
+		done: func(body []byte) {
+			stored.Body = body
+			c.save(storeCtx, key, vary, r, stored, now)
+		},
+	}
+
+	return response, nil
+}
+
+// lookup finds the entry for the request, following a Vary listing to the entry of the request's variant
+func (c *Cache) lookup(ctx context.Context, key string, r *http.Request) (string, *Entry) {
+	entry, err := c.store.Get(ctx, key)
+
+	if err != nil {
+		slog.Warn("error reading from the response cache", "key", key, "error", err)
+		return key, nil
+	}
+
+	if entry == nil || len(entry.Vary) == 0 {
+		return key, entry
+	}
+
+	key = variantKey(key, entry.Vary, r)
+
+	if entry, err = c.store.Get(ctx, key); err != nil {
+		slog.Warn("error reading from the response cache", "key", key, "error", err)
+		return key, nil
+	}
+
+	return key, entry
+}
+
+func (c *Cache) save(ctx context.Context, key string, vary []string, r *http.Request, entry *Entry, now time.Time) {
+	if len(vary) > 0 {
+		c.set(ctx, key, &Entry{Vary: vary, StoredAt: now, Expires: entry.Expires}, now)
+		key = variantKey(key, vary, r)
+	}
+
+	c.set(ctx, key, entry, now)
+}
+
+func (c *Cache) set(ctx context.Context, key string, entry *Entry, now time.Time) {
+	if err := c.store.Set(ctx, key, entry, entry.ttl(now)); err != nil {
+		slog.Warn("error writing to the response cache", "key", key, "error", err)
+	}
+}
+
+// acceptable checks the caller's own limits on the entry, no-cache forces a revalidation and max-age caps its age
+func acceptable(requestCC cacheControl, entry *Entry, now time.Time) bool {
+	if requestCC.has("no-cache") {
+		return false
+	}
+
+	if maxAge, ok := requestCC.seconds("max-age"); ok && entry.age(now) > maxAge {
+		return false
+	}
+
+	return true
+}
+
+func variantKey(key string, vary []string, r *http.Request) string {
+	hash := sha256.New()
+
+	for _, name := range vary {
+		hash.Write([]byte(name + "=" + strings.Join(r.Header.Values(name), ",") + "\n"))
+	}
+
+	return key + "#" + hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func storedHeader(header http.Header) http.Header {
+	stored := header.Clone()
+
+	for _, name := range hopByHopHeaders {
+		stored.Del(name)
+	}
+
+	return stored
+}
+
+// capturingBody keeps a copy of the body as it is read and hands it over once it was read to the end within limit
+type capturingBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+	done     func(body []byte)
+}
+
+func (cb *capturingBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+
+	if !cb.overflow {
+		if int64(cb.buf.Len()+n) > cb.limit {
+			cb.overflow = true
+			cb.buf = bytes.Buffer{}
+		} else {
+			cb.buf.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF && !cb.overflow && cb.done != nil {
+		cb.done(bytes.Clone(cb.buf.Bytes()))
+		cb.done = nil
+	}
+
+	return n, err
+}
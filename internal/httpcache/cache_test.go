@@ -0,0 +1,409 @@
+package httpcache
+
+import (
+	"api-proxy/internal/identity"
+	"api-proxy/internal/model"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backend counts the requests it answers and replies with a fixed response
+type backend struct {
+	calls    int
+	status   int
+	header   http.Header
+	body     string
+	requests []*http.Request
+}
+
+func (b *backend) send(r *http.Request) (*http.Response, error) {
+	b.calls++
+	b.requests = append(b.requests, r)
+
+	return &http.Response{
+		StatusCode:    b.status,
+		Header:        b.header.Clone(),
+		Body:          io.NopCloser(strings.NewReader(b.body)),
+		ContentLength: int64(len(b.body)),
+		Request:       r,
+	}, nil
+}
+
+func readAll(t *testing.T, response *http.Response) string {
+	t.Helper()
+
+	body, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		t.Fatalf("unable to read the response body: %v", err)
+	}
+
+	response.Body.Close()
+
+	return string(body)
+}
+
+func newTestCache(now *time.Time) *Cache {
+	store := NewMemoryStore(100, 1<<20)
+	store.now = func() time.Time { return *now }
+
+	cache := New(store, 1<<10)
+	cache.now = func() time.Time { return *now }
+
+	return cache
+}
+
+func TestCache_Do(t *testing.T) {
+	policy := PolicyFor(&model.Route{ID: 1, CacheEnabled: true})
+
+	scenarios := []struct {
+		name           string
+		status         int
+		header         http.Header
+		requestHeader  http.Header
+		advance        time.Duration
+		expectedCalls  int
+		expectedStatus string
+	}{
+		{name: "fresh entry is served", status: http.StatusOK, header: http.Header{"Cache-Control": {"max-age=60"}}, advance: 30 * time.Second, expectedCalls: 1, expectedStatus: StatusHit},
+		{name: "expired entry without validators is fetched again", status: http.StatusOK, header: http.Header{"Cache-Control": {"max-age=60"}}, advance: 2 * time.Minute, expectedCalls: 2, expectedStatus: StatusMiss},
+		{name: "no-store response is not stored", status: http.StatusOK, header: http.Header{"Cache-Control": {"no-store, max-age=60"}}, expectedCalls: 2, expectedStatus: StatusMiss},
+		{name: "private response is not stored", status: http.StatusOK, header: http.Header{"Cache-Control": {"private, max-age=60"}}, expectedCalls: 2, expectedStatus: StatusMiss},
+		{name: "server error is not stored", status: http.StatusInternalServerError, header: http.Header{"Cache-Control": {"max-age=60"}}, expectedCalls: 2, expectedStatus: StatusMiss},
+		{name: "response with a cookie is not stored", status: http.StatusOK, header: http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}, expectedCalls: 2, expectedStatus: StatusMiss},
+		{name: "request no-store bypasses the cache", status: http.StatusOK, header: http.Header{"Cache-Control": {"max-age=60"}}, requestHeader: http.Header{"Cache-Control": {"no-store"}}, expectedCalls: 2, expectedStatus: StatusBypass},
+		{name: "request max-age caps the entry age", status: http.StatusOK, header: http.Header{"Cache-Control": {"max-age=60"}}, requestHeader: http.Header{"Cache-Control": {"max-age=10"}}, advance: 30 * time.Second, expectedCalls: 2, expectedStatus: StatusMiss},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			now := time.Now()
+			cache := newTestCache(&now)
+			b := &backend{status: scenario.status, header: scenario.header, body: "payload"}
+
+			r := httptest.NewRequest(http.MethodGet, "/countries", nil)
+			key := policy.KeyFor(r, nil, "")
+
+			first, err := cache.Do(r, key, policy, b.send)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if body := readAll(t, first); body != "payload" {
+				t.Fatalf("expected the backend body, got %q", body)
+			}
+
+			now = now.Add(scenario.advance)
+
+			second := httptest.NewRequest(http.MethodGet, "/countries", nil)
+
+			for name, values := range scenario.requestHeader {
+				second.Header[name] = values
+			}
+
+			response, err := cache.Do(second, key, policy, b.send)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if body := readAll(t, response); body != "payload" {
+				t.Fatalf("expected the same body, got %q", body)
+			}
+
+			if b.calls != scenario.expectedCalls {
+				t.Fatalf("expected %d backend calls, got %d", scenario.expectedCalls, b.calls)
+			}
+
+			if status := response.Header.Get(HeaderCacheStatus); status != scenario.expectedStatus {
+				t.Fatalf("expected cache status %s, got %s", scenario.expectedStatus, status)
+			}
+		})
+	}
+}
+
+func TestCache_Do_Revalidates(t *testing.T) {
+	now := time.Now()
+	cache := newTestCache(&now)
+	policy := PolicyFor(&model.Route{ID: 1, CacheEnabled: true})
+	b := &backend{status: http.StatusOK, header: http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"v1"`}}, body: "payload"}
+
+	r := httptest.NewRequest(http.MethodGet, "/countries", nil)
+	key := policy.KeyFor(r, nil, "")
+
+	first, _ := cache.Do(r, key, policy, b.send)
+	readAll(t, first)
+
+	now = now.Add(2 * time.Minute)
+	b.status = http.StatusNotModified
+	b.body = ""
+
+	response, err := cache.Do(httptest.NewRequest(http.MethodGet, "/countries", nil), key, policy, b.send)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := b.requests[1].Header.Get("If-None-Match"); got != `"v1"` {
+		t.Fatalf("expected a conditional request with the stored etag, got %q", got)
+	}
+
+	if body := readAll(t, response); body != "payload" || response.StatusCode != http.StatusOK {
+		t.Fatalf("expected the stored response, got %d %q", response.StatusCode, body)
+	}
+
+	if status := response.Header.Get(HeaderCacheStatus); status != StatusRevalidated {
+		t.Fatalf("expected cache status %s, got %s", StatusRevalidated, status)
+	}
+
+	conditional := httptest.NewRequest(http.MethodGet, "/countries", nil)
+	conditional.Header.Set("If-None-Match", `W/"v1"`)
+
+	response, _ = cache.Do(conditional, key, policy, b.send)
+
+	if response.StatusCode != http.StatusNotModified || b.calls != 2 {
+		t.Fatalf("expected a 304 from the refreshed entry without a backend call, got %d after %d calls", response.StatusCode, b.calls)
+	}
+}
+
+func TestCache_Do_Vary(t *testing.T) {
+	now := time.Now()
+	cache := newTestCache(&now)
+	policy := PolicyFor(&model.Route{ID: 1, CacheEnabled: true})
+	b := &backend{status: http.StatusOK, header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}}, body: "payload"}
+
+	request := func(language string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/countries", nil)
+		r.Header.Set("Accept-Language", language)
+		return r
+	}
+
+	key := policy.KeyFor(request("en"), nil, "")
+
+	for _, language := range []string{"en", "en", "fr", "fr", "en"} {
+		response, err := cache.Do(request(language), key, policy, b.send)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		readAll(t, response)
+	}
+
+	if b.calls != 2 {
+		t.Fatalf("expected one backend call per variant, got %d", b.calls)
+	}
+}
+
+func TestCache_Do_DefaultTTL(t *testing.T) {
+	scenarios := []struct {
+		name          string
+		ttlMs         int
+		expectedCalls int
+	}{
+		{name: "stored for the route ttl", ttlMs: 60000, expectedCalls: 1},
+		{name: "not stored without a ttl", ttlMs: 0, expectedCalls: 2},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			now := time.Now()
+			cache := newTestCache(&now)
+			policy := PolicyFor(&model.Route{ID: 1, CacheEnabled: true, CacheTTLMs: scenario.ttlMs})
+			b := &backend{status: http.StatusOK, header: http.Header{}, body: "payload"}
+
+			for range 2 {
+				r := httptest.NewRequest(http.MethodGet, "/countries", nil)
+				response, _ := cache.Do(r, policy.KeyFor(r, nil, ""), policy, b.send)
+				readAll(t, response)
+			}
+
+			if b.calls != scenario.expectedCalls {
+				t.Fatalf("expected %d backend calls, got %d", scenario.expectedCalls, b.calls)
+			}
+		})
+	}
+}
+
+func TestCache_Do_SkipsLargeBodies(t *testing.T) {
+	now := time.Now()
+	cache := newTestCache(&now)
+	policy := PolicyFor(&model.Route{ID: 1, CacheEnabled: true})
+	b := &backend{status: http.StatusOK, header: http.Header{"Cache-Control": {"max-age=60"}}, body: strings.Repeat("a", 2<<10)}
+
+	for range 2 {
+		r := httptest.NewRequest(http.MethodGet, "/countries", nil)
+		response, _ := cache.Do(r, policy.KeyFor(r, nil, ""), policy, b.send)
+		readAll(t, response)
+	}
+
+	if b.calls != 2 {
+		t.Fatalf("expected bodies over the entry limit not to be stored, got %d backend calls", b.calls)
+	}
+}
+
+func TestPolicy_KeyFor(t *testing.T) {
+	caller := &identity.Identity{OrgID: 7, ServiceAccountID: 9}
+
+	scenarios := []struct {
+		name     string
+		key      []string
+		caller   *identity.Identity
+		variant  string
+		expected string
+	}{
+		{name: "default key uses the query and org", caller: caller, expected: "route_3:/countries|query=a=1&b=2|org_id=7"},
+		{name: "default key without a caller", expected: "route_3:/countries|query=a=1&b=2|org_id="},
+		{name: "org", key: []string{KeyOrgID}, caller: caller, expected: "route_3:/countries|org_id=7"},
+		{name: "service account and header", key: []string{KeyServiceAccountID, "header:Accept-Language"}, caller: caller, expected: "route_3:/countries|service_account_id=9|accept-language=en"},
+		{name: "no caller", key: []string{KeyOrgID}, expected: "route_3:/countries|org_id="},
+		{name: "variant", key: []string{KeyQuery}, variant: "canary", expected: "route_3:/countries|variant=canary|query=a=1&b=2"},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/countries?b=2&a=1", nil)
+			r.Header.Set("Accept-Language", "en")
+
+			policy := PolicyFor(&model.Route{ID: 3, CacheEnabled: true, CacheKey: scenario.key})
+
+			if key := policy.KeyFor(r, scenario.caller, scenario.variant); key != scenario.expected {
+				t.Fatalf("expected key %q, got %q", scenario.expected, key)
+			}
+		})
+	}
+}
+
+func TestStorable(t *testing.T) {
+	scenarios := []struct {
+		name          string
+		authorization string
+		cacheControl  string
+		expected      bool
+	}{
+		{name: "anonymous request", cacheControl: "max-age=60", expected: true},
+		{name: "authenticated request", authorization: "Bearer token", cacheControl: "max-age=60"},
+		{name: "authenticated request with public", authorization: "Bearer token", cacheControl: "public, max-age=60", expected: true},
+		{name: "authenticated request with s-maxage", authorization: "Bearer token", cacheControl: "s-maxage=60", expected: true},
+		{name: "authenticated request with must-revalidate", authorization: "Bearer token", cacheControl: "max-age=60, must-revalidate", expected: true},
+		{name: "authenticated request with public and private", authorization: "Bearer token", cacheControl: "public, private"},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/countries", nil)
+
+			if scenario.authorization != "" {
+				r.Header.Set("Authorization", scenario.authorization)
+			}
+
+			response := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {scenario.cacheControl}}}
+
+			if got := storable(r, response); got != scenario.expected {
+				t.Fatalf("expected storable to be %v, got %v", scenario.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidKeyPart(t *testing.T) {
+	scenarios := map[string]bool{
+		KeyQuery:            true,
+		KeyOrgID:            true,
+		KeyServiceAccountID: true,
+		"header:Accept":     true,
+		"header:":           false,
+		"path":              false,
+	}
+
+	for part, expected := range scenarios {
+		if ValidKeyPart(part) != expected {
+			t.Fatalf("expected ValidKeyPart(%q) to be %v", part, expected)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(2, 1<<20)
+	entry := &Entry{Status: http.StatusOK, Body: []byte("payload")}
+
+	_ = store.Set(ctx, "route_1:/a", entry, time.Minute)
+	_ = store.Set(ctx, "route_1:/b", entry, time.Minute)
+
+	if got, _ := store.Get(ctx, "route_1:/a"); got == nil {
+		t.Fatalf("expected the entry to be stored")
+	}
+
+	_ = store.Set(ctx, "route_2:/c", entry, time.Minute)
+
+	if got, _ := store.Get(ctx, "route_1:/b"); got != nil {
+		t.Fatalf("expected the least recently used entry to be evicted")
+	}
+
+	purged, _ := store.Purge(ctx, "route_1:")
+
+	if purged != 1 {
+		t.Fatalf("expected one entry to be purged, got %d", purged)
+	}
+
+	if got, _ := store.Get(ctx, "route_2:/c"); got == nil {
+		t.Fatalf("expected entries of other routes to survive a purge")
+	}
+
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	_ = store.Set(ctx, "route_3:/d", entry, time.Second)
+	now = now.Add(2 * time.Second)
+
+	if got, _ := store.Get(ctx, "route_3:/d"); got != nil {
+		t.Fatalf("expected expired entries not to be served")
+	}
+}
+
+func TestMemoryStore_MaxBytes(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(100, 40)
+
+	_ = store.Set(ctx, "a", &Entry{Body: []byte(strings.Repeat("a", 20))}, time.Minute)
+	_ = store.Set(ctx, "b", &Entry{Body: []byte(strings.Repeat("b", 20))}, time.Minute)
+
+	if got, _ := store.Get(ctx, "a"); got != nil {
+		t.Fatalf("expected the older entry to be evicted past the byte limit")
+	}
+
+	if got, _ := store.Get(ctx, "b"); got == nil {
+		t.Fatalf("expected the newer entry to be kept")
+	}
+}
+
+func TestLifetime(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	scenarios := []struct {
+		name     string
+		header   http.Header
+		expected time.Duration
+	}{
+		{name: "s-maxage wins", header: http.Header{"Cache-Control": {"max-age=10, s-maxage=20"}}, expected: 20 * time.Second},
+		{name: "max-age", header: http.Header{"Cache-Control": {"public, max-age=10"}}, expected: 10 * time.Second},
+		{name: "no-cache", header: http.Header{"Cache-Control": {"no-cache, max-age=10"}}, expected: 0},
+		{name: "expires", header: http.Header{"Date": {now.Format(http.TimeFormat)}, "Expires": {now.Add(time.Minute).Format(http.TimeFormat)}}, expected: time.Minute},
+		{name: "invalid expires", header: http.Header{"Expires": {"0"}}, expected: 0},
+		{name: "route default", header: http.Header{}, expected: 5 * time.Second},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			if got := lifetime(scenario.header, now, 5*time.Second); got != scenario.expected {
+				t.Fatalf("expected %s, got %s", scenario.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package httpcache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staleRetention is how long an expired entry with validators is kept around so it can be revalidated
+const staleRetention = time.Hour
+
+// cacheableStatuses are the statuses a response may be stored with
+var cacheableStatuses = []int{
+	http.StatusOK,
+	http.StatusNonAuthoritativeInfo,
+	http.StatusNoContent,
+	http.StatusMovedPermanently,
+	http.StatusNotFound,
+	http.StatusGone,
+}
+
+// Entry is a stored response. An entry for a response with a Vary header only lists the varying request headers,
+// the responses themselves are stored under a key per combination of their values.
+type Entry struct {
+	Status   int         `json:"status,omitempty"`
+	Header   http.Header `json:"header,omitempty"`
+	Body     []byte      `json:"body,omitempty"`
+	Vary     []string    `json:"vary,omitempty"`
+	StoredAt time.Time   `json:"stored_at"`
+	Expires  time.Time   `json:"expires"`
+}
+
+func (e *Entry) size() int64 {
+	size := int64(len(e.Body))
+
+	for name, values := range e.Header {
+		size += int64(len(name))
+
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+
+	return size
+}
+
+func (e *Entry) fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+func (e *Entry) age(now time.Time) time.Duration {
+	return max(now.Sub(e.StoredAt), 0)
+}
+
+func (e *Entry) hasValidators() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// ttl is how long the store keeps the entry, past its freshness when it can still be revalidated
+func (e *Entry) ttl(now time.Time) time.Duration {
+	ttl := e.Expires.Sub(now)
+
+	if e.hasValidators() || len(e.Vary) > 0 {
+		ttl += staleRetention
+	}
+
+	return ttl
+}
+
+// matches reports whether the request's If-None-Match lists the entry's ETag, using the weak comparison
+func (e *Entry) matches(r *http.Request) bool {
+	etag := e.Header.Get("ETag")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+
+	if etag == "" || ifNoneMatch == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// response builds the response served from the entry, a 304 when the caller already holds the stored version
+func (e *Entry) response(r *http.Request, now time.Time, status string) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(e.age(now).Seconds())))
+	header.Set(HeaderCacheStatus, status)
+
+	response := &http.Response{
+		Status:     strconv.Itoa(e.Status) + " " + http.StatusText(e.Status),
+		StatusCode: e.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewReader(e.Body)),
+		Request:    r,
+	}
+
+	if e.matches(r) {
+		response.Status = "304 Not Modified"
+		response.StatusCode = http.StatusNotModified
+		response.Body = http.NoBody
+		header.Del("Content-Length")
+
+		return response
+	}
+
+	response.ContentLength = int64(len(e.Body))
+	header.Set("Content-Length", strconv.Itoa(len(e.Body)))
+
+	return response
+}
+
+// revalidated returns a copy of the entry with the headers of a 304 from the backend merged in and its freshness
+// recomputed from them
+func (e *Entry) revalidated(header http.Header, now time.Time, defaultTTL time.Duration) *Entry {
+	updated := *e
+	updated.Header = e.Header.Clone()
+
+	for name, values := range header {
+		if name == "Content-Length" || name == HeaderCacheStatus {
+			continue
+		}
+
+		updated.Header[name] = values
+	}
+
+	updated.StoredAt = now
+	updated.Expires = now.Add(lifetime(updated.Header, now, defaultTTL))
+
+	return &updated
+}
+
+// cacheControl holds the directives of a Cache-Control header, directives without a value map to an empty string
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	value, ok := cc[name]
+
+	if !ok {
+		return 0, false
+	}
+
+	seconds, err := strconv.Atoi(value)
+
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+// lifetime works out how long a response stays fresh as a shared cache: s-maxage, then max-age, then Expires,
+// then the route's default. A no-cache response has to be revalidated every time.
+func lifetime(header http.Header, now time.Time, defaultTTL time.Duration) time.Duration {
+	cc := parseCacheControl(header)
+
+	if cc.has("no-cache") {
+		return 0
+	}
+
+	if sMaxAge, ok := cc.seconds("s-maxage"); ok {
+		return sMaxAge
+	}
+
+	if maxAge, ok := cc.seconds("max-age"); ok {
+		return maxAge
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		at, err := http.ParseTime(expires)
+
+		if err != nil {
+			return 0
+		}
+
+		date := now
+
+		if served, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = served
+		}
+
+		return max(at.Sub(date), 0)
+	}
+
+	return defaultTTL
+}
+
+// storable reports whether a backend response to r may be stored by a shared cache. The response to a request with
+// an Authorization header is only stored when it explicitly allows it, as RFC 9111 section 3.5 requires.
+func storable(r *http.Request, response *http.Response) bool {
+	if !slices.Contains(cacheableStatuses, response.StatusCode) {
+		return false
+	}
+
+	cc := parseCacheControl(response.Header)
+
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+
+	if r.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return false
+	}
+
+	return response.Header.Get("Set-Cookie") == "" && response.Header.Get("Vary") != "*"
+}
+
+// varyHeaders lists the request headers named in the response's Vary header, in canonical form
+func varyHeaders(header http.Header) []string {
+	var names []string
+
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	slices.Sort(names)
+
+	return slices.Compact(names)
+}
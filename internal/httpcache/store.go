@@ -0,0 +1,220 @@
+package httpcache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisKeyPrefix = "response_cache:"
+	redisScanCount = 500
+)
+
+// Store keeps cache entries by key. Entries handed out by Get are shared and must not be modified.
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error
+	// Purge removes every entry whose key starts with prefix and returns how many were removed
+	Purge(ctx context.Context, prefix string) (int, error)
+}
+
+type memoryItem struct {
+	key       string
+	entry     *Entry
+	size      int64
+	expiresAt time.Time
+}
+
+// MemoryStore keeps entries in process and evicts the least recently used ones beyond its entry and byte limits
+type MemoryStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	size       int64
+	items      map[string]*list.Element
+	order      *list.List
+	now        func() time.Time
+}
+
+func NewMemoryStore(maxEntries int, maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		mu:         sync.Mutex{},
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+func (ms *MemoryStore) Get(_ context.Context, key string) (*Entry, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	element, ok := ms.items[key]
+
+	if !ok {
+		return nil, nil
+	}
+
+	item := element.Value.(*memoryItem)
+
+	if !ms.now().Before(item.expiresAt) {
+		ms.remove(element)
+		return nil, nil
+	}
+
+	ms.order.MoveToFront(element)
+
+	return item.entry, nil
+}
+
+func (ms *MemoryStore) Set(_ context.Context, key string, entry *Entry, ttl time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if element, ok := ms.items[key]; ok {
+		ms.remove(element)
+	}
+
+	item := &memoryItem{key: key, entry: entry, size: int64(len(key)) + entry.size(), expiresAt: ms.now().Add(ttl)}
+
+	if ttl <= 0 || item.size > ms.maxBytes {
+		return nil
+	}
+
+	ms.items[key] = ms.order.PushFront(item)
+	ms.size += item.size
+
+	for len(ms.items) > ms.maxEntries || ms.size > ms.maxBytes {
+		ms.remove(ms.order.Back())
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) Purge(_ context.Context, prefix string) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	purged := 0
+
+	for key, element := range ms.items {
+		if strings.HasPrefix(key, prefix) {
+			ms.remove(element)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (ms *MemoryStore) remove(element *list.Element) {
+	item := element.Value.(*memoryItem)
+
+	ms.order.Remove(element)
+	delete(ms.items, item.key)
+	ms.size -= item.size
+}
+
+// RedisStore keeps entries in redis as json so every proxy instance shares them, redis expires them
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (rs *RedisStore) Get(ctx context.Context, key string) (*Entry, error) {
+	raw, err := rs.client.Get(ctx, redisKeyPrefix+key).Bytes()
+
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entry Entry
+
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+func (rs *RedisStore) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	raw, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	return rs.client.Set(ctx, redisKeyPrefix+key, raw, ttl).Err()
+}
+
+func (rs *RedisStore) Purge(ctx context.Context, prefix string) (int, error) {
+	purged := 0
+	iter := rs.client.Scan(ctx, 0, redisKeyPrefix+escapeGlob(prefix)+"*", redisScanCount).Iterator()
+	var keys []string
+
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+
+		if len(keys) == redisScanCount {
+			deleted, err := rs.client.Del(ctx, keys...).Result()
+
+			if err != nil {
+				return purged, err
+			}
+
+			purged += int(deleted)
+			keys = keys[:0]
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return purged, err
+	}
+
+	if len(keys) > 0 {
+		deleted, err := rs.client.Del(ctx, keys...).Result()
+
+		if err != nil {
+			return purged, err
+		}
+
+		purged += int(deleted)
+	}
+
+	return purged, nil
+}
+
+// escapeGlob escapes the characters redis treats as patterns in SCAN MATCH
+func escapeGlob(s string) string {
+	var b strings.Builder
+
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteRune('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
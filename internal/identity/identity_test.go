@@ -0,0 +1,103 @@
+package identity
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestInjector_Apply(t *testing.T) {
+	caller := &Identity{OrgID: 3, ServiceAccountID: 12, SubjectType: "service-account"}
+
+	scenarios := []struct {
+		name        string
+		mode        string
+		identity    *Identity
+		expectedErr error
+		assert      func(t *testing.T, h http.Header)
+	}{
+		{
+			name:     "passthrough keeps authorization and drops spoofed headers",
+			mode:     ModePassthrough,
+			identity: caller,
+			assert: func(t *testing.T, h http.Header) {
+				if h.Get("Authorization") != "Bearer caller-token" {
+					t.Fatalf("expected caller authorization to be forwarded, got %q", h.Get("Authorization"))
+				}
+
+				if h.Get(HeaderOrgID) != "" || h.Get(HeaderServiceAccountID) != "" {
+					t.Fatal("expected spoofed identity headers to be removed")
+				}
+			},
+		},
+		{
+			name:     "headers",
+			mode:     ModeHeaders,
+			identity: caller,
+			assert: func(t *testing.T, h http.Header) {
+				if h.Get("Authorization") != "" {
+					t.Fatal("expected authorization to be removed")
+				}
+
+				if h.Get(HeaderOrgID) != "3" || h.Get(HeaderServiceAccountID) != "12" {
+					t.Fatalf("expected identity headers 3/12, got %q/%q", h.Get(HeaderOrgID), h.Get(HeaderServiceAccountID))
+				}
+			},
+		},
+		{
+			name:     "token",
+			mode:     ModeToken,
+			identity: caller,
+			assert: func(t *testing.T, h http.Header) {
+				token := strings.TrimPrefix(h.Get("Authorization"), "Bearer ")
+				claims := jwt.MapClaims{}
+
+				_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+					return []byte("identity-secret"), nil
+				}, jwt.WithAudience("users-svc:8080"), jwt.WithValidMethods([]string{"HS256"}))
+
+				if err != nil {
+					t.Fatalf("expected a verifiable identity token, got %v", err)
+				}
+
+				if claims["org_id"] != float64(3) || claims["sub"] != float64(12) || claims["type"] != "identity" {
+					t.Fatalf("unexpected claims %v", claims)
+				}
+			},
+		},
+		{
+			name:        "headers without identity",
+			mode:        ModeHeaders,
+			expectedErr: ErrMissingIdentity,
+		},
+		{
+			name:        "unknown mode",
+			mode:        "cookie",
+			identity:    caller,
+			expectedErr: ErrUnknownMode,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			h := http.Header{}
+			h.Set("Authorization", "Bearer caller-token")
+			h.Set(HeaderOrgID, "999")
+			h.Set(HeaderServiceAccountID, "999")
+
+			err := NewInjector("identity-secret", time.Minute, nil).Apply(h, scenario.mode, scenario.identity, "users-svc:8080")
+
+			if !errors.Is(err, scenario.expectedErr) {
+				t.Fatalf("expected error %v, got %v", scenario.expectedErr, err)
+			}
+
+			if scenario.assert != nil {
+				scenario.assert(t, h)
+			}
+		})
+	}
+}
@@ -17,6 +17,11 @@ type RequestLog struct {
 	URL        string
 	StatusCode int
 	Latency    time.Duration
+	BytesIn    int64
+	BytesOut   int64
+	GRPCStatus *int
+	Variant    string
+	Shadow     bool
 }
 
 type RequestLogger struct {
@@ -31,22 +36,23 @@ func NewRequestLogger(requestDataStore RequestDataStorer, queueSize int) Request
 	}
 }
 
-func NewRequestLog(route *model.Route, method, url string, statusCode int, latency time.Duration) RequestLog {
-	return RequestLog{
-		Route:      route,
-		Method:     method,
-		URL:        url,
-		StatusCode: statusCode,
-		Latency:    latency,
+// Log insert log requests into the channel for asynchronous logging (if RequestLogger was configured with queueSize > 0, default is 500), synchronous logging if queueSize == 0
+func (rl RequestLogger) Log(entry RequestLog) {
+	select {
+	case rl.ch <- entry:
+	default:
+		slog.Warn("request log channel full, dropping entry")
 	}
 }
 
-// Log insert log requests into the channel for asynchronous logging (if RequestLogger was configured with queueSize > 0, default is 500), synchronous logging if queueSize == 0
-func (rl RequestLogger) Log(route *model.Route, method, url string, statusCode int, latency time.Duration) {
+// LogShadow queues the outcome of a mirrored copy of a request, it is stored apart from the primary request's entry
+func (rl RequestLogger) LogShadow(entry RequestLog) {
+	entry.Shadow = true
+
 	select {
-	case rl.ch <- NewRequestLog(route, method, url, statusCode, latency):
+	case rl.ch <- entry:
 	default:
-		slog.Warn("request log channel full, dropping entry")
+		slog.Warn("request log channel full, dropping shadow entry")
 	}
 }
 
@@ -64,6 +70,11 @@ func (rl RequestLogger) Start(ctx context.Context) {
 					URL:        entry.URL,
 					StatusCode: entry.StatusCode,
 					Latency:    entry.Latency.Milliseconds(),
+					BytesIn:    entry.BytesIn,
+					BytesOut:   entry.BytesOut,
+					GRPCStatus: entry.GRPCStatus,
+					Variant:    entry.Variant,
+					Shadow:     entry.Shadow,
 				}); err != nil {
 					slog.Error("Failed to insert request", "method", entry.Method, "url", entry.URL)
 				}
@@ -44,7 +44,7 @@ func TestRequestLogger_Log(t *testing.T) {
 			requestLogger := NewRequestLogger(scenario.dataStore, scenario.queueSize)
 
 			for i := 0; i < scenario.numLogs; i++ {
-				requestLogger.Log(new(route), "GET", "/api/v1/test", 201, time.Duration(200)*time.Millisecond)
+				requestLogger.Log(RequestLog{Route: new(route), Method: "GET", URL: "/api/v1/test", StatusCode: 201, Latency: 200 * time.Millisecond, BytesIn: 12, BytesOut: 34})
 			}
 
 			if scenario.expectedChanLen != len(requestLogger.ch) {
@@ -71,6 +71,9 @@ func TestRequestLogger_Log(t *testing.T) {
 					if entry.Latency.Milliseconds() != 200 {
 						t.Errorf("expected latency 200ms, got %d", entry.Latency.Milliseconds())
 					}
+					if entry.BytesIn != 12 || entry.BytesOut != 34 {
+						t.Errorf("expected 12 bytes in and 34 bytes out, got %d and %d", entry.BytesIn, entry.BytesOut)
+					}
 				}
 
 				if len(requestLogger.ch) != 0 {
@@ -81,6 +84,23 @@ func TestRequestLogger_Log(t *testing.T) {
 	}
 }
 
+func TestRequestLogger_LogShadow(t *testing.T) {
+	requestLogger := NewRequestLogger(&fakeRouteDataStore{}, 10)
+
+	requestLogger.Log(RequestLog{Route: &model.Route{ID: 1}, Method: "GET", URL: "/api/v1/test", StatusCode: 200, Latency: 100 * time.Millisecond})
+	requestLogger.LogShadow(RequestLog{Route: &model.Route{ID: 1}, Method: "GET", URL: "/api/v1/test", StatusCode: 500, Latency: 300 * time.Millisecond})
+
+	if primary := <-requestLogger.ch; primary.Shadow {
+		t.Errorf("expected the primary entry not to be marked as shadow")
+	}
+
+	shadow := <-requestLogger.ch
+
+	if !shadow.Shadow || shadow.StatusCode != 500 || shadow.Latency != 300*time.Millisecond {
+		t.Errorf("expected a shadow entry with status 500 and 300ms latency, got %+v", shadow)
+	}
+}
+
 func TestRequestLogger_Start(t *testing.T) {
 	route := model.Route{
 		ID: 1,
@@ -95,18 +115,27 @@ func TestRequestLogger_Start(t *testing.T) {
 	}{
 		{
 			name:      "Persisted",
-			entry:     NewRequestLog(new(route), "GET", "/api/v1/test", 201, time.Duration(100)*time.Millisecond),
+			entry:     RequestLog{Route: new(route), Method: "GET", URL: "/api/v1/test", StatusCode: 201, Latency: 100 * time.Millisecond, BytesIn: 12, BytesOut: 34, Variant: "canary"},
 			dataStore: &fakeRouteDataStore{},
 			cancelled: false,
 			assert: func(t *testing.T, ds *fakeRouteDataStore) {
 				if len(ds.requests) != 1 {
 					t.Errorf("expected 1 persisted request, got %d", len(ds.requests))
+					return
+				}
+
+				if ds.requests[0].BytesIn != 12 || ds.requests[0].BytesOut != 34 {
+					t.Errorf("expected 12 bytes in and 34 bytes out, got %d and %d", ds.requests[0].BytesIn, ds.requests[0].BytesOut)
+				}
+
+				if ds.requests[0].Variant != "canary" {
+					t.Errorf("expected variant canary, got %q", ds.requests[0].Variant)
 				}
 			},
 		},
 		{
 			name:      "Errored",
-			entry:     NewRequestLog(new(route), "GET", "/api/v1/test", 201, time.Duration(100)*time.Millisecond),
+			entry:     RequestLog{Route: new(route), Method: "GET", URL: "/api/v1/test", StatusCode: 201, Latency: 100 * time.Millisecond},
 			dataStore: &fakeRouteDataStore{err: errors.New("test insert err")},
 			cancelled: false,
 			assert: func(t *testing.T, ds *fakeRouteDataStore) {
@@ -117,7 +146,7 @@ func TestRequestLogger_Start(t *testing.T) {
 		},
 		{
 			name:      "Cancelled",
-			entry:     NewRequestLog(new(route), "GET", "/api/v1/test", 201, time.Duration(100)*time.Millisecond),
+			entry:     RequestLog{Route: new(route), Method: "GET", URL: "/api/v1/test", StatusCode: 201, Latency: 100 * time.Millisecond},
 			dataStore: &fakeRouteDataStore{},
 			cancelled: true,
 			assert: func(t *testing.T, ds *fakeRouteDataStore) {
@@ -142,7 +171,7 @@ func TestRequestLogger_Start(t *testing.T) {
 			} else {
 				cancel()
 				time.Sleep(10 * time.Millisecond)
-				requestLogger.Log(new(route), "GET", "/api/v1/test", 201, 100*time.Millisecond) // non-blocking
+				requestLogger.Log(RequestLog{Route: new(route), Method: "GET", URL: "/api/v1/test", StatusCode: 201, Latency: 100 * time.Millisecond}) // non-blocking
 			}
 
 			scenario.assert(t, scenario.dataStore.(*fakeRouteDataStore))
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+var (
+	// CircuitBreakerState holds the current state of every circuit breaker, keyed by breaker name
+	CircuitBreakerState = expvar.NewMap("circuit_breaker_state")
+	// CircuitBreakerTransitions counts state changes, keyed by breaker name and the state moved to
+	CircuitBreakerTransitions = expvar.NewMap("circuit_breaker_transitions")
+	// Retries counts attempts sent again after a retryable failure, keyed by retry policy name
+	Retries = expvar.NewMap("retries")
+	// RetryBudgetExhausted counts retries skipped because the global retry budget was spent, keyed by retry policy name
+	RetryBudgetExhausted = expvar.NewMap("retry_budget_exhausted")
+	// ResponseCache counts how cached routes were served, keyed by cache policy name and hit, miss or revalidated
+	ResponseCache = expvar.NewMap("response_cache")
+	// Coalescing counts collapsed requests, keyed by coalescing policy name and leader or shared
+	Coalescing = expvar.NewMap("coalescing")
+	// Mirror counts shadow requests, keyed by route and sent, dropped when too many are in flight or skipped when
+	// the request body could not be copied
+	Mirror = expvar.NewMap("mirror")
+	// WebSocketConnections is the number of upgraded connections currently open
+	WebSocketConnections = expvar.NewInt("websocket_connections")
+)
+
+// Handler serves every published metric as json
+func Handler() http.Handler {
+	return expvar.Handler()
+}
+
+// SetString sets a string value in m
+func SetString(m *expvar.Map, key, value string) {
+	var v expvar.String
+	v.Set(value)
+	m.Set(key, &v)
+}
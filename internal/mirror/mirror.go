@@ -0,0 +1,207 @@
+package mirror
+
+import (
+	"api-proxy/internal/identity"
+	"api-proxy/internal/logger"
+	"api-proxy/internal/metrics"
+	"api-proxy/internal/model"
+	"api-proxy/internal/upstream"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Recorder stores the outcome of a shadow request apart from the primary request's
+type Recorder interface {
+	LogShadow(entry logger.RequestLog)
+}
+
+// Mirror sends copies of a sample of each mirrored route's requests to its shadow upstream. Copies are fired once
+// the primary request completed and their responses are dropped, at most maxInFlight are outstanding at a time and
+// requests with bodies over maxBodyBytes are not mirrored.
+type Mirror struct {
+	recorder     Recorder
+	slots        chan struct{}
+	maxBodyBytes int64
+	timeout      time.Duration
+}
+
+func New(recorder Recorder, maxInFlight int, maxBodyBytes int64, timeout time.Duration) *Mirror {
+	return &Mirror{
+		recorder:     recorder,
+		slots:        make(chan struct{}, maxInFlight),
+		maxBodyBytes: maxBodyBytes,
+		timeout:      timeout,
+	}
+}
+
+// Sampled reports whether a request on the route should be mirrored
+func Sampled(route *model.Route) bool {
+	return route.MirrorUpstreamID != nil && route.MirrorPercent > 0 && rand.IntN(100) < route.MirrorPercent
+}
+
+// Shadow is the copy of one sampled request. It is captured as the primary request is sent and fired afterwards.
+type Shadow struct {
+	mirror    *Mirror
+	route     *model.Route
+	path      string
+	target    *url.URL
+	transport http.RoundTripper
+	request   *http.Request
+	body      *capturingBody
+}
+
+// Shadow prepares a copy of the request for path to be sent to target through transport
+func (m *Mirror) Shadow(route *model.Route, path string, target *url.URL, transport http.RoundTripper) *Shadow {
+	return &Shadow{mirror: m, route: route, path: path, target: target, transport: transport}
+}
+
+// Capture copies the outbound request, its body is copied as the primary request reads it. Only the first call
+// counts, the copy is of the request as first sent. The credentials the proxy attached for the primary backend are
+// left out of the copy, the route's upstream auth and the identity token are not meant for the shadow.
+func (s *Shadow) Capture(r *http.Request) {
+	if s.request != nil {
+		return
+	}
+
+	s.request = r.Clone(context.WithoutCancel(r.Context()))
+	s.request.URL = s.target
+	s.request.Host = ""
+	s.request.RequestURI = ""
+
+	if s.route.UpstreamAuth != nil {
+		s.request.Header.Del(upstream.CredentialHeader(s.route.UpstreamAuth))
+	}
+
+	if s.route.IdentityMode == identity.ModeToken {
+		s.request.Header.Del("Authorization")
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+
+	s.body = &capturingBody{ReadCloser: r.Body, limit: s.mirror.maxBodyBytes}
+	r.Body = s.body
+}
+
+// Fire sends the copy unless the primary request never got to the transport or its body was not read whole
+func (s *Shadow) Fire() {
+	if s.request == nil {
+		return
+	}
+
+	name := "route_" + strconv.Itoa(s.route.ID)
+
+	if s.body != nil {
+		body, ok := s.body.captured()
+
+		if !ok {
+			metrics.Mirror.Add(name+":skipped", 1)
+			return
+		}
+
+		s.request.Body = io.NopCloser(bytes.NewReader(body))
+		s.request.ContentLength = int64(len(body))
+		s.request.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	}
+
+	select {
+	case s.mirror.slots <- struct{}{}:
+	default:
+		metrics.Mirror.Add(name+":dropped", 1)
+		return
+	}
+
+	metrics.Mirror.Add(name+":sent", 1)
+
+	go func() {
+		defer func() { <-s.mirror.slots }()
+
+		s.send()
+	}()
+}
+
+func (s *Shadow) send() {
+	ctx, cancel := context.WithTimeout(s.request.Context(), s.mirror.timeout)
+	defer cancel()
+
+	start := time.Now()
+	status := http.StatusBadGateway
+	response, err := s.transport.RoundTrip(s.request.WithContext(ctx))
+
+	if err == nil {
+		status = response.StatusCode
+		_, err = io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+	}
+
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+
+		slog.Debug("error mirroring request", "route_id", s.route.ID, "target", s.target.Host, "error", err)
+	}
+
+	s.mirror.recorder.LogShadow(logger.RequestLog{
+		Route:      s.route,
+		Method:     s.request.Method,
+		URL:        s.path,
+		StatusCode: status,
+		Latency:    time.Since(start),
+	})
+}
+
+// capturingBody keeps a copy of the body as it is read, as long as it stays within limit. The transport may still be
+// reading it when the primary response is done, hence the lock.
+type capturingBody struct {
+	io.ReadCloser
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+	eof      bool
+}
+
+func (cb *capturingBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if !cb.overflow {
+		if int64(cb.buf.Len()+n) > cb.limit {
+			cb.overflow = true
+			cb.buf = bytes.Buffer{}
+		} else {
+			cb.buf.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF {
+		cb.eof = true
+	}
+
+	return n, err
+}
+
+// captured returns a copy of the body once it was read to the end within limit
+func (cb *capturingBody) captured() ([]byte, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if !cb.eof || cb.overflow {
+		return nil, false
+	}
+
+	return bytes.Clone(cb.buf.Bytes()), true
+}
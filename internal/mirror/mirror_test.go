@@ -0,0 +1,227 @@
+package mirror
+
+import (
+	"api-proxy/internal/identity"
+	"api-proxy/internal/logger"
+	"api-proxy/internal/model"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type shadowEntry struct {
+	method     string
+	url        string
+	statusCode int
+}
+
+type fakeRecorder struct {
+	entries chan shadowEntry
+}
+
+func (f *fakeRecorder) LogShadow(entry logger.RequestLog) {
+	f.entries <- shadowEntry{method: entry.Method, url: entry.URL, statusCode: entry.StatusCode}
+}
+
+func TestShadow_Fire(t *testing.T) {
+	received := make(chan string, 10)
+
+	shadowBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r.Method + " " + r.URL.Path + " " + r.Header.Get("X-Test") + " " + string(body)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer shadowBackend.Close()
+
+	target, _ := url.Parse(shadowBackend.URL + "/v2/countries")
+
+	scenarios := []struct {
+		name           string
+		method         string
+		body           string
+		readBody       bool
+		expectedShadow string
+	}{
+		{name: "request without a body", method: http.MethodGet, expectedShadow: "GET /v2/countries primary "},
+		{name: "request with a body read by the primary", method: http.MethodPost, body: "payload", readBody: true, expectedShadow: "POST /v2/countries primary payload"},
+		{name: "body over the limit", method: http.MethodPost, body: strings.Repeat("a", 64), readBody: true},
+		{name: "body not read by the primary", method: http.MethodPost, body: "payload"},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			recorder := &fakeRecorder{entries: make(chan shadowEntry, 1)}
+			m := New(recorder, 10, 32, time.Second)
+			route := &model.Route{ID: 1}
+
+			var body io.Reader
+
+			if scenario.body != "" {
+				body = strings.NewReader(scenario.body)
+			}
+
+			outbound := httptest.NewRequest(scenario.method, "http://primary/v1/countries", body)
+			outbound.Header.Set("X-Test", "primary")
+
+			shadow := m.Shadow(route, "/countries", target, http.DefaultTransport)
+			shadow.Capture(outbound)
+
+			if scenario.readBody {
+				_, _ = io.ReadAll(outbound.Body)
+			}
+
+			shadow.Fire()
+
+			if scenario.expectedShadow == "" {
+				select {
+				case got := <-received:
+					t.Fatalf("expected no shadow request, got %q", got)
+				case <-time.After(50 * time.Millisecond):
+				}
+
+				return
+			}
+
+			if got := <-received; got != scenario.expectedShadow {
+				t.Fatalf("expected shadow request %q, got %q", scenario.expectedShadow, got)
+			}
+
+			entry := <-recorder.entries
+
+			if entry.method != scenario.method || entry.url != "/countries" || entry.statusCode != http.StatusTeapot {
+				t.Fatalf("expected the shadow outcome to be recorded against the inbound path, got %+v", entry)
+			}
+		})
+	}
+}
+
+func TestShadow_Capture_Credentials(t *testing.T) {
+	received := make(chan http.Header, 1)
+
+	shadowBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer shadowBackend.Close()
+
+	target, _ := url.Parse(shadowBackend.URL)
+
+	scenarios := []struct {
+		name                  string
+		route                 *model.Route
+		header                http.Header
+		expectedAbsent        []string
+		expectedAuthorization string
+	}{
+		{
+			name:           "api key",
+			route:          &model.Route{ID: 1, IdentityMode: identity.ModePassthrough, UpstreamAuth: &model.UpstreamAuth{Type: model.UpstreamAuthAPIKey, HeaderName: "X-Partner-Key", APIKey: "key-123"}},
+			header:         http.Header{"X-Partner-Key": {"key-123"}},
+			expectedAbsent: []string{"X-Partner-Key"},
+		},
+		{
+			name:           "oauth2 token",
+			route:          &model.Route{ID: 1, IdentityMode: identity.ModePassthrough, UpstreamAuth: &model.UpstreamAuth{Type: model.UpstreamAuthOAuth2}},
+			header:         http.Header{"Authorization": {"Bearer upstream-token"}},
+			expectedAbsent: []string{"Authorization"},
+		},
+		{
+			name:           "identity token",
+			route:          &model.Route{ID: 1, IdentityMode: identity.ModeToken},
+			header:         http.Header{"Authorization": {"Bearer identity-token"}},
+			expectedAbsent: []string{"Authorization"},
+		},
+		{
+			name:                  "caller's own token passed through",
+			route:                 &model.Route{ID: 1, IdentityMode: identity.ModePassthrough},
+			header:                http.Header{"Authorization": {"Bearer caller-token"}},
+			expectedAuthorization: "Bearer caller-token",
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			m := New(&fakeRecorder{entries: make(chan shadowEntry, 1)}, 10, 32, time.Second)
+
+			outbound := httptest.NewRequest(http.MethodGet, "http://primary/countries", nil)
+			outbound.Header = scenario.header.Clone()
+
+			shadow := m.Shadow(scenario.route, "/countries", target, http.DefaultTransport)
+			shadow.Capture(outbound)
+			shadow.Fire()
+
+			got := <-received
+
+			for _, name := range scenario.expectedAbsent {
+				if got.Get(name) != "" {
+					t.Errorf("expected the shadow not to receive the primary's %s, got %q", name, got.Get(name))
+				}
+			}
+
+			if got.Get("Authorization") != scenario.expectedAuthorization {
+				t.Errorf("expected the shadow's Authorization to be %q, got %q", scenario.expectedAuthorization, got.Get("Authorization"))
+			}
+
+			if outbound.Header.Get("Authorization") != scenario.header.Get("Authorization") {
+				t.Errorf("expected the primary request to keep its credentials")
+			}
+		})
+	}
+}
+
+func TestShadow_Fire_Unreachable(t *testing.T) {
+	recorder := &fakeRecorder{entries: make(chan shadowEntry, 1)}
+	m := New(recorder, 10, 32, time.Second)
+	target, _ := url.Parse("http://127.0.0.1:1/countries")
+
+	shadow := m.Shadow(&model.Route{ID: 1}, "/countries", target, http.DefaultTransport)
+	shadow.Capture(httptest.NewRequest(http.MethodGet, "http://primary/countries", nil))
+	shadow.Fire()
+
+	if entry := <-recorder.entries; entry.statusCode != http.StatusBadGateway {
+		t.Fatalf("expected an unreachable shadow to be recorded as a bad gateway, got %d", entry.statusCode)
+	}
+}
+
+func TestShadow_Fire_Dropped(t *testing.T) {
+	release := make(chan struct{})
+
+	shadowBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer shadowBackend.Close()
+	defer close(release)
+
+	recorder := &fakeRecorder{entries: make(chan shadowEntry, 2)}
+	m := New(recorder, 1, 32, time.Second)
+	target, _ := url.Parse(shadowBackend.URL)
+
+	for range 2 {
+		shadow := m.Shadow(&model.Route{ID: 1}, "/countries", target, http.DefaultTransport)
+		shadow.Capture(httptest.NewRequest(http.MethodGet, "http://primary/countries", nil))
+		shadow.Fire()
+	}
+
+	if len(m.slots) != 1 {
+		t.Fatalf("expected one shadow request in flight, got %d", len(m.slots))
+	}
+}
+
+func TestSampled(t *testing.T) {
+	upstreamID := 1
+
+	if Sampled(&model.Route{MirrorPercent: 100}) {
+		t.Fatalf("expected routes without a mirror upstream not to be sampled")
+	}
+
+	if !Sampled(&model.Route{MirrorUpstreamID: &upstreamID, MirrorPercent: 100}) {
+		t.Fatalf("expected every request to be sampled at 100 percent")
+	}
+
+	if Sampled(&model.Route{MirrorUpstreamID: &upstreamID}) {
+		t.Fatalf("expected no request to be sampled at 0 percent")
+	}
+}
@@ -14,11 +14,17 @@ func (action Action) String() string {
 }
 
 const (
-	ROUTE      EntityType = "route"
-	RATE_LIMIT EntityType = "rate_limit"
+	ROUTE            EntityType = "route"
+	RATE_LIMIT       EntityType = "rate_limit"
+	UPSTREAM         EntityType = "upstream"
+	RESPONSE_CACHE   EntityType = "response_cache"
+	ROUTE_GRANT      EntityType = "route_grant"
+	SIGNING_KEY      EntityType = "signing_key"
+	TOKEN_REVOCATION EntityType = "token_revocation"
 
 	CREATE Action = "create"
 	UPDATE Action = "update"
+	PURGE  Action = "purge"
 )
 
 type AuditLog struct {
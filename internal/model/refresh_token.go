@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// RefreshToken is one link in a chain of rotating refresh tokens. Every refresh uses up the presented token and issues
+// the next one in the same family, presenting a used token again revokes the whole family. Only the token's hash is
+// stored.
+type RefreshToken struct {
+	ID                   int        `json:"id"`
+	FamilyID             string     `json:"family_id"`
+	TokenHash            string     `json:"-"`
+	ServiceAccountID     *int       `json:"service_account_id"`
+	InternalUserID       *int       `json:"internal_user_id"`
+	Scopes               []string   `json:"scopes,omitempty"`
+	AccessTokenJTI       *string    `json:"access_token_jti"`
+	AccessTokenExpiresAt *time.Time `json:"access_token_expires_at"`
+	ExpiresAt            time.Time  `json:"expires_at"`
+	UsedAt               *time.Time `json:"used_at"`
+	RevokedAt            *time.Time `json:"revoked_at"`
+	CreatedAt            time.Time  `json:"created_at"`
+}
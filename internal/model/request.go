@@ -2,7 +2,7 @@ package model
 
 import "time"
 
-// Request represents a request to the API proxy
+// Request represents a request to the API proxy, or with Shadow set the copy of one sent to the route's mirror
 type Request struct {
 	ID         int       `json:"id"`
 	RouteID    int       `json:"route_id"`
@@ -10,5 +10,10 @@ type Request struct {
 	URL        string    `json:"url"`
 	StatusCode int       `json:"status_code"`
 	Latency    int64     `json:"latency"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	GRPCStatus *int      `json:"grpc_status"`
+	Variant    string    `json:"variant"`
+	Shadow     bool      `json:"shadow"`
 	CreatedAt  time.Time `json:"created_at"`
 }
@@ -4,13 +4,62 @@ import "time"
 
 // Route represents a possible API endpoint to push a call to
 type Route struct {
-	ID            int        `json:"id"`
-	Pattern       string     `json:"pattern"`
-	BackendURL    string     `json:"backend_url"`
-	Method        string     `json:"method"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     *time.Time `json:"updated_at"`
-	InactivatedAt *time.Time `json:"inactivated_at"`
+	ID                         int               `json:"id"`
+	Pattern                    string            `json:"pattern"`
+	Host                       string            `json:"host"`
+	MatchHeaders               map[string]string `json:"match_headers,omitempty"`
+	MatchQuery                 map[string]string `json:"match_query,omitempty"`
+	BackendURL                 string            `json:"backend_url"`
+	UpstreamID                 *int              `json:"upstream_id"`
+	StripPrefix                string            `json:"strip_prefix"`
+	UpstreamProtocol           string            `json:"upstream_protocol"`
+	Method                     string            `json:"method"`
+	ConnectTimeoutMs           int               `json:"connect_timeout_ms"`
+	ResponseHeaderTimeoutMs    int               `json:"response_header_timeout_ms"`
+	IdleConnTimeoutMs          int               `json:"idle_conn_timeout_ms"`
+	TimeoutMs                  int               `json:"timeout_ms"`
+	MaxIdleConns               int               `json:"max_idle_conns"`
+	MaxConns                   int               `json:"max_conns"`
+	BreakerErrorRatePercent    int               `json:"breaker_error_rate_percent"`
+	BreakerSlowCallMs          int               `json:"breaker_slow_call_ms"`
+	BreakerSlowCallRatePercent int               `json:"breaker_slow_call_rate_percent"`
+	BreakerMinRequests         int               `json:"breaker_min_requests"`
+	BreakerWindowMs            int               `json:"breaker_window_ms"`
+	BreakerOpenMs              int               `json:"breaker_open_ms"`
+	BreakerHalfOpenRequests    int               `json:"breaker_half_open_requests"`
+	RetryMaxAttempts           int               `json:"retry_max_attempts"`
+	RetryStatusCodes           []int             `json:"retry_status_codes,omitempty"`
+	RetryOn                    []string          `json:"retry_on,omitempty"`
+	RetryBackoffMs             int               `json:"retry_backoff_ms"`
+	RetryMaxBackoffMs          int               `json:"retry_max_backoff_ms"`
+	RetryNonIdempotent         bool              `json:"retry_non_idempotent"`
+	WebSocketIdleTimeoutMs     int               `json:"websocket_idle_timeout_ms"`
+	CacheEnabled               bool              `json:"cache_enabled"`
+	CacheTTLMs                 int               `json:"cache_ttl_ms"`
+	CacheKey                   []string          `json:"cache_key,omitempty"`
+	CoalesceEnabled            bool              `json:"coalesce_enabled"`
+	CoalesceKey                []string          `json:"coalesce_key,omitempty"`
+	MirrorUpstreamID           *int              `json:"mirror_upstream_id"`
+	MirrorPercent              int               `json:"mirror_percent"`
+	Variants                   []RouteVariant    `json:"variants,omitempty"`
+	VariantStickyBy            string            `json:"variant_sticky_by"`
+	Group                      string            `json:"group"`
+	RequireGrant               bool              `json:"require_grant"`
+	RequiredScopes             []string          `json:"required_scopes,omitempty"`
+	IdentityMode               string            `json:"identity_mode"`
+	UpstreamAuth               *UpstreamAuth     `json:"upstream_auth,omitempty"`
+	CreatedAt                  time.Time         `json:"created_at"`
+	UpdatedAt                  *time.Time        `json:"updated_at"`
+	InactivatedAt              *time.Time        `json:"inactivated_at"`
+}
+
+// RouteVariant is one version of a route's backend. A route with variants splits its traffic between them by weight,
+// each variant sets exactly one of BackendURL or UpstreamID.
+type RouteVariant struct {
+	Name       string `json:"name"`
+	BackendURL string `json:"backend_url"`
+	UpstreamID *int   `json:"upstream_id"`
+	Weight     int    `json:"weight"`
 }
 
 type RouteFilter struct {
@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// RouteGrant entitles an org, or a single service account of it, to call a route or every route of a group. Only
+// routes that require a grant are checked against them.
+type RouteGrant struct {
+	ID               int        `json:"id"`
+	OrgID            int        `json:"org_id"`
+	ServiceAccountID *int       `json:"service_account_id"`
+	RouteID          *int       `json:"route_id"`
+	RouteGroup       *string    `json:"route_group"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        *time.Time `json:"updated_at"`
+	InactivatedAt    *time.Time `json:"inactivated_at"`
+}
+
+type RouteGrantFilter struct {
+	OrgID            *int
+	ServiceAccountID *int
+	RouteID          *int
+	RouteGroup       string
+}
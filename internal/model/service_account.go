@@ -8,6 +8,7 @@ type ServiceAccount struct {
 	Identifier    string     `json:"identifier"`
 	ClientID      string     `json:"client_id"`
 	ClientSecret  string     `json:"client_secret,omitempty"`
+	Scopes        []string   `json:"scopes,omitempty"`
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     *time.Time `json:"updated_at"`
 	InactivatedAt *time.Time `json:"inactivated_at"`
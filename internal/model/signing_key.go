@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// SigningKey is an asymmetric key the proxy signs its tokens with. It signs from ActivatesAt until RetiresAt and its
+// public half is published until ExpiresAt, which should leave time for the last tokens it signed to expire.
+type SigningKey struct {
+	ID            int        `json:"id"`
+	KID           string     `json:"kid"`
+	Algorithm     string     `json:"algorithm"`
+	PrivateKey    []byte     `json:"-"`
+	ActivatesAt   time.Time  `json:"activates_at"`
+	RetiresAt     *time.Time `json:"retires_at"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     *time.Time `json:"updated_at"`
+	InactivatedAt *time.Time `json:"inactivated_at"`
+}
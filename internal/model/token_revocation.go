@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// TokenRevocation invalidates a single token by its jti, or every token of an org or service account issued up to
+// RevokedAt. It only has to be kept until ExpiresAt, when the tokens it covers have expired on their own.
+type TokenRevocation struct {
+	ID               int       `json:"id"`
+	JTI              *string   `json:"jti"`
+	OrgID            *int      `json:"org_id"`
+	ServiceAccountID *int      `json:"service_account_id"`
+	RevokedAt        time.Time `json:"revoked_at"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	CreatedAt        time.Time `json:"created_at"`
+}
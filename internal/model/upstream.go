@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+const (
+	StrategyRoundRobin       = "round_robin"
+	StrategyWeightedRandom   = "weighted_random"
+	StrategyLeastOutstanding = "least_outstanding"
+	StrategyConsistentHash   = "consistent_hash"
+)
+
+// Upstream is a named pool of backend targets a route can send its traffic to. Targets are actively probed when a
+// health check path is set and passively ejected after consecutive failures, zero values fall back to defaults.
+type Upstream struct {
+	ID                     int               `json:"id"`
+	Name                   string            `json:"name"`
+	Strategy               string            `json:"strategy"`
+	HealthCheckPath        string            `json:"health_check_path"`
+	HealthCheckIntervalMs  int               `json:"health_check_interval_ms"`
+	HealthyThreshold       int               `json:"healthy_threshold"`
+	UnhealthyThreshold     int               `json:"unhealthy_threshold"`
+	MaxConsecutiveFailures int               `json:"max_consecutive_failures"`
+	EjectionDurationMs     int               `json:"ejection_duration_ms"`
+	Targets                []*UpstreamTarget `json:"targets"`
+	CreatedAt              time.Time         `json:"created_at"`
+	UpdatedAt              *time.Time        `json:"updated_at"`
+	InactivatedAt          *time.Time        `json:"inactivated_at"`
+}
+
+// UpstreamTarget is a single backend in an upstream pool, weight is relative to the other targets in the pool. A
+// target without a weight gets 1, a weight of 0 drains the target: it receives no new requests.
+type UpstreamTarget struct {
+	ID         int       `json:"id"`
+	UpstreamID int       `json:"upstream_id"`
+	URL        string    `json:"url"`
+	Weight     *int      `json:"weight"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type UpstreamFilter struct {
+	Name string
+}
@@ -0,0 +1,21 @@
+package model
+
+const (
+	UpstreamAuthAPIKey = "api_key"
+	UpstreamAuthBasic  = "basic"
+	UpstreamAuthOAuth2 = "oauth2"
+)
+
+// UpstreamAuth holds the credentials the proxy attaches when calling a route's backend. It is stored encrypted
+// and the secret fields are never returned by the admin API.
+type UpstreamAuth struct {
+	Type         string `json:"type"`
+	HeaderName   string `json:"header_name,omitempty"`
+	APIKey       string `json:"api_key,omitempty"`
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	TokenURL     string `json:"token_url,omitempty"`
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	Scopes       string `json:"scopes,omitempty"`
+}
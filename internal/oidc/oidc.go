@@ -0,0 +1,267 @@
+package oidc
+
+import (
+	"api-proxy/internal/signing"
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// keysTTL is how long a provider's JWKS is used before it is fetched again
+	keysTTL = time.Hour
+	// minRefreshInterval keeps tokens with unknown kids from making us fetch the JWKS on every exchange
+	minRefreshInterval = time.Minute
+	maxDocumentBytes   = 1 << 20
+	leeway             = 30 * time.Second
+)
+
+var ErrUnknownIssuer = errors.New("token issuer is not a configured provider")
+var ErrUnknownKey = errors.New("token is signed with a key the provider does not publish")
+var ErrMissingSubject = errors.New("token is missing the subject claim")
+var ErrSubjectNotAllowed = errors.New("token names an org or service account the provider may not map to")
+
+// validMethods are the algorithms provider tokens may be signed with, HMAC is never accepted
+var validMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Provider is a third-party identity provider whose tokens can be exchanged. Its keys are read from JWKSURL, or
+// from the jwks_uri of the issuer's discovery document when JWKSURL is empty. A provider only vouches for the
+// service accounts in ServiceAccounts and the orgs in Orgs, which maps each org to the service account the
+// provider's users of that org act as.
+type Provider struct {
+	Name                string
+	Issuer              string
+	Audience            string
+	JWKSURL             string
+	SubjectClaim        string
+	ServiceAccountClaim string
+	OrgClaim            string
+	ServiceAccounts     []string
+	Orgs                map[string]string
+}
+
+// Subject is who a verified provider token stands for. ServiceAccount is the service account the token names or,
+// for a token naming only an org, the one the provider's users of that org act as, which sets OrgUser. Org is empty
+// when the provider does not map it or the token lacks the claim.
+type Subject struct {
+	Provider       string
+	Subject        string
+	ServiceAccount string
+	Org            string
+	OrgUser        bool
+}
+
+// Verifier checks tokens issued by any of the configured providers against the keys they publish
+type Verifier struct {
+	client    *http.Client
+	providers map[string]*provider
+	now       func() time.Time
+}
+
+// provider caches the published keys of a Provider by kid
+type provider struct {
+	Provider
+	mu          sync.Mutex
+	jwksURL     string
+	keys        map[string]signing.JWK
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+func NewVerifier(client *http.Client, providers []Provider) *Verifier {
+	v := &Verifier{
+		client:    client,
+		providers: make(map[string]*provider, len(providers)),
+		now:       time.Now,
+	}
+
+	for _, p := range providers {
+		v.providers[p.Issuer] = &provider{Provider: p, jwksURL: p.JWKSURL}
+	}
+
+	return v
+}
+
+// Verify checks the token's signature, issuer, audience and expiry and maps its claims to a Subject. The provider is
+// picked by the token's iss claim before anything else is trusted.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Subject, error) {
+	unverified, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	issuer, _ := unverified.Claims.GetIssuer()
+	p, ok := v.providers[issuer]
+
+	if !ok {
+		return nil, ErrUnknownIssuer
+	}
+
+	parsed, err := jwt.Parse(
+		token,
+		func(token *jwt.Token) (any, error) {
+			kid, _ := token.Header["kid"].(string)
+			return v.key(ctx, p, kid, token.Method.Alg())
+		},
+		jwt.WithValidMethods(validMethods),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+		jwt.WithTimeFunc(v.now),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims := parsed.Claims.(jwt.MapClaims)
+	subject := &Subject{Provider: p.Name, Subject: stringClaim(claims, p.SubjectClaim)}
+
+	if subject.Subject == "" {
+		return nil, ErrMissingSubject
+	}
+
+	subject.ServiceAccount = stringClaim(claims, p.ServiceAccountClaim)
+	subject.Org = stringClaim(claims, p.OrgClaim)
+
+	if err := p.scope(subject); err != nil {
+		return nil, err
+	}
+
+	return subject, nil
+}
+
+// scope checks the org and service account the token names are ones the provider may vouch for, and resolves the
+// service account of a token that only names an org
+func (p *provider) scope(subject *Subject) error {
+	accountOfOrg, ok := p.Orgs[subject.Org]
+
+	if subject.Org != "" && !ok {
+		return ErrSubjectNotAllowed
+	}
+
+	switch {
+	case subject.ServiceAccount != "":
+		if !slices.Contains(p.ServiceAccounts, subject.ServiceAccount) {
+			return ErrSubjectNotAllowed
+		}
+	case subject.Org != "":
+		subject.ServiceAccount, subject.OrgUser = accountOfOrg, true
+	}
+
+	return nil
+}
+
+// key returns the provider's public key for kid, fetching the JWKS again when it is stale or does not know kid. The
+// JWKS is fetched at most once per minRefreshInterval, the keys already known keep being used if that fails.
+func (v *Verifier) key(ctx context.Context, p *provider, kid, alg string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := v.now()
+	jwk, ok := p.keys[kid]
+
+	if (!ok || now.Sub(p.fetchedAt) > keysTTL) && now.Sub(p.attemptedAt) > minRefreshInterval {
+		p.attemptedAt = now
+
+		if err := v.refresh(ctx, p); err != nil {
+			if !ok {
+				return nil, err
+			}
+
+			slog.Warn("error refreshing provider keys, using the cached ones", "provider", p.Name, "error", err)
+		}
+
+		jwk, ok = p.keys[kid]
+	}
+
+	if !ok || (jwk.Algorithm != "" && jwk.Algorithm != alg) {
+		return nil, ErrUnknownKey
+	}
+
+	return jwk.PublicKey()
+}
+
+func (v *Verifier) refresh(ctx context.Context, p *provider) error {
+	if p.jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+
+		if err := v.fetch(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return fmt.Errorf("error discovering the keys of %s: %w", p.Name, err)
+		}
+
+		if discovery.JWKSURI == "" {
+			return fmt.Errorf("discovery document of %s has no jwks_uri", p.Name)
+		}
+
+		p.jwksURL = discovery.JWKSURI
+	}
+
+	var jwks signing.JWKS
+
+	if err := v.fetch(ctx, p.jwksURL, &jwks); err != nil {
+		return fmt.Errorf("error fetching the keys of %s: %w", p.Name, err)
+	}
+
+	keys := make(map[string]signing.JWK, len(jwks.Keys))
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Use == "" || jwk.Use == "sig" {
+			keys[jwk.KeyID] = jwk
+		}
+	}
+
+	p.keys = keys
+	p.fetchedAt = v.now()
+
+	return nil
+}
+
+func (v *Verifier) fetch(ctx context.Context, url string, document any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Accept", "application/json")
+
+	response, err := v.client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", response.StatusCode, url)
+	}
+
+	return json.NewDecoder(io.LimitReader(response.Body, maxDocumentBytes)).Decode(document)
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	if name == "" {
+		return ""
+	}
+
+	value, _ := claims[name].(string)
+
+	return value
+}
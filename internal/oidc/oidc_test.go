@@ -0,0 +1,204 @@
+package oidc
+
+import (
+	"api-proxy/internal/signing"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// identityProvider stands in for a provider's discovery document and JWKS
+type identityProvider struct {
+	server  *httptest.Server
+	keys    atomic.Pointer[[]*signing.Key]
+	fetches atomic.Int32
+}
+
+func newIdentityProvider(t *testing.T, keys ...*signing.Key) *identityProvider {
+	t.Helper()
+
+	idp := &identityProvider{}
+	idp.keys.Store(&keys)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": idp.server.URL, "jwks_uri": idp.server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		idp.fetches.Add(1)
+		jwks := signing.JWKS{}
+
+		for _, key := range *idp.keys.Load() {
+			jwk, err := key.JWK()
+
+			if err != nil {
+				t.Error(err)
+			}
+
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+
+		json.NewEncoder(w).Encode(jwks)
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+func newKey(t *testing.T, kid, alg string) *signing.Key {
+	t.Helper()
+
+	der, err := signing.Generate(alg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := signing.ParseKey(kid, alg, der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func sign(t *testing.T, key *signing.Key, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := key.Sign(claims)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	key := newKey(t, "k1", signing.AlgorithmRS256)
+	unpublished := newKey(t, "k2", signing.AlgorithmES256)
+	idp := newIdentityProvider(t, key)
+	verifier := NewVerifier(idp.server.Client(), []Provider{{
+		Name:                "kinde",
+		Issuer:              idp.server.URL,
+		Audience:            "api-proxy",
+		SubjectClaim:        "sub",
+		ServiceAccountClaim: "client_name",
+		OrgClaim:            "org_code",
+		ServiceAccounts:     []string{"billing-bot"},
+		Orgs:                map[string]string{"acme": "acme-users"},
+	}})
+
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss":         idp.server.URL,
+			"aud":         "api-proxy",
+			"sub":         "kp_123",
+			"client_name": "billing-bot",
+			"org_code":    "acme",
+			"exp":         time.Now().Add(time.Hour).Unix(),
+		}
+
+		for name, value := range overrides {
+			if value == nil {
+				delete(c, name)
+			} else {
+				c[name] = value
+			}
+		}
+
+		return c
+	}
+
+	hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(nil)).SignedString([]byte("secret"))
+
+	scenarios := []struct {
+		name        string
+		token       string
+		expected    *Subject
+		expectedErr error
+	}{
+		{
+			name:     "valid",
+			token:    sign(t, key, claims(nil)),
+			expected: &Subject{Provider: "kinde", Subject: "kp_123", ServiceAccount: "billing-bot", Org: "acme"},
+		},
+		{
+			name:     "audience list",
+			token:    sign(t, key, claims(jwt.MapClaims{"aud": []string{"other", "api-proxy"}, "client_name": nil})),
+			expected: &Subject{Provider: "kinde", Subject: "kp_123", ServiceAccount: "acme-users", Org: "acme", OrgUser: true},
+		},
+		{name: "org the provider may not map to", token: sign(t, key, claims(jwt.MapClaims{"org_code": "globex"})), expectedErr: ErrSubjectNotAllowed},
+		{name: "service account the provider may not map to", token: sign(t, key, claims(jwt.MapClaims{"client_name": "admin-bot"})), expectedErr: ErrSubjectNotAllowed},
+		{name: "wrong audience", token: sign(t, key, claims(jwt.MapClaims{"aud": "other"})), expectedErr: jwt.ErrTokenInvalidAudience},
+		{name: "expired", token: sign(t, key, claims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})), expectedErr: jwt.ErrTokenExpired},
+		{name: "no expiry", token: sign(t, key, claims(jwt.MapClaims{"exp": nil})), expectedErr: jwt.ErrTokenRequiredClaimMissing},
+		{name: "unknown issuer", token: sign(t, key, claims(jwt.MapClaims{"iss": "https://evil.example.com"})), expectedErr: ErrUnknownIssuer},
+		{name: "unpublished key", token: sign(t, unpublished, claims(nil)), expectedErr: ErrUnknownKey},
+		{name: "hmac", token: hmac, expectedErr: jwt.ErrTokenSignatureInvalid},
+		{name: "no subject", token: sign(t, key, claims(jwt.MapClaims{"sub": nil})), expectedErr: ErrMissingSubject},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			subject, err := verifier.Verify(context.Background(), s.token)
+
+			if s.expectedErr != nil {
+				if !errors.Is(err, s.expectedErr) {
+					t.Fatalf("expected error %v, got %v", s.expectedErr, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if *subject != *s.expected {
+				t.Errorf("expected %+v, got %+v", s.expected, subject)
+			}
+		})
+	}
+}
+
+func TestVerifier_KeyRotation(t *testing.T) {
+	old := newKey(t, "old", signing.AlgorithmEdDSA)
+	rotated := newKey(t, "new", signing.AlgorithmES256)
+	idp := newIdentityProvider(t, old)
+	verifier := NewVerifier(idp.server.Client(), []Provider{{Name: "idp", Issuer: idp.server.URL, Audience: "api-proxy", SubjectClaim: "sub"}})
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	claims := jwt.MapClaims{"iss": idp.server.URL, "aud": "api-proxy", "sub": "user-1", "exp": now.Add(time.Hour).Unix()}
+
+	if _, err := verifier.Verify(context.Background(), sign(t, old, claims)); err != nil {
+		t.Fatal(err)
+	}
+
+	idp.keys.Store(&[]*signing.Key{old, rotated})
+
+	if _, err := verifier.Verify(context.Background(), sign(t, rotated, claims)); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected the unknown kid not to be fetched again right away, got %v", err)
+	}
+
+	now = now.Add(2 * minRefreshInterval)
+
+	if _, err := verifier.Verify(context.Background(), sign(t, rotated, claims)); err != nil {
+		t.Fatalf("expected the rotated key to be fetched, got %v", err)
+	}
+
+	if fetches := idp.fetches.Load(); fetches != 2 {
+		t.Errorf("expected 2 jwks fetches, got %d", fetches)
+	}
+}
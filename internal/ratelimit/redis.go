@@ -40,6 +40,11 @@ func NewRedisRateLimiter(url string) *RedisRateLimiter {
 	}
 }
 
+// Client returns the redis connection so other features can share it
+func (rrl *RedisRateLimiter) Client() *redis.Client {
+	return rrl.client
+}
+
 func (rrl *RedisRateLimiter) AllowRequest(orgID, saID int) bool {
 	ctx := context.Background()
 
@@ -0,0 +1,144 @@
+package refresh
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// ErrInvalidGrant is returned for refresh tokens that are unknown, expired or revoked
+var ErrInvalidGrant = errors.New("invalid refresh token")
+
+// ErrReused is returned when a refresh token that was already used is presented again. Either the client or someone
+// who stole one of its tokens is replaying it, so the whole family has been revoked.
+var ErrReused = errors.New("refresh token reused")
+
+// Store keeps the refresh tokens, hashed
+type Store interface {
+	FindByHash(hash string) (*model.RefreshToken, error)
+	FindByFamily(familyID string) ([]*model.RefreshToken, error)
+	Insert(token *model.RefreshToken) (*model.RefreshToken, error)
+	MarkUsed(id int) (bool, error)
+	RevokeFamily(familyID string) error
+}
+
+// AccessTokenRevoker revokes the access tokens issued alongside the refresh tokens of a revoked family
+type AccessTokenRevoker interface {
+	Revoke(ctx context.Context, revocation *model.TokenRevocation) error
+}
+
+// Tokens issues and rotates refresh tokens
+type Tokens struct {
+	store   Store
+	revoker AccessTokenRevoker
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func NewTokens(store Store, revoker AccessTokenRevoker, ttl time.Duration) *Tokens {
+	return &Tokens{store: store, revoker: revoker, ttl: ttl, now: time.Now}
+}
+
+// Issue stores a new refresh token and returns it, the only time it is seen in the clear. A token without a family
+// starts a new one.
+func (t *Tokens) Issue(token *model.RefreshToken) (string, error) {
+	raw := rand.Text()
+
+	if token.FamilyID == "" {
+		token.FamilyID = rand.Text()
+	}
+
+	token.TokenHash = hash(raw)
+	token.ExpiresAt = t.now().Add(t.ttl)
+
+	if _, err := t.store.Insert(token); err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+// Find returns the refresh token, nil when it is unknown. Callers check what it was issued for before redeeming it,
+// so a token presented in the wrong place is not used up.
+func (t *Tokens) Find(raw string) (*model.RefreshToken, error) {
+	return t.store.FindByHash(hash(raw))
+}
+
+// Redeem uses up a refresh token found with Find so the next one in its family can be issued. Using a token twice
+// revokes its family and returns ErrReused.
+func (t *Tokens) Redeem(ctx context.Context, token *model.RefreshToken) error {
+	if token.RevokedAt != nil || !t.now().Before(token.ExpiresAt) {
+		return ErrInvalidGrant
+	}
+
+	if token.UsedAt != nil {
+		return t.reused(ctx, token)
+	}
+
+	ok, err := t.store.MarkUsed(token.ID)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return t.reused(ctx, token)
+	}
+
+	return nil
+}
+
+func (t *Tokens) reused(ctx context.Context, token *model.RefreshToken) error {
+	slog.Warn("refresh token reused, revoking its family", "family_id", token.FamilyID)
+
+	if err := t.RevokeFamily(ctx, token.FamilyID); err != nil {
+		return err
+	}
+
+	return ErrReused
+}
+
+// RevokeFamily revokes every refresh token of a family and the access tokens issued with them that have not expired
+func (t *Tokens) RevokeFamily(ctx context.Context, familyID string) error {
+	if err := t.store.RevokeFamily(familyID); err != nil {
+		return err
+	}
+
+	tokens, err := t.store.FindByFamily(familyID)
+
+	if err != nil {
+		return err
+	}
+
+	now := t.now()
+
+	for _, token := range tokens {
+		if token.AccessTokenJTI == nil || token.AccessTokenExpiresAt == nil || !now.Before(*token.AccessTokenExpiresAt) {
+			continue
+		}
+
+		err := t.revoker.Revoke(ctx, &model.TokenRevocation{
+			JTI:       token.AccessTokenJTI,
+			RevokedAt: now,
+			ExpiresAt: *token.AccessTokenExpiresAt,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hash is what the store knows a refresh token by. The tokens are random, unlike passwords they need no slow hash.
+func hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,173 @@
+package refresh
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memoryStore stands in for the refresh token repository
+type memoryStore struct {
+	tokens []*model.RefreshToken
+}
+
+func (ms *memoryStore) FindByHash(hash string) (*model.RefreshToken, error) {
+	for _, token := range ms.tokens {
+		if token.TokenHash == hash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (ms *memoryStore) FindByFamily(familyID string) ([]*model.RefreshToken, error) {
+	var family []*model.RefreshToken
+
+	for _, token := range ms.tokens {
+		if token.FamilyID == familyID {
+			family = append(family, token)
+		}
+	}
+
+	return family, nil
+}
+
+func (ms *memoryStore) Insert(token *model.RefreshToken) (*model.RefreshToken, error) {
+	token.ID = len(ms.tokens) + 1
+	ms.tokens = append(ms.tokens, token)
+
+	return token, nil
+}
+
+func (ms *memoryStore) MarkUsed(id int) (bool, error) {
+	token := ms.tokens[id-1]
+
+	if token.UsedAt != nil || token.RevokedAt != nil {
+		return false, nil
+	}
+
+	token.UsedAt = new(time.Now())
+	return true, nil
+}
+
+func (ms *memoryStore) RevokeFamily(familyID string) error {
+	for _, token := range ms.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = new(time.Now())
+		}
+	}
+
+	return nil
+}
+
+type recordingRevoker struct {
+	revoked []string
+}
+
+func (rr *recordingRevoker) Revoke(_ context.Context, revocation *model.TokenRevocation) error {
+	rr.revoked = append(rr.revoked, *revocation.JTI)
+	return nil
+}
+
+// redeem finds and redeems a refresh token the way the token endpoints do
+func redeem(t *testing.T, tokens *Tokens, raw string) (*model.RefreshToken, error) {
+	t.Helper()
+
+	token, err := tokens.Find(raw)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token == nil {
+		return nil, ErrInvalidGrant
+	}
+
+	return token, tokens.Redeem(context.Background(), token)
+}
+
+func TestTokens_Redeem(t *testing.T) {
+	store := &memoryStore{}
+	revoker := &recordingRevoker{}
+	tokens := NewTokens(store, revoker, time.Hour)
+	now := time.Now()
+	tokens.now = func() time.Time { return now }
+
+	issue := func(familyID, accessJTI string) string {
+		accessExpiresAt := now.Add(time.Hour)
+		raw, err := tokens.Issue(&model.RefreshToken{FamilyID: familyID, ServiceAccountID: new(1), AccessTokenJTI: &accessJTI, AccessTokenExpiresAt: &accessExpiresAt})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return raw
+	}
+
+	first := issue("", "access-1")
+	family := store.tokens[0].FamilyID
+
+	redeemed, err := redeem(t, tokens, first)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *redeemed.ServiceAccountID != 1 {
+		t.Errorf("expected the token of service account 1, got %d", *redeemed.ServiceAccountID)
+	}
+
+	second := issue(family, "access-2")
+	other := issue("", "access-3")
+
+	if _, err := redeem(t, tokens, first); !errors.Is(err, ErrReused) {
+		t.Fatalf("expected reusing the first token to fail with %v, got %v", ErrReused, err)
+	}
+
+	if _, err := redeem(t, tokens, second); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("expected the rest of the family to be revoked, got %v", err)
+	}
+
+	if len(revoker.revoked) != 2 || revoker.revoked[0] != "access-1" || revoker.revoked[1] != "access-2" {
+		t.Errorf("expected the family's access tokens to be revoked, got %v", revoker.revoked)
+	}
+
+	if _, err := redeem(t, tokens, other); err != nil {
+		t.Errorf("expected other families to be left alone, got %v", err)
+	}
+}
+
+func TestTokens_RedeemInvalid(t *testing.T) {
+	store := &memoryStore{}
+	tokens := NewTokens(store, &recordingRevoker{}, time.Hour)
+	now := time.Now()
+	tokens.now = func() time.Time { return now }
+
+	expired, err := tokens.Issue(&model.RefreshToken{InternalUserID: new(1)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Hour)
+
+	scenarios := []struct {
+		name  string
+		token string
+	}{
+		{name: "unknown", token: "not-a-refresh-token"},
+		{name: "expired", token: expired},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if _, err := redeem(t, tokens, s.token); !errors.Is(err, ErrInvalidGrant) {
+				t.Errorf("expected %v, got %v", ErrInvalidGrant, err)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 const (
 	findActiveOrgs = "SELECT id, name, created_at, updated_at, inactivated_at FROM org where inactivated_at is null"
 	findOrgByID    = "SELECT id, name, created_at, updated_at, inactivated_at FROM org where id = ?"
+	findOrgByName  = "SELECT id, name, created_at, updated_at, inactivated_at FROM org where name = ? and inactivated_at is null"
 	insertOrg      = "INSERT INTO org (name, updated_at, inactivated_at) VALUES (?, CURRENT_TIMESTAMP(6), null)"
 	updateOrg      = "UPDATE org SET name = ?, updated_at = CURRENT_TIMESTAMP(6), inactivated_at = ? WHERE id = ?"
 	deleteOrg      = "DELETE FROM org WHERE id = ?"
@@ -33,6 +34,11 @@ func (or *OrgRepository) FindByID(id int) (*model.Org, error) {
 	return or.findOrg(findOrgByID, id)
 }
 
+// FindActiveByName queries the DB and returns the active org with matching name
+func (or *OrgRepository) FindActiveByName(name string) (*model.Org, error) {
+	return or.findOrg(findOrgByName, name)
+}
+
 // Insert creates a new active org in the database and returns it
 func (or *OrgRepository) Insert(org *model.Org) (*model.Org, error) {
 	createdId, err := execInsert(or.db, insertOrg, org.Name)
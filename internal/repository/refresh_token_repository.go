@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"api-proxy/internal/model"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	refreshTokenColumns       = "id, family_id, token_hash, service_account_id, internal_user_id, scopes, access_token_jti, access_token_expires_at, expires_at, used_at, revoked_at, created_at"
+	findRefreshTokenByHash    = "SELECT " + refreshTokenColumns + " FROM refresh_token where token_hash = ?"
+	findRefreshTokensByFamily = "SELECT " + refreshTokenColumns + " FROM refresh_token where family_id = ?"
+	insertRefreshToken        = "INSERT INTO refresh_token (family_id, token_hash, service_account_id, internal_user_id, scopes, access_token_jti, access_token_expires_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	markRefreshTokenUsed      = "UPDATE refresh_token SET used_at = CURRENT_TIMESTAMP(6) WHERE id = ? and used_at is null and revoked_at is null"
+	revokeRefreshTokenFamily  = "UPDATE refresh_token SET revoked_at = CURRENT_TIMESTAMP(6) WHERE family_id = ? and revoked_at is null"
+)
+
+// RefreshTokenRepository represents an object through which RefreshToken queries can be run
+type RefreshTokenRepository struct {
+	db *sql.DB
+}
+
+func NewRefreshTokenRepository(db *sql.DB) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+// FindByHash queries the database and returns the refresh token with the matching hash, used and revoked ones included
+func (rtr *RefreshTokenRepository) FindByHash(hash string) (*model.RefreshToken, error) {
+	token, err := scanRefreshToken(rtr.db.QueryRow(findRefreshTokenByHash, hash))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// FindByFamily queries every refresh token issued in a family
+func (rtr *RefreshTokenRepository) FindByFamily(familyID string) ([]*model.RefreshToken, error) {
+	tokens := make([]*model.RefreshToken, 0)
+
+	result, err := rtr.db.Query(findRefreshTokensByFamily, familyID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer result.Close()
+
+	for result.Next() {
+		token, rowErr := scanRefreshToken(result)
+
+		if rowErr != nil {
+			return nil, rowErr
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// Insert stores a new refresh token and returns it
+func (rtr *RefreshTokenRepository) Insert(token *model.RefreshToken) (*model.RefreshToken, error) {
+	scopes, err := marshalList(token.Scopes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	createdId, err := execInsert(
+		rtr.db,
+		insertRefreshToken,
+		token.FamilyID,
+		token.TokenHash,
+		token.ServiceAccountID,
+		token.InternalUserID,
+		scopes,
+		token.AccessTokenJTI,
+		token.AccessTokenExpiresAt,
+		token.ExpiresAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token.ID = createdId
+	return token, nil
+}
+
+// MarkUsed uses up a refresh token, ok is false when it had already been used or revoked. Checking and marking in a
+// single statement keeps two concurrent refreshes from both succeeding.
+func (rtr *RefreshTokenRepository) MarkUsed(id int) (bool, error) {
+	err := execUpdate(rtr.db, markRefreshTokenUsed, id)
+
+	if errors.Is(err, ErrNoRowsAffectedOnUpdate) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RevokeFamily revokes every refresh token of a family that is not revoked yet
+func (rtr *RefreshTokenRepository) RevokeFamily(familyID string) error {
+	_, err := rtr.db.Exec(revokeRefreshTokenFamily, familyID)
+
+	return err
+}
+
+// scanRefreshToken reads a single row selected with refreshTokenColumns, in order
+func scanRefreshToken(row interface{ Scan(dest ...any) error }) (*model.RefreshToken, error) {
+	var token model.RefreshToken
+	var scopes []byte
+
+	err := row.Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.ServiceAccountID,
+		&token.InternalUserID,
+		&scopes,
+		&token.AccessTokenJTI,
+		&token.AccessTokenExpiresAt,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Scopes, err = unmarshalList[string](scopes); err != nil {
+		return nil, fmt.Errorf("error reading scopes for refresh token %d: %w", token.ID, err)
+	}
+
+	return &token, nil
+}
@@ -8,8 +8,8 @@ import (
 )
 
 const (
-	findRequestsBetween        = "SELECT id, route_id, method, url, status_code, latency, created_at FROM request WHERE ? <= created_at AND created_at <= ?"
-	insertRequest              = "INSERT INTO request (route_id, method, url, status_code, latency, created_at) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP(6))"
+	findRequestsBetween        = "SELECT id, route_id, method, url, status_code, latency, bytes_in, bytes_out, grpc_status, variant, shadow, created_at FROM request WHERE ? <= created_at AND created_at <= ?"
+	insertRequest              = "INSERT INTO request (route_id, method, url, status_code, latency, bytes_in, bytes_out, grpc_status, variant, shadow, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP(6))"
 	deleteAllRequestsOlderThan = "DELETE FROM request WHERE created_at < ?"
 )
 
@@ -36,6 +36,11 @@ func (rr *RequestRepository) Insert(request *model.Request) (*model.Request, err
 		request.URL,
 		request.StatusCode,
 		request.Latency,
+		request.BytesIn,
+		request.BytesOut,
+		request.GRPCStatus,
+		request.Variant,
+		request.Shadow,
 	)
 
 	if err != nil {
@@ -73,6 +78,11 @@ func (rr *RequestRepository) findRequests(query string, args ...any) ([]*model.R
 			&request.URL,
 			&request.StatusCode,
 			&request.Latency,
+			&request.BytesIn,
+			&request.BytesOut,
+			&request.GRPCStatus,
+			&request.Variant,
+			&request.Shadow,
 			&request.CreatedAt,
 		)
 
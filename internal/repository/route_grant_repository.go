@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"api-proxy/internal/model"
+	"database/sql"
+	"errors"
+)
+
+const (
+	routeGrantColumns     = "id, org_id, service_account_id, route_id, route_group, created_at, updated_at, inactivated_at"
+	findActiveRouteGrants = "SELECT " + routeGrantColumns + " FROM route_grant where inactivated_at is null"
+	routeIdWhereClause    = " AND route_id = ?"
+	routeGroupWhereClause = " AND route_group = ?"
+	findRouteGrantByID    = "SELECT " + routeGrantColumns + " FROM route_grant where id = ?"
+	insertRouteGrant      = "INSERT INTO route_grant (org_id, service_account_id, route_id, route_group, updated_at, inactivated_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP(6), null)"
+	updateRouteGrant      = "UPDATE route_grant SET org_id = ?, service_account_id = ?, route_id = ?, route_group = ?, updated_at = CURRENT_TIMESTAMP(6), inactivated_at = ? WHERE id = ?"
+	deleteRouteGrant      = "DELETE FROM route_grant WHERE id = ?"
+)
+
+// RouteGrantRepository represents an object through which RouteGrant queries can be run
+type RouteGrantRepository struct {
+	db *sql.DB
+}
+
+func NewRouteGrantRepository(db *sql.DB) *RouteGrantRepository {
+	return &RouteGrantRepository{db: db}
+}
+
+// FindActiveByFilter queries route grants from the database using the specified filters
+func (rgr *RouteGrantRepository) FindActiveByFilter(filter *model.RouteGrantFilter) ([]*model.RouteGrant, error) {
+	var args []any
+	query := findActiveRouteGrants
+
+	if filter != nil && filter.OrgID != nil {
+		query += orgIdWhereClause
+		args = append(args, *filter.OrgID)
+	}
+
+	if filter != nil && filter.ServiceAccountID != nil {
+		query += serviceAccountIdWhereClause
+		args = append(args, *filter.ServiceAccountID)
+	}
+
+	if filter != nil && filter.RouteID != nil {
+		query += routeIdWhereClause
+		args = append(args, *filter.RouteID)
+	}
+
+	if filter != nil && filter.RouteGroup != "" {
+		query += routeGroupWhereClause
+		args = append(args, filter.RouteGroup)
+	}
+
+	return rgr.findRouteGrants(query, args...)
+}
+
+// FindByID queries the database and returns a single route grant with matching ID
+func (rgr *RouteGrantRepository) FindByID(id int) (*model.RouteGrant, error) {
+	grant, err := scanRouteGrant(rgr.db.QueryRow(findRouteGrantByID, id))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return grant, nil
+}
+
+// Insert creates a new active route grant in the database and returns it
+func (rgr *RouteGrantRepository) Insert(grant *model.RouteGrant) (*model.RouteGrant, error) {
+	createdId, err := execInsert(
+		rgr.db,
+		insertRouteGrant,
+		grant.OrgID,
+		grant.ServiceAccountID,
+		grant.RouteID,
+		grant.RouteGroup,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	grant.ID = createdId
+	return grant, nil
+}
+
+// Update updates an existing route grant in the database and returns the updated data
+func (rgr *RouteGrantRepository) Update(grant *model.RouteGrant) (*model.RouteGrant, error) {
+	err := execUpdate(
+		rgr.db,
+		updateRouteGrant,
+		grant.OrgID,
+		grant.ServiceAccountID,
+		grant.RouteID,
+		grant.RouteGroup,
+		grant.InactivatedAt,
+		grant.ID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return grant, nil
+}
+
+// Delete removes any existing route grant if it's ID matches the given id
+func (rgr *RouteGrantRepository) Delete(id int) error {
+	return execDelete(rgr.db, deleteRouteGrant, id)
+}
+
+func (rgr *RouteGrantRepository) findRouteGrants(query string, args ...any) ([]*model.RouteGrant, error) {
+	grants := make([]*model.RouteGrant, 0)
+
+	result, err := rgr.db.Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer result.Close()
+
+	for result.Next() {
+		grant, rowErr := scanRouteGrant(result)
+
+		if rowErr != nil {
+			return nil, rowErr
+		}
+
+		grants = append(grants, grant)
+	}
+
+	return grants, nil
+}
+
+// scanRouteGrant reads a single row selected with routeGrantColumns, in order
+func scanRouteGrant(row interface{ Scan(dest ...any) error }) (*model.RouteGrant, error) {
+	var grant model.RouteGrant
+
+	err := row.Scan(
+		&grant.ID,
+		&grant.OrgID,
+		&grant.ServiceAccountID,
+		&grant.RouteID,
+		&grant.RouteGroup,
+		&grant.CreatedAt,
+		&grant.UpdatedAt,
+		&grant.InactivatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &grant, nil
+}
@@ -2,29 +2,37 @@ package repository
 
 import (
 	"api-proxy/internal/model"
+	"api-proxy/internal/secret"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 )
 
 const (
-	findActiveRoutes         = "SELECT id, pattern, backend_url, method, created_at, updated_at, inactivated_at FROM route where inactivated_at is null"
+	routeColumns             = "id, pattern, host, match_headers, match_query, backend_url, upstream_id, strip_prefix, upstream_protocol, method, connect_timeout_ms, response_header_timeout_ms, idle_conn_timeout_ms, timeout_ms, max_idle_conns, max_conns, breaker_error_rate_percent, breaker_slow_call_ms, breaker_slow_call_rate_percent, breaker_min_requests, breaker_window_ms, breaker_open_ms, breaker_half_open_requests, retry_max_attempts, retry_status_codes, retry_on, retry_backoff_ms, retry_max_backoff_ms, retry_non_idempotent, websocket_idle_timeout_ms, cache_enabled, cache_ttl_ms, cache_key, coalesce_enabled, coalesce_key, mirror_upstream_id, mirror_percent, variants, variant_sticky_by, route_group, require_grant, required_scopes, identity_mode, upstream_auth, created_at, updated_at, inactivated_at"
+	findActiveRoutes         = "SELECT " + routeColumns + " FROM route where inactivated_at is null"
 	patternWhereClause       = " AND pattern = ?"
 	methodWhereClause        = " AND method = ?"
 	updatedAfterWhereClause  = " AND updated_at > ?"
 	updatedBeforeWhereClause = " AND updated_at < ?"
-	findRouteByID            = "SELECT id, pattern, backend_url, method, created_at, updated_at, inactivated_at FROM route where id = ?"
-	insertRoute              = "INSERT INTO route (pattern, backend_url, method, updated_at, inactivated_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP(6), null)"
-	updateRoute              = "UPDATE route SET backend_url = ?, method = ?, updated_at = CURRENT_TIMESTAMP(6), inactivated_at = ? WHERE id = ?"
+	findRouteByID            = "SELECT " + routeColumns + " FROM route where id = ?"
+	insertRoute              = "INSERT INTO route (pattern, host, match_headers, match_query, backend_url, upstream_id, strip_prefix, upstream_protocol, method, connect_timeout_ms, response_header_timeout_ms, idle_conn_timeout_ms, timeout_ms, max_idle_conns, max_conns, breaker_error_rate_percent, breaker_slow_call_ms, breaker_slow_call_rate_percent, breaker_min_requests, breaker_window_ms, breaker_open_ms, breaker_half_open_requests, retry_max_attempts, retry_status_codes, retry_on, retry_backoff_ms, retry_max_backoff_ms, retry_non_idempotent, websocket_idle_timeout_ms, cache_enabled, cache_ttl_ms, cache_key, coalesce_enabled, coalesce_key, mirror_upstream_id, mirror_percent, variants, variant_sticky_by, route_group, require_grant, required_scopes, identity_mode, upstream_auth, updated_at, inactivated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP(6), null)"
+	updateRoute              = "UPDATE route SET host = ?, match_headers = ?, match_query = ?, backend_url = ?, upstream_id = ?, strip_prefix = ?, upstream_protocol = ?, method = ?, connect_timeout_ms = ?, response_header_timeout_ms = ?, idle_conn_timeout_ms = ?, timeout_ms = ?, max_idle_conns = ?, max_conns = ?, breaker_error_rate_percent = ?, breaker_slow_call_ms = ?, breaker_slow_call_rate_percent = ?, breaker_min_requests = ?, breaker_window_ms = ?, breaker_open_ms = ?, breaker_half_open_requests = ?, retry_max_attempts = ?, retry_status_codes = ?, retry_on = ?, retry_backoff_ms = ?, retry_max_backoff_ms = ?, retry_non_idempotent = ?, websocket_idle_timeout_ms = ?, cache_enabled = ?, cache_ttl_ms = ?, cache_key = ?, coalesce_enabled = ?, coalesce_key = ?, mirror_upstream_id = ?, mirror_percent = ?, variants = ?, variant_sticky_by = ?, route_group = ?, require_grant = ?, required_scopes = ?, identity_mode = ?, upstream_auth = ?, updated_at = CURRENT_TIMESTAMP(6), inactivated_at = ? WHERE id = ?"
 	deleteRoute              = "DELETE FROM route WHERE id = ?"
 )
 
-// RouteRepository represents an object through which Route queries can be run
+var ErrEncryptionKeyMissing = errors.New("an encryption key must be configured to store upstream credentials")
+
+// RouteRepository represents an object through which Route queries can be run. Upstream credentials are
+// encrypted with the cipher before they are written and decrypted as they are read.
 type RouteRepository struct {
-	db *sql.DB
+	db     *sql.DB
+	cipher *secret.Cipher
 }
 
-func NewRouteRepository(db *sql.DB) *RouteRepository {
-	return &RouteRepository{db: db}
+func NewRouteRepository(db *sql.DB, cipher *secret.Cipher) *RouteRepository {
+	return &RouteRepository{db: db, cipher: cipher}
 }
 
 // FindActiveByFilter queries routes from the DB using the specified filters
@@ -62,7 +70,95 @@ func (rr *RouteRepository) FindByID(id int) (*model.Route, error) {
 
 // Insert creates a new active route in the database and returns it
 func (rr *RouteRepository) Insert(route *model.Route) (*model.Route, error) {
-	createdId, err := execInsert(rr.db, insertRoute, route.Pattern, route.BackendURL, route.Method)
+	upstreamAuth, err := rr.encryptUpstreamAuth(route.UpstreamAuth)
+
+	if err != nil {
+		return nil, err
+	}
+
+	matchHeaders, matchQuery, err := marshalMatchConditions(route)
+
+	if err != nil {
+		return nil, err
+	}
+
+	retryStatusCodes, retryOn, err := marshalRetryConditions(route)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey, err := marshalList(route.CacheKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	coalesceKey, err := marshalList(route.CoalesceKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := marshalList(route.Variants)
+
+	if err != nil {
+		return nil, err
+	}
+
+	requiredScopes, err := marshalList(route.RequiredScopes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	createdId, err := execInsert(
+		rr.db,
+		insertRoute,
+		route.Pattern,
+		route.Host,
+		matchHeaders,
+		matchQuery,
+		route.BackendURL,
+		route.UpstreamID,
+		route.StripPrefix,
+		route.UpstreamProtocol,
+		route.Method,
+		route.ConnectTimeoutMs,
+		route.ResponseHeaderTimeoutMs,
+		route.IdleConnTimeoutMs,
+		route.TimeoutMs,
+		route.MaxIdleConns,
+		route.MaxConns,
+		route.BreakerErrorRatePercent,
+		route.BreakerSlowCallMs,
+		route.BreakerSlowCallRatePercent,
+		route.BreakerMinRequests,
+		route.BreakerWindowMs,
+		route.BreakerOpenMs,
+		route.BreakerHalfOpenRequests,
+		route.RetryMaxAttempts,
+		retryStatusCodes,
+		retryOn,
+		route.RetryBackoffMs,
+		route.RetryMaxBackoffMs,
+		route.RetryNonIdempotent,
+		route.WebSocketIdleTimeoutMs,
+		route.CacheEnabled,
+		route.CacheTTLMs,
+		cacheKey,
+		route.CoalesceEnabled,
+		coalesceKey,
+		route.MirrorUpstreamID,
+		route.MirrorPercent,
+		variants,
+		route.VariantStickyBy,
+		route.Group,
+		route.RequireGrant,
+		requiredScopes,
+		route.IdentityMode,
+		upstreamAuth,
+	)
 
 	if err != nil {
 		return nil, err
@@ -74,7 +170,98 @@ func (rr *RouteRepository) Insert(route *model.Route) (*model.Route, error) {
 
 // Update updates an existing route in the database and returns the updated data
 func (rr *RouteRepository) Update(route *model.Route) (*model.Route, error) {
-	if err := execUpdate(rr.db, updateRoute, route.BackendURL, route.Method, route.InactivatedAt, route.ID); err != nil {
+	upstreamAuth, err := rr.encryptUpstreamAuth(route.UpstreamAuth)
+
+	if err != nil {
+		return nil, err
+	}
+
+	matchHeaders, matchQuery, err := marshalMatchConditions(route)
+
+	if err != nil {
+		return nil, err
+	}
+
+	retryStatusCodes, retryOn, err := marshalRetryConditions(route)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey, err := marshalList(route.CacheKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	coalesceKey, err := marshalList(route.CoalesceKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := marshalList(route.Variants)
+
+	if err != nil {
+		return nil, err
+	}
+
+	requiredScopes, err := marshalList(route.RequiredScopes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = execUpdate(
+		rr.db,
+		updateRoute,
+		route.Host,
+		matchHeaders,
+		matchQuery,
+		route.BackendURL,
+		route.UpstreamID,
+		route.StripPrefix,
+		route.UpstreamProtocol,
+		route.Method,
+		route.ConnectTimeoutMs,
+		route.ResponseHeaderTimeoutMs,
+		route.IdleConnTimeoutMs,
+		route.TimeoutMs,
+		route.MaxIdleConns,
+		route.MaxConns,
+		route.BreakerErrorRatePercent,
+		route.BreakerSlowCallMs,
+		route.BreakerSlowCallRatePercent,
+		route.BreakerMinRequests,
+		route.BreakerWindowMs,
+		route.BreakerOpenMs,
+		route.BreakerHalfOpenRequests,
+		route.RetryMaxAttempts,
+		retryStatusCodes,
+		retryOn,
+		route.RetryBackoffMs,
+		route.RetryMaxBackoffMs,
+		route.RetryNonIdempotent,
+		route.WebSocketIdleTimeoutMs,
+		route.CacheEnabled,
+		route.CacheTTLMs,
+		cacheKey,
+		route.CoalesceEnabled,
+		coalesceKey,
+		route.MirrorUpstreamID,
+		route.MirrorPercent,
+		variants,
+		route.VariantStickyBy,
+		route.Group,
+		route.RequireGrant,
+		requiredScopes,
+		route.IdentityMode,
+		upstreamAuth,
+		route.InactivatedAt,
+		route.ID,
+	)
+
+	if err != nil {
 		return nil, err
 	}
 
@@ -98,49 +285,245 @@ func (rr *RouteRepository) findRoutes(query string, args ...any) ([]*model.Route
 	defer result.Close()
 
 	for result.Next() {
-		var route model.Route
-
-		rowErr := result.Scan(
-			&route.ID,
-			&route.Pattern,
-			&route.BackendURL,
-			&route.Method,
-			&route.CreatedAt,
-			&route.UpdatedAt,
-			&route.InactivatedAt,
-		)
+		route, rowErr := rr.scanRoute(result)
 
 		if rowErr != nil {
 			return nil, rowErr
 		}
 
-		routes = append(routes, &route)
+		routes = append(routes, route)
 	}
 
 	return routes, nil
 }
 
 func (rr *RouteRepository) findRoute(query string, args ...any) (*model.Route, error) {
+	route, err := rr.scanRoute(rr.db.QueryRow(query, args...))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return route, nil
+}
+
+// scanRoute reads a single row selected with routeColumns, in order
+func (rr *RouteRepository) scanRoute(row interface{ Scan(dest ...any) error }) (*model.Route, error) {
 	var route model.Route
-	row := rr.db.QueryRow(query, args...)
+	var upstreamAuth, matchHeaders, matchQuery, retryStatusCodes, retryOn, cacheKey, coalesceKey, variants, requiredScopes []byte
 
 	err := row.Scan(
 		&route.ID,
 		&route.Pattern,
+		&route.Host,
+		&matchHeaders,
+		&matchQuery,
 		&route.BackendURL,
+		&route.UpstreamID,
+		&route.StripPrefix,
+		&route.UpstreamProtocol,
 		&route.Method,
+		&route.ConnectTimeoutMs,
+		&route.ResponseHeaderTimeoutMs,
+		&route.IdleConnTimeoutMs,
+		&route.TimeoutMs,
+		&route.MaxIdleConns,
+		&route.MaxConns,
+		&route.BreakerErrorRatePercent,
+		&route.BreakerSlowCallMs,
+		&route.BreakerSlowCallRatePercent,
+		&route.BreakerMinRequests,
+		&route.BreakerWindowMs,
+		&route.BreakerOpenMs,
+		&route.BreakerHalfOpenRequests,
+		&route.RetryMaxAttempts,
+		&retryStatusCodes,
+		&retryOn,
+		&route.RetryBackoffMs,
+		&route.RetryMaxBackoffMs,
+		&route.RetryNonIdempotent,
+		&route.WebSocketIdleTimeoutMs,
+		&route.CacheEnabled,
+		&route.CacheTTLMs,
+		&cacheKey,
+		&route.CoalesceEnabled,
+		&coalesceKey,
+		&route.MirrorUpstreamID,
+		&route.MirrorPercent,
+		&variants,
+		&route.VariantStickyBy,
+		&route.Group,
+		&route.RequireGrant,
+		&requiredScopes,
+		&route.IdentityMode,
+		&upstreamAuth,
 		&route.CreatedAt,
 		&route.UpdatedAt,
 		&route.InactivatedAt,
 	)
 
-	if errors.Is(err, sql.ErrNoRows) {
+	if err != nil {
+		return nil, err
+	}
+
+	if route.MatchHeaders, err = unmarshalMatchCondition(matchHeaders); err != nil {
+		return nil, fmt.Errorf("error reading match_headers for route %d: %w", route.ID, err)
+	}
+
+	if route.MatchQuery, err = unmarshalMatchCondition(matchQuery); err != nil {
+		return nil, fmt.Errorf("error reading match_query for route %d: %w", route.ID, err)
+	}
+
+	if route.RetryStatusCodes, err = unmarshalList[int](retryStatusCodes); err != nil {
+		return nil, fmt.Errorf("error reading retry_status_codes for route %d: %w", route.ID, err)
+	}
+
+	if route.RetryOn, err = unmarshalList[string](retryOn); err != nil {
+		return nil, fmt.Errorf("error reading retry_on for route %d: %w", route.ID, err)
+	}
+
+	if route.CacheKey, err = unmarshalList[string](cacheKey); err != nil {
+		return nil, fmt.Errorf("error reading cache_key for route %d: %w", route.ID, err)
+	}
+
+	if route.CoalesceKey, err = unmarshalList[string](coalesceKey); err != nil {
+		return nil, fmt.Errorf("error reading coalesce_key for route %d: %w", route.ID, err)
+	}
+
+	if route.Variants, err = unmarshalList[model.RouteVariant](variants); err != nil {
+		return nil, fmt.Errorf("error reading variants for route %d: %w", route.ID, err)
+	}
+
+	if route.RequiredScopes, err = unmarshalList[string](requiredScopes); err != nil {
+		return nil, fmt.Errorf("error reading required_scopes for route %d: %w", route.ID, err)
+	}
+
+	if route.UpstreamAuth, err = rr.decryptUpstreamAuth(upstreamAuth); err != nil {
+		return nil, fmt.Errorf("error decrypting upstream auth for route %d: %w", route.ID, err)
+	}
+
+	return &route, nil
+}
+
+func (rr *RouteRepository) encryptUpstreamAuth(upstreamAuth *model.UpstreamAuth) ([]byte, error) {
+	if upstreamAuth == nil {
 		return nil, nil
 	}
 
+	if rr.cipher == nil {
+		return nil, ErrEncryptionKeyMissing
+	}
+
+	plaintext, err := json.Marshal(upstreamAuth)
+
 	if err != nil {
 		return nil, err
 	}
 
-	return &route, nil
+	return rr.cipher.Encrypt(plaintext)
+}
+
+func (rr *RouteRepository) decryptUpstreamAuth(ciphertext []byte) (*model.UpstreamAuth, error) {
+	if ciphertext == nil {
+		return nil, nil
+	}
+
+	if rr.cipher == nil {
+		return nil, ErrEncryptionKeyMissing
+	}
+
+	plaintext, err := rr.cipher.Decrypt(ciphertext)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var upstreamAuth model.UpstreamAuth
+
+	if err := json.Unmarshal(plaintext, &upstreamAuth); err != nil {
+		return nil, err
+	}
+
+	return &upstreamAuth, nil
+}
+
+func marshalMatchConditions(route *model.Route) ([]byte, []byte, error) {
+	matchHeaders, err := marshalMatchCondition(route.MatchHeaders)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	matchQuery, err := marshalMatchCondition(route.MatchQuery)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return matchHeaders, matchQuery, nil
+}
+
+func marshalMatchCondition(condition map[string]string) ([]byte, error) {
+	if len(condition) == 0 {
+		return nil, nil
+	}
+
+	return json.Marshal(condition)
+}
+
+func unmarshalMatchCondition(raw []byte) (map[string]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	var condition map[string]string
+
+	if err := json.Unmarshal(raw, &condition); err != nil {
+		return nil, err
+	}
+
+	return condition, nil
+}
+
+func marshalRetryConditions(route *model.Route) ([]byte, []byte, error) {
+	retryStatusCodes, err := marshalList(route.RetryStatusCodes)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	retryOn, err := marshalList(route.RetryOn)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return retryStatusCodes, retryOn, nil
+}
+
+// marshalList stores an empty list as null so the column reads back as nil
+func marshalList[T any](list []T) ([]byte, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	return json.Marshal(list)
+}
+
+func unmarshalList[T any](raw []byte) ([]T, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	var list []T
+
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
 }
@@ -4,16 +4,17 @@ import (
 	"api-proxy/internal/model"
 	"database/sql"
 	"errors"
+	"fmt"
 )
 
 const (
-	findActiveServiceAccounts    = "SELECT id, org_id, identifier, client_id, client_secret, created_at, updated_at, inactivated_at FROM service_account where inactivated_at is null"
+	findActiveServiceAccounts    = "SELECT id, org_id, identifier, client_id, client_secret, scopes, created_at, updated_at, inactivated_at FROM service_account where inactivated_at is null"
 	identifierWhereClause        = " AND identifier = ?"
 	clientIdWhereClause          = " AND client_id = ?"
-	findServiceAccountByID       = "SELECT id, org_id, identifier, client_id, client_secret, created_at, updated_at, inactivated_at FROM service_account where id = ?"
-	findServiceAccountByClientID = "SELECT id, org_id, identifier, client_id, client_secret, created_at, updated_at, inactivated_at FROM service_account where client_id = ?"
-	insertServiceAccount         = "INSERT INTO service_account (org_id, identifier, client_id, client_secret, updated_at, inactivated_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP(6), null)"
-	updateServiceAccount         = "UPDATE service_account SET identifier = ?, client_id = ?, client_secret = ?, updated_at = CURRENT_TIMESTAMP(6), inactivated_at = ? WHERE id = ?"
+	findServiceAccountByID       = "SELECT id, org_id, identifier, client_id, client_secret, scopes, created_at, updated_at, inactivated_at FROM service_account where id = ?"
+	findServiceAccountByClientID = "SELECT id, org_id, identifier, client_id, client_secret, scopes, created_at, updated_at, inactivated_at FROM service_account where client_id = ?"
+	insertServiceAccount         = "INSERT INTO service_account (org_id, identifier, client_id, client_secret, scopes, updated_at, inactivated_at) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP(6), null)"
+	updateServiceAccount         = "UPDATE service_account SET identifier = ?, client_id = ?, client_secret = ?, scopes = ?, updated_at = CURRENT_TIMESTAMP(6), inactivated_at = ? WHERE id = ?"
 	deleteServiceAccount         = "DELETE FROM service_account WHERE id = ?"
 )
 
@@ -56,7 +57,13 @@ func (sar *ServiceAccountRepository) FindByClientID(clientID string) (*model.Ser
 
 // Insert creates a new active service account in the database and returns it
 func (sar *ServiceAccountRepository) Insert(serviceAccount *model.ServiceAccount) (*model.ServiceAccount, error) {
-	createdId, err := execInsert(sar.db, insertServiceAccount, serviceAccount.OrgID, serviceAccount.Identifier, serviceAccount.ClientID, serviceAccount.ClientSecret)
+	scopes, err := marshalList(serviceAccount.Scopes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	createdId, err := execInsert(sar.db, insertServiceAccount, serviceAccount.OrgID, serviceAccount.Identifier, serviceAccount.ClientID, serviceAccount.ClientSecret, scopes)
 
 	if err != nil {
 		return nil, err
@@ -68,12 +75,19 @@ func (sar *ServiceAccountRepository) Insert(serviceAccount *model.ServiceAccount
 
 // Update updates an existing service account in the database and returns the updated data
 func (sar *ServiceAccountRepository) Update(serviceAccount *model.ServiceAccount) (*model.ServiceAccount, error) {
-	err := execUpdate(
+	scopes, err := marshalList(serviceAccount.Scopes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = execUpdate(
 		sar.db,
 		updateServiceAccount,
 		serviceAccount.Identifier,
 		serviceAccount.ClientID,
 		serviceAccount.ClientSecret,
+		scopes,
 		serviceAccount.InactivatedAt,
 		serviceAccount.ID,
 	)
@@ -102,32 +116,36 @@ func (sar *ServiceAccountRepository) findServiceAccounts(query string, args ...a
 	defer result.Close()
 
 	for result.Next() {
-		var serviceAccount model.ServiceAccount
-
-		rowErr := result.Scan(
-			&serviceAccount.ID,
-			&serviceAccount.OrgID,
-			&serviceAccount.Identifier,
-			&serviceAccount.ClientID,
-			&serviceAccount.ClientSecret,
-			&serviceAccount.CreatedAt,
-			&serviceAccount.UpdatedAt,
-			&serviceAccount.InactivatedAt,
-		)
+		serviceAccount, rowErr := scanServiceAccount(result)
 
 		if rowErr != nil {
 			return nil, rowErr
 		}
 
-		serviceAccounts = append(serviceAccounts, &serviceAccount)
+		serviceAccounts = append(serviceAccounts, serviceAccount)
 	}
 
 	return serviceAccounts, nil
 }
 
 func (sar *ServiceAccountRepository) findServiceAccount(query string, args ...any) (*model.ServiceAccount, error) {
+	serviceAccount, err := scanServiceAccount(sar.db.QueryRow(query, args...))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return serviceAccount, nil
+}
+
+// scanServiceAccount reads a single service account row, in the column order of the select queries
+func scanServiceAccount(row interface{ Scan(dest ...any) error }) (*model.ServiceAccount, error) {
 	var serviceAccount model.ServiceAccount
-	row := sar.db.QueryRow(query, args...)
+	var scopes []byte
 
 	err := row.Scan(
 		&serviceAccount.ID,
@@ -135,18 +153,19 @@ func (sar *ServiceAccountRepository) findServiceAccount(query string, args ...an
 		&serviceAccount.Identifier,
 		&serviceAccount.ClientID,
 		&serviceAccount.ClientSecret,
+		&scopes,
 		&serviceAccount.CreatedAt,
 		&serviceAccount.UpdatedAt,
 		&serviceAccount.InactivatedAt,
 	)
 
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, nil
-	}
-
 	if err != nil {
 		return nil, err
 	}
 
+	if serviceAccount.Scopes, err = unmarshalList[string](scopes); err != nil {
+		return nil, fmt.Errorf("error reading scopes for service account %d: %w", serviceAccount.ID, err)
+	}
+
 	return &serviceAccount, nil
 }
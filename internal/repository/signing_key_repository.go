@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"api-proxy/internal/model"
+	"api-proxy/internal/secret"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrSigningKeyEncryptionMissing = errors.New("an encryption key must be configured to store signing keys")
+
+const (
+	signingKeyColumns     = "id, kid, algorithm, private_key, activates_at, retires_at, expires_at, created_at, updated_at, inactivated_at"
+	findActiveSigningKeys = "SELECT " + signingKeyColumns + " FROM signing_key where inactivated_at is null"
+	findSigningKeyByID    = "SELECT " + signingKeyColumns + " FROM signing_key where id = ?"
+	insertSigningKey      = "INSERT INTO signing_key (kid, algorithm, private_key, activates_at, retires_at, expires_at, updated_at, inactivated_at) VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP(6), null)"
+	updateSigningKey      = "UPDATE signing_key SET activates_at = ?, retires_at = ?, expires_at = ?, updated_at = CURRENT_TIMESTAMP(6), inactivated_at = ? WHERE id = ?"
+)
+
+// SigningKeyRepository represents an object through which SigningKey queries can be run. Private keys are encrypted
+// with the cipher before they are written and decrypted as they are read.
+type SigningKeyRepository struct {
+	db     *sql.DB
+	cipher *secret.Cipher
+}
+
+func NewSigningKeyRepository(db *sql.DB, cipher *secret.Cipher) *SigningKeyRepository {
+	return &SigningKeyRepository{db: db, cipher: cipher}
+}
+
+// FindActive queries every signing key that was not inactivated, expired ones included
+func (skr *SigningKeyRepository) FindActive() ([]*model.SigningKey, error) {
+	keys := make([]*model.SigningKey, 0)
+
+	result, err := skr.db.Query(findActiveSigningKeys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer result.Close()
+
+	for result.Next() {
+		key, rowErr := skr.scanSigningKey(result)
+
+		if rowErr != nil {
+			return nil, rowErr
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// FindByID queries the database and returns a single signing key with matching ID
+func (skr *SigningKeyRepository) FindByID(id int) (*model.SigningKey, error) {
+	key, err := skr.scanSigningKey(skr.db.QueryRow(findSigningKeyByID, id))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Insert stores a new signing key and returns it
+func (skr *SigningKeyRepository) Insert(key *model.SigningKey) (*model.SigningKey, error) {
+	if skr.cipher == nil {
+		return nil, ErrSigningKeyEncryptionMissing
+	}
+
+	privateKey, err := skr.cipher.Encrypt(key.PrivateKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	createdId, err := execInsert(
+		skr.db,
+		insertSigningKey,
+		key.KID,
+		key.Algorithm,
+		privateKey,
+		key.ActivatesAt,
+		key.RetiresAt,
+		key.ExpiresAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = createdId
+	return key, nil
+}
+
+// Update reschedules an existing signing key, its kid, algorithm and key material never change
+func (skr *SigningKeyRepository) Update(key *model.SigningKey) (*model.SigningKey, error) {
+	err := execUpdate(
+		skr.db,
+		updateSigningKey,
+		key.ActivatesAt,
+		key.RetiresAt,
+		key.ExpiresAt,
+		key.InactivatedAt,
+		key.ID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// scanSigningKey reads a single row selected with signingKeyColumns, in order
+func (skr *SigningKeyRepository) scanSigningKey(row interface{ Scan(dest ...any) error }) (*model.SigningKey, error) {
+	var key model.SigningKey
+	var privateKey []byte
+
+	err := row.Scan(
+		&key.ID,
+		&key.KID,
+		&key.Algorithm,
+		&privateKey,
+		&key.ActivatesAt,
+		&key.RetiresAt,
+		&key.ExpiresAt,
+		&key.CreatedAt,
+		&key.UpdatedAt,
+		&key.InactivatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if skr.cipher == nil {
+		return nil, ErrSigningKeyEncryptionMissing
+	}
+
+	if key.PrivateKey, err = skr.cipher.Decrypt(privateKey); err != nil {
+		return nil, fmt.Errorf("error decrypting signing key %d: %w", key.ID, err)
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"api-proxy/internal/model"
+	"database/sql"
+)
+
+const (
+	tokenRevocationColumns     = "id, jti, org_id, service_account_id, revoked_at, expires_at, created_at"
+	findActiveTokenRevocations = "SELECT " + tokenRevocationColumns + " FROM token_revocation where expires_at > CURRENT_TIMESTAMP(6)"
+	insertTokenRevocation      = "INSERT INTO token_revocation (jti, org_id, service_account_id, revoked_at, expires_at) VALUES (?, ?, ?, ?, ?)"
+)
+
+// TokenRevocationRepository represents an object through which TokenRevocation queries can be run
+type TokenRevocationRepository struct {
+	db *sql.DB
+}
+
+func NewTokenRevocationRepository(db *sql.DB) *TokenRevocationRepository {
+	return &TokenRevocationRepository{db: db}
+}
+
+// FindActive queries the revocations whose tokens may not have expired yet
+func (trr *TokenRevocationRepository) FindActive() ([]*model.TokenRevocation, error) {
+	revocations := make([]*model.TokenRevocation, 0)
+
+	result, err := trr.db.Query(findActiveTokenRevocations)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer result.Close()
+
+	for result.Next() {
+		var revocation model.TokenRevocation
+
+		rowErr := result.Scan(
+			&revocation.ID,
+			&revocation.JTI,
+			&revocation.OrgID,
+			&revocation.ServiceAccountID,
+			&revocation.RevokedAt,
+			&revocation.ExpiresAt,
+			&revocation.CreatedAt,
+		)
+
+		if rowErr != nil {
+			return nil, rowErr
+		}
+
+		revocations = append(revocations, &revocation)
+	}
+
+	return revocations, nil
+}
+
+// Insert records a new revocation and returns it
+func (trr *TokenRevocationRepository) Insert(revocation *model.TokenRevocation) (*model.TokenRevocation, error) {
+	createdId, err := execInsert(
+		trr.db,
+		insertTokenRevocation,
+		revocation.JTI,
+		revocation.OrgID,
+		revocation.ServiceAccountID,
+		revocation.RevokedAt,
+		revocation.ExpiresAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	revocation.ID = createdId
+	return revocation, nil
+}
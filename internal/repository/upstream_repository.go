@@ -0,0 +1,276 @@
+package repository
+
+import (
+	"api-proxy/internal/model"
+	"database/sql"
+	"errors"
+)
+
+const (
+	upstreamColumns             = "id, name, strategy, health_check_path, health_check_interval_ms, healthy_threshold, unhealthy_threshold, max_consecutive_failures, ejection_duration_ms, created_at, updated_at, inactivated_at"
+	findActiveUpstreams         = "SELECT " + upstreamColumns + " FROM upstream where inactivated_at is null"
+	nameWhereClause             = " AND name = ?"
+	findUpstreamByID            = "SELECT " + upstreamColumns + " FROM upstream where id = ?"
+	insertUpstream              = "INSERT INTO upstream (name, strategy, health_check_path, health_check_interval_ms, healthy_threshold, unhealthy_threshold, max_consecutive_failures, ejection_duration_ms, updated_at, inactivated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP(6), null)"
+	updateUpstream              = "UPDATE upstream SET name = ?, strategy = ?, health_check_path = ?, health_check_interval_ms = ?, healthy_threshold = ?, unhealthy_threshold = ?, max_consecutive_failures = ?, ejection_duration_ms = ?, updated_at = CURRENT_TIMESTAMP(6), inactivated_at = ? WHERE id = ?"
+	deleteUpstream              = "DELETE FROM upstream WHERE id = ?"
+	findUpstreamTargets         = "SELECT id, upstream_id, url, weight, created_at FROM upstream_target where upstream_id = ? ORDER BY id"
+	insertUpstreamTarget        = "INSERT INTO upstream_target (upstream_id, url, weight) VALUES (?, ?, ?)"
+	deleteUpstreamTargetsByPool = "DELETE FROM upstream_target WHERE upstream_id = ?"
+)
+
+// UpstreamRepository represents an object through which Upstream queries can be run. Targets are always read and
+// written together with their upstream.
+type UpstreamRepository struct {
+	db *sql.DB
+}
+
+func NewUpstreamRepository(db *sql.DB) *UpstreamRepository {
+	return &UpstreamRepository{db: db}
+}
+
+// FindActiveByFilter queries upstreams and their targets from the DB using the specified filters
+func (ur *UpstreamRepository) FindActiveByFilter(filter *model.UpstreamFilter) ([]*model.Upstream, error) {
+	var args []any
+	query := findActiveUpstreams
+
+	if filter != nil && filter.Name != "" {
+		query += nameWhereClause
+		args = append(args, filter.Name)
+	}
+
+	upstreams, err := ur.findUpstreams(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, upstream := range upstreams {
+		if upstream.Targets, err = ur.findTargets(upstream.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return upstreams, nil
+}
+
+// FindByID queries the DB and returns a single upstream with matching ID along with its targets
+func (ur *UpstreamRepository) FindByID(id int) (*model.Upstream, error) {
+	upstream, err := scanUpstream(ur.db.QueryRow(findUpstreamByID, id))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if upstream.Targets, err = ur.findTargets(upstream.ID); err != nil {
+		return nil, err
+	}
+
+	return upstream, nil
+}
+
+// Insert creates a new active upstream and its targets in the database and returns it
+func (ur *UpstreamRepository) Insert(upstream *model.Upstream) (*model.Upstream, error) {
+	tx, err := ur.db.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	exec, err := tx.Exec(
+		insertUpstream,
+		upstream.Name,
+		upstream.Strategy,
+		upstream.HealthCheckPath,
+		upstream.HealthCheckIntervalMs,
+		upstream.HealthyThreshold,
+		upstream.UnhealthyThreshold,
+		upstream.MaxConsecutiveFailures,
+		upstream.EjectionDurationMs,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	createdId, err := exec.LastInsertId()
+
+	if err != nil {
+		return nil, err
+	}
+
+	upstream.ID = int(createdId)
+
+	if err := insertTargets(tx, upstream); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return upstream, nil
+}
+
+// Update updates an existing upstream in the database and replaces its targets, then returns the updated data
+func (ur *UpstreamRepository) Update(upstream *model.Upstream) (*model.Upstream, error) {
+	tx, err := ur.db.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	exec, err := tx.Exec(
+		updateUpstream,
+		upstream.Name,
+		upstream.Strategy,
+		upstream.HealthCheckPath,
+		upstream.HealthCheckIntervalMs,
+		upstream.HealthyThreshold,
+		upstream.UnhealthyThreshold,
+		upstream.MaxConsecutiveFailures,
+		upstream.EjectionDurationMs,
+		upstream.InactivatedAt,
+		upstream.ID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected, err := exec.RowsAffected(); err != nil {
+		return nil, err
+	} else if rowsAffected == 0 {
+		return nil, ErrNoRowsAffectedOnUpdate
+	}
+
+	if _, err := tx.Exec(deleteUpstreamTargetsByPool, upstream.ID); err != nil {
+		return nil, err
+	}
+
+	if err := insertTargets(tx, upstream); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return upstream, nil
+}
+
+// Delete removes any existing upstream, and with it its targets, if it's ID matches the given id
+func (ur *UpstreamRepository) Delete(id int) error {
+	return execDelete(ur.db, deleteUpstream, id)
+}
+
+func (ur *UpstreamRepository) findUpstreams(query string, args ...any) ([]*model.Upstream, error) {
+	upstreams := make([]*model.Upstream, 0)
+
+	result, err := ur.db.Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer result.Close()
+
+	for result.Next() {
+		upstream, rowErr := scanUpstream(result)
+
+		if rowErr != nil {
+			return nil, rowErr
+		}
+
+		upstreams = append(upstreams, upstream)
+	}
+
+	return upstreams, nil
+}
+
+func (ur *UpstreamRepository) findTargets(upstreamID int) ([]*model.UpstreamTarget, error) {
+	targets := make([]*model.UpstreamTarget, 0)
+
+	result, err := ur.db.Query(findUpstreamTargets, upstreamID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer result.Close()
+
+	for result.Next() {
+		var target model.UpstreamTarget
+
+		rowErr := result.Scan(
+			&target.ID,
+			&target.UpstreamID,
+			&target.URL,
+			&target.Weight,
+			&target.CreatedAt,
+		)
+
+		if rowErr != nil {
+			return nil, rowErr
+		}
+
+		targets = append(targets, &target)
+	}
+
+	return targets, nil
+}
+
+func insertTargets(tx *sql.Tx, upstream *model.Upstream) error {
+	for _, target := range upstream.Targets {
+		exec, err := tx.Exec(insertUpstreamTarget, upstream.ID, target.URL, target.Weight)
+
+		if err != nil {
+			return err
+		}
+
+		createdId, err := exec.LastInsertId()
+
+		if err != nil {
+			return err
+		}
+
+		target.ID = int(createdId)
+		target.UpstreamID = upstream.ID
+	}
+
+	return nil
+}
+
+// scanUpstream reads a single row selected with upstreamColumns, in order
+func scanUpstream(row interface{ Scan(dest ...any) error }) (*model.Upstream, error) {
+	var upstream model.Upstream
+
+	err := row.Scan(
+		&upstream.ID,
+		&upstream.Name,
+		&upstream.Strategy,
+		&upstream.HealthCheckPath,
+		&upstream.HealthCheckIntervalMs,
+		&upstream.HealthyThreshold,
+		&upstream.UnhealthyThreshold,
+		&upstream.MaxConsecutiveFailures,
+		&upstream.EjectionDurationMs,
+		&upstream.CreatedAt,
+		&upstream.UpdatedAt,
+		&upstream.InactivatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &upstream, nil
+}
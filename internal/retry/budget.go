@@ -0,0 +1,82 @@
+package retry
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	budgetBuckets = 10
+	budgetWindow  = 10 * time.Second
+)
+
+type budgetBucket struct {
+	start    time.Time
+	requests int
+	retries  int
+}
+
+// Budget caps retries across every route so a failing backend is not hit with a retry storm. Over a sliding window
+// retries may add up to a percentage of the requests seen, plus a small per second allowance so quiet routes can
+// still retry.
+type Budget struct {
+	mu           sync.Mutex
+	percent      int
+	minPerSecond int
+	buckets      [budgetBuckets]budgetBucket
+	now          func() time.Time
+}
+
+func NewBudget(percent, minPerSecond int) *Budget {
+	return &Budget{
+		percent:      percent,
+		minPerSecond: minPerSecond,
+		now:          time.Now,
+	}
+}
+
+// Request counts a request sent for the first time
+func (b *Budget) Request() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bucketAt(b.now()).requests++
+}
+
+// Withdraw asks to make a retry and counts it when the budget allows it
+func (b *Budget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	requests, retries := 0, 0
+
+	for _, bk := range b.buckets {
+		if now.Sub(bk.start) < budgetWindow {
+			requests += bk.requests
+			retries += bk.retries
+		}
+	}
+
+	allowed := b.minPerSecond*int(budgetWindow/time.Second) + requests*b.percent/100
+
+	if retries >= allowed {
+		return false
+	}
+
+	b.bucketAt(now).retries++
+
+	return true
+}
+
+func (b *Budget) bucketAt(now time.Time) *budgetBucket {
+	width := budgetWindow / budgetBuckets
+	start := now.Truncate(width)
+	current := &b.buckets[(start.UnixNano()/int64(width))%budgetBuckets]
+
+	if !current.start.Equal(start) {
+		*current = budgetBucket{start: start}
+	}
+
+	return current
+}
@@ -0,0 +1,162 @@
+package retry
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Error classes a route can retry on
+const (
+	// ClassConnect covers failures to establish a connection, including connect timeouts. The backend never saw
+	// the request.
+	ClassConnect = "connect"
+	// ClassReset covers connections closed or reset by the backend before a response arrived
+	ClassReset = "reset"
+	// ClassTimeout covers transport timeouts such as the response header timeout, not the route's overall timeout
+	ClassTimeout = "timeout"
+)
+
+const (
+	defaultBackoff           = 25 * time.Millisecond
+	defaultMaxBackoffFactor  = 10
+	maxBackoffDoublings      = 16
+	discardedBodyDrainLength = 4 << 10
+)
+
+var defaultStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+var defaultClasses = []string{ClassConnect, ClassReset}
+
+// ValidClass reports whether class is a known error class
+func ValidClass(class string) bool {
+	return class == ClassConnect || class == ClassReset || class == ClassTimeout
+}
+
+// Policy decides which failed attempts of a route are tried again and how long to wait in between
+type Policy struct {
+	Name          string
+	MaxAttempts   int
+	StatusCodes   []int
+	Classes       []string
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	NonIdempotent bool
+}
+
+// PolicyFor reads the route's retry policy. Unset status codes and error classes default to 502, 503 and 504 and to
+// connect and reset errors, the max backoff defaults to ten times the base backoff.
+func PolicyFor(route *model.Route) Policy {
+	policy := Policy{
+		Name:          "route_" + strconv.Itoa(route.ID),
+		MaxAttempts:   route.RetryMaxAttempts,
+		StatusCodes:   route.RetryStatusCodes,
+		Classes:       route.RetryOn,
+		Backoff:       time.Duration(route.RetryBackoffMs) * time.Millisecond,
+		MaxBackoff:    time.Duration(route.RetryMaxBackoffMs) * time.Millisecond,
+		NonIdempotent: route.RetryNonIdempotent,
+	}
+
+	if len(policy.StatusCodes) == 0 {
+		policy.StatusCodes = defaultStatusCodes
+	}
+
+	if len(policy.Classes) == 0 {
+		policy.Classes = defaultClasses
+	}
+
+	if policy.Backoff <= 0 {
+		policy.Backoff = defaultBackoff
+	}
+
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = policy.Backoff * defaultMaxBackoffFactor
+	}
+
+	return policy
+}
+
+// Enabled reports whether the policy allows more than one attempt
+func (p Policy) Enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+// AllowsMethod reports whether requests with the method may be retried, only idempotent methods are unless the
+// route opted in to retrying everything
+func (p Policy) AllowsMethod(method string) bool {
+	if p.NonIdempotent {
+		return true
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// RetryableStatus reports whether a response with the status code is tried again
+func (p Policy) RetryableStatus(code int) bool {
+	return slices.Contains(p.StatusCodes, code)
+}
+
+// RetryableError reports whether a transport error falls in one of the policy's error classes
+func (p Policy) RetryableError(err error) bool {
+	class := ClassifyError(err)
+
+	return class != "" && slices.Contains(p.Classes, class)
+}
+
+// BackoffFor returns how long to wait before the given retry, counting from 1. It uses full jitter: a random
+// duration up to the base backoff doubled for every earlier retry, capped at the max backoff.
+func (p Policy) BackoffFor(retry int) time.Duration {
+	ceiling := p.Backoff << min(max(retry-1, 0), maxBackoffDoublings)
+
+	if ceiling <= 0 || ceiling > p.MaxBackoff {
+		ceiling = p.MaxBackoff
+	}
+
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return rand.N(ceiling)
+}
+
+// ClassifyError returns the error class of a transport error, or an empty string when the error is not one a retry
+// could help with. Context errors never classify, they mean the caller hung up or the route's timeout passed.
+func ClassifyError(err error) string {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ""
+	}
+
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	var tlsErr *tls.RecordHeaderError
+
+	switch {
+	case errors.As(err, &dnsErr), errors.As(err, &tlsErr):
+		return ClassConnect
+	case errors.As(err, &opErr) && opErr.Op == "dial", errors.Is(err, syscall.ECONNREFUSED):
+		return ClassConnect
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ClassReset
+	}
+
+	var netErr net.Error
+
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ClassTimeout
+	}
+
+	return ""
+}
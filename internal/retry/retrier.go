@@ -0,0 +1,133 @@
+package retry
+
+import (
+	"api-proxy/internal/metrics"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Retrier sends requests again when a route's policy allows it and the shared budget has room
+type Retrier struct {
+	budget       *Budget
+	maxBodyBytes int64
+}
+
+// NewRetrier returns a retrier drawing from budget. Request bodies up to maxBodyBytes are buffered so they can be
+// replayed, requests with larger bodies are sent once.
+func NewRetrier(budget *Budget, maxBodyBytes int64) *Retrier {
+	return &Retrier{budget: budget, maxBodyBytes: maxBodyBytes}
+}
+
+// Do sends r with send and tries again while the policy considers the result retryable, attempts are left and the
+// budget allows it. The responses of discarded attempts are drained and closed, the last attempt's result is
+// returned as is. Waiting between attempts stops as soon as the request's context is done.
+func (rt *Retrier) Do(r *http.Request, policy Policy, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	rt.budget.Request()
+
+	if !policy.Enabled() || !policy.AllowsMethod(r.Method) {
+		return send(r)
+	}
+
+	body, replayable, err := rt.buffer(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !replayable {
+		return send(r)
+	}
+
+	for attempt := 1; ; attempt++ {
+		response, err := send(withBody(r, body))
+
+		retryable := (err != nil && policy.RetryableError(err)) || (err == nil && policy.RetryableStatus(response.StatusCode))
+
+		if !retryable || attempt >= policy.MaxAttempts || r.Context().Err() != nil {
+			return response, err
+		}
+
+		if !rt.budget.Withdraw() {
+			metrics.RetryBudgetExhausted.Add(policy.Name, 1)
+			return response, err
+		}
+
+		if response != nil {
+			discard(response)
+		}
+
+		metrics.Retries.Add(policy.Name, 1)
+
+		if err := wait(r.Context(), policy.BackoffFor(attempt)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// buffer reads the request body so it can be replayed. A body over the size cap is stitched back together with
+// what was already read and reported as not replayable.
+func (rt *Retrier) buffer(r *http.Request) ([]byte, bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+
+	if r.ContentLength > rt.maxBodyBytes {
+		return nil, false, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, rt.maxBodyBytes+1))
+
+	if err != nil {
+		r.Body.Close()
+		return nil, false, err
+	}
+
+	if int64(len(body)) > rt.maxBodyBytes {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+		return nil, false, nil
+	}
+
+	r.Body.Close()
+
+	return body, true, nil
+}
+
+// withBody returns a copy of r reading from the buffered body, requests without a body are sent as they are
+func withBody(r *http.Request, body []byte) *http.Request {
+	if body == nil {
+		return r
+	}
+
+	attempt := r.Clone(r.Context())
+	attempt.Body = io.NopCloser(bytes.NewReader(body))
+	attempt.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return attempt
+}
+
+// discard drains a little of the response so the connection can be reused, then closes it
+func discard(response *http.Response) {
+	_, _ = io.CopyN(io.Discard, response.Body, discardedBodyDrainLength)
+	response.Body.Close()
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,240 @@
+package retry
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout awaiting response headers" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{name: "refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, expected: ClassConnect},
+		{name: "dns", err: &net.DNSError{Err: "no such host", Name: "backend"}, expected: ClassConnect},
+		{name: "reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, expected: ClassReset},
+		{name: "closed early", err: fmt.Errorf("reading response: %w", io.EOF), expected: ClassReset},
+		{name: "header timeout", err: timeoutError{}, expected: ClassTimeout},
+		{name: "caller gone", err: fmt.Errorf("proxying: %w", context.Canceled), expected: ""},
+		{name: "route timeout", err: context.DeadlineExceeded, expected: ""},
+		{name: "other", err: errors.New("malformed response"), expected: ""},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			if class := ClassifyError(scenario.err); class != scenario.expected {
+				t.Fatalf("expected class %q, got %q", scenario.expected, class)
+			}
+		})
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	policy := PolicyFor(&model.Route{ID: 3, RetryMaxAttempts: 3, RetryBackoffMs: 10})
+
+	if !policy.RetryableStatus(http.StatusBadGateway) || policy.RetryableStatus(http.StatusInternalServerError) {
+		t.Fatalf("expected 502, 503 and 504 to be retried by default, got %v", policy.StatusCodes)
+	}
+
+	if !policy.RetryableError(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}) || policy.RetryableError(timeoutError{}) {
+		t.Fatalf("expected connect and reset errors to be retried by default, got %v", policy.Classes)
+	}
+
+	if policy.MaxBackoff != 100*time.Millisecond {
+		t.Fatalf("expected the max backoff to default to ten times the backoff, got %s", policy.MaxBackoff)
+	}
+
+	if policy.AllowsMethod(http.MethodPost) || !policy.AllowsMethod(http.MethodPut) {
+		t.Fatalf("expected only idempotent methods to be retried by default")
+	}
+}
+
+func TestPolicy_BackoffFor(t *testing.T) {
+	policy := Policy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	scenarios := []struct {
+		retry   int
+		ceiling time.Duration
+	}{
+		{retry: 1, ceiling: 10 * time.Millisecond},
+		{retry: 2, ceiling: 20 * time.Millisecond},
+		{retry: 3, ceiling: 40 * time.Millisecond},
+		{retry: 4, ceiling: 50 * time.Millisecond},
+		{retry: 100, ceiling: 50 * time.Millisecond},
+	}
+
+	for _, scenario := range scenarios {
+		for i := 0; i < 100; i++ {
+			if backoff := policy.BackoffFor(scenario.retry); backoff < 0 || backoff >= scenario.ceiling {
+				t.Fatalf("retry %d: expected a backoff below %s, got %s", scenario.retry, scenario.ceiling, backoff)
+			}
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	budget := NewBudget(20, 0)
+	budget.now = func() time.Time { return now }
+
+	if budget.Withdraw() {
+		t.Fatalf("expected no retries before any requests")
+	}
+
+	for i := 0; i < 10; i++ {
+		budget.Request()
+	}
+
+	if !budget.Withdraw() || !budget.Withdraw() || budget.Withdraw() {
+		t.Fatalf("expected 2 retries for 10 requests at 20%%")
+	}
+
+	now = now.Add(budgetWindow)
+
+	if budget.Withdraw() {
+		t.Fatalf("expected requests outside the window to be forgotten")
+	}
+
+	floor := NewBudget(0, 1)
+	floor.now = budget.now
+
+	for i := 0; i < 10; i++ {
+		if !floor.Withdraw() {
+			t.Fatalf("expected the per second allowance to cover retry %d", i+1)
+		}
+	}
+
+	if floor.Withdraw() {
+		t.Fatalf("expected the per second allowance to run out")
+	}
+}
+
+type sender struct {
+	results []int
+	bodies  []string
+	calls   int
+}
+
+// send answers with the next status, a zero status fails with a connection reset
+func (s *sender) send(r *http.Request) (*http.Response, error) {
+	status := s.results[min(s.calls, len(s.results)-1)]
+	s.calls++
+
+	if r.Body != nil {
+		body, _ := io.ReadAll(r.Body)
+		s.bodies = append(s.bodies, string(body))
+	}
+
+	if status == 0 {
+		return nil, &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+	}
+
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+}
+
+func TestRetrier_Do(t *testing.T) {
+	policy := Policy{
+		MaxAttempts: 3,
+		StatusCodes: defaultStatusCodes,
+		Classes:     defaultClasses,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+	}
+
+	scenarios := []struct {
+		name           string
+		method         string
+		body           string
+		policy         Policy
+		results        []int
+		expectedStatus int
+		expectedErr    bool
+		expectedCalls  int
+	}{
+		{name: "success", method: http.MethodGet, policy: policy, results: []int{200}, expectedStatus: 200, expectedCalls: 1},
+		{name: "reset then success", method: http.MethodGet, policy: policy, results: []int{0, 200}, expectedStatus: 200, expectedCalls: 2},
+		{name: "exhausted", method: http.MethodGet, policy: policy, results: []int{503}, expectedStatus: 503, expectedCalls: 3},
+		{name: "exhausted on errors", method: http.MethodGet, policy: policy, results: []int{0}, expectedErr: true, expectedCalls: 3},
+		{name: "not retryable", method: http.MethodGet, policy: policy, results: []int{500, 200}, expectedStatus: 500, expectedCalls: 1},
+		{name: "non idempotent", method: http.MethodPost, body: "{}", policy: policy, results: []int{503, 200}, expectedStatus: 503, expectedCalls: 1},
+		{name: "disabled", method: http.MethodGet, policy: Policy{}, results: []int{503, 200}, expectedStatus: 503, expectedCalls: 1},
+		{name: "body replayed", method: http.MethodPut, body: "payload", policy: policy, results: []int{502, 200}, expectedStatus: 200, expectedCalls: 2},
+		{name: "body over cap", method: http.MethodPut, body: strings.Repeat("x", 64), policy: policy, results: []int{502, 200}, expectedStatus: 502, expectedCalls: 1},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			retrier := NewRetrier(NewBudget(0, 100), 32)
+			s := &sender{results: scenario.results}
+
+			var body io.Reader
+
+			if scenario.body != "" {
+				body = strings.NewReader(scenario.body)
+			}
+
+			r, _ := http.NewRequest(scenario.method, "http://backend/", body)
+			response, err := retrier.Do(r, scenario.policy, s.send)
+
+			if (err != nil) != scenario.expectedErr {
+				t.Fatalf("expected error %v, got %v", scenario.expectedErr, err)
+			}
+
+			if err == nil && response.StatusCode != scenario.expectedStatus {
+				t.Fatalf("expected status %d, got %d", scenario.expectedStatus, response.StatusCode)
+			}
+
+			if s.calls != scenario.expectedCalls {
+				t.Fatalf("expected %d attempts, got %d", scenario.expectedCalls, s.calls)
+			}
+
+			for i, sent := range s.bodies {
+				if sent != scenario.body {
+					t.Fatalf("attempt %d: expected body %q, got %q", i+1, scenario.body, sent)
+				}
+			}
+		})
+	}
+}
+
+func TestRetrier_DoBudgetExhausted(t *testing.T) {
+	retrier := NewRetrier(NewBudget(0, 0), 32)
+	s := &sender{results: []int{503, 200}}
+	r, _ := http.NewRequest(http.MethodGet, "http://backend/", nil)
+
+	response, err := retrier.Do(r, Policy{MaxAttempts: 3, StatusCodes: defaultStatusCodes}, s.send)
+
+	if err != nil || response.StatusCode != http.StatusServiceUnavailable || s.calls != 1 {
+		t.Fatalf("expected no retries once the budget is spent, got %d attempts", s.calls)
+	}
+}
+
+func TestRetrier_DoStopsWaitingWhenCallerLeaves(t *testing.T) {
+	retrier := NewRetrier(NewBudget(0, 100), 32)
+	s := &sender{results: []int{503}}
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://backend/", nil)
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err := retrier.Do(r, Policy{MaxAttempts: 3, StatusCodes: defaultStatusCodes, Backoff: time.Hour, MaxBackoff: time.Hour}, s.send)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the wait to stop with the caller's context, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package revocation
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// MemoryList keeps the revocations in memory, instances learn about each other's revocations on the next sync
+type MemoryList struct {
+	rw              sync.RWMutex
+	tokens          map[string]struct{}
+	orgs            map[int]time.Time
+	serviceAccounts map[int]time.Time
+}
+
+func NewMemoryList() *MemoryList {
+	return &MemoryList{
+		rw:              sync.RWMutex{},
+		tokens:          make(map[string]struct{}),
+		orgs:            make(map[int]time.Time),
+		serviceAccounts: make(map[int]time.Time),
+	}
+}
+
+func (ml *MemoryList) Add(_ context.Context, revocation *model.TokenRevocation) error {
+	ml.rw.Lock()
+	defer ml.rw.Unlock()
+
+	ml.add(revocation)
+
+	return nil
+}
+
+func (ml *MemoryList) Revoked(_ context.Context, token Token) bool {
+	ml.rw.RLock()
+	defer ml.rw.RUnlock()
+
+	if _, ok := ml.tokens[token.ID]; ok && token.ID != "" {
+		return true
+	}
+
+	if revokedAt, ok := ml.orgs[token.OrgID]; ok && token.OrgID != 0 && covers(revokedAt, token.IssuedAt) {
+		return true
+	}
+
+	revokedAt, ok := ml.serviceAccounts[token.ServiceAccountID]
+
+	return ok && token.ServiceAccountID != 0 && covers(revokedAt, token.IssuedAt)
+}
+
+// Set replaces the revocations
+func (ml *MemoryList) Set(revocations []*model.TokenRevocation) {
+	ml.rw.Lock()
+	defer ml.rw.Unlock()
+
+	ml.tokens = make(map[string]struct{}, len(revocations))
+	ml.orgs = make(map[int]time.Time)
+	ml.serviceAccounts = make(map[int]time.Time)
+
+	for _, revocation := range revocations {
+		ml.add(revocation)
+	}
+}
+
+// add keeps the latest revocation of every org and service account, the caller holds the write lock
+func (ml *MemoryList) add(revocation *model.TokenRevocation) {
+	if revocation.JTI != nil {
+		ml.tokens[*revocation.JTI] = struct{}{}
+	}
+
+	if revocation.OrgID != nil && revocation.RevokedAt.After(ml.orgs[*revocation.OrgID]) {
+		ml.orgs[*revocation.OrgID] = revocation.RevokedAt
+	}
+
+	if revocation.ServiceAccountID != nil && revocation.RevokedAt.After(ml.serviceAccounts[*revocation.ServiceAccountID]) {
+		ml.serviceAccounts[*revocation.ServiceAccountID] = revocation.RevokedAt
+	}
+}
+
+func (ml *MemoryList) StartSync(ctx context.Context, interval time.Duration, findRevocations func() ([]*model.TokenRevocation, error)) {
+	ml.syncCache(findRevocations)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ml.syncCache(findRevocations)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (ml *MemoryList) syncCache(findRevocations func() ([]*model.TokenRevocation, error)) {
+	slog.Info("started token revocation sync...")
+
+	revocations, err := findRevocations()
+
+	if err != nil {
+		slog.Error("error syncing token revocations from db", "err", err)
+		return
+	}
+
+	ml.Set(revocations)
+
+	slog.Info("finished token revocation sync...")
+}
@@ -0,0 +1,108 @@
+package revocation
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestMemoryList_Revoked(t *testing.T) {
+	revokedAt := time.Now().Add(-time.Minute)
+	expiresAt := revokedAt.Add(time.Hour)
+
+	list := NewMemoryList()
+	list.Set([]*model.TokenRevocation{
+		{ID: 1, JTI: new("revoked-jti"), RevokedAt: revokedAt, ExpiresAt: expiresAt},
+		{ID: 2, OrgID: new(1), RevokedAt: revokedAt, ExpiresAt: expiresAt},
+		{ID: 3, ServiceAccountID: new(20), RevokedAt: revokedAt, ExpiresAt: expiresAt},
+		{ID: 4, ServiceAccountID: new(30), RevokedAt: revokedAt.Add(-time.Hour), ExpiresAt: expiresAt.Add(-time.Hour)},
+		{ID: 5, ServiceAccountID: new(30), RevokedAt: revokedAt, ExpiresAt: expiresAt},
+	})
+
+	scenarios := []struct {
+		name     string
+		token    Token
+		expected bool
+	}{
+		{name: "revoked jti", token: Token{ID: "revoked-jti", OrgID: 2, ServiceAccountID: 21, IssuedAt: time.Now()}, expected: true},
+		{name: "other jti", token: Token{ID: "other-jti", OrgID: 2, ServiceAccountID: 21, IssuedAt: revokedAt.Add(-time.Minute)}, expected: false},
+		{name: "issued before org revocation", token: Token{ID: "a", OrgID: 1, ServiceAccountID: 10, IssuedAt: revokedAt.Add(-time.Minute)}, expected: true},
+		{name: "issued within the second of org revocation", token: Token{ID: "a", OrgID: 1, ServiceAccountID: 10, IssuedAt: revokedAt.Truncate(time.Second)}, expected: true},
+		{name: "issued after org revocation", token: Token{ID: "a", OrgID: 1, ServiceAccountID: 10, IssuedAt: revokedAt.Add(time.Second)}, expected: false},
+		{name: "issued before service account revocation", token: Token{ID: "a", OrgID: 2, ServiceAccountID: 20, IssuedAt: revokedAt.Add(-time.Minute)}, expected: true},
+		{name: "without iat", token: Token{ID: "a", OrgID: 2, ServiceAccountID: 20}, expected: true},
+		{name: "latest revocation wins", token: Token{ID: "a", OrgID: 2, ServiceAccountID: 30, IssuedAt: revokedAt.Add(-time.Minute)}, expected: true},
+		{name: "org only token", token: Token{ID: "a", OrgID: 2, IssuedAt: revokedAt.Add(-time.Minute)}, expected: false},
+		{name: "token without jti", token: Token{OrgID: 2, ServiceAccountID: 21}, expected: false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if got := list.Revoked(context.Background(), s.token); got != s.expected {
+				t.Errorf("expected %v, got %v", s.expected, got)
+			}
+		})
+	}
+}
+
+func TestMemoryList_Add(t *testing.T) {
+	list := NewMemoryList()
+	token := Token{ID: "a", OrgID: 1, ServiceAccountID: 10, IssuedAt: time.Now().Add(-time.Minute)}
+
+	if list.Revoked(context.Background(), token) {
+		t.Fatal("expected the token not to be revoked yet")
+	}
+
+	if err := list.Add(context.Background(), &model.TokenRevocation{ServiceAccountID: new(10), RevokedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !list.Revoked(context.Background(), token) {
+		t.Error("expected the token to be revoked with its service account")
+	}
+
+	list.Set(nil)
+
+	if list.Revoked(context.Background(), token) {
+		t.Error("expected the sync to replace the revocations")
+	}
+}
+
+func TestTokenFromClaims(t *testing.T) {
+	issuedAt := time.Now().Truncate(time.Second)
+
+	scenarios := []struct {
+		name     string
+		claims   jwt.MapClaims
+		expected Token
+	}{
+		{
+			name:     "service account token",
+			claims:   jwt.MapClaims{"jti": "a", "sub": float64(10), "org_id": float64(1), "type": "external", "iat": float64(issuedAt.Unix())},
+			expected: Token{ID: "a", OrgID: 1, ServiceAccountID: 10, IssuedAt: issuedAt},
+		},
+		{
+			name:     "internal token is no service account",
+			claims:   jwt.MapClaims{"jti": "b", "sub": float64(10), "type": "internal", "iat": float64(issuedAt.Unix())},
+			expected: Token{ID: "b", IssuedAt: issuedAt},
+		},
+		{
+			name:     "token issued before jti and iat",
+			claims:   jwt.MapClaims{"sub": float64(10), "org_id": float64(1), "type": "external"},
+			expected: Token{OrgID: 1, ServiceAccountID: 10},
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			got := TokenFromClaims(s.claims)
+
+			if got.ID != s.expected.ID || got.OrgID != s.expected.OrgID || got.ServiceAccountID != s.expected.ServiceAccountID || !got.IssuedAt.Equal(s.expected.IssuedAt) {
+				t.Errorf("expected %+v, got %+v", s.expected, got)
+			}
+		})
+	}
+}
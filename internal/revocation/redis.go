@@ -0,0 +1,152 @@
+package revocation
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// keepLatestRedisScript never moves a revocation back in time, a sync replaying an older row must not shorten
+	// the revocation of an org or service account that was revoked again since
+	keepLatestRedisScript = `local current = redis.call('GET', KEYS[1])
+if not current or tonumber(current) < tonumber(ARGV[1]) then
+    redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+end
+return 1`
+)
+
+var keepLatestScript = redis.NewScript(keepLatestRedisScript)
+
+// RedisList shares the revocations between instances through redis, every key expires with the tokens it covers.
+// The revocations are also kept in memory, as of the last sync, to answer with while redis cannot be reached.
+type RedisList struct {
+	client   *redis.Client
+	fallback *MemoryList
+}
+
+func NewRedisList(client *redis.Client) *RedisList {
+	return &RedisList{client: client, fallback: NewMemoryList()}
+}
+
+func (rl *RedisList) Add(ctx context.Context, revocation *model.TokenRevocation) error {
+	ttl := time.Until(revocation.ExpiresAt)
+
+	if ttl <= 0 {
+		return nil
+	}
+
+	_ = rl.fallback.Add(ctx, revocation)
+
+	revokedAt := revocation.RevokedAt.Unix()
+
+	if revocation.JTI != nil {
+		if err := rl.set(ctx, tokenKey(*revocation.JTI), revokedAt, ttl); err != nil {
+			return err
+		}
+	}
+
+	if revocation.OrgID != nil {
+		if err := rl.set(ctx, subjectKey("org", *revocation.OrgID), revokedAt, ttl); err != nil {
+			return err
+		}
+	}
+
+	if revocation.ServiceAccountID != nil {
+		if err := rl.set(ctx, subjectKey("sa", *revocation.ServiceAccountID), revokedAt, ttl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (rl *RedisList) set(ctx context.Context, key string, revokedAt int64, ttl time.Duration) error {
+	return keepLatestScript.Run(ctx, rl.client, []string{key}, revokedAt, ttl.Milliseconds()).Err()
+}
+
+// Revoked falls back to the revocations kept in memory when redis cannot be reached, a revoked token must not be let
+// through because of an outage
+func (rl *RedisList) Revoked(ctx context.Context, token Token) bool {
+	keys := []string{tokenKey(token.ID), subjectKey("org", token.OrgID), subjectKey("sa", token.ServiceAccountID)}
+	values, err := rl.client.MGet(ctx, keys...).Result()
+
+	if err != nil {
+		slog.Error("redis invoke fail, checking the revocations kept in memory", "err", err)
+		return rl.fallback.Revoked(ctx, token)
+	}
+
+	if token.ID != "" && values[0] != nil {
+		return true
+	}
+
+	for i, id := range []int{token.OrgID, token.ServiceAccountID} {
+		value, ok := values[i+1].(string)
+
+		if !ok || id == 0 {
+			continue
+		}
+
+		revokedAt, err := strconv.ParseInt(value, 10, 64)
+
+		if err == nil && covers(time.Unix(revokedAt, 0), token.IssuedAt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// StartSync replays the stored revocations into redis, so they survive redis losing its data, and into memory
+func (rl *RedisList) StartSync(ctx context.Context, interval time.Duration, findRevocations func() ([]*model.TokenRevocation, error)) {
+	rl.syncCache(ctx, findRevocations)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				rl.syncCache(ctx, findRevocations)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (rl *RedisList) syncCache(ctx context.Context, findRevocations func() ([]*model.TokenRevocation, error)) {
+	slog.Info("started token revocation sync...")
+
+	revocations, err := findRevocations()
+
+	if err != nil {
+		slog.Error("error syncing token revocations from db", "err", err)
+		return
+	}
+
+	rl.fallback.Set(revocations)
+
+	for _, revocation := range revocations {
+		if err := rl.Add(ctx, revocation); err != nil {
+			slog.Error("error syncing token revocation to redis", "id", revocation.ID, "err", err)
+			return
+		}
+	}
+
+	slog.Info("finished token revocation sync...")
+}
+
+func tokenKey(jti string) string {
+	return "revoked:jti:" + jti
+}
+
+func subjectKey(t string, id int) string {
+	return fmt.Sprintf("revoked:%s:%d", t, id)
+}
@@ -0,0 +1,37 @@
+package revocation
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisList_RevokedWithoutRedis(t *testing.T) {
+	revokedAt := time.Now().Add(-time.Minute)
+	expiresAt := revokedAt.Add(time.Hour)
+
+	list := NewRedisList(redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond}))
+	list.syncCache(context.Background(), func() ([]*model.TokenRevocation, error) {
+		return []*model.TokenRevocation{{ID: 1, OrgID: new(1), RevokedAt: revokedAt, ExpiresAt: expiresAt}}, nil
+	})
+
+	scenarios := []struct {
+		name     string
+		token    Token
+		expected bool
+	}{
+		{name: "token of a revoked org", token: Token{ID: "a", OrgID: 1, ServiceAccountID: 10, IssuedAt: revokedAt.Add(-time.Minute)}, expected: true},
+		{name: "token of another org", token: Token{ID: "b", OrgID: 2, ServiceAccountID: 20, IssuedAt: revokedAt.Add(-time.Minute)}, expected: false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if got := list.Revoked(context.Background(), s.token); got != s.expected {
+				t.Errorf("expected %v, got %v", s.expected, got)
+			}
+		})
+	}
+}
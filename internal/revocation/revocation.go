@@ -0,0 +1,79 @@
+package revocation
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token is what a revocation check needs to know about a verified token
+type Token struct {
+	ID               string
+	OrgID            int
+	ServiceAccountID int
+	IssuedAt         time.Time
+}
+
+// TokenFromClaims reads the token's jti, org, service account and issue time. Only external tokens belong to an org
+// or service account, the sub of internal tokens is a user. Tokens issued without iat count as issued at the zero
+// time so any revocation of their org or service account covers them.
+func TokenFromClaims(claims jwt.MapClaims) Token {
+	id, _ := claims["jti"].(string)
+	token := Token{ID: id}
+
+	if claims["type"] == "external" {
+		orgID, _ := claims["org_id"].(float64)
+		subject, _ := claims["sub"].(float64)
+		token.OrgID, token.ServiceAccountID = int(orgID), int(subject)
+	}
+
+	if issuedAt, err := claims.GetIssuedAt(); err == nil && issuedAt != nil {
+		token.IssuedAt = issuedAt.Time
+	}
+
+	return token
+}
+
+// List holds the revocations the proxy checks every token against
+type List interface {
+	Add(ctx context.Context, revocation *model.TokenRevocation) error
+	Revoked(ctx context.Context, token Token) bool
+	StartSync(ctx context.Context, interval time.Duration, findRevocations func() ([]*model.TokenRevocation, error))
+}
+
+// Recorder stores revocations so every proxy instance, and the list after a restart, learns about them
+type Recorder interface {
+	Insert(revocation *model.TokenRevocation) (*model.TokenRevocation, error)
+}
+
+// Revoker records revocations and applies them to the list right away
+type Revoker struct {
+	recorder Recorder
+	list     List
+}
+
+func NewRevoker(recorder Recorder, list List) *Revoker {
+	return &Revoker{recorder: recorder, list: list}
+}
+
+// Revoke stores the revocation first so it is never only in effect on this instance
+func (r *Revoker) Revoke(ctx context.Context, revocation *model.TokenRevocation) error {
+	if _, err := r.recorder.Insert(revocation); err != nil {
+		return err
+	}
+
+	return r.list.Add(ctx, revocation)
+}
+
+// Revoked reports whether the token was revoked by its jti, or through its org or service account
+func (r *Revoker) Revoked(ctx context.Context, token Token) bool {
+	return r.list.Revoked(ctx, token)
+}
+
+// covers reports whether a subject revoked at revokedAt covers a token issued at issuedAt. Token issue times have
+// second precision, a token issued within the second of the revocation is revoked with it.
+func covers(revokedAt, issuedAt time.Time) bool {
+	return !issuedAt.After(revokedAt.Truncate(time.Second))
+}
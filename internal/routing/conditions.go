@@ -0,0 +1,159 @@
+package routing
+
+import (
+	"api-proxy/internal/model"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// request holds what the conditions of a route are checked against. The query is only parsed once a candidate route
+// actually has query conditions.
+type request struct {
+	method string
+	host   string
+	header http.Header
+	url    *url.URL
+	query  url.Values
+}
+
+func newRequest(r *http.Request) *request {
+	return &request{
+		method: r.Method,
+		host:   NormalizeHost(r.Host),
+		header: r.Header,
+		url:    r.URL,
+	}
+}
+
+func (req *request) queryValues() url.Values {
+	if req.query == nil {
+		req.query = req.url.Query()
+	}
+
+	return req.query
+}
+
+// NormalizeHost lowercases a host and drops any port and trailing dot so it can be compared with a route's host
+func NormalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// ValidateHost reports whether host is empty, a hostname, or a hostname whose first label is the * wildcard
+func ValidateHost(host string) error {
+	if host == "" {
+		return nil
+	}
+
+	name := strings.TrimPrefix(host, "*.")
+
+	if name == "" || strings.ContainsAny(name, "*/:?# ") || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") {
+		return fmt.Errorf("%w: host %q must be a hostname, optionally starting with *.", ErrInvalidPattern, host)
+	}
+
+	return nil
+}
+
+// conditions are the optional, non path parts of a route that a request must also satisfy
+type conditions struct {
+	host    string
+	headers map[string]string
+	query   map[string]string
+}
+
+func newConditions(route *model.Route) conditions {
+	headers := make(map[string]string, len(route.MatchHeaders))
+
+	for name, value := range route.MatchHeaders {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+
+	return conditions{
+		host:    NormalizeHost(route.Host),
+		headers: headers,
+		query:   route.MatchQuery,
+	}
+}
+
+// matches checks the host, then every header and query condition. An empty condition value only requires presence.
+func (c conditions) matches(req *request) bool {
+	if !c.matchesHost(req.host) {
+		return false
+	}
+
+	for name, expected := range c.headers {
+		values, ok := req.header[name]
+
+		if !ok || (expected != "" && !slices.Contains(values, expected)) {
+			return false
+		}
+	}
+
+	if len(c.query) == 0 {
+		return true
+	}
+
+	query := req.queryValues()
+
+	for name, expected := range c.query {
+		values, ok := query[name]
+
+		if !ok || (expected != "" && !slices.Contains(values, expected)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c conditions) matchesHost(host string) bool {
+	switch {
+	case c.host == "":
+		return true
+	case strings.HasPrefix(c.host, "*."):
+		return strings.HasSuffix(host, c.host[1:])
+	default:
+		return host == c.host
+	}
+}
+
+// moreSpecific orders candidates sharing a path: exact hosts before wildcards (longest first) before any host, then
+// routes with more header and query conditions, then the oldest route
+func (c conditions) moreSpecific(other conditions, id, otherID int) bool {
+	if rank, otherRank := c.hostRank(), other.hostRank(); rank != otherRank {
+		return rank > otherRank
+	}
+
+	if len(c.host) != len(other.host) {
+		return len(c.host) > len(other.host)
+	}
+
+	if count, otherCount := len(c.headers)+len(c.query), len(other.headers)+len(other.query); count != otherCount {
+		return count > otherCount
+	}
+
+	return id < otherID
+}
+
+func (c conditions) hostRank() int {
+	switch {
+	case c.host == "":
+		return 0
+	case strings.HasPrefix(c.host, "*."):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (c conditions) equal(other conditions) bool {
+	return c.host == other.host && maps.Equal(c.headers, other.headers) && maps.Equal(c.query, other.query)
+}
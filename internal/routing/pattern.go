@@ -0,0 +1,119 @@
+package routing
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	segmentStatic segmentKind = iota
+	segmentRegex
+	segmentParam
+	segmentCatchAll
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+	name  string
+	re    *regexp.Regexp
+}
+
+// ParamNames returns the names of the path parameters a pattern captures, including * for a trailing catch-all
+func ParamNames(pattern string) ([]string, error) {
+	segments, err := parsePattern(pattern)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	for _, s := range segments {
+		switch s.kind {
+		case segmentRegex, segmentParam:
+			names = append(names, s.name)
+		case segmentCatchAll:
+			names = append(names, CatchAllParam)
+		}
+	}
+
+	return names, nil
+}
+
+// ValidatePattern reports whether a pattern can be compiled into the tree
+func ValidatePattern(pattern string) error {
+	_, err := parsePattern(pattern)
+
+	return err
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("%w: %s must start with /", ErrInvalidPattern, pattern)
+	}
+
+	parts := strings.Split(pattern, "/")
+	segments := make([]segment, 0, len(parts))
+	seen := make(map[string]bool)
+
+	for i, part := range parts {
+		s, err := parseSegment(part)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidPattern, pattern, err)
+		}
+
+		if s.kind == segmentCatchAll && i != len(parts)-1 {
+			return nil, fmt.Errorf("%w: /* must be the last segment of %s", ErrInvalidPattern, pattern)
+		}
+
+		if s.name != "" {
+			if seen[s.name] {
+				return nil, fmt.Errorf("%w: %s captures {%s} more than once", ErrInvalidPattern, pattern, s.name)
+			}
+
+			seen[s.name] = true
+		}
+
+		segments = append(segments, s)
+	}
+
+	return segments, nil
+}
+
+func parseSegment(part string) (segment, error) {
+	if part == "*" {
+		return segment{kind: segmentCatchAll}, nil
+	}
+
+	if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+		if strings.ContainsAny(part, "{}") {
+			return segment{}, fmt.Errorf("segment %q must be either static or a whole {param}", part)
+		}
+
+		return segment{kind: segmentStatic, value: part}, nil
+	}
+
+	inner := part[1 : len(part)-1]
+	name, expr, constrained := strings.Cut(inner, ":")
+
+	if name == "" || name == CatchAllParam {
+		return segment{}, fmt.Errorf("segment %q needs a parameter name", part)
+	}
+
+	if !constrained {
+		return segment{kind: segmentParam, name: name}, nil
+	}
+
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+
+	if err != nil {
+		return segment{}, err
+	}
+
+	return segment{kind: segmentRegex, value: expr, name: name, re: re}, nil
+}
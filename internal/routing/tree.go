@@ -0,0 +1,232 @@
+package routing
+
+import (
+	"api-proxy/internal/model"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// CatchAllParam is the name the remainder of the path is captured under by a trailing /* segment
+const CatchAllParam = "*"
+
+var ErrDuplicateRoute = errors.New("another route already matches the same pattern and method")
+var ErrInvalidPattern = errors.New("invalid route pattern")
+
+type leaf struct {
+	route      *model.Route
+	names      []string
+	conditions conditions
+}
+
+type regexChild struct {
+	expr string
+	re   *regexp.Regexp
+	node *node
+}
+
+type node struct {
+	static   map[string]*node
+	regexes  []*regexChild
+	param    *node
+	catchAll map[string][]*leaf
+	leaves   map[string][]*leaf
+}
+
+func newNode() *node {
+	return &node{
+		static:   make(map[string]*node),
+		catchAll: make(map[string][]*leaf),
+		leaves:   make(map[string][]*leaf),
+	}
+}
+
+// Tree matches request paths against route patterns one segment at a time. When several patterns overlap the most
+// specific one wins regardless of insertion order: static segments beat regex constrained params, which beat plain
+// params, which beat a trailing /* catch-all. A branch that fails deeper down falls back to the next most specific one.
+// Routes sharing a pattern and method are told apart by their host, header and query conditions.
+type Tree struct {
+	root *node
+	size int
+}
+
+func NewTree() *Tree {
+	return &Tree{root: newNode()}
+}
+
+// Build compiles routes into a tree, routes that cannot be inserted are returned alongside their error and left out
+func Build(routes []*model.Route) (*Tree, map[*model.Route]error) {
+	tree := NewTree()
+	var failed map[*model.Route]error
+
+	for _, route := range routes {
+		if err := tree.Insert(route); err != nil {
+			if failed == nil {
+				failed = make(map[*model.Route]error)
+			}
+
+			failed[route] = err
+		}
+	}
+
+	return tree, failed
+}
+
+// Len returns the number of routes in the tree
+func (t *Tree) Len() int {
+	return t.size
+}
+
+// Insert adds a route to the tree
+func (t *Tree) Insert(route *model.Route) error {
+	segments, err := parsePattern(route.Pattern)
+
+	if err != nil {
+		return err
+	}
+
+	if err := ValidateHost(route.Host); err != nil {
+		return err
+	}
+
+	current := t.root
+	var names []string
+
+	for _, s := range segments {
+		switch s.kind {
+		case segmentStatic:
+			child, ok := current.static[s.value]
+
+			if !ok {
+				child = newNode()
+				current.static[s.value] = child
+			}
+
+			current = child
+		case segmentRegex:
+			current = current.regexChild(s)
+			names = append(names, s.name)
+		case segmentParam:
+			if current.param == nil {
+				current.param = newNode()
+			}
+
+			current = current.param
+			names = append(names, s.name)
+		case segmentCatchAll:
+			return t.insertLeaf(current.catchAll, route, append(names, CatchAllParam))
+		}
+	}
+
+	return t.insertLeaf(current.leaves, route, names)
+}
+
+func (t *Tree) insertLeaf(leaves map[string][]*leaf, route *model.Route, names []string) error {
+	added := &leaf{route: route, names: names, conditions: newConditions(route)}
+	candidates := leaves[route.Method]
+
+	for _, candidate := range candidates {
+		if candidate.conditions.equal(added.conditions) {
+			return ErrDuplicateRoute
+		}
+	}
+
+	i := sort.Search(len(candidates), func(i int) bool {
+		return added.conditions.moreSpecific(candidates[i].conditions, route.ID, candidates[i].route.ID)
+	})
+
+	leaves[route.Method] = slices.Insert(candidates, i, added)
+	t.size++
+
+	return nil
+}
+
+// Match returns the most specific route for the request along with its captured path parameters
+func (t *Tree) Match(r *http.Request) (*model.Route, map[string]string, bool) {
+	if t == nil {
+		return nil, nil, false
+	}
+
+	segments := strings.Split(r.URL.Path, "/")
+	values := make([]string, 0, len(segments))
+
+	found, values := t.root.match(newRequest(r), segments, values)
+
+	if found == nil {
+		return nil, nil, false
+	}
+
+	params := make(map[string]string, len(found.names))
+
+	for i, name := range found.names {
+		params[name] = values[i]
+	}
+
+	return found.route, params, true
+}
+
+func (n *node) match(req *request, segments []string, values []string) (*leaf, []string) {
+	if len(segments) == 0 {
+		return firstMatching(n.leaves[req.method], req), values
+	}
+
+	segment, rest := segments[0], segments[1:]
+
+	if child, ok := n.static[segment]; ok {
+		if found, captured := child.match(req, rest, values); found != nil {
+			return found, captured
+		}
+	}
+
+	for _, rc := range n.regexes {
+		if !rc.re.MatchString(segment) {
+			continue
+		}
+
+		if found, captured := rc.node.match(req, rest, append(values, segment)); found != nil {
+			return found, captured
+		}
+	}
+
+	if n.param != nil {
+		if found, captured := n.param.match(req, rest, append(values, segment)); found != nil {
+			return found, captured
+		}
+	}
+
+	if found := firstMatching(n.catchAll[req.method], req); found != nil {
+		return found, append(values, strings.Join(segments, "/"))
+	}
+
+	return nil, values
+}
+
+// firstMatching returns the first candidate whose conditions hold, candidates are kept most specific first
+func firstMatching(candidates []*leaf, req *request) *leaf {
+	for _, candidate := range candidates {
+		if candidate.conditions.matches(req) {
+			return candidate
+		}
+	}
+
+	return nil
+}
+
+func (n *node) regexChild(s segment) *node {
+	for _, rc := range n.regexes {
+		if rc.expr == s.value {
+			return rc.node
+		}
+	}
+
+	rc := &regexChild{expr: s.value, re: s.re, node: newNode()}
+	n.regexes = append(n.regexes, rc)
+
+	// Keep the order independent of insertion order so overlapping constraints always resolve the same way
+	sort.Slice(n.regexes, func(i, j int) bool { return n.regexes[i].expr < n.regexes[j].expr })
+
+	return rc.node
+}
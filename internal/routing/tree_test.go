@@ -0,0 +1,346 @@
+package routing
+
+import (
+	"api-proxy/internal/model"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestTree_Match(t *testing.T) {
+	routes := []*model.Route{
+		{ID: 1, Pattern: "/api/v1/users/{id}", Method: http.MethodGet},
+		{ID: 2, Pattern: "/api/v1/users/me", Method: http.MethodGet},
+		{ID: 3, Pattern: "/api/v1/orders/{id:[0-9]+}", Method: http.MethodGet},
+		{ID: 4, Pattern: "/api/v1/orders/{slug}", Method: http.MethodGet},
+		{ID: 5, Pattern: "/api/v1/*", Method: http.MethodGet},
+		{ID: 6, Pattern: "/api/v1/users/{userId}/posts", Method: http.MethodGet},
+		{ID: 7, Pattern: "/api/v1/users/me/settings", Method: http.MethodPut},
+		{ID: 8, Pattern: "/api/v1/users/{id}", Method: http.MethodDelete},
+	}
+
+	scenarios := []struct {
+		name           string
+		method         string
+		path           string
+		expectedID     int
+		expectedParams map[string]string
+	}{
+		{
+			name:           "static beats param",
+			method:         http.MethodGet,
+			path:           "/api/v1/users/me",
+			expectedID:     2,
+			expectedParams: map[string]string{},
+		},
+		{
+			name:           "param",
+			method:         http.MethodGet,
+			path:           "/api/v1/users/42",
+			expectedID:     1,
+			expectedParams: map[string]string{"id": "42"},
+		},
+		{
+			name:           "regex beats plain param",
+			method:         http.MethodGet,
+			path:           "/api/v1/orders/42",
+			expectedID:     3,
+			expectedParams: map[string]string{"id": "42"},
+		},
+		{
+			name:           "plain param when regex does not match",
+			method:         http.MethodGet,
+			path:           "/api/v1/orders/latest",
+			expectedID:     4,
+			expectedParams: map[string]string{"slug": "latest"},
+		},
+		{
+			name:           "falls back from static to param deeper down",
+			method:         http.MethodGet,
+			path:           "/api/v1/users/me/posts",
+			expectedID:     6,
+			expectedParams: map[string]string{"userId": "me"},
+		},
+		{
+			name:           "catch-all",
+			method:         http.MethodGet,
+			path:           "/api/v1/reports/2024/q1",
+			expectedID:     5,
+			expectedParams: map[string]string{"*": "reports/2024/q1"},
+		},
+		{
+			name:           "catch-all when method does not match deeper route",
+			method:         http.MethodGet,
+			path:           "/api/v1/users/me/settings",
+			expectedID:     5,
+			expectedParams: map[string]string{"*": "users/me/settings"},
+		},
+		{
+			name:           "method",
+			method:         http.MethodDelete,
+			path:           "/api/v1/users/me",
+			expectedID:     8,
+			expectedParams: map[string]string{"id": "me"},
+		},
+		{
+			name:   "no match",
+			method: http.MethodPost,
+			path:   "/api/v1/users/42",
+		},
+		{
+			name:   "catch-all needs a segment",
+			method: http.MethodGet,
+			path:   "/api/v1",
+		},
+	}
+
+	// Insertion order must not change the outcome, so run every scenario against the routes forwards and backwards
+	reversed := make([]*model.Route, len(routes))
+
+	for i, route := range routes {
+		reversed[len(routes)-1-i] = route
+	}
+
+	for name, order := range map[string][]*model.Route{"forwards": routes, "backwards": reversed} {
+		tree, failed := Build(order)
+
+		if len(failed) != 0 {
+			t.Fatalf("unexpected build failures: %v", failed)
+		}
+
+		for _, scenario := range scenarios {
+			t.Run(name+" "+scenario.name, func(t *testing.T) {
+				route, params, ok := tree.Match(httptest.NewRequest(scenario.method, scenario.path, nil))
+
+				if scenario.expectedID == 0 {
+					if ok {
+						t.Fatalf("expected no match, got route %d", route.ID)
+					}
+					return
+				}
+
+				if !ok {
+					t.Fatalf("expected route %d, got no match", scenario.expectedID)
+				}
+
+				if route.ID != scenario.expectedID {
+					t.Fatalf("expected route %d, got %d", scenario.expectedID, route.ID)
+				}
+
+				if !reflect.DeepEqual(scenario.expectedParams, params) {
+					t.Fatalf("expected params %v, got %v", scenario.expectedParams, params)
+				}
+			})
+		}
+	}
+}
+
+func TestTree_Insert(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		existing    []*model.Route
+		route       *model.Route
+		expectedErr error
+	}{
+		{
+			name:     "distinct method",
+			existing: []*model.Route{{Pattern: "/users/{id}", Method: http.MethodGet}},
+			route:    &model.Route{Pattern: "/users/{id}", Method: http.MethodPut},
+		},
+		{
+			name:        "duplicate",
+			existing:    []*model.Route{{Pattern: "/users/{id}", Method: http.MethodGet}},
+			route:       &model.Route{Pattern: "/users/{userId}", Method: http.MethodGet},
+			expectedErr: ErrDuplicateRoute,
+		},
+		{
+			name:     "distinct host",
+			existing: []*model.Route{{Pattern: "/users/{id}", Method: http.MethodGet}},
+			route:    &model.Route{Pattern: "/users/{id}", Method: http.MethodGet, Host: "api.example.com"},
+		},
+		{
+			name:        "duplicate host and conditions",
+			existing:    []*model.Route{{Pattern: "/users/{id}", Method: http.MethodGet, Host: "API.example.com", MatchHeaders: map[string]string{"x-version": "2"}}},
+			route:       &model.Route{Pattern: "/users/{id}", Method: http.MethodGet, Host: "api.example.com", MatchHeaders: map[string]string{"X-Version": "2"}},
+			expectedErr: ErrDuplicateRoute,
+		},
+		{
+			name:     "distinct query",
+			existing: []*model.Route{{Pattern: "/users/{id}", Method: http.MethodGet, MatchQuery: map[string]string{"version": "2"}}},
+			route:    &model.Route{Pattern: "/users/{id}", Method: http.MethodGet, MatchQuery: map[string]string{"version": "3"}},
+		},
+		{
+			name:        "invalid host",
+			route:       &model.Route{Pattern: "/users", Method: http.MethodGet, Host: "api.*.example.com"},
+			expectedErr: ErrInvalidPattern,
+		},
+		{
+			name:        "duplicate catch-all",
+			existing:    []*model.Route{{Pattern: "/users/*", Method: http.MethodGet}},
+			route:       &model.Route{Pattern: "/users/*", Method: http.MethodGet},
+			expectedErr: ErrDuplicateRoute,
+		},
+		{
+			name:        "catch-all not last",
+			route:       &model.Route{Pattern: "/users/*/posts", Method: http.MethodGet},
+			expectedErr: ErrInvalidPattern,
+		},
+		{
+			name:        "invalid regex",
+			route:       &model.Route{Pattern: "/users/{id:[0-9+}", Method: http.MethodGet},
+			expectedErr: ErrInvalidPattern,
+		},
+		{
+			name:        "partial param",
+			route:       &model.Route{Pattern: "/users/id-{id}", Method: http.MethodGet},
+			expectedErr: ErrInvalidPattern,
+		},
+		{
+			name:        "repeated param",
+			route:       &model.Route{Pattern: "/users/{id}/posts/{id}", Method: http.MethodGet},
+			expectedErr: ErrInvalidPattern,
+		},
+		{
+			name:        "relative",
+			route:       &model.Route{Pattern: "users", Method: http.MethodGet},
+			expectedErr: ErrInvalidPattern,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			tree, _ := Build(scenario.existing)
+
+			if err := tree.Insert(scenario.route); !errors.Is(err, scenario.expectedErr) {
+				t.Fatalf("expected error %v, got %v", scenario.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestTree_MatchConditions(t *testing.T) {
+	tree, failed := Build([]*model.Route{
+		{ID: 1, Pattern: "/users", Method: http.MethodGet},
+		{ID: 2, Pattern: "/users", Method: http.MethodGet, Host: "*.example.com"},
+		{ID: 3, Pattern: "/users", Method: http.MethodGet, Host: "api.example.com"},
+		{ID: 4, Pattern: "/users", Method: http.MethodGet, Host: "*.eu.example.com"},
+		{ID: 5, Pattern: "/users", Method: http.MethodGet, Host: "api.example.com", MatchHeaders: map[string]string{"X-Version": "2"}},
+		{ID: 6, Pattern: "/users", Method: http.MethodGet, MatchQuery: map[string]string{"beta": ""}},
+		{ID: 7, Pattern: "/orders", Method: http.MethodGet, Host: "shop.example.com"},
+		{ID: 8, Pattern: "/*", Method: http.MethodGet},
+	})
+
+	if len(failed) != 0 {
+		t.Fatalf("unexpected build failures: %v", failed)
+	}
+
+	scenarios := []struct {
+		name       string
+		target     string
+		header     http.Header
+		expectedID int
+	}{
+		{name: "no host condition", target: "http://other.org/users", expectedID: 1},
+		{name: "wildcard host", target: "http://www.example.com/users", expectedID: 2},
+		{name: "wildcard does not match apex", target: "http://example.com/users", expectedID: 1},
+		{name: "exact host beats wildcard", target: "http://api.example.com/users", expectedID: 3},
+		{name: "exact host ignores port and case", target: "http://API.example.com:8443/users", expectedID: 3},
+		{name: "longer wildcard beats shorter", target: "http://api.eu.example.com/users", expectedID: 4},
+		{name: "header condition", target: "http://api.example.com/users", header: http.Header{"X-Version": {"2"}}, expectedID: 5},
+		{name: "header condition value mismatch", target: "http://api.example.com/users", header: http.Header{"X-Version": {"3"}}, expectedID: 3},
+		{name: "query presence", target: "http://other.org/users?beta", expectedID: 6},
+		{name: "falls back to catch-all when host does not match", target: "http://other.org/orders", expectedID: 8},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, scenario.target, nil)
+
+			for name, values := range scenario.header {
+				r.Header[name] = values
+			}
+
+			route, _, ok := tree.Match(r)
+
+			if !ok || route.ID != scenario.expectedID {
+				t.Fatalf("expected route %d, got %v", scenario.expectedID, route)
+			}
+		})
+	}
+}
+
+func TestParamNames(t *testing.T) {
+	names, err := ParamNames("/orgs/{org}/users/{id:[0-9]+}/*")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual([]string{"org", "id", "*"}, names) {
+		t.Fatalf("expected [org id *], got %v", names)
+	}
+}
+
+func benchmarkTree(b *testing.B, size int) *Tree {
+	routes := make([]*model.Route, 0, size*4)
+
+	for i := 0; i < size; i++ {
+		routes = append(routes,
+			&model.Route{ID: len(routes), Pattern: fmt.Sprintf("/api/v1/service%d/users", i), Method: http.MethodGet},
+			&model.Route{ID: len(routes) + 1, Pattern: fmt.Sprintf("/api/v1/service%d/users/{id}", i), Method: http.MethodGet},
+			&model.Route{ID: len(routes) + 2, Pattern: fmt.Sprintf("/api/v1/service%d/orders/{id:[0-9]+}", i), Method: http.MethodGet},
+			&model.Route{ID: len(routes) + 3, Pattern: fmt.Sprintf("/api/v1/service%d/*", i), Method: http.MethodGet},
+		)
+	}
+
+	tree, failed := Build(routes)
+
+	if len(failed) != 0 {
+		b.Fatalf("unexpected build failures: %v", failed)
+	}
+
+	return tree
+}
+
+func BenchmarkTree_Match(b *testing.B) {
+	paths := map[string]string{
+		"static":    "/api/v1/service%d/users",
+		"param":     "/api/v1/service%d/users/42",
+		"regex":     "/api/v1/service%d/orders/42",
+		"catch-all": "/api/v1/service%d/reports/2024/q1",
+		"miss":      "/api/v2/service%d/users",
+	}
+
+	for _, size := range []int{10, 100, 1000} {
+		tree := benchmarkTree(b, size)
+
+		for name, path := range paths {
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf(path, size/2), nil)
+
+			b.Run(fmt.Sprintf("%s/%d", name, size*4), func(b *testing.B) {
+				b.ReportAllocs()
+
+				for b.Loop() {
+					tree.Match(r)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	routes := make([]*model.Route, 0, 1000)
+
+	for i := 0; i < 1000; i++ {
+		routes = append(routes, &model.Route{ID: i, Pattern: fmt.Sprintf("/api/v1/service%d/users/{id}", i), Method: http.MethodGet})
+	}
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		Build(routes)
+	}
+}
@@ -0,0 +1,77 @@
+package scope
+
+import (
+	"slices"
+	"strings"
+)
+
+// Claim is the token claim carrying the granted scopes as a space separated list
+const Claim = "scope"
+
+// ErrorInsufficientScope is the OAuth error code for a token lacking scopes a route requires
+const ErrorInsufficientScope = "insufficient_scope"
+
+// Valid reports whether s is a single scope token, printable ASCII without spaces, quotes or backslashes
+func Valid(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Parse splits a space separated scope parameter into its scopes, dropping duplicates
+func Parse(s string) []string {
+	var scopes []string
+
+	for _, scope := range strings.Fields(s) {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
+}
+
+// Join builds the space separated form of scopes used in tokens and challenges
+func Join(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// Cap narrows the requested scopes to the allowed ones. Requesting nothing asks for everything allowed.
+func Cap(requested, allowed []string) []string {
+	if len(requested) == 0 {
+		return slices.Clone(allowed)
+	}
+
+	var granted []string
+
+	for _, scope := range requested {
+		if slices.Contains(allowed, scope) {
+			granted = append(granted, scope)
+		}
+	}
+
+	return granted
+}
+
+// Missing returns the required scopes that were not granted
+func Missing(granted, required []string) []string {
+	var missing []string
+
+	for _, scope := range required {
+		if !slices.Contains(granted, scope) {
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
@@ -0,0 +1,95 @@
+package scope
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		scope    string
+		expected bool
+	}{
+		{name: "simple", scope: "orders:read", expected: true},
+		{name: "url", scope: "https://api.example.com/orders", expected: true},
+		{name: "empty", scope: "", expected: false},
+		{name: "space", scope: "orders read", expected: false},
+		{name: "quote", scope: `orders"read`, expected: false},
+		{name: "backslash", scope: `orders\read`, expected: false},
+		{name: "non ascii", scope: "commandes:lecture-é", expected: false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if got := Valid(s.scope); got != s.expected {
+				t.Errorf("expected %v, got %v", s.expected, got)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		param    string
+		expected []string
+	}{
+		{name: "empty", param: "", expected: nil},
+		{name: "single", param: "orders:read", expected: []string{"orders:read"}},
+		{name: "extra spaces", param: "  orders:read   orders:write ", expected: []string{"orders:read", "orders:write"}},
+		{name: "duplicates", param: "orders:read orders:read", expected: []string{"orders:read"}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if got := Parse(s.param); !reflect.DeepEqual(got, s.expected) {
+				t.Errorf("expected %v, got %v", s.expected, got)
+			}
+		})
+	}
+}
+
+func TestCap(t *testing.T) {
+	allowed := []string{"orders:read", "orders:write"}
+
+	scenarios := []struct {
+		name      string
+		requested []string
+		expected  []string
+	}{
+		{name: "nothing requested", requested: nil, expected: []string{"orders:read", "orders:write"}},
+		{name: "subset", requested: []string{"orders:read"}, expected: []string{"orders:read"}},
+		{name: "beyond allowed", requested: []string{"orders:read", "admin"}, expected: []string{"orders:read"}},
+		{name: "nothing allowed", requested: []string{"admin"}, expected: nil},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if got := Cap(s.requested, allowed); !reflect.DeepEqual(got, s.expected) {
+				t.Errorf("expected %v, got %v", s.expected, got)
+			}
+		})
+	}
+}
+
+func TestMissing(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		granted  []string
+		required []string
+		expected []string
+	}{
+		{name: "nothing required", granted: nil, required: nil, expected: nil},
+		{name: "all granted", granted: []string{"orders:read", "orders:write"}, required: []string{"orders:read"}, expected: nil},
+		{name: "some missing", granted: []string{"orders:read"}, required: []string{"orders:read", "orders:write"}, expected: []string{"orders:write"}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if got := Missing(s.granted, s.required); !reflect.DeepEqual(got, s.expected) {
+				t.Errorf("expected %v, got %v", s.expected, got)
+			}
+		})
+	}
+}
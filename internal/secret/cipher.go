@@ -0,0 +1,60 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+var ErrInvalidKey = errors.New("encryption key must be 32 bytes, base64 encoded")
+var ErrCiphertextTooShort = errors.New("ciphertext too short")
+
+// Cipher encrypts secrets at rest with AES-256-GCM. Ciphertexts are the random nonce followed by the sealed data.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher builds a cipher from a base64 encoded 32 byte key
+func NewCipher(base64Key string) (*Cipher, error) {
+	key, err := base64.StdEncoding.DecodeString(base64Key)
+
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{aead: aead}, nil
+}
+
+func (c *Cipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (c *Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+
+	if len(ciphertext) < nonceSize {
+		return nil, ErrCiphertextTooShort
+	}
+
+	return c.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], nil)
+}
@@ -0,0 +1,66 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+var testKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+
+func TestCipher_EncryptDecrypt(t *testing.T) {
+	c, err := NewCipher(testKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte(`{"type":"api_key","api_key":"sk_live_123"}`)
+
+	ciphertext, err := c.Encrypt(plaintext)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(ciphertext, []byte("sk_live_123")) {
+		t.Fatal("expected the secret to be encrypted")
+	}
+
+	decrypted, err := c.Decrypt(ciphertext)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Fatalf("expected %s, got %s", plaintext, decrypted)
+	}
+
+	ciphertext[len(ciphertext)-1] ^= 1
+
+	if _, err := c.Decrypt(ciphertext); err == nil {
+		t.Fatal("expected tampered ciphertext to fail")
+	}
+}
+
+func TestNewCipher(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		key         string
+		expectedErr error
+	}{
+		{name: "valid", key: testKey},
+		{name: "not base64", key: "not base64!", expectedErr: ErrInvalidKey},
+		{name: "too short", key: base64.StdEncoding.EncodeToString([]byte("short")), expectedErr: ErrInvalidKey},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			if _, err := NewCipher(scenario.key); !errors.Is(err, scenario.expectedErr) {
+				t.Fatalf("expected %v, got %v", scenario.expectedErr, err)
+			}
+		})
+	}
+}
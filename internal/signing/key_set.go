@@ -0,0 +1,142 @@
+package signing
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"crypto"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeySet holds the signing keys in memory. Keys overlap during a rotation: the newest active key signs while the
+// ones it replaced keep verifying the tokens they already signed until they expire.
+type KeySet struct {
+	rw   sync.RWMutex
+	keys []*Key
+	now  func() time.Time
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{
+		rw:  sync.RWMutex{},
+		now: time.Now,
+	}
+}
+
+// Current returns the key new tokens are signed with, the most recently activated one that has not retired
+func (ks *KeySet) Current() (*Key, bool) {
+	ks.rw.RLock()
+	defer ks.rw.RUnlock()
+
+	now := ks.now()
+	var current *Key
+
+	for _, key := range ks.keys {
+		if key.signs(now) && (current == nil || key.ActivatesAt.After(current.ActivatesAt)) {
+			current = key
+		}
+	}
+
+	return current, current != nil
+}
+
+// VerificationKey returns the signing method and public key of the unexpired key named kid
+func (ks *KeySet) VerificationKey(kid string) (jwt.SigningMethod, crypto.PublicKey, bool) {
+	ks.rw.RLock()
+	defer ks.rw.RUnlock()
+
+	now := ks.now()
+
+	for _, key := range ks.keys {
+		if key.KID == kid && key.verifies(now) {
+			return key.method, key.Public(), true
+		}
+	}
+
+	return nil, nil, false
+}
+
+// JWKS lists the public keys of every unexpired key, including ones scheduled to activate later
+func (ks *KeySet) JWKS() JWKS {
+	ks.rw.RLock()
+	defer ks.rw.RUnlock()
+
+	now := ks.now()
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+
+	for _, key := range ks.keys {
+		if !key.verifies(now) {
+			continue
+		}
+
+		jwk, err := key.JWK()
+
+		if err != nil {
+			slog.Error("error describing signing key", "kid", key.KID, "error", err)
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks
+}
+
+// Set replaces the keys, keys that cannot be parsed are logged and left out
+func (ks *KeySet) Set(signingKeys []*model.SigningKey) {
+	keys := make([]*Key, 0, len(signingKeys))
+
+	for _, signingKey := range signingKeys {
+		key, err := ParseKey(signingKey.KID, signingKey.Algorithm, signingKey.PrivateKey)
+
+		if err != nil {
+			slog.Error("error parsing signing key", "kid", signingKey.KID, "error", err)
+			continue
+		}
+
+		key.ActivatesAt = signingKey.ActivatesAt
+		key.RetiresAt = signingKey.RetiresAt
+		key.ExpiresAt = signingKey.ExpiresAt
+		keys = append(keys, key)
+	}
+
+	ks.rw.Lock()
+	defer ks.rw.Unlock()
+	ks.keys = keys
+}
+
+func (ks *KeySet) StartSync(ctx context.Context, interval time.Duration, findKeys func() ([]*model.SigningKey, error)) {
+	ks.syncCache(findKeys)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ks.syncCache(findKeys)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (ks *KeySet) syncCache(findKeys func() ([]*model.SigningKey, error)) {
+	slog.Info("started signing key sync...")
+
+	keys, err := findKeys()
+
+	if err != nil {
+		slog.Error("error syncing signing keys from db", "err", err)
+		return
+	}
+
+	ks.Set(keys)
+
+	slog.Info("finished signing key sync...")
+}
@@ -0,0 +1,197 @@
+package signing
+
+import (
+	"api-proxy/internal/model"
+	"crypto"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func generateKey(t *testing.T, kid, alg string, activatesAt time.Time, retiresAt, expiresAt *time.Time) *model.SigningKey {
+	t.Helper()
+
+	der, err := Generate(alg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &model.SigningKey{KID: kid, Algorithm: alg, PrivateKey: der, ActivatesAt: activatesAt, RetiresAt: retiresAt, ExpiresAt: expiresAt}
+}
+
+func TestKeySet_SignAndVerify(t *testing.T) {
+	now := time.Now()
+
+	for _, alg := range []string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			keys := NewKeySet()
+			keys.Set([]*model.SigningKey{generateKey(t, "k1", alg, now.Add(-time.Minute), nil, nil)})
+
+			current, ok := keys.Current()
+
+			if !ok {
+				t.Fatal("expected a current key")
+			}
+
+			signed, err := current.Sign(jwt.MapClaims{"sub": 1})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, err := jwt.Parse(signed, func(token *jwt.Token) (any, error) {
+				method, key, ok := keys.VerificationKey(token.Header["kid"].(string))
+
+				if !ok || method.Alg() != token.Method.Alg() {
+					t.Fatalf("expected the key to verify %s tokens", token.Method.Alg())
+				}
+
+				return key, nil
+			})
+
+			if err != nil || !parsed.Valid {
+				t.Fatalf("expected a valid token, got %v", err)
+			}
+
+			jwks := keys.JWKS()
+
+			if len(jwks.Keys) != 1 || jwks.Keys[0].KeyID != "k1" || jwks.Keys[0].Algorithm != alg {
+				t.Errorf("expected k1 to be published as %s, got %+v", alg, jwks.Keys)
+			}
+		})
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	now := time.Now()
+
+	scenarios := []struct {
+		name        string
+		keys        []*model.SigningKey
+		current     string
+		verifies    []string
+		notVerifies []string
+	}{
+		{
+			name: "newest active key signs",
+			keys: []*model.SigningKey{
+				generateKey(t, "old", AlgorithmES256, now.Add(-2*time.Hour), nil, nil),
+				generateKey(t, "new", AlgorithmES256, now.Add(-time.Minute), nil, nil),
+			},
+			current:  "new",
+			verifies: []string{"old", "new"},
+		},
+		{
+			name: "scheduled key is published before it signs",
+			keys: []*model.SigningKey{
+				generateKey(t, "old", AlgorithmES256, now.Add(-2*time.Hour), new(now.Add(time.Hour)), new(now.Add(2*time.Hour))),
+				generateKey(t, "next", AlgorithmES256, now.Add(time.Hour), nil, nil),
+			},
+			current:  "old",
+			verifies: []string{"old", "next"},
+		},
+		{
+			name: "retired key still verifies",
+			keys: []*model.SigningKey{
+				generateKey(t, "old", AlgorithmES256, now.Add(-2*time.Hour), new(now.Add(-time.Minute)), new(now.Add(time.Hour))),
+				generateKey(t, "new", AlgorithmES256, now.Add(-time.Minute), nil, nil),
+			},
+			current:  "new",
+			verifies: []string{"old", "new"},
+		},
+		{
+			name: "expired key neither signs nor verifies",
+			keys: []*model.SigningKey{
+				generateKey(t, "old", AlgorithmES256, now.Add(-3*time.Hour), nil, new(now.Add(-time.Minute))),
+			},
+			current:     "",
+			notVerifies: []string{"old"},
+		},
+		{
+			name: "unparsable key is left out",
+			keys: []*model.SigningKey{
+				{KID: "broken", Algorithm: AlgorithmES256, PrivateKey: []byte("not a key"), ActivatesAt: now.Add(-time.Minute)},
+				generateKey(t, "valid", AlgorithmRS256, now.Add(-time.Minute), nil, nil),
+			},
+			current:     "valid",
+			notVerifies: []string{"broken"},
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			keys := NewKeySet()
+			keys.Set(s.keys)
+
+			current, ok := keys.Current()
+
+			if s.current == "" && ok {
+				t.Errorf("expected no current key, got %s", current.KID)
+			}
+
+			if s.current != "" && (!ok || current.KID != s.current) {
+				t.Errorf("expected %s to be the current key, got %v", s.current, current)
+			}
+
+			for _, kid := range s.verifies {
+				if _, _, ok := keys.VerificationKey(kid); !ok {
+					t.Errorf("expected %s to verify", kid)
+				}
+			}
+
+			for _, kid := range s.notVerifies {
+				if _, _, ok := keys.VerificationKey(kid); ok {
+					t.Errorf("expected %s not to verify", kid)
+				}
+			}
+		})
+	}
+}
+
+func TestParseKey_AlgorithmMismatch(t *testing.T) {
+	der, err := Generate(AlgorithmEdDSA)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseKey("k1", AlgorithmES256, der); err == nil {
+		t.Error("expected an EdDSA key labelled ES256 to be rejected")
+	}
+}
+
+func TestJWK_PublicKey(t *testing.T) {
+	for _, alg := range []string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			der, err := Generate(alg)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key, err := ParseKey("k1", alg, der)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jwk, err := key.JWK()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			public, err := jwk.PublicKey()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !public.(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+				t.Error("expected the jwk to describe the key's public half")
+			}
+		})
+	}
+}
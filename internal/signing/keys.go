@@ -0,0 +1,229 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algorithms tokens can be signed with
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+
+// ValidAlgorithm reports whether keys can be generated for alg
+func ValidAlgorithm(alg string) bool {
+	return alg == AlgorithmRS256 || alg == AlgorithmES256 || alg == AlgorithmEdDSA
+}
+
+// Generate creates a new private key for alg, returned PKCS #8 encoded
+func Generate(alg string) ([]byte, error) {
+	var key crypto.Signer
+	var err error
+
+	switch alg {
+	case AlgorithmRS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+// NewKID returns a random key id for the kid header of the tokens a key signs
+func NewKID() (string, error) {
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Key is a parsed signing key. It is published from the moment it is loaded so verifiers can pick it up ahead of
+// time, signs tokens from ActivatesAt until RetiresAt and verifies them until ExpiresAt.
+type Key struct {
+	KID         string
+	Algorithm   string
+	ActivatesAt time.Time
+	RetiresAt   *time.Time
+	ExpiresAt   *time.Time
+	private     crypto.Signer
+	method      jwt.SigningMethod
+}
+
+// ParseKey reads a PKCS #8 encoded private key and checks it matches alg
+func ParseKey(kid, alg string, der []byte) (*Key, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{KID: kid, Algorithm: alg}
+
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.private, key.method = private, jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		key.private, key.method = private, jwt.SigningMethodES256
+	case ed25519.PrivateKey:
+		key.private, key.method = private, jwt.SigningMethodEdDSA
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	if key.method.Alg() != alg {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	return key, nil
+}
+
+// Method is the jwt signing method of the key
+func (k *Key) Method() jwt.SigningMethod {
+	return k.method
+}
+
+// Public is the public half of the key, used to verify the tokens it signed
+func (k *Key) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+// Sign signs the claims and names the key in the kid header
+func (k *Key) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.KID
+
+	return token.SignedString(k.private)
+}
+
+func (k *Key) signs(now time.Time) bool {
+	return !now.Before(k.ActivatesAt) && (k.RetiresAt == nil || now.Before(*k.RetiresAt)) && k.verifies(now)
+}
+
+func (k *Key) verifies(now time.Time) bool {
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// JWK is the RFC 7517 representation of a public signing key
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is the document listing the public keys tokens can be verified with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK describes the public half of the key
+func (k *Key) JWK() (JWK, error) {
+	jwk := JWK{KeyID: k.KID, Use: "sig", Algorithm: k.Algorithm}
+
+	switch public := k.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		point, err := public.ECDH()
+
+		if err != nil {
+			return JWK{}, err
+		}
+
+		// An uncompressed P-256 point is 0x04 followed by the 32 byte x and y coordinates
+		raw := point.Bytes()
+		jwk.KeyType = "EC"
+		jwk.Curve = "P-256"
+		jwk.X = base64.RawURLEncoding.EncodeToString(raw[1:33])
+		jwk.Y = base64.RawURLEncoding.EncodeToString(raw[33:])
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	default:
+		return JWK{}, ErrUnsupportedAlgorithm
+	}
+
+	return jwk, nil
+}
+
+// PublicKey reads the public key the JWK describes, as published by this proxy or a third-party identity provider
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[jwk.Curve]
+
+		if !ok {
+			return nil, ErrUnsupportedAlgorithm
+		}
+
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		size := (curve.Params().BitSize + 7) / 8
+
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, errors.New("invalid ec point")
+		}
+
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+
+		if err != nil || jwk.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedAlgorithm
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
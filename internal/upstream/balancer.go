@@ -0,0 +1,179 @@
+package upstream
+
+import (
+	"api-proxy/internal/model"
+	"hash/crc32"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// replicasPerWeight is how many points each unit of weight places on the consistent hash ring
+const replicasPerWeight = 64
+
+// Balancer picks one of a pool's targets for a request. Key identifies the caller for strategies that keep a caller
+// on the same target, it is empty when the caller is unknown.
+type Balancer interface {
+	Pick(targets []*PoolTarget, key string) *PoolTarget
+}
+
+// NewBalancer returns the balancer for strategy, falling back to round robin for an unknown or empty strategy
+func NewBalancer(strategy string, targets []*PoolTarget) Balancer {
+	switch strategy {
+	case model.StrategyWeightedRandom:
+		return weightedRandom{}
+	case model.StrategyLeastOutstanding:
+		return leastOutstanding{}
+	case model.StrategyConsistentHash:
+		return newConsistentHash(targets)
+	default:
+		return &roundRobin{}
+	}
+}
+
+// ValidStrategy reports whether strategy names a supported balancer, an empty strategy defaults to round robin
+func ValidStrategy(strategy string) bool {
+	switch strategy {
+	case "", model.StrategyRoundRobin, model.StrategyWeightedRandom, model.StrategyLeastOutstanding, model.StrategyConsistentHash:
+		return true
+	default:
+		return false
+	}
+}
+
+// roundRobin is smooth weighted round robin: every target is picked in proportion to its weight and the picks of a
+// heavy target are interleaved with the others instead of coming in a burst
+type roundRobin struct {
+	mu      sync.Mutex
+	current map[int]int
+}
+
+// Pick raises every target's current weight by its weight, picks the highest and lowers it by the total weight
+func (rr *roundRobin) Pick(targets []*PoolTarget, _ string) *PoolTarget {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if rr.current == nil {
+		rr.current = make(map[int]int)
+	}
+
+	var best *PoolTarget
+	total := 0
+
+	for _, target := range targets {
+		rr.current[target.ID] += target.Weight
+		total += target.Weight
+
+		if best == nil || rr.current[target.ID] > rr.current[best.ID] {
+			best = target
+		}
+	}
+
+	if best == nil || total <= 0 {
+		return nil
+	}
+
+	rr.current[best.ID] -= total
+
+	return best
+}
+
+type weightedRandom struct{}
+
+func (weightedRandom) Pick(targets []*PoolTarget, _ string) *PoolTarget {
+	total := 0
+
+	for _, target := range targets {
+		total += target.Weight
+	}
+
+	if total <= 0 {
+		return nil
+	}
+
+	n := rand.IntN(total)
+
+	for _, target := range targets {
+		if n < target.Weight {
+			return target
+		}
+
+		n -= target.Weight
+	}
+
+	return nil
+}
+
+type leastOutstanding struct{}
+
+// Pick returns the target with the fewest in-flight requests relative to its weight
+func (leastOutstanding) Pick(targets []*PoolTarget, _ string) *PoolTarget {
+	var best *PoolTarget
+	var bestOutstanding int64
+
+	for _, target := range targets {
+		outstanding := target.Outstanding()
+
+		// outstanding/weight < bestOutstanding/best.weight without dividing
+		if best == nil || outstanding*int64(best.Weight) < bestOutstanding*int64(target.Weight) {
+			best, bestOutstanding = target, outstanding
+		}
+	}
+
+	return best
+}
+
+type ringPoint struct {
+	hash     uint32
+	targetID int
+}
+
+// consistentHash keeps a caller on the same target while the pool is unchanged and only moves the callers of a
+// target that is added or removed. Callers without a key are spread round robin.
+type consistentHash struct {
+	ring     []ringPoint
+	fallback *roundRobin
+}
+
+func newConsistentHash(targets []*PoolTarget) *consistentHash {
+	ch := &consistentHash{fallback: &roundRobin{}}
+
+	for _, target := range targets {
+		for i := 0; i < target.Weight*replicasPerWeight; i++ {
+			ch.ring = append(ch.ring, ringPoint{
+				hash:     crc32.ChecksumIEEE([]byte(target.URL + "#" + strconv.Itoa(i))),
+				targetID: target.ID,
+			})
+		}
+	}
+
+	sort.Slice(ch.ring, func(i, j int) bool { return ch.ring[i].hash < ch.ring[j].hash })
+
+	return ch
+}
+
+// Pick walks the ring clockwise from the key's hash to the first point owned by one of targets, so a target that is
+// skipped, for instance while it is unhealthy, only moves its own callers
+func (ch *consistentHash) Pick(targets []*PoolTarget, key string) *PoolTarget {
+	if key == "" || len(ch.ring) == 0 {
+		return ch.fallback.Pick(targets, key)
+	}
+
+	byID := make(map[int]*PoolTarget, len(targets))
+
+	for _, target := range targets {
+		byID[target.ID] = target
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(ch.ring), func(i int) bool { return ch.ring[i].hash >= hash })
+
+	for i := 0; i < len(ch.ring); i++ {
+		if target, ok := byID[ch.ring[(start+i)%len(ch.ring)].targetID]; ok {
+			return target
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package upstream
+
+import (
+	"api-proxy/internal/model"
+	"strconv"
+	"testing"
+)
+
+func testTargets(weights ...int) []*PoolTarget {
+	targets := make([]*PoolTarget, 0, len(weights))
+
+	for i, weight := range weights {
+		targets = append(targets, &PoolTarget{ID: i + 1, URL: "http://backend-" + strconv.Itoa(i+1), Weight: weight})
+	}
+
+	return targets
+}
+
+func TestBalancer_RoundRobin(t *testing.T) {
+	targets := testTargets(1, 1, 1)
+	balancer := NewBalancer(model.StrategyRoundRobin, targets)
+
+	for i := 0; i < 6; i++ {
+		if picked := balancer.Pick(targets, ""); picked.ID != i%3+1 {
+			t.Fatalf("pick %d: expected target %d, got %d", i, i%3+1, picked.ID)
+		}
+	}
+}
+
+func TestBalancer_SmoothWeightedRoundRobin(t *testing.T) {
+	targets := testTargets(5, 1, 1)
+	balancer := NewBalancer("", targets)
+	expected := []int{1, 1, 2, 1, 3, 1, 1}
+
+	for round := 0; round < 2; round++ {
+		for i, expectedID := range expected {
+			if picked := balancer.Pick(targets, ""); picked.ID != expectedID {
+				t.Fatalf("round %d pick %d: expected target %d, got %d", round, i, expectedID, picked.ID)
+			}
+		}
+	}
+}
+
+func TestBalancer_WeightedRandom(t *testing.T) {
+	targets := testTargets(1, 0, 3)
+	balancer := NewBalancer(model.StrategyWeightedRandom, targets)
+	counts := make(map[int]int)
+
+	for i := 0; i < 4000; i++ {
+		counts[balancer.Pick(targets, "").ID]++
+	}
+
+	if counts[2] != 0 {
+		t.Fatalf("expected a zero weight target to never be picked, got %d picks", counts[2])
+	}
+
+	if ratio := float64(counts[3]) / float64(counts[1]); ratio < 2.5 || ratio > 3.5 {
+		t.Fatalf("expected target 3 to be picked about 3 times as often as target 1, got %v", counts)
+	}
+}
+
+func TestBalancer_LeastOutstanding(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		weights     []int
+		outstanding []int64
+		expectedID  int
+	}{
+		{name: "fewest in flight", weights: []int{1, 1, 1}, outstanding: []int64{3, 1, 2}, expectedID: 2},
+		{name: "relative to weight", weights: []int{1, 4}, outstanding: []int64{1, 3}, expectedID: 2},
+		{name: "first on ties", weights: []int{1, 1}, outstanding: []int64{0, 0}, expectedID: 1},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			targets := testTargets(scenario.weights...)
+
+			for i, outstanding := range scenario.outstanding {
+				targets[i].outstanding.Store(outstanding)
+			}
+
+			if picked := NewBalancer(model.StrategyLeastOutstanding, targets).Pick(targets, ""); picked.ID != scenario.expectedID {
+				t.Fatalf("expected target %d, got %d", scenario.expectedID, picked.ID)
+			}
+		})
+	}
+}
+
+func TestBalancer_ConsistentHash(t *testing.T) {
+	targets := testTargets(1, 1, 1, 1)
+	balancer := NewBalancer(model.StrategyConsistentHash, targets)
+	assigned := make(map[string]int)
+
+	for org := 1; org <= 200; org++ {
+		key := strconv.Itoa(org)
+		picked := balancer.Pick(targets, key)
+
+		if again := balancer.Pick(targets, key); again.ID != picked.ID {
+			t.Fatalf("expected org %s to stay on target %d, moved to %d", key, picked.ID, again.ID)
+		}
+
+		assigned[key] = picked.ID
+	}
+
+	// Dropping a target must only move the orgs that were on it
+	remaining := targets[:3]
+
+	for key, id := range assigned {
+		picked := balancer.Pick(remaining, key)
+
+		if id != 4 && picked.ID != id {
+			t.Fatalf("expected org %s to stay on target %d, moved to %d", key, id, picked.ID)
+		}
+
+		if picked.ID == 4 {
+			t.Fatalf("expected org %s to move off the removed target", key)
+		}
+	}
+}
+
+func TestPoolTarget_Acquire(t *testing.T) {
+	target := &PoolTarget{ID: 1, Weight: 1}
+
+	release := target.Acquire()
+	target.Acquire()
+
+	release()
+	release()
+
+	if target.Outstanding() != 1 {
+		t.Fatalf("expected 1 outstanding request, got %d", target.Outstanding())
+	}
+}
+
+func TestPools_Set(t *testing.T) {
+	pools := NewPools()
+	upstream := &model.Upstream{
+		ID:       1,
+		Strategy: model.StrategyRoundRobin,
+		Targets: []*model.UpstreamTarget{
+			{ID: 1, URL: "http://backend-1", Weight: new(1)},
+			{ID: 2, URL: "http://backend-2"},
+			{ID: 3, URL: "http://backend-3", Weight: new(0)},
+		},
+	}
+
+	pools.Set([]*model.Upstream{upstream})
+
+	before := pools.Get(1).Targets()
+	before[0].Acquire()
+
+	pools.Set([]*model.Upstream{upstream})
+
+	after := pools.Get(1).Targets()
+
+	if after[0].Outstanding() != 1 {
+		t.Fatalf("expected in-flight count to survive a sync, got %d", after[0].Outstanding())
+	}
+
+	if after[1].Weight != 1 {
+		t.Fatalf("expected a missing weight to default to 1, got %d", after[1].Weight)
+	}
+
+	if after[2].Weight != 0 {
+		t.Fatalf("expected a weight of 0 to be kept, got %d", after[2].Weight)
+	}
+
+	for i := 0; i < 10; i++ {
+		if target, err := pools.Get(1).Pick(""); err != nil || target.ID == 3 {
+			t.Fatalf("expected the drained target to receive no requests, got %v, %v", target, err)
+		}
+	}
+
+	if pools.Get(2) != nil {
+		t.Fatalf("expected no pool for an unknown upstream")
+	}
+}
@@ -0,0 +1,211 @@
+package upstream
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAPIKeyHeader = "X-API-Key"
+	tokenRefreshSkew    = 30 * time.Second
+	defaultTokenTTL     = 5 * time.Minute
+)
+
+var ErrUnknownUpstreamAuth = errors.New("unknown upstream auth type")
+
+// tokenKey includes a hash of the client secret so a rotated secret fetches a new token right away
+type tokenKey struct {
+	tokenURL   string
+	clientID   string
+	secretHash [sha256.Size]byte
+	scopes     string
+}
+
+type cachedToken struct {
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Credentials attaches a route's upstream auth to outbound requests. OAuth2 client_credentials tokens are cached
+// per token url, client, secret and scopes and refreshed shortly before they expire or once the backend rejects them.
+type Credentials struct {
+	client *http.Client
+	mu     sync.Mutex
+	tokens map[tokenKey]*cachedToken
+}
+
+func NewCredentials(client *http.Client) *Credentials {
+	return &Credentials{
+		client: client,
+		mu:     sync.Mutex{},
+		tokens: make(map[tokenKey]*cachedToken),
+	}
+}
+
+// Apply sets the credential headers for auth on h, a nil auth leaves the headers untouched
+func (c *Credentials) Apply(ctx context.Context, h http.Header, auth *model.UpstreamAuth) error {
+	if auth == nil {
+		return nil
+	}
+
+	switch auth.Type {
+	case model.UpstreamAuthAPIKey:
+		h.Set(CredentialHeader(auth), auth.APIKey)
+	case model.UpstreamAuthBasic:
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password)))
+	case model.UpstreamAuthOAuth2:
+		accessToken, err := c.token(ctx, auth)
+
+		if err != nil {
+			return err
+		}
+
+		h.Set("Authorization", "Bearer "+accessToken)
+	default:
+		return ErrUnknownUpstreamAuth
+	}
+
+	return nil
+}
+
+// CredentialHeader returns the header Apply sets for auth
+func CredentialHeader(auth *model.UpstreamAuth) string {
+	if auth.Type == model.UpstreamAuthAPIKey {
+		if auth.HeaderName == "" {
+			return defaultAPIKeyHeader
+		}
+
+		return auth.HeaderName
+	}
+
+	return "Authorization"
+}
+
+// Invalidate drops the cached OAuth2 token sent in h after the backend answered it with a 401, the next request
+// fetches a new one. A token refreshed in the meantime is kept.
+func (c *Credentials) Invalidate(h http.Header, auth *model.UpstreamAuth) {
+	if auth == nil || auth.Type != model.UpstreamAuthOAuth2 {
+		return
+	}
+
+	c.mu.Lock()
+	cached, ok := c.tokens[keyFor(auth)]
+	c.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+
+	if cached.accessToken != "" && h.Get("Authorization") == "Bearer "+cached.accessToken {
+		cached.accessToken = ""
+	}
+}
+
+func keyFor(auth *model.UpstreamAuth) tokenKey {
+	return tokenKey{
+		tokenURL:   auth.TokenURL,
+		clientID:   auth.ClientID,
+		secretHash: sha256.Sum256([]byte(auth.ClientSecret)),
+		scopes:     auth.Scopes,
+	}
+}
+
+func (c *Credentials) token(ctx context.Context, auth *model.UpstreamAuth) (string, error) {
+	key := keyFor(auth)
+
+	c.mu.Lock()
+	cached, ok := c.tokens[key]
+
+	if !ok {
+		cached = &cachedToken{}
+		c.tokens[key] = cached
+	}
+	c.mu.Unlock()
+
+	// Holding the per-token lock while fetching makes concurrent callers wait for a single refresh
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+
+	if cached.accessToken != "" && time.Now().Add(tokenRefreshSkew).Before(cached.expiresAt) {
+		return cached.accessToken, nil
+	}
+
+	response, err := c.fetchToken(ctx, auth)
+
+	if err != nil {
+		return "", err
+	}
+
+	ttl := time.Duration(response.ExpiresIn) * time.Second
+
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+
+	cached.accessToken = response.AccessToken
+	cached.expiresAt = time.Now().Add(ttl)
+
+	return cached.accessToken, nil
+}
+
+func (c *Credentials) fetchToken(ctx context.Context, auth *model.UpstreamAuth) (*tokenResponse, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+
+	if auth.Scopes != "" {
+		form.Set("scope", auth.Scopes)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, auth.TokenURL, strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(auth.ClientID), url.QueryEscape(auth.ClientSecret))
+
+	response, err := c.client.Do(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint %s returned status %d", auth.TokenURL, response.StatusCode)
+	}
+
+	var token tokenResponse
+
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("token endpoint %s returned no access token", auth.TokenURL)
+	}
+
+	return &token, nil
+}
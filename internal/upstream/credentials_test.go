@@ -0,0 +1,164 @@
+package upstream
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCredentials_Apply(t *testing.T) {
+	var tokenRequests atomic.Int32
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, ok := r.BasicAuth()
+
+		if !ok || clientID != "proxy" || clientSecret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		n := tokenRequests.Add(1)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, n)
+	}))
+	defer tokenServer.Close()
+
+	scenarios := []struct {
+		name           string
+		auth           *model.UpstreamAuth
+		expectedHeader string
+		expectedValue  string
+		expectedErr    bool
+	}{
+		{
+			name:           "api key default header",
+			auth:           &model.UpstreamAuth{Type: model.UpstreamAuthAPIKey, APIKey: "key-123"},
+			expectedHeader: "X-API-Key",
+			expectedValue:  "key-123",
+		},
+		{
+			name:           "api key custom header",
+			auth:           &model.UpstreamAuth{Type: model.UpstreamAuthAPIKey, HeaderName: "X-Partner-Key", APIKey: "key-123"},
+			expectedHeader: "X-Partner-Key",
+			expectedValue:  "key-123",
+		},
+		{
+			name:           "basic",
+			auth:           &model.UpstreamAuth{Type: model.UpstreamAuthBasic, Username: "proxy", Password: "pw"},
+			expectedHeader: "Authorization",
+			expectedValue:  "Basic cHJveHk6cHc=",
+		},
+		{
+			name:           "oauth2",
+			auth:           &model.UpstreamAuth{Type: model.UpstreamAuthOAuth2, TokenURL: tokenServer.URL, ClientID: "proxy", ClientSecret: "s3cret"},
+			expectedHeader: "Authorization",
+			expectedValue:  "Bearer token-1",
+		},
+		{
+			name:        "oauth2 rejected",
+			auth:        &model.UpstreamAuth{Type: model.UpstreamAuthOAuth2, TokenURL: tokenServer.URL, ClientID: "proxy", ClientSecret: "wrong"},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown",
+			auth:        &model.UpstreamAuth{Type: "digest"},
+			expectedErr: true,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			h := http.Header{}
+			err := NewCredentials(tokenServer.Client()).Apply(context.Background(), h, scenario.auth)
+
+			if scenario.expectedErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", scenario.expectedErr, err)
+			}
+
+			if scenario.expectedHeader != "" && h.Get(scenario.expectedHeader) != scenario.expectedValue {
+				t.Fatalf("expected %s to be %q, got %q", scenario.expectedHeader, scenario.expectedValue, h.Get(scenario.expectedHeader))
+			}
+		})
+	}
+
+	t.Run("oauth2 token is cached", func(t *testing.T) {
+		tokenRequests.Store(0)
+
+		credentials := NewCredentials(tokenServer.Client())
+		auth := &model.UpstreamAuth{Type: model.UpstreamAuthOAuth2, TokenURL: tokenServer.URL, ClientID: "proxy", ClientSecret: "s3cret"}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				h := http.Header{}
+
+				if err := credentials.Apply(context.Background(), h, auth); err != nil {
+					errs <- err
+					return
+				}
+
+				if h.Get("Authorization") != "Bearer token-1" {
+					errs <- errors.New("unexpected token " + h.Get("Authorization"))
+				}
+			}()
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Fatal(err)
+		}
+
+		if tokenRequests.Load() != 1 {
+			t.Fatalf("expected 1 token request, got %d", tokenRequests.Load())
+		}
+	})
+
+	t.Run("oauth2 token is refetched after the secret rotates or the backend rejects it", func(t *testing.T) {
+		tokenRequests.Store(0)
+
+		credentials := NewCredentials(tokenServer.Client())
+		auth := &model.UpstreamAuth{Type: model.UpstreamAuthOAuth2, TokenURL: tokenServer.URL, ClientID: "proxy", ClientSecret: "s3cret"}
+		h := http.Header{}
+
+		if err := credentials.Apply(context.Background(), h, auth); err != nil {
+			t.Fatal(err)
+		}
+
+		rotated := *auth
+		rotated.ClientSecret = "rotated"
+
+		if err := credentials.Apply(context.Background(), http.Header{}, &rotated); err == nil {
+			t.Fatal("expected the rotated secret to fetch its own token instead of reusing the cached one")
+		}
+
+		credentials.Invalidate(h, auth)
+
+		if err := credentials.Apply(context.Background(), h, auth); err != nil {
+			t.Fatal(err)
+		}
+
+		if h.Get("Authorization") != "Bearer token-2" {
+			t.Fatalf("expected a new token after the 401, got %q", h.Get("Authorization"))
+		}
+
+		credentials.Invalidate(http.Header{"Authorization": {"Bearer token-1"}}, auth)
+
+		if err := credentials.Apply(context.Background(), h, auth); err != nil || h.Get("Authorization") != "Bearer token-2" {
+			t.Fatalf("expected a rejection of an older token to keep the current one, got %q, %v", h.Get("Authorization"), err)
+		}
+	})
+}
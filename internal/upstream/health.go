@@ -0,0 +1,226 @@
+package upstream
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval    = 10 * time.Second
+	defaultHealthyThreshold       = 2
+	defaultUnhealthyThreshold     = 3
+	defaultMaxConsecutiveFailures = 5
+	defaultEjectionDuration       = 30 * time.Second
+	maxProbeTimeout               = 5 * time.Second
+	// maxProbeDrainBytes is how much of a probe's response is read so its connection can be reused
+	maxProbeDrainBytes = 4 << 10
+)
+
+// HealthSettings controls how a pool's targets are probed and when they are ejected
+type HealthSettings struct {
+	Path                   string
+	Interval               time.Duration
+	HealthyThreshold       int
+	UnhealthyThreshold     int
+	MaxConsecutiveFailures int
+	EjectionDuration       time.Duration
+}
+
+// HealthSettingsFor overlays the upstream's non-zero settings on the defaults, active probing stays off without a path
+func HealthSettingsFor(upstream *model.Upstream) HealthSettings {
+	settings := HealthSettings{
+		Path:                   upstream.HealthCheckPath,
+		Interval:               defaultHealthCheckInterval,
+		HealthyThreshold:       defaultHealthyThreshold,
+		UnhealthyThreshold:     defaultUnhealthyThreshold,
+		MaxConsecutiveFailures: defaultMaxConsecutiveFailures,
+		EjectionDuration:       defaultEjectionDuration,
+	}
+
+	if upstream.HealthCheckIntervalMs > 0 {
+		settings.Interval = time.Duration(upstream.HealthCheckIntervalMs) * time.Millisecond
+	}
+
+	if upstream.HealthyThreshold > 0 {
+		settings.HealthyThreshold = upstream.HealthyThreshold
+	}
+
+	if upstream.UnhealthyThreshold > 0 {
+		settings.UnhealthyThreshold = upstream.UnhealthyThreshold
+	}
+
+	if upstream.MaxConsecutiveFailures > 0 {
+		settings.MaxConsecutiveFailures = upstream.MaxConsecutiveFailures
+	}
+
+	if upstream.EjectionDurationMs > 0 {
+		settings.EjectionDuration = time.Duration(upstream.EjectionDurationMs) * time.Millisecond
+	}
+
+	return settings
+}
+
+// TargetHealth is a point in time view of a target's health for the admin API
+type TargetHealth struct {
+	ID                  int        `json:"id"`
+	URL                 string     `json:"url"`
+	Weight              int        `json:"weight"`
+	Healthy             bool       `json:"healthy"`
+	Ejected             bool       `json:"ejected"`
+	EjectedUntil        *time.Time `json:"ejected_until"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Outstanding         int64      `json:"outstanding"`
+}
+
+// Health reports the health of every target in the pool
+func (p *Pool) Health() []TargetHealth {
+	now := time.Now()
+	health := make([]TargetHealth, 0, len(p.targets))
+
+	for _, target := range p.targets {
+		th := TargetHealth{
+			ID:                  target.ID,
+			URL:                 target.URL,
+			Weight:              target.Weight,
+			Healthy:             !target.unhealthy.Load(),
+			ConsecutiveFailures: int(target.consecutiveFailures.Load()),
+			Outstanding:         target.Outstanding(),
+		}
+
+		if until := time.Unix(0, target.ejectedUntil.Load()); until.After(now) {
+			th.Ejected = true
+			th.EjectedUntil = &until
+		}
+
+		health = append(health, th)
+	}
+
+	return health
+}
+
+// Report records the outcome of a proxied request for passive outlier detection. A target is ejected for the
+// ejection duration after enough consecutive failures, any success resets the count.
+func (p *Pool) Report(target *PoolTarget, failed bool) {
+	if !failed {
+		target.consecutiveFailures.Store(0)
+		return
+	}
+
+	if int(target.consecutiveFailures.Add(1)) < p.health.MaxConsecutiveFailures {
+		return
+	}
+
+	target.consecutiveFailures.Store(0)
+	target.ejectedUntil.Store(time.Now().Add(p.health.EjectionDuration).UnixNano())
+
+	slog.Warn("ejecting upstream target after consecutive failures", "upstream", p.Name, "target", target.URL, "duration", p.health.EjectionDuration)
+}
+
+// recordProbe moves a target between healthy and unhealthy once enough consecutive probes agree
+func (p *Pool) recordProbe(target *PoolTarget, ok bool) {
+	if ok {
+		target.probeFailures.Store(0)
+
+		if int(target.probeSuccesses.Add(1)) >= p.health.HealthyThreshold && target.unhealthy.CompareAndSwap(true, false) {
+			slog.Info("upstream target is healthy", "upstream", p.Name, "target", target.URL)
+		}
+
+		return
+	}
+
+	target.probeSuccesses.Store(0)
+
+	if int(target.probeFailures.Add(1)) >= p.health.UnhealthyThreshold && target.unhealthy.CompareAndSwap(false, true) {
+		slog.Warn("upstream target is unhealthy", "upstream", p.Name, "target", target.URL)
+	}
+}
+
+// HealthChecker actively probes the targets of every pool with a health check path
+type HealthChecker struct {
+	pools      *Pools
+	transports *Transports
+}
+
+// NewHealthChecker returns a checker that probes each pool through the transport of a route using it, redirects are
+// not followed and count as healthy
+func NewHealthChecker(pools *Pools, transports *Transports) *HealthChecker {
+	return &HealthChecker{pools: pools, transports: transports}
+}
+
+// Start checks every tick which targets are due a probe, each target is probed at its pool's interval
+func (hc *HealthChecker) Start(ctx context.Context, tick time.Duration) {
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				hc.probeDue(ctx, now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (hc *HealthChecker) probeDue(ctx context.Context, now time.Time) {
+	for _, pool := range hc.pools.All() {
+		if pool.health.Path == "" {
+			continue
+		}
+
+		for _, target := range pool.targets {
+			if now.Sub(time.Unix(0, target.lastProbe.Load())) < pool.health.Interval {
+				continue
+			}
+
+			// Skip targets whose previous probe is still running
+			if !target.probing.CompareAndSwap(false, true) {
+				continue
+			}
+
+			target.lastProbe.Store(now.UnixNano())
+
+			go func() {
+				defer target.probing.Store(false)
+
+				pool.recordProbe(target, hc.probe(ctx, pool, target.URL))
+			}()
+		}
+	}
+}
+
+func (hc *HealthChecker) probe(ctx context.Context, pool *Pool, targetURL string) bool {
+	ctx, cancel := context.WithTimeout(ctx, min(pool.health.Interval, maxProbeTimeout))
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(targetURL, "/")+pool.health.Path, nil)
+
+	if err != nil {
+		return false
+	}
+
+	client := &http.Client{
+		Transport: hc.transports.ForUpstream(pool.ID),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return false
+	}
+
+	_, _ = io.CopyN(io.Discard, response.Body, maxProbeDrainBytes)
+	response.Body.Close()
+
+	return response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusBadRequest
+}
@@ -0,0 +1,224 @@
+package upstream
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testPool(upstream *model.Upstream) *Pool {
+	pools := NewPools()
+	pools.Set([]*model.Upstream{upstream})
+
+	return pools.Get(upstream.ID)
+}
+
+func TestPool_Report(t *testing.T) {
+	pool := testPool(&model.Upstream{
+		ID:                     1,
+		MaxConsecutiveFailures: 3,
+		EjectionDurationMs:     60000,
+		Targets:                []*model.UpstreamTarget{{ID: 1, URL: "http://backend-1"}, {ID: 2, URL: "http://backend-2"}},
+	})
+
+	first := pool.Targets()[0]
+
+	pool.Report(first, true)
+	pool.Report(first, true)
+	pool.Report(first, false)
+	pool.Report(first, true)
+	pool.Report(first, true)
+
+	if !first.Available(time.Now()) {
+		t.Fatalf("expected a success to reset the consecutive failure count")
+	}
+
+	pool.Report(first, true)
+
+	if first.Available(time.Now()) {
+		t.Fatalf("expected the target to be ejected after 3 consecutive failures")
+	}
+
+	if !first.Available(time.Now().Add(61 * time.Second)) {
+		t.Fatalf("expected the target to return once the ejection expires")
+	}
+
+	for i := 0; i < 4; i++ {
+		if picked, err := pool.Pick(""); err != nil || picked.ID != 2 {
+			t.Fatalf("expected the ejected target to be skipped, got %v, %v", picked, err)
+		}
+	}
+
+	pool.Report(pool.Targets()[1], true)
+	pool.Report(pool.Targets()[1], true)
+	pool.Report(pool.Targets()[1], true)
+
+	if _, err := pool.Pick(""); !errors.Is(err, ErrNoTargets) {
+		t.Fatalf("expected ErrNoTargets once every target is ejected, got %v", err)
+	}
+
+	health := pool.Health()
+
+	if !health[0].Ejected || health[0].EjectedUntil == nil || !health[0].Healthy {
+		t.Fatalf("expected health to report the ejection, got %+v", health[0])
+	}
+}
+
+func TestPool_PickExclude(t *testing.T) {
+	pool := testPool(&model.Upstream{
+		ID:       1,
+		Strategy: model.StrategyConsistentHash,
+		Targets:  []*model.UpstreamTarget{{ID: 1, URL: "http://backend-1", Weight: new(1)}, {ID: 2, URL: "http://backend-2", Weight: new(1)}},
+	})
+
+	first, err := pool.Pick("org-7")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if retried, err := pool.Pick("org-7", first); err != nil || retried == first {
+		t.Fatalf("expected a retry to go to the other target, got %v, %v", retried, err)
+	}
+
+	if _, err := pool.Pick("org-7", pool.Targets()...); !errors.Is(err, ErrNoTargets) {
+		t.Fatalf("expected ErrNoTargets once every target is excluded, got %v", err)
+	}
+}
+
+func TestPool_RecordProbe(t *testing.T) {
+	pool := testPool(&model.Upstream{
+		ID:                 1,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 2,
+		Targets:            []*model.UpstreamTarget{{ID: 1, URL: "http://backend-1"}},
+	})
+
+	target := pool.Targets()[0]
+	scenarios := []struct {
+		ok              bool
+		expectedHealthy bool
+	}{
+		{ok: false, expectedHealthy: true},
+		{ok: false, expectedHealthy: false},
+		{ok: true, expectedHealthy: false},
+		{ok: false, expectedHealthy: false},
+		{ok: true, expectedHealthy: false},
+		{ok: true, expectedHealthy: true},
+	}
+
+	for i, scenario := range scenarios {
+		pool.recordProbe(target, scenario.ok)
+
+		if healthy := !target.unhealthy.Load(); healthy != scenario.expectedHealthy {
+			t.Fatalf("probe %d: expected healthy %v, got %v", i, scenario.expectedHealthy, healthy)
+		}
+	}
+}
+
+func TestPools_SetKeepsHealth(t *testing.T) {
+	pools := NewPools()
+	upstream := &model.Upstream{ID: 1, Targets: []*model.UpstreamTarget{{ID: 1, URL: "http://backend-1"}}}
+
+	pools.Set([]*model.Upstream{upstream})
+	pools.Get(1).Targets()[0].unhealthy.Store(true)
+
+	// Updating an upstream re-creates its targets with new ids
+	upstream.Targets = []*model.UpstreamTarget{{ID: 7, URL: "http://backend-1", Weight: new(2)}}
+	pools.Set([]*model.Upstream{upstream})
+
+	if target := pools.Get(1).Targets()[0]; target.Available(time.Now()) || target.ID != 7 || target.Weight != 2 {
+		t.Fatalf("expected the replaced target to stay unhealthy, got %+v", target)
+	}
+}
+
+func TestHealthChecker_ProbeDue(t *testing.T) {
+	var status atomic.Int32
+	var probes atomic.Int32
+
+	status.Store(http.StatusServiceUnavailable)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			t.Errorf("expected probe of /healthz, got %s", r.URL.Path)
+		}
+
+		probes.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer backend.Close()
+
+	pools := NewPools()
+	pools.Set([]*model.Upstream{{
+		ID:                 1,
+		HealthCheckPath:    "/healthz",
+		UnhealthyThreshold: 1,
+		HealthyThreshold:   1,
+		Targets:            []*model.UpstreamTarget{{ID: 1, URL: backend.URL + "/"}},
+	}})
+
+	checker := NewHealthChecker(pools, NewTransports(Settings{}))
+	target := pools.Get(1).Targets()[0]
+	now := time.Now()
+
+	probeAndWait := func(at time.Time) {
+		checker.probeDue(context.Background(), at)
+
+		for deadline := time.Now().Add(time.Second); target.probing.Load() && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	probeAndWait(now)
+
+	if target.Available(now) {
+		t.Fatalf("expected a failing probe to mark the target unhealthy")
+	}
+
+	status.Store(http.StatusOK)
+	probeAndWait(now.Add(time.Second))
+
+	if probes.Load() != 1 {
+		t.Fatalf("expected no probe before the interval elapsed, got %d probes", probes.Load())
+	}
+
+	probeAndWait(now.Add(defaultHealthCheckInterval))
+
+	if !target.Available(now) {
+		t.Fatalf("expected a passing probe to mark the target healthy")
+	}
+}
+
+func TestHealthChecker_ProbeH2C(t *testing.T) {
+	backend := h2cServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	pools := NewPools()
+	pools.Set([]*model.Upstream{{ID: 1, HealthCheckPath: "/healthz", Targets: []*model.UpstreamTarget{{ID: 1, URL: backend.URL}}}})
+
+	transports := NewTransports(Settings{})
+	checker := NewHealthChecker(pools, transports)
+	pool := pools.Get(1)
+
+	if checker.probe(context.Background(), pool, backend.URL) {
+		t.Fatal("expected a probe over HTTP/1.1 to fail before a route uses the upstream over h2c")
+	}
+
+	transports.Retain([]*model.Route{{ID: 1, UpstreamID: new(1), UpstreamProtocol: ProtocolH2C}})
+
+	if !checker.probe(context.Background(), pool, backend.URL) {
+		t.Fatal("expected the probe to go over h2c like the route using the upstream")
+	}
+}
@@ -0,0 +1,222 @@
+package upstream
+
+import (
+	"api-proxy/internal/model"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoTargets = errors.New("no upstream targets are available")
+
+// PoolTarget is a target of an upstream pool along with the runtime state the balancers and health checks need
+type PoolTarget struct {
+	ID                  int
+	URL                 string
+	Weight              int
+	outstanding         atomic.Int64
+	unhealthy           atomic.Bool
+	probeSuccesses      atomic.Int32
+	probeFailures       atomic.Int32
+	consecutiveFailures atomic.Int32
+	ejectedUntil        atomic.Int64
+	lastProbe           atomic.Int64
+	probing             atomic.Bool
+}
+
+// Available reports whether the target passes its active health checks and is not ejected
+func (pt *PoolTarget) Available(now time.Time) bool {
+	return !pt.unhealthy.Load() && now.UnixNano() >= pt.ejectedUntil.Load()
+}
+
+// Outstanding returns the number of requests currently in flight to the target
+func (pt *PoolTarget) Outstanding() int64 {
+	return pt.outstanding.Load()
+}
+
+// Acquire marks a request as in flight to the target, the returned func must be called once it has completed
+func (pt *PoolTarget) Acquire() func() {
+	pt.outstanding.Add(1)
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() { pt.outstanding.Add(-1) })
+	}
+}
+
+// Pool is the runtime form of an upstream
+type Pool struct {
+	ID       int
+	Name     string
+	targets  []*PoolTarget
+	balancer Balancer
+	health   HealthSettings
+}
+
+// Pick chooses one of the available targets for the caller identified by key, leaving out the excluded ones and the
+// drained ones with a weight of 0
+func (p *Pool) Pick(key string, exclude ...*PoolTarget) (*PoolTarget, error) {
+	now := time.Now()
+	available := make([]*PoolTarget, 0, len(p.targets))
+
+	for _, target := range p.targets {
+		if target.Weight > 0 && target.Available(now) && !slices.Contains(exclude, target) {
+			available = append(available, target)
+		}
+	}
+
+	target := p.balancer.Pick(available, key)
+
+	if target == nil {
+		return nil, ErrNoTargets
+	}
+
+	return target, nil
+}
+
+// Targets returns every target in the pool
+func (p *Pool) Targets() []*PoolTarget {
+	return p.targets
+}
+
+// Pools holds the active upstream pools, synced from the database. Targets keep their in-flight counts across syncs
+// as long as they are unchanged, and their health as long as their url is.
+type Pools struct {
+	rw    sync.RWMutex
+	pools map[int]*Pool
+}
+
+func NewPools() *Pools {
+	return &Pools{
+		rw:    sync.RWMutex{},
+		pools: make(map[int]*Pool),
+	}
+}
+
+// Get returns the pool for the upstream id, or nil if there is no active upstream with that id
+func (p *Pools) Get(id int) *Pool {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+
+	return p.pools[id]
+}
+
+// All returns every active pool
+func (p *Pools) All() []*Pool {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+
+	pools := make([]*Pool, 0, len(p.pools))
+
+	for _, pool := range p.pools {
+		pools = append(pools, pool)
+	}
+
+	return pools
+}
+
+// Set replaces the pools with ones built from upstreams
+func (p *Pools) Set(upstreams []*model.Upstream) {
+	p.rw.Lock()
+	defer p.rw.Unlock()
+
+	pools := make(map[int]*Pool, len(upstreams))
+
+	for _, upstream := range upstreams {
+		pools[upstream.ID] = newPool(upstream, p.pools[upstream.ID])
+	}
+
+	p.pools = pools
+}
+
+func (p *Pools) StartSync(ctx context.Context, interval time.Duration, findUpstreams func() ([]*model.Upstream, error)) {
+	p.syncPools(findUpstreams)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.syncPools(findUpstreams)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (p *Pools) syncPools(findUpstreams func() ([]*model.Upstream, error)) {
+	slog.Info("started upstream pool sync...")
+
+	upstreams, err := findUpstreams()
+
+	if err != nil {
+		slog.Error("error syncing upstreams from db to pools", "err", err)
+		return
+	}
+
+	p.Set(upstreams)
+
+	slog.Info("finished upstream pool sync...")
+}
+
+func newPool(upstream *model.Upstream, previous *Pool) *Pool {
+	existing := make(map[string]*PoolTarget)
+
+	if previous != nil {
+		for _, target := range previous.targets {
+			existing[target.URL] = target
+		}
+	}
+
+	targets := make([]*PoolTarget, 0, len(upstream.Targets))
+
+	for _, t := range upstream.Targets {
+		weight := 1
+
+		if t.Weight != nil {
+			weight = max(*t.Weight, 0)
+		}
+
+		target, ok := existing[t.URL]
+
+		switch {
+		case !ok:
+			target = &PoolTarget{ID: t.ID, URL: t.URL, Weight: weight}
+		case target.ID != t.ID || target.Weight != weight:
+			target = target.replace(t.ID, weight)
+		}
+
+		targets = append(targets, target)
+	}
+
+	return &Pool{
+		ID:       upstream.ID,
+		Name:     upstream.Name,
+		targets:  targets,
+		balancer: NewBalancer(upstream.Strategy, targets),
+		health:   HealthSettingsFor(upstream),
+	}
+}
+
+// replace returns a new target for the same url that carries over the health state. Requests still in flight
+// release the old target, so the in-flight count starts again from zero.
+func (pt *PoolTarget) replace(id, weight int) *PoolTarget {
+	replacement := &PoolTarget{ID: id, URL: pt.URL, Weight: weight}
+
+	replacement.unhealthy.Store(pt.unhealthy.Load())
+	replacement.probeSuccesses.Store(pt.probeSuccesses.Load())
+	replacement.probeFailures.Store(pt.probeFailures.Load())
+	replacement.consecutiveFailures.Store(pt.consecutiveFailures.Load())
+	replacement.ejectedUntil.Store(pt.ejectedUntil.Load())
+	replacement.lastProbe.Store(pt.lastProbe.Load())
+
+	return replacement
+}
@@ -0,0 +1,148 @@
+package upstream
+
+import (
+	"api-proxy/internal/model"
+	"api-proxy/internal/routing"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var ErrMissingPathParam = errors.New("backend url references a path parameter the route does not capture")
+
+// ErrDotSegment is returned for a path parameter that is, or for the catch-all contains, a . or .. segment, which
+// would move the request to another path of the backend once expanded into the template
+var ErrDotSegment = errors.New("path parameter is a dot segment")
+
+// Target builds the backend url for a request. A backend url containing {param} placeholders is a template and
+// is expanded with the captured path parameters, otherwise the request path is appended to it after removing the
+// route's strip prefix. The request's query string is always carried over. backendURL is the route's own backend
+// url, or the url of the target picked from the route's upstream pool.
+func Target(backendURL string, route *model.Route, requestURL *url.URL, params map[string]string) (*url.URL, error) {
+	if IsTemplate(backendURL) {
+		return expandTemplate(backendURL, requestURL.RawQuery, params)
+	}
+
+	path := requestURL.EscapedPath()
+
+	if hasPathPrefix(path, route.StripPrefix) {
+		path = strings.TrimPrefix(path, strings.TrimSuffix(route.StripPrefix, "/"))
+
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+
+	target := backendURL + path
+
+	if requestURL.RawQuery != "" {
+		target += "?" + requestURL.RawQuery
+	}
+
+	return url.Parse(target)
+}
+
+// hasPathPrefix reports whether prefix covers whole segments of path, /api is a prefix of /api and /api/users but
+// not of /apiv2/users
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	if prefix == "" || !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
+
+// IsTemplate reports whether a backend url contains {param} placeholders
+func IsTemplate(backendURL string) bool {
+	return strings.Contains(backendURL, "{")
+}
+
+// TemplateParams returns the names of the placeholders in a backend url template
+func TemplateParams(backendURL string) []string {
+	var names []string
+
+	for rest := backendURL; ; {
+		start := strings.Index(rest, "{")
+
+		if start < 0 {
+			return names
+		}
+
+		end := strings.Index(rest[start:], "}")
+
+		if end < 0 {
+			return names
+		}
+
+		names = append(names, rest[start+1:start+end])
+		rest = rest[start+end+1:]
+	}
+}
+
+func expandTemplate(template, rawQuery string, params map[string]string) (*url.URL, error) {
+	var builder strings.Builder
+
+	for rest := template; ; {
+		start := strings.Index(rest, "{")
+		end := strings.Index(rest, "}")
+
+		if start < 0 || end < start {
+			builder.WriteString(rest)
+			break
+		}
+
+		value, ok := params[rest[start+1:end]]
+
+		if !ok {
+			return nil, ErrMissingPathParam
+		}
+
+		escaped, err := escapeParam(rest[start+1:end], value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		builder.WriteString(rest[:start])
+		builder.WriteString(escaped)
+		rest = rest[end+1:]
+	}
+
+	target, err := url.Parse(builder.String())
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case rawQuery == "":
+	case target.RawQuery == "":
+		target.RawQuery = rawQuery
+	default:
+		target.RawQuery += "&" + rawQuery
+	}
+
+	return target, nil
+}
+
+// escapeParam escapes a captured value for the backend path. The catch-all spans several segments, each is escaped on
+// its own so its slashes still separate segments. PathEscape leaves dots alone, so dot segments are refused.
+func escapeParam(name, value string) (string, error) {
+	segments := []string{value}
+
+	if name == routing.CatchAllParam {
+		segments = strings.Split(value, "/")
+	}
+
+	for i, segment := range segments {
+		if segment == "." || segment == ".." {
+			return "", ErrDotSegment
+		}
+
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return strings.Join(segments, "/"), nil
+}
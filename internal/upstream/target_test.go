@@ -0,0 +1,142 @@
+package upstream
+
+import (
+	"api-proxy/internal/model"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestTarget(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		route       *model.Route
+		requestURI  string
+		params      map[string]string
+		expected    string
+		expectedErr error
+	}{
+		{
+			name:       "appends request uri",
+			route:      &model.Route{BackendURL: "http://users-svc"},
+			requestURI: "/api/v1/users/42?expand=org",
+			params:     map[string]string{"id": "42"},
+			expected:   "http://users-svc/api/v1/users/42?expand=org",
+		},
+		{
+			name:       "strips prefix",
+			route:      &model.Route{BackendURL: "http://users-svc/internal", StripPrefix: "/api/v1"},
+			requestURI: "/api/v1/users/42",
+			expected:   "http://users-svc/internal/users/42",
+		},
+		{
+			name:       "strips whole path",
+			route:      &model.Route{BackendURL: "http://users-svc", StripPrefix: "/api/v1/users"},
+			requestURI: "/api/v1/users",
+			expected:   "http://users-svc/",
+		},
+		{
+			name:       "prefix not present",
+			route:      &model.Route{BackendURL: "http://users-svc", StripPrefix: "/api/v2"},
+			requestURI: "/api/v1/users",
+			expected:   "http://users-svc/api/v1/users",
+		},
+		{
+			name:       "prefix matches part of a segment",
+			route:      &model.Route{BackendURL: "http://users-svc", StripPrefix: "/api"},
+			requestURI: "/apiv2/users",
+			expected:   "http://users-svc/apiv2/users",
+		},
+		{
+			name:       "prefix with trailing slash",
+			route:      &model.Route{BackendURL: "http://users-svc", StripPrefix: "/api/"},
+			requestURI: "/api/users",
+			expected:   "http://users-svc/users",
+		},
+		{
+			name:       "template",
+			route:      &model.Route{BackendURL: "http://users-svc/v2/accounts/{id}"},
+			requestURI: "/api/v1/users/42?expand=org",
+			params:     map[string]string{"id": "42"},
+			expected:   "http://users-svc/v2/accounts/42?expand=org",
+		},
+		{
+			name:       "template escapes values and merges query",
+			route:      &model.Route{BackendURL: "http://users-svc/v2/orgs/{org}/accounts/{id}?source=proxy"},
+			requestURI: "/api/v1/orgs/acme%20co/users/42?expand=org",
+			params:     map[string]string{"org": "acme co", "id": "42"},
+			expected:   "http://users-svc/v2/orgs/acme%20co/accounts/42?source=proxy&expand=org",
+		},
+		{
+			name:       "template with catch-all keeps its slashes",
+			route:      &model.Route{BackendURL: "http://files-svc/v2/{*}"},
+			requestURI: "/files/a b/c",
+			params:     map[string]string{"*": "a b/c"},
+			expected:   "http://files-svc/v2/a%20b/c",
+		},
+		{
+			name:        "template with a dot segment param",
+			route:       &model.Route{BackendURL: "http://users-svc/v2/accounts/{id}/profile"},
+			requestURI:  "/api/users/%2e%2e",
+			params:      map[string]string{"id": ".."},
+			expectedErr: ErrDotSegment,
+		},
+		{
+			name:        "template with a catch-all climbing out of its path",
+			route:       &model.Route{BackendURL: "http://files-svc/v2/public/{*}"},
+			requestURI:  "/files/a/../../private/b",
+			params:      map[string]string{"*": "a/../../private/b"},
+			expectedErr: ErrDotSegment,
+		},
+		{
+			name:        "template with a catch-all holding a . segment",
+			route:       &model.Route{BackendURL: "http://files-svc/v2/{*}"},
+			requestURI:  "/files/./b",
+			params:      map[string]string{"*": "./b"},
+			expectedErr: ErrDotSegment,
+		},
+		{
+			name:       "template with dots inside a segment",
+			route:      &model.Route{BackendURL: "http://files-svc/v2/{*}"},
+			requestURI: "/files/a/..b/c.txt",
+			params:     map[string]string{"*": "a/..b/c.txt"},
+			expected:   "http://files-svc/v2/a/..b/c.txt",
+		},
+		{
+			name:        "template with unknown param",
+			route:       &model.Route{BackendURL: "http://users-svc/v2/accounts/{account_id}"},
+			requestURI:  "/api/v1/users/42",
+			params:      map[string]string{"id": "42"},
+			expectedErr: ErrMissingPathParam,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			requestURL, err := url.ParseRequestURI(scenario.requestURI)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			target, err := Target(scenario.route.BackendURL, scenario.route, requestURL, scenario.params)
+
+			if !errors.Is(err, scenario.expectedErr) {
+				t.Fatalf("expected error %v, got %v", scenario.expectedErr, err)
+			}
+
+			if err == nil && target.String() != scenario.expected {
+				t.Fatalf("expected %s, got %s", scenario.expected, target.String())
+			}
+		})
+	}
+}
+
+func TestTemplateParams(t *testing.T) {
+	actual := TemplateParams("http://users-svc/v2/orgs/{org}/accounts/{id}")
+
+	if !reflect.DeepEqual([]string{"org", "id"}, actual) {
+		t.Fatalf("expected [org id], got %v", actual)
+	}
+}
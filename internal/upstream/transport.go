@@ -0,0 +1,249 @@
+package upstream
+
+import (
+	"api-proxy/internal/model"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Protocols a route can speak to its backend
+const (
+	// ProtocolAuto uses HTTP/1.1 for http backends and lets https backends negotiate HTTP/2
+	ProtocolAuto = ""
+	// ProtocolHTTP1 always uses HTTP/1.1
+	ProtocolHTTP1 = "http1"
+	// ProtocolH2 requires HTTP/2 over TLS
+	ProtocolH2 = "h2"
+	// ProtocolH2C uses HTTP/2 over cleartext with prior knowledge, as gRPC backends without TLS expect
+	ProtocolH2C = "h2c"
+)
+
+// ValidProtocol reports whether protocol is a known upstream protocol
+func ValidProtocol(protocol string) bool {
+	switch protocol {
+	case ProtocolAuto, ProtocolHTTP1, ProtocolH2, ProtocolH2C:
+		return true
+	default:
+		return false
+	}
+}
+
+// Settings describes how connections to a single backend are dialed, timed out and pooled
+type Settings struct {
+	ConnectTimeout        time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	Timeout               time.Duration
+	MaxIdleConns          int
+	MaxConns              int
+	Protocol              string
+}
+
+// routeTransport is a route's transport along with the settings it was built with
+type routeTransport struct {
+	settings  Settings
+	transport *http.Transport
+}
+
+// Transports hands out one tuned http.Transport per route. Each keeps a connection pool per backend host so a slow or
+// saturated backend cannot exhaust the pool of another.
+type Transports struct {
+	mu         sync.Mutex
+	defaults   Settings
+	transports map[int]*routeTransport
+	upstreams  map[int]*model.Route
+	fallback   *http.Transport
+}
+
+func NewTransports(defaults Settings) *Transports {
+	return &Transports{
+		mu:         sync.Mutex{},
+		defaults:   defaults,
+		transports: make(map[int]*routeTransport),
+		upstreams:  make(map[int]*model.Route),
+		fallback:   newTransport(defaults),
+	}
+}
+
+// SettingsFor overlays the route's non-zero overrides on top of the configured defaults
+func (t *Transports) SettingsFor(route *model.Route) Settings {
+	settings := t.defaults
+
+	if route == nil {
+		return settings
+	}
+
+	if route.ConnectTimeoutMs > 0 {
+		settings.ConnectTimeout = time.Duration(route.ConnectTimeoutMs) * time.Millisecond
+	}
+
+	if route.ResponseHeaderTimeoutMs > 0 {
+		settings.ResponseHeaderTimeout = time.Duration(route.ResponseHeaderTimeoutMs) * time.Millisecond
+	}
+
+	if route.IdleConnTimeoutMs > 0 {
+		settings.IdleConnTimeout = time.Duration(route.IdleConnTimeoutMs) * time.Millisecond
+	}
+
+	if route.TimeoutMs > 0 {
+		settings.Timeout = time.Duration(route.TimeoutMs) * time.Millisecond
+	}
+
+	if route.MaxIdleConns > 0 {
+		settings.MaxIdleConns = route.MaxIdleConns
+	}
+
+	if route.MaxConns > 0 {
+		settings.MaxConns = route.MaxConns
+	}
+
+	if route.UpstreamProtocol != ProtocolAuto {
+		settings.Protocol = route.UpstreamProtocol
+	}
+
+	return settings
+}
+
+// Get returns the route's transport, creating it on first use. Changing a route's settings moves it onto a fresh
+// transport and closes the idle connections of the old one.
+func (t *Transports) Get(route *model.Route) *http.Transport {
+	settings := t.SettingsFor(route)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, ok := t.transports[route.ID]
+
+	if ok && current.settings == settings {
+		return current.transport
+	}
+
+	if ok {
+		current.transport.CloseIdleConnections()
+	}
+
+	transport := newTransport(settings)
+	t.transports[route.ID] = &routeTransport{settings: settings, transport: transport}
+
+	return transport
+}
+
+// Retain drops the transports of routes that are no longer active or whose settings changed, closing their idle
+// connections. Requests still in flight on them finish on their own. It also notes which route sends requests to
+// each upstream for ForUpstream.
+func (t *Transports) Retain(routes []*model.Route) {
+	active := make(map[int]Settings, len(routes))
+	upstreams := make(map[int]*model.Route)
+
+	for _, route := range routes {
+		active[route.ID] = t.SettingsFor(route)
+
+		for _, upstreamID := range upstreamsOf(route) {
+			if _, ok := upstreams[upstreamID]; !ok {
+				upstreams[upstreamID] = route
+			}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.upstreams = upstreams
+
+	for id, current := range t.transports {
+		if settings, ok := active[id]; !ok || settings != current.settings {
+			current.transport.CloseIdleConnections()
+			delete(t.transports, id)
+		}
+	}
+}
+
+// ForUpstream returns the transport of a route sending requests to the upstream, so its targets are health checked
+// over the protocol the route speaks to them. Upstreams no route sends requests to get the default settings.
+func (t *Transports) ForUpstream(upstreamID int) *http.Transport {
+	t.mu.Lock()
+	route, ok := t.upstreams[upstreamID]
+	t.mu.Unlock()
+
+	if !ok {
+		return t.fallback
+	}
+
+	return t.Get(route)
+}
+
+// upstreamsOf lists the upstreams the route sends requests to, directly, through its variants or as its mirror
+func upstreamsOf(route *model.Route) []int {
+	var ids []int
+
+	if route.UpstreamID != nil {
+		ids = append(ids, *route.UpstreamID)
+	}
+
+	for _, v := range route.Variants {
+		if v.UpstreamID != nil {
+			ids = append(ids, *v.UpstreamID)
+		}
+	}
+
+	if route.MirrorUpstreamID != nil {
+		ids = append(ids, *route.MirrorUpstreamID)
+	}
+
+	return ids
+}
+
+// CloseIdleConnections closes idle connections across every backend pool
+func (t *Transports) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, current := range t.transports {
+		current.transport.CloseIdleConnections()
+	}
+
+	t.fallback.CloseIdleConnections()
+}
+
+func newTransport(settings Settings) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   settings.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		Protocols:             protocolsFor(settings.Protocol),
+		TLSHandshakeTimeout:   settings.ConnectTimeout,
+		ResponseHeaderTimeout: settings.ResponseHeaderTimeout,
+		IdleConnTimeout:       settings.IdleConnTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConns:          settings.MaxIdleConns,
+		MaxIdleConnsPerHost:   settings.MaxIdleConns,
+		MaxConnsPerHost:       settings.MaxConns,
+	}
+}
+
+// protocolsFor returns nil for ProtocolAuto so the transport falls back to ForceAttemptHTTP2
+func protocolsFor(protocol string) *http.Protocols {
+	if protocol == ProtocolAuto {
+		return nil
+	}
+
+	protocols := new(http.Protocols)
+
+	switch protocol {
+	case ProtocolHTTP1:
+		protocols.SetHTTP1(true)
+	case ProtocolH2:
+		protocols.SetHTTP2(true)
+	case ProtocolH2C:
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return protocols
+}
@@ -0,0 +1,177 @@
+package upstream
+
+import (
+	"api-proxy/internal/model"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTransports_SettingsFor(t *testing.T) {
+	defaults := Settings{
+		ConnectTimeout:        5 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+	}
+
+	scenarios := []struct {
+		name     string
+		route    *model.Route
+		expected Settings
+	}{
+		{
+			name:     "nil route",
+			route:    nil,
+			expected: defaults,
+		},
+		{
+			name:     "no overrides",
+			route:    &model.Route{ID: 1},
+			expected: defaults,
+		},
+		{
+			name: "overrides",
+			route: &model.Route{
+				ID:                      1,
+				ConnectTimeoutMs:        250,
+				ResponseHeaderTimeoutMs: 1000,
+				TimeoutMs:               2000,
+				MaxConns:                10,
+				UpstreamProtocol:        ProtocolH2C,
+			},
+			expected: Settings{
+				ConnectTimeout:        250 * time.Millisecond,
+				ResponseHeaderTimeout: time.Second,
+				IdleConnTimeout:       90 * time.Second,
+				Timeout:               2 * time.Second,
+				MaxIdleConns:          100,
+				MaxConns:              10,
+				Protocol:              ProtocolH2C,
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			actual := NewTransports(defaults).SettingsFor(scenario.route)
+
+			if !reflect.DeepEqual(scenario.expected, actual) {
+				t.Fatalf("expected %+v, got %+v", scenario.expected, actual)
+			}
+		})
+	}
+}
+
+func TestTransports_Get(t *testing.T) {
+	transports := NewTransports(Settings{ConnectTimeout: time.Second})
+
+	users := &model.Route{ID: 1}
+	orders := &model.Route{ID: 2}
+
+	if transports.Get(users) != transports.Get(users) {
+		t.Fatal("expected a route to keep its transport")
+	}
+
+	if transports.Get(users) == transports.Get(orders) {
+		t.Fatal("expected different routes to get their own transport")
+	}
+
+	previous := transports.Get(users)
+	tuned := &model.Route{ID: 1, MaxConns: 5}
+
+	if transports.Get(tuned) == previous {
+		t.Fatal("expected changed settings to get a new transport")
+	}
+
+	if transports.Get(tuned).MaxConnsPerHost != 5 {
+		t.Fatalf("expected max conns per host 5, got %d", transports.Get(tuned).MaxConnsPerHost)
+	}
+
+	if len(transports.transports) != 2 {
+		t.Fatalf("expected the old transport to be dropped, got %d transports", len(transports.transports))
+	}
+}
+
+func TestTransports_Retain(t *testing.T) {
+	transports := NewTransports(Settings{ConnectTimeout: time.Second})
+
+	kept := transports.Get(&model.Route{ID: 1})
+	transports.Get(&model.Route{ID: 2})
+	changed := transports.Get(&model.Route{ID: 3})
+
+	transports.Retain([]*model.Route{{ID: 1}, {ID: 3, MaxConns: 5}})
+
+	if len(transports.transports) != 1 {
+		t.Fatalf("expected only the unchanged route's transport to be kept, got %d transports", len(transports.transports))
+	}
+
+	if transports.Get(&model.Route{ID: 1}) != kept {
+		t.Fatal("expected the unchanged route to keep its transport")
+	}
+
+	if transports.Get(&model.Route{ID: 3, MaxConns: 5}) == changed {
+		t.Fatal("expected the changed route to get a new transport")
+	}
+}
+
+func h2cServer(handler http.Handler) *httptest.Server {
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+
+	return server
+}
+
+// TestTransports_H2CTrailers proxies a gRPC style call over h2c on both legs and checks the trailers survive
+func TestTransports_H2CTrailers(t *testing.T) {
+	backend := h2cServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("expected the backend to be called over HTTP/2, got %s", r.Proto)
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write([]byte("message"))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer backend.Close()
+
+	transports := NewTransports(Settings{})
+	target, _ := url.Parse(backend.URL)
+	reverseProxy := httputil.NewSingleHostReverseProxy(target)
+	reverseProxy.Transport = transports.Get(&model.Route{ID: 1, UpstreamProtocol: ProtocolH2C})
+
+	proxy := h2cServer(reverseProxy)
+	defer proxy.Close()
+
+	client := &http.Client{Transport: newTransport(Settings{Protocol: ProtocolH2C})}
+	request, _ := http.NewRequest(http.MethodPost, proxy.URL+"/helloworld.Greeter/SayHello", nil)
+	request.Header.Set("Content-Type", "application/grpc")
+	request.Header.Set("TE", "trailers")
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		t.Fatalf("unexpected error calling the proxy: %v", err)
+	}
+
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(response.Body)
+
+	if response.ProtoMajor != 2 || string(body) != "message" {
+		t.Fatalf("expected an HTTP/2 response with the message, got %s with %q", response.Proto, body)
+	}
+
+	if status := response.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Fatalf("expected the grpc-status trailer to pass through, got %q", status)
+	}
+}
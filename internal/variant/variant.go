@@ -0,0 +1,92 @@
+package variant
+
+import (
+	"api-proxy/internal/identity"
+	"api-proxy/internal/model"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// HeaderVariant forces a request onto the named variant of its route, whatever the weights
+const HeaderVariant = "X-Route-Variant"
+
+// What requests stick to a variant by, the org is the default
+const (
+	StickyByOrgID            = "org_id"
+	StickyByServiceAccountID = "service_account_id"
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+
+// ValidName reports whether name can identify a variant, it is recorded with every request the variant serves
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// ValidStickyBy reports whether requests can stick to variants by s
+func ValidStickyBy(s string) bool {
+	return s == "" || s == StickyByOrgID || s == StickyByServiceAccountID
+}
+
+// Pick chooses the variant serving the request, nil when the route has none. A variant named in HeaderVariant wins,
+// otherwise the caller's org or service account is hashed onto the weights so it keeps landing on the same variant.
+// Changing weights only moves the callers needed to reach the new split. Anonymous callers are spread at random.
+func Pick(route *model.Route, r *http.Request, caller *identity.Identity) *model.RouteVariant {
+	if len(route.Variants) == 0 {
+		return nil
+	}
+
+	if forced := r.Header.Get(HeaderVariant); forced != "" {
+		for i := range route.Variants {
+			if route.Variants[i].Name == forced {
+				return &route.Variants[i]
+			}
+		}
+	}
+
+	total := 0
+
+	for _, v := range route.Variants {
+		total += v.Weight
+	}
+
+	if total <= 0 {
+		return &route.Variants[0]
+	}
+
+	point := rand.Float64()
+
+	if key, ok := stickyKey(route, caller); ok {
+		sum := sha256.Sum256([]byte(strconv.Itoa(route.ID) + ":" + key))
+		point = float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+	}
+
+	threshold := point * float64(total)
+	cumulative := 0
+
+	for i, v := range route.Variants {
+		cumulative += v.Weight
+
+		if threshold < float64(cumulative) {
+			return &route.Variants[i]
+		}
+	}
+
+	return &route.Variants[len(route.Variants)-1]
+}
+
+func stickyKey(route *model.Route, caller *identity.Identity) (string, bool) {
+	if caller == nil {
+		return "", false
+	}
+
+	if route.VariantStickyBy == StickyByServiceAccountID {
+		return "service_account_id=" + strconv.Itoa(caller.ServiceAccountID), true
+	}
+
+	return "org_id=" + strconv.Itoa(caller.OrgID), true
+}
@@ -0,0 +1,136 @@
+package variant
+
+import (
+	"api-proxy/internal/identity"
+	"api-proxy/internal/model"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func canaryRoute(stableWeight, canaryWeight int) *model.Route {
+	return &model.Route{
+		ID: 1,
+		Variants: []model.RouteVariant{
+			{Name: "stable", BackendURL: "http://stable", Weight: stableWeight},
+			{Name: "canary", BackendURL: "http://canary", Weight: canaryWeight},
+		},
+	}
+}
+
+func TestPick(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		route    *model.Route
+		forced   string
+		expected string
+	}{
+		{name: "no variants", route: &model.Route{ID: 1}},
+		{name: "all weight on one variant", route: canaryRoute(0, 100), expected: "canary"},
+		{name: "header forces a variant without weight", route: canaryRoute(100, 0), forced: "canary", expected: "canary"},
+		{name: "unknown forced variant falls back to the weights", route: canaryRoute(100, 0), forced: "blue", expected: "stable"},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			if scenario.forced != "" {
+				r.Header.Set(HeaderVariant, scenario.forced)
+			}
+
+			picked := Pick(scenario.route, r, &identity.Identity{OrgID: 1, ServiceAccountID: 1})
+
+			if scenario.expected == "" {
+				if picked != nil {
+					t.Fatalf("expected no variant, got %s", picked.Name)
+				}
+
+				return
+			}
+
+			if picked == nil || picked.Name != scenario.expected {
+				t.Fatalf("expected variant %s, got %v", scenario.expected, picked)
+			}
+		})
+	}
+}
+
+func TestPick_Sticky(t *testing.T) {
+	route := canaryRoute(50, 50)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	for orgID := range 50 {
+		caller := &identity.Identity{OrgID: orgID, ServiceAccountID: 1}
+		first := Pick(route, r, caller).Name
+
+		for range 10 {
+			if picked := Pick(route, r, &identity.Identity{OrgID: orgID, ServiceAccountID: 1000 + orgID}); picked.Name != first {
+				t.Fatalf("expected org %d to stick to %s, got %s", orgID, first, picked.Name)
+			}
+		}
+	}
+}
+
+func TestPick_Split(t *testing.T) {
+	route := canaryRoute(95, 5)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	canary := 0
+	orgs := 10000
+
+	for orgID := range orgs {
+		if Pick(route, r, &identity.Identity{OrgID: orgID}).Name == "canary" {
+			canary++
+		}
+	}
+
+	if share := float64(canary) / float64(orgs); math.Abs(share-0.05) > 0.01 {
+		t.Fatalf("expected about 5%% of orgs on the canary, got %.2f%%", share*100)
+	}
+}
+
+// TestPick_GradualShift checks raising the canary's weight only moves orgs onto the canary, never back off it
+func TestPick_GradualShift(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	for orgID := range 1000 {
+		caller := &identity.Identity{OrgID: orgID}
+
+		if Pick(canaryRoute(95, 5), r, caller).Name == "canary" && Pick(canaryRoute(75, 25), r, caller).Name != "canary" {
+			t.Fatalf("expected org %d to stay on the canary as its weight grows", orgID)
+		}
+	}
+}
+
+func TestPick_StickyByServiceAccount(t *testing.T) {
+	route := canaryRoute(50, 50)
+	route.VariantStickyBy = StickyByServiceAccountID
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	picked := map[string]bool{}
+
+	for serviceAccountID := range 50 {
+		picked[Pick(route, r, &identity.Identity{OrgID: 1, ServiceAccountID: serviceAccountID}).Name] = true
+	}
+
+	if len(picked) != 2 {
+		t.Fatalf("expected the service accounts of one org to be spread over both variants, got %v", picked)
+	}
+}
+
+func TestValidName(t *testing.T) {
+	scenarios := map[string]bool{
+		"canary":   true,
+		"v2.1-rc":  true,
+		"":         false,
+		"Canary":   false,
+		"-canary":  false,
+		"with spa": false,
+	}
+
+	for name, expected := range scenarios {
+		if ValidName(name) != expected {
+			t.Fatalf("expected ValidName(%q) to be %v", name, expected)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"api-proxy/internal/metrics"
+	"api-proxy/internal/model"
+	"bufio"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IsUpgrade reports whether the request asks to switch protocols, such as a WebSocket handshake
+func IsUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Connections tracks the upgraded connections open through this proxy instance and caps how many a single service
+// account may hold at once
+type Connections struct {
+	mu                   sync.Mutex
+	idleTimeout          time.Duration
+	maxPerServiceAccount int
+	open                 map[int]int
+}
+
+func NewConnections(idleTimeout time.Duration, maxPerServiceAccount int) *Connections {
+	return &Connections{
+		mu:                   sync.Mutex{},
+		idleTimeout:          idleTimeout,
+		maxPerServiceAccount: maxPerServiceAccount,
+		open:                 make(map[int]int),
+	}
+}
+
+// IdleTimeoutFor returns the route's idle timeout, falling back to the configured default
+func (c *Connections) IdleTimeoutFor(route *model.Route) time.Duration {
+	if route != nil && route.WebSocketIdleTimeoutMs > 0 {
+		return time.Duration(route.WebSocketIdleTimeoutMs) * time.Millisecond
+	}
+
+	return c.idleTimeout
+}
+
+// Acquire reserves a connection for the service account. When it is allowed the returned func must be called once
+// the connection is closed.
+func (c *Connections) Acquire(serviceAccountID int) (func(), bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxPerServiceAccount > 0 && c.open[serviceAccountID] >= c.maxPerServiceAccount {
+		return nil, false
+	}
+
+	c.open[serviceAccountID]++
+	metrics.WebSocketConnections.Add(1)
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			if c.open[serviceAccountID]--; c.open[serviceAccountID] <= 0 {
+				delete(c.open, serviceAccountID)
+			}
+
+			metrics.WebSocketConnections.Add(-1)
+		})
+	}, true
+}
+
+// WithIdleTimeout returns a writer whose hijacked connection is closed once no bytes moved in either direction for
+// the timeout. Closing the client side ends the reverse proxy's copy loop, which then closes the backend side.
+func WithIdleTimeout(w http.ResponseWriter, timeout time.Duration) http.ResponseWriter {
+	if timeout <= 0 {
+		return w
+	}
+
+	return &idleWriter{ResponseWriter: w, timeout: timeout}
+}
+
+type idleWriter struct {
+	http.ResponseWriter
+	timeout time.Duration
+}
+
+func (iw *idleWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(iw.ResponseWriter).Hijack()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	idle := newIdleConn(conn, iw.timeout)
+
+	return idle, bufio.NewReadWriter(brw.Reader, bufio.NewWriter(idle)), nil
+}
+
+// Unwrap exposes the underlying writer so http.ResponseController can reach Flush
+func (iw *idleWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+type idleConn struct {
+	net.Conn
+	mu         sync.Mutex
+	timeout    time.Duration
+	lastActive atomic.Int64
+	timer      *time.Timer
+	closed     bool
+}
+
+func newIdleConn(conn net.Conn, timeout time.Duration) *idleConn {
+	ic := &idleConn{Conn: conn, timeout: timeout}
+	ic.lastActive.Store(time.Now().UnixNano())
+
+	ic.mu.Lock()
+	ic.timer = time.AfterFunc(timeout, ic.check)
+	ic.mu.Unlock()
+
+	return ic
+}
+
+// check closes the connection when it has been idle for the timeout, otherwise it waits for the remainder
+func (ic *idleConn) check() {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	if ic.closed {
+		return
+	}
+
+	idle := time.Since(time.Unix(0, ic.lastActive.Load()))
+
+	if idle < ic.timeout {
+		ic.timer.Reset(ic.timeout - idle)
+		return
+	}
+
+	slog.Info("closing idle upgraded connection", "remote_addr", ic.RemoteAddr().String(), "idle", idle)
+
+	ic.closed = true
+	ic.Conn.Close()
+}
+
+func (ic *idleConn) Read(p []byte) (int, error) {
+	n, err := ic.Conn.Read(p)
+
+	if n > 0 {
+		ic.lastActive.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+func (ic *idleConn) Write(p []byte) (int, error) {
+	n, err := ic.Conn.Write(p)
+
+	if n > 0 {
+		ic.lastActive.Store(time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+func (ic *idleConn) Close() error {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	ic.timer.Stop()
+
+	if ic.closed {
+		return nil
+	}
+
+	ic.closed = true
+
+	return ic.Conn.Close()
+}
@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"api-proxy/internal/model"
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestIsUpgrade(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		connection []string
+		upgrade    string
+		expected   bool
+	}{
+		{name: "websocket", connection: []string{"Upgrade"}, upgrade: "websocket", expected: true},
+		{name: "token list", connection: []string{"keep-alive, upgrade"}, upgrade: "websocket", expected: true},
+		{name: "no connection token", connection: []string{"keep-alive"}, upgrade: "websocket", expected: false},
+		{name: "no upgrade header", connection: []string{"Upgrade"}, expected: false},
+		{name: "plain", expected: false},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			for _, value := range scenario.connection {
+				r.Header.Add("Connection", value)
+			}
+
+			if scenario.upgrade != "" {
+				r.Header.Set("Upgrade", scenario.upgrade)
+			}
+
+			if IsUpgrade(r) != scenario.expected {
+				t.Fatalf("expected IsUpgrade to be %v", scenario.expected)
+			}
+		})
+	}
+}
+
+func TestConnections_Acquire(t *testing.T) {
+	connections := NewConnections(time.Minute, 2)
+
+	first, ok := connections.Acquire(1)
+
+	if !ok {
+		t.Fatalf("expected the first connection to be allowed")
+	}
+
+	if _, ok := connections.Acquire(1); !ok {
+		t.Fatalf("expected the second connection to be allowed")
+	}
+
+	if _, ok := connections.Acquire(1); ok {
+		t.Fatalf("expected a third connection for the same service account to be refused")
+	}
+
+	if _, ok := connections.Acquire(2); !ok {
+		t.Fatalf("expected other service accounts not to be affected")
+	}
+
+	first()
+	first()
+
+	if _, ok := connections.Acquire(1); !ok {
+		t.Fatalf("expected a released connection to free up exactly one slot")
+	}
+
+	if _, ok := connections.Acquire(1); ok {
+		t.Fatalf("expected releasing twice to only free one slot")
+	}
+
+	if timeout := connections.IdleTimeoutFor(&model.Route{WebSocketIdleTimeoutMs: 500}); timeout != 500*time.Millisecond {
+		t.Fatalf("expected the route's idle timeout to win, got %s", timeout)
+	}
+}
+
+// TestWithIdleTimeout proxies an upgraded echo connection and checks it is closed once it goes quiet
+func TestWithIdleTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+
+		if err != nil {
+			t.Errorf("unable to hijack the backend connection: %v", err)
+			return
+		}
+
+		defer conn.Close()
+
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = brw.Flush()
+		_, _ = io.Copy(conn, brw)
+	}))
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+	reverseProxy := httputil.NewSingleHostReverseProxy(target)
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reverseProxy.ServeHTTP(WithIdleTimeout(w, 100*time.Millisecond), r)
+	}))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+
+	if err != nil {
+		t.Fatalf("unable to dial the proxy: %v", err)
+	}
+
+	defer conn.Close()
+
+	_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nHost: proxy\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+
+	if err != nil || response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected a 101 response, got %v, %v", response, err)
+	}
+
+	_, _ = conn.Write([]byte("ping"))
+	echoed := make([]byte, 4)
+
+	if _, err := io.ReadFull(reader, echoed); err != nil || string(echoed) != "ping" {
+		t.Fatalf("expected the backend to echo ping, got %q, %v", echoed, err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("expected the idle connection to be closed, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the connection to close shortly after the idle timeout, took %s", elapsed)
+	}
+}